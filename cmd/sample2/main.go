@@ -50,7 +50,7 @@ func initContext() (*catalog.Catalog, *txnbase.TxnManager, txnbase.NodeDriver, b
 	driver := txnbase.NewNodeDriver(sampleDir, "store", nil)
 	txnBufMgr := buffer.NewNodeManager(txnBufSize, nil)
 	mutBufMgr := buffer.NewNodeManager(mutBufSize, nil)
-	factory := tables.NewDataFactory(dataio.SegmentFileMockFactory, mutBufMgr)
+	factory := tables.NewDataFactory(dataio.SegmentFileMockFactory, mutBufMgr, sampleDir)
 	mgr := txnbase.NewTxnManager(txnimpl.TxnStoreFactory(c, driver, txnBufMgr, factory), txnimpl.TxnFactory(c))
 	mgr.Start()
 	return c, mgr, driver, txnBufMgr, mutBufMgr
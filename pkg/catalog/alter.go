@@ -0,0 +1,142 @@
+package catalog
+
+import (
+	"github.com/matrixorigin/matrixone/pkg/container/types"
+)
+
+type AlterKind uint8
+
+const (
+	AlterAddColumn AlterKind = iota
+	AlterDropColumn
+	AlterRenameColumn
+	AlterRenameTable
+)
+
+// AlterTableReq is an alter of the columns or the name of a table. Name is
+// the column to drop or rename for AlterDropColumn and AlterRenameColumn
+type AlterTableReq struct {
+	Kind    AlterKind
+	Name    string
+	NewName string
+	Type    types.Type
+	Opts    []ColOption
+}
+
+// NewAddColumnReq adds a column, which must be nullable or have a constant
+// default for the rows written before
+func NewAddColumnReq(name string, typ types.Type, opts ...ColOption) *AlterTableReq {
+	return &AlterTableReq{
+		Kind: AlterAddColumn,
+		Name: name,
+		Type: typ,
+		Opts: opts,
+	}
+}
+
+func NewDropColumnReq(name string) *AlterTableReq {
+	return &AlterTableReq{
+		Kind: AlterDropColumn,
+		Name: name,
+	}
+}
+
+func NewRenameColumnReq(name, newName string) *AlterTableReq {
+	return &AlterTableReq{
+		Kind:    AlterRenameColumn,
+		Name:    name,
+		NewName: newName,
+	}
+}
+
+// NewRenameTableReq renames the table. The txns started before the rename
+// is committed still find the table by the previous name
+func NewRenameTableReq(newName string) *AlterTableReq {
+	return &AlterTableReq{
+		Kind:    AlterRenameTable,
+		NewName: newName,
+	}
+}
+
+// applyAlter applies req to the schema. An added column takes colID, which
+// is never taken by any column of the previous versions
+func (s *Schema) applyAlter(req *AlterTableReq, colID uint16) error {
+	switch req.Kind {
+	case AlterAddColumn:
+		if s.GetColIdx(req.Name) >= 0 {
+			return ErrDuplicate
+		}
+		s.AppendCol(req.Name, req.Type, req.Opts...)
+		colDef := s.ColDefs[len(s.ColDefs)-1]
+		colDef.ID = colID
+		if !colDef.Valid() || !colDef.HasFill() {
+			return ErrValidation
+		}
+	case AlterDropColumn:
+		idx := s.GetColIdx(req.Name)
+		if idx < 0 {
+			return ErrNotFound
+		}
+		if s.IsKeyCol(idx) {
+			return ErrDropPrimaryKey
+		}
+		s.dropCol(idx)
+	case AlterRenameColumn:
+		idx := s.GetColIdx(req.Name)
+		if idx < 0 {
+			return ErrNotFound
+		}
+		if s.GetColIdx(req.NewName) >= 0 {
+			return ErrDuplicate
+		}
+		delete(s.NameIndex, req.Name)
+		s.ColDefs[idx].Name = req.NewName
+		s.NameIndex[req.NewName] = idx
+	case AlterRenameTable:
+		if req.NewName == "" {
+			return ErrValidation
+		}
+		s.Name = req.NewName
+	default:
+		return ErrValidation
+	}
+	s.Version++
+	return nil
+}
+
+// dropCol removes the column at idx. The columns after it are shifted, and
+// so are the primary key columns and the columns of the indexes
+func (s *Schema) dropCol(idx int) {
+	s.ColDefs = append(s.ColDefs[:idx], s.ColDefs[idx+1:]...)
+	s.NameIndex = make(map[string]int)
+	for i, colDef := range s.ColDefs {
+		colDef.Idx = i
+		s.NameIndex[colDef.Name] = i
+	}
+	if int(s.PrimaryKey) > idx {
+		s.PrimaryKey--
+	}
+	for i, col := range s.CompositeKey {
+		if int(col) > idx {
+			s.CompositeKey[i]--
+		}
+	}
+	indexes := s.Indexes[:0]
+	for _, index := range s.Indexes {
+		cols := index.Columns[:0]
+		for _, col := range index.Columns {
+			if int(col) == idx {
+				continue
+			}
+			if int(col) > idx {
+				col--
+			}
+			cols = append(cols, col)
+		}
+		index.Columns = cols
+		if len(cols) > 0 {
+			indexes = append(indexes, index)
+		}
+	}
+	s.Indexes = indexes
+}
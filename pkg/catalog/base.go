@@ -6,15 +6,6 @@ import (
 	"tae/pkg/iface/txnif"
 )
 
-func CompareUint64(left, right uint64) int {
-	if left > right {
-		return 1
-	} else if left < right {
-		return -1
-	}
-	return 0
-}
-
 type Waitable interface {
 	Wait() error
 }
@@ -38,7 +29,7 @@ type BaseEntry struct {
 	CommitInfo
 	PrevCommit         *CommitInfo
 	ID                 uint64
-	CreateAt, DeleteAt uint64
+	CreateAt, DeleteAt txnif.TS
 }
 
 func (be *BaseEntry) GetTxn() txnif.TxnReader { return be.Txn }
@@ -64,13 +55,13 @@ func (be *BaseEntry) DoCompre(oe *BaseEntry) int {
 	defer oe.RUnlock()
 	r := 0
 	if be.CreateAt != 0 && oe.CreateAt != 0 {
-		r = CompareUint64(be.CreateAt, oe.CreateAt)
+		r = be.CreateAt.Compare(oe.CreateAt)
 	} else if be.CreateAt != 0 {
 		r = -1
 	} else if oe.CreateAt != 0 {
 		r = 1
 	} else {
-		r = CompareUint64(be.Txn.GetStartTS(), oe.Txn.GetStartTS())
+		r = be.Txn.GetStartTS().Compare(oe.Txn.GetStartTS())
 	}
 	return r
 }
@@ -118,28 +109,28 @@ func (be *BaseEntry) HasDropped() bool {
 	return be.DeleteAt != 0
 }
 
-func (be *BaseEntry) CreateBefore(ts uint64) bool {
+func (be *BaseEntry) CreateBefore(ts txnif.TS) bool {
 	if be.CreateAt != 0 {
 		return be.CreateAt < ts
 	}
 	return false
 }
 
-func (be *BaseEntry) CreateAfter(ts uint64) bool {
+func (be *BaseEntry) CreateAfter(ts txnif.TS) bool {
 	if be.CreateAt != 0 {
 		return be.CreateAt > ts
 	}
 	return false
 }
 
-func (be *BaseEntry) DeleteBefore(ts uint64) bool {
+func (be *BaseEntry) DeleteBefore(ts txnif.TS) bool {
 	if be.DeleteAt != 0 {
 		return be.DeleteAt < ts
 	}
 	return false
 }
 
-func (be *BaseEntry) DeleteAfter(ts uint64) bool {
+func (be *BaseEntry) DeleteAfter(ts txnif.TS) bool {
 	if be.DeleteAt != 0 {
 		return be.DeleteAt > ts
 	}
@@ -175,6 +166,17 @@ func (be *BaseEntry) DropEntryLocked(txnCtx txnif.TxnReader) error {
 	return txnif.TxnWWConflictErr
 }
 
+// UndoDropLocked reverts an uncommitted drop made by DropEntryLocked
+func (be *BaseEntry) UndoDropLocked() {
+	if be.PrevCommit != nil {
+		be.CurrOp = be.PrevCommit.CurrOp
+		be.PrevCommit = nil
+		be.Txn = nil
+		return
+	}
+	be.CurrOp = OpCreate
+}
+
 func (be *BaseEntry) SameTxn(o *BaseEntry) bool {
 	if be.Txn != nil && o.Txn != nil {
 		return be.Txn.GetID() == o.Txn.GetID()
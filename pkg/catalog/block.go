@@ -45,6 +45,11 @@ func (entry *BlockEntry) IsAppendable() bool {
 	return entry.state == ES_Appendable
 }
 
+// GetSchema returns the schema version the block is written in
+func (entry *BlockEntry) GetSchema() *Schema {
+	return entry.segment.schema
+}
+
 func (entry *BlockEntry) GetSegment() *SegmentEntry {
 	return entry.segment
 }
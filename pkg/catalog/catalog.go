@@ -7,7 +7,6 @@ import (
 	"tae/pkg/iface/txnif"
 
 	"github.com/jiangxinmeng1/logstore/pkg/store"
-	"github.com/sirupsen/logrus"
 )
 
 // +--------+---------+----------+----------+------------+
@@ -29,15 +28,24 @@ type Catalog struct {
 
 	nodesMu  sync.RWMutex
 	commitMu sync.RWMutex
+
+	ckpMu        sync.RWMutex
+	checkpointed txnif.TS
 }
 
 func MockCatalog(dir, name string, cfg *store.StoreCfg) *Catalog {
-	var driver store.Store
-	var err error
-	driver, err = store.NewBaseStore(dir, name, cfg)
+	catalog, err := OpenCatalog(dir, name, cfg)
 	if err != nil {
 		panic(err)
 	}
+	return catalog
+}
+
+func OpenCatalog(dir, name string, cfg *store.StoreCfg) (*Catalog, error) {
+	driver, err := store.NewBaseStore(dir, name, cfg)
+	if err != nil {
+		return nil, err
+	}
 	catalog := &Catalog{
 		RWMutex:    new(sync.RWMutex),
 		IDAlloctor: NewIDAllocator(),
@@ -47,7 +55,7 @@ func MockCatalog(dir, name string, cfg *store.StoreCfg) *Catalog {
 		link:       new(common.Link),
 	}
 	// catalog.StateMachine.Start()
-	return catalog
+	return catalog, nil
 }
 
 func (catalog *Catalog) Close() error {
@@ -70,40 +78,12 @@ func (catalog *Catalog) GetDatabaseByID(id uint64) (db *DBEntry, err error) {
 }
 
 func (catalog *Catalog) addEntryLocked(database *DBEntry) error {
-	nn := catalog.nameNodes[database.name]
-	if nn == nil {
-		n := catalog.link.Insert(database)
-		catalog.entries[database.GetID()] = n
-
-		nn := newNodeList(catalog, &catalog.nodesMu, database.name)
-		catalog.nameNodes[database.name] = nn
-
-		nn.CreateNode(database.GetID())
-	} else {
-		node := nn.GetDBNode()
-		record := node.GetPayload().(*DBEntry)
-		record.RLock()
-		err := record.PrepareWrite(database.GetTxn(), record.RWMutex)
-		if err != nil {
-			record.RUnlock()
-			return err
-		}
-		if record.HasActiveTxn() {
-			if !record.IsDroppedUncommitted() {
-				record.RUnlock()
-				return ErrDuplicate
-			}
-		} else if !record.HasDropped() {
-			record.RUnlock()
-			logrus.Info(record.String())
-			return ErrDuplicate
-		}
-
-		record.RUnlock()
-		n := catalog.link.Insert(database)
-		catalog.entries[database.GetID()] = n
-		nn.CreateNode(database.GetID())
+	if err := catalog.checkDBNameLocked(database.name, database.GetTxn()); err != nil {
+		return err
 	}
+	n := catalog.link.Insert(database)
+	catalog.entries[database.GetID()] = n
+	addNameNode(catalog.nameNodes, catalog, &catalog.nodesMu, database.name, database.GetID(), &database.nameLists)
 	return nil
 }
 
@@ -145,9 +125,9 @@ func (catalog *Catalog) RemoveEntry(database *DBEntry) error {
 	if n, ok := catalog.entries[database.GetID()]; !ok {
 		return ErrNotFound
 	} else {
-		nn := catalog.nameNodes[database.name]
-		nn.DeleteNode(database.GetID())
+		deleteNameNodes(catalog.nameNodes, database.GetID(), database.nameLists)
 		catalog.link.Delete(n)
+		delete(catalog.entries, database.GetID())
 	}
 	return nil
 }
@@ -181,6 +161,10 @@ func (catalog *Catalog) DropDBEntry(name string, txnCtx txnif.AsyncTxn) (deleted
 	entry := dn.GetPayload().(*DBEntry)
 	entry.Lock()
 	defer entry.Unlock()
+	if entry.renameTxn != nil && entry.renameTxn.GetID() != txnCtx.GetID() {
+		err = txnif.TxnWWConflictErr
+		return
+	}
 	err = entry.DropEntryLocked(txnCtx)
 	if err == nil {
 		deleted = entry
@@ -190,6 +174,9 @@ func (catalog *Catalog) DropDBEntry(name string, txnCtx txnif.AsyncTxn) (deleted
 
 func (catalog *Catalog) CreateDBEntry(name string, txnCtx txnif.AsyncTxn) (*DBEntry, error) {
 	var err error
+	if name == SystemDBName {
+		return nil, ErrDuplicate
+	}
 	catalog.Lock()
 	// node := catalog.txnGetNodeByNameLocked(name, txnCtx)
 	// if node != nil {
@@ -12,6 +12,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/matrixorigin/matrixone/pkg/container/nulls"
 	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/common"
 	"github.com/stretchr/testify/assert"
 )
@@ -337,7 +338,7 @@ func TestCommand(t *testing.T) {
 	name := "db"
 
 	db := NewDBEntry(catalog, name, nil)
-	db.CreateAt = common.NextGlobalSeqNum()
+	db.CreateAt = txnif.TS(common.NextGlobalSeqNum())
 	db.CurrOp = OpCreate
 	db.ID = uint64(99)
 
@@ -360,7 +361,7 @@ func TestCommand(t *testing.T) {
 	assert.Equal(t, db.ID, eCmd.entry.ID)
 
 	db.CurrOp = OpSoftDelete
-	db.DeleteAt = common.NextGlobalSeqNum()
+	db.DeleteAt = txnif.TS(common.NextGlobalSeqNum())
 
 	cdb, err = db.MakeCommand(1)
 	assert.Nil(t, err)
@@ -380,8 +381,11 @@ func TestCommand(t *testing.T) {
 	assert.Equal(t, db.ID, eCmd.entry.ID)
 
 	schema := MockSchemaAll(13)
+	assert.Nil(t, schema.AppendIndex("zm", ZoneMap, 1, 2))
+	assert.Equal(t, ErrDuplicate, schema.AppendIndex("zm", ZoneMap, 3))
+	assert.Equal(t, ErrValidation, schema.AppendIndex("zm2", ZoneMap, 13))
 	tb := NewTableEntry(db, schema, nil, nil)
-	tb.CreateAt = common.NextGlobalSeqNum()
+	tb.CreateAt = txnif.TS(common.NextGlobalSeqNum())
 	tb.ID = common.NextGlobalSeqNum()
 
 	w.Reset()
@@ -400,9 +404,12 @@ func TestCommand(t *testing.T) {
 	assert.Equal(t, tb.ID, eCmd.table.ID)
 	assert.Equal(t, tb.CreateAt, eCmd.table.CreateAt)
 	assert.Equal(t, tb.GetSchema().Name, eCmd.table.GetSchema().Name)
+	assert.Equal(t, tb.GetSchema().Indexes, eCmd.table.GetSchema().Indexes)
+	assert.Equal(t, []int{1, 2}, eCmd.table.GetSchema().GetIndexedCols(ZoneMap))
+	assert.Equal(t, 12, eCmd.table.GetSchema().GetColIdx(schema.ColDefs[12].Name))
 	assert.Equal(t, tb.db.ID, eCmd.db.ID)
 
-	tb.DeleteAt = common.NextGlobalSeqNum()
+	tb.DeleteAt = txnif.TS(common.NextGlobalSeqNum())
 	tb.CurrOp = OpSoftDelete
 
 	cmd, err = tb.MakeCommand(3)
@@ -453,3 +460,268 @@ func TestSegment1(t *testing.T) {
 	t.Log(seg1.String())
 	t.Log(tb.String())
 }
+
+func TestSchemaAlter(t *testing.T) {
+	schema := MockSchema(4)
+	schema.PrimaryKey = 1
+	assert.Nil(t, schema.AppendIndex("zm", ZoneMap, 0, 3))
+	typ := schema.ColDefs[0].Type
+
+	altered := schema.Clone()
+	assert.Nil(t, altered.applyAlter(NewAddColumnReq("added", typ, WithNullable()), altered.MaxColID()+1))
+	assert.Equal(t, ErrDuplicate, altered.applyAlter(NewAddColumnReq("added", typ, WithNullable()), altered.MaxColID()+1))
+	assert.Equal(t, ErrDropPrimaryKey, altered.applyAlter(NewDropColumnReq(schema.ColDefs[1].Name), 0))
+	assert.Equal(t, ErrNotFound, altered.applyAlter(NewDropColumnReq("xx"), 0))
+	assert.Nil(t, altered.applyAlter(NewDropColumnReq(schema.ColDefs[0].Name), 0))
+	assert.Nil(t, altered.applyAlter(NewRenameColumnReq(schema.ColDefs[2].Name, "renamed"), 0))
+	assert.Equal(t, ErrDuplicate, altered.applyAlter(NewRenameColumnReq("renamed", "added"), 0))
+	assert.Equal(t, uint32(3), altered.Version)
+	assert.Equal(t, uint32(0), schema.Version)
+	assert.Equal(t, 4, len(schema.ColDefs))
+	assert.Equal(t, []int{0, 3}, schema.GetIndexedCols(ZoneMap))
+
+	// The columns after the dropped one are shifted, while the ids are kept
+	assert.Equal(t, 0, int(altered.PrimaryKey))
+	assert.Equal(t, []int{2}, altered.GetIndexedCols(ZoneMap))
+	assert.Equal(t, 1, altered.GetColIdx("renamed"))
+	assert.Equal(t, uint16(2), altered.ColDefs[1].ID)
+	assert.Equal(t, 3, altered.GetColIdxByID(4))
+	assert.Equal(t, -1, altered.GetColIdxByID(0))
+
+	buf, err := altered.Marshal()
+	assert.Nil(t, err)
+	replayed := new(Schema)
+	assert.Nil(t, replayed.ReadFrom(bytes.NewBuffer(buf)))
+	assert.Equal(t, altered.Version, replayed.Version)
+	assert.Equal(t, altered.Attrs(), replayed.Attrs())
+	assert.Equal(t, 3, replayed.GetColIdxByID(4))
+	assert.Equal(t, altered.MaxColID(), replayed.MaxColID())
+}
+
+func TestCompositeKey(t *testing.T) {
+	schema := MockSchema(4)
+	assert.Equal(t, ErrValidation, schema.SetCompositeKey(1))
+	assert.Equal(t, ErrValidation, schema.SetCompositeKey(1, 4))
+	assert.Nil(t, schema.SetCompositeKey(2, 1))
+	assert.True(t, schema.Valid())
+	assert.True(t, schema.IsCompositeKey())
+	assert.Equal(t, 2, int(schema.PrimaryKey))
+	assert.Equal(t, []int{2, 1}, schema.KeyCols())
+	assert.True(t, schema.IsKeyCol(1))
+	assert.False(t, schema.IsKeyCol(0))
+
+	// The first column should be the PrimaryKey and the columns distinct
+	invalid := schema.Clone()
+	invalid.PrimaryKey = 1
+	assert.False(t, invalid.Valid())
+	invalid = schema.Clone()
+	invalid.CompositeKey[1] = 2
+	assert.False(t, invalid.Valid())
+	assert.Equal(t, []int32{2, 1}, schema.CompositeKey)
+
+	buf, err := schema.Marshal()
+	assert.Nil(t, err)
+	replayed := new(Schema)
+	assert.Nil(t, replayed.ReadFrom(bytes.NewBuffer(buf)))
+	assert.Equal(t, schema.CompositeKey, replayed.CompositeKey)
+	assert.Equal(t, schema.KeyCols(), replayed.KeyCols())
+
+	// A key column cannot be dropped and the key is shifted with the columns
+	altered := schema.Clone()
+	assert.Equal(t, ErrDropPrimaryKey, altered.applyAlter(NewDropColumnReq(schema.ColDefs[1].Name), 0))
+	assert.Nil(t, altered.applyAlter(NewDropColumnReq(schema.ColDefs[0].Name), 0))
+	assert.Equal(t, []int{1, 0}, altered.KeyCols())
+	assert.Equal(t, 1, int(altered.PrimaryKey))
+	assert.True(t, altered.Valid())
+}
+
+func TestColumnAttrs(t *testing.T) {
+	schema := MockSchema(2)
+	typ := schema.ColDefs[0].Type
+	schema.AppendCol("nullable", typ, WithNullable(), WithComment("a nullable column"))
+	schema.AppendCol("constant", typ, WithDefault(int32(7)))
+	schema.AppendCol("seq", typ, WithAutoIncrement())
+	assert.True(t, schema.Valid())
+	assert.True(t, schema.ColDefs[2].HasFill())
+	assert.True(t, schema.ColDefs[3].HasFill())
+	assert.False(t, schema.ColDefs[4].HasFill())
+
+	// The default should match the type of the column
+	invalid := schema.Clone()
+	WithDefault(int64(7))(invalid.ColDefs[1])
+	assert.False(t, invalid.Valid())
+	invalid = schema.Clone()
+	WithNullable()(invalid.ColDefs[invalid.PrimaryKey])
+	assert.False(t, invalid.Valid())
+	assert.Equal(t, ErrValidation, schema.Clone().applyAlter(NewAddColumnReq("notnull", typ), schema.MaxColID()+1))
+
+	buf, err := schema.Marshal()
+	assert.Nil(t, err)
+	replayed := new(Schema)
+	assert.Nil(t, replayed.ReadFrom(bytes.NewBuffer(buf)))
+	for i, colDef := range schema.ColDefs {
+		assert.Equal(t, colDef.Nullable, replayed.ColDefs[i].Nullable)
+		assert.Equal(t, colDef.Default, replayed.ColDefs[i].Default)
+		assert.Equal(t, colDef.Comment, replayed.ColDefs[i].Comment)
+	}
+	fill := replayed.ColDefs[3].MakeFill(3)
+	assert.Equal(t, []int32{7, 7, 7}, fill.Col)
+	fill = replayed.ColDefs[2].MakeFill(3)
+	assert.Equal(t, 3, nulls.Length(fill.Nsp))
+}
+
+func TestTableSeq(t *testing.T) {
+	dir := initTestPath(t)
+	catalog := MockCatalog(dir, "mock", nil)
+	defer catalog.Close()
+	db := NewDBEntry(catalog, "db", nil)
+	schema := MockSchema(2)
+	schema.AppendCol("seq", schema.ColDefs[0].Type, WithAutoIncrement())
+	assert.True(t, schema.Valid())
+	tb := NewTableEntry(db, schema, nil, nil)
+
+	start, end := tb.AllocSeqRange(SeqRangeSize)
+	assert.Equal(t, uint64(1), start)
+	assert.Equal(t, uint64(SeqRangeSize), end)
+	tb.ObserveSeq(10)
+	assert.Equal(t, uint64(SeqRangeSize), tb.GetSeq())
+	tb.ObserveSeq(5000)
+	start, _ = tb.AllocSeqRange(1)
+	assert.Equal(t, uint64(5001), start)
+
+	var w bytes.Buffer
+	assert.Nil(t, tb.MakeSeqCommand(0, tb.GetSeq()).WriteTo(&w))
+	cmd, err := txnbase.BuildCommandFrom(bytes.NewBuffer(w.Bytes()))
+	assert.Nil(t, err)
+	eCmd := cmd.(*entryCmd)
+	assert.Equal(t, tb.ID, eCmd.entry.ID)
+	assert.Equal(t, db.ID, eCmd.db.ID)
+	assert.Equal(t, uint64(5001), eCmd.seq)
+
+	// Only one column can be auto-incremented
+	schema.AppendCol("seq2", schema.ColDefs[0].Type, WithAutoIncrement())
+	assert.False(t, schema.Valid())
+}
+
+func TestRemoveRenamed(t *testing.T) {
+	dir := initTestPath(t)
+	catalog := MockCatalog(dir, "mock", nil)
+	defer catalog.Close()
+	db, err := catalog.CreateDBEntry("db", nil)
+	assert.Nil(t, err)
+	tb, err := db.CreateTableEntry(MockSchema(2), nil, nil)
+	assert.Nil(t, err)
+	newTxn := func(ts txnif.TS) *txnbase.Txn {
+		txn := new(txnbase.Txn)
+		txn.TxnCtx = txnbase.NewTxnCtx(nil, common.NextGlobalSeqNum(), ts, nil)
+		return txn
+	}
+
+	// A committed rename and a rollbacked one both leave a node of the entry
+	txn := newTxn(10)
+	_, err = tb.AlterSchema(txn, NewRenameTableReq("t1"))
+	assert.Nil(t, err)
+	_, err = catalog.RenameDBEntry("db", "db1", txn)
+	assert.Nil(t, err)
+	txn.CommitTS = 11
+	tb.ApplyCommitAlter(txn)
+	db.ApplyCommitRename(txn)
+	txn = newTxn(12)
+	_, err = tb.AlterSchema(txn, NewRenameTableReq("t2"))
+	assert.Nil(t, err)
+	_, err = catalog.RenameDBEntry("db1", "db2", txn)
+	assert.Nil(t, err)
+	tb.RollbackAlter(txn)
+	db.RollbackRename(txn)
+	assert.Equal(t, 3, len(db.nameNodes))
+	assert.Equal(t, 3, len(catalog.nameNodes))
+
+	// The nodes in the lists of all the names are deleted
+	assert.Nil(t, db.RemoveEntry(tb))
+	assert.Equal(t, 0, len(db.nameNodes))
+	assert.Nil(t, catalog.RemoveEntry(db))
+	assert.Equal(t, 0, len(catalog.nameNodes))
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir := initTestPath(t)
+	catalog := MockCatalog(dir, "mock", nil)
+	txnMgr := txnbase.NewTxnManager(MockTxnStoreFactory(catalog), MockTxnFactory(catalog))
+	txnMgr.Start()
+	schemas := []*Schema{MockSchema(2), MockSchema(2), MockSchema(2)}
+	var dropped *TableEntry
+	{
+		txn := txnMgr.StartTxn(nil)
+		db, err := txn.CreateDatabase("db")
+		assert.Nil(t, err)
+		for _, schema := range schemas {
+			rel, err := db.CreateRelation(schema)
+			assert.Nil(t, err)
+			if schema == schemas[1] {
+				dropped = rel.(*mockTableHandle).entry
+			}
+		}
+		assert.Nil(t, txn.Commit())
+	}
+	{
+		txn := txnMgr.StartTxn(nil)
+		db, err := txn.GetDatabase("db")
+		assert.Nil(t, err)
+		_, err = db.DropRelationByName(schemas[1].Name)
+		assert.Nil(t, err)
+		assert.Nil(t, txn.Commit())
+	}
+	assert.Nil(t, catalog.Checkpoint(txnMgr.Clock.Last()))
+	assert.Equal(t, ErrStaleCheckpoint, catalog.Checkpoint(txnMgr.Clock.Last()))
+
+	// The drop not committed at the checkpoint ts is excluded
+	txn := txnMgr.StartTxn(nil)
+	db, err := txn.GetDatabase("db")
+	assert.Nil(t, err)
+	_, err = db.DropRelationByName(schemas[2].Name)
+	assert.Nil(t, err)
+	ckpTs := txnMgr.Clock.Last()
+	snap := &catalogSnapshot{
+		ts:  ckpTs,
+		db:  catalog.CurrDB(),
+		tbl: catalog.CurrTable(),
+		cmd: catalog.collectSnapshotCmds(ckpTs),
+	}
+	// A create cmd of the database and the tables, and a drop cmd of the
+	// dropped table
+	assert.Equal(t, 5, len(snap.cmd.Cmds))
+	assert.Nil(t, catalog.Checkpoint(ckpTs))
+	assert.Nil(t, txn.Commit())
+	txnMgr.Stop()
+	catalog.Close()
+
+	var w bytes.Buffer
+	assert.Nil(t, snap.WriteTo(&w))
+	replayedSnap := new(catalogSnapshot)
+	assert.Nil(t, replayedSnap.ReadFrom(bytes.NewBuffer(w.Bytes())))
+	assert.Equal(t, snap.ts, replayedSnap.ts)
+	assert.Equal(t, snap.tbl, replayedSnap.tbl)
+	assert.Equal(t, len(snap.cmd.Cmds), len(replayedSnap.cmd.Cmds))
+
+	replayed := MockCatalog(dir, "mock", nil)
+	defer replayed.Close()
+	assert.Nil(t, replayed.ReplayCheckpoint(nil))
+	assert.Equal(t, ckpTs, replayed.GetCheckpointed())
+	assert.Equal(t, snap.tbl, replayed.CurrTable())
+	txnMgr = txnbase.NewTxnManager(MockTxnStoreFactory(replayed), MockTxnFactory(replayed))
+	txnMgr.Start()
+	defer txnMgr.Stop()
+	txn = txnMgr.StartTxn(nil)
+	dbEntry, err := replayed.GetDBEntry("db", txn)
+	assert.Nil(t, err)
+	_, err = dbEntry.GetTableEntry(schemas[0].Name, txn)
+	assert.Nil(t, err)
+	_, err = dbEntry.GetTableEntry(schemas[1].Name, txn)
+	assert.Equal(t, ErrNotFound, err)
+	_, err = dbEntry.GetTableEntry(schemas[2].Name, txn)
+	assert.Nil(t, err)
+	replayedTable, err := dbEntry.GetTableEntryByID(dropped.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, dropped.CreateAt, replayedTable.CreateAt)
+	assert.Equal(t, dropped.DeleteAt, replayedTable.DeleteAt)
+}
@@ -0,0 +1,248 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"tae/pkg/iface/txnif"
+	"tae/pkg/txn/txnbase"
+
+	"github.com/jiangxinmeng1/logstore/pkg/common"
+	"github.com/jiangxinmeng1/logstore/pkg/entry"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	GroupCatalog uint32 = entry.GTCustomizedStart + iota
+)
+
+const (
+	ETCatalogCheckpoint = entry.ETCustomizedStart + iota
+)
+
+// +----------+----------+-----------+-------------+-----------+----------------+
+// | TS (8B)  | DBID(8B) | TblID(8B) | SegID (8B)  | BlkID(8B) | <ComposedCmd>  |
+// +----------+----------+-----------+-------------+-----------+----------------+
+type catalogSnapshot struct {
+	ts                txnif.TS
+	db, tbl, seg, blk uint64
+	cmd               *txnbase.ComposedCmd
+}
+
+func (snap *catalogSnapshot) WriteTo(w io.Writer) (err error) {
+	for _, v := range []uint64{uint64(snap.ts), snap.db, snap.tbl, snap.seg, snap.blk} {
+		if err = binary.Write(w, binary.BigEndian, v); err != nil {
+			return
+		}
+	}
+	var buf []byte
+	if buf, err = snap.cmd.Marshal(); err != nil {
+		return
+	}
+	_, err = w.Write(buf)
+	return
+}
+
+func (snap *catalogSnapshot) ReadFrom(r io.Reader) (err error) {
+	for _, v := range []*uint64{(*uint64)(&snap.ts), &snap.db, &snap.tbl, &snap.seg, &snap.blk} {
+		if err = binary.Read(r, binary.BigEndian, v); err != nil {
+			return
+		}
+	}
+	var cmd txnif.TxnCmd
+	if cmd, err = txnbase.BuildCommandFrom(r); err != nil {
+		return
+	}
+	snap.cmd = cmd.(*txnbase.ComposedCmd)
+	return
+}
+
+// checkpointState returns whether the creation and the deletion of the entry
+// were committed at or before ts. A txn committing at or before ts is waited
+func (be *BaseEntry) checkpointState(ts txnif.TS) (created, dropped bool) {
+	be.RLock()
+	txn := be.Txn
+	be.RUnlock()
+	state := txnif.TxnStateActive
+	if txn != nil && txn.GetCommitTS() <= ts {
+		state = txn.GetTxnState(true)
+	}
+	be.RLock()
+	defer be.RUnlock()
+	committed := be.Txn == nil || (be.Txn == txn && state == txnif.TxnStateCommitted)
+	if be.CreateAt == 0 || be.CreateAt > ts {
+		return
+	}
+	if be.CurrOp == OpCreate && !committed {
+		return
+	}
+	created = true
+	dropped = be.DeleteAt != 0 && be.DeleteAt <= ts && committed
+	return
+}
+
+func (catalog *Catalog) collectSnapshotCmds(ts txnif.TS) *txnbase.ComposedCmd {
+	composed := txnbase.NewComposedCmd()
+	addEntry := func(entry *BaseEntry, makeCmd func(cmdType int16) *entryCmd, createType, dropType int16, children func()) {
+		created, dropped := entry.checkpointState(ts)
+		if !created {
+			return
+		}
+		composed.AddCmd(makeCmd(createType))
+		children()
+		if dropped {
+			composed.AddCmd(makeCmd(dropType))
+		}
+	}
+	dbIt := catalog.MakeDBIt(true)
+	for dbIt.Valid() {
+		db := dbIt.Get().GetPayload().(*DBEntry)
+		addEntry(db.BaseEntry, func(cmdType int16) *entryCmd {
+			return newDBCmd(0, cmdType, db)
+		}, CmdCreateDatabase, CmdDropDatabase, func() {
+			for _, v := range db.collectNames(ts) {
+				composed.AddCmd(newRenameDBCmd(0, db, v.name, v.ts))
+			}
+			tableIt := db.MakeTableIt(true)
+			for tableIt.Valid() {
+				table := tableIt.Get().GetPayload().(*TableEntry)
+				addEntry(table.BaseEntry, func(cmdType int16) *entryCmd {
+					return newTableCmd(0, cmdType, table)
+				}, CmdCreateTable, CmdDropTable, func() {
+					for _, v := range table.collectVersions(ts) {
+						composed.AddCmd(newAlterTableCmd(0, table, v.schema, v.ts))
+					}
+					// The values taken by the txns not committed yet are
+					// skipped after restart
+					if seq := table.GetSeq(); seq > 0 {
+						composed.AddCmd(newSeqCmd(0, table, seq))
+					}
+					segIt := table.MakeSegmentIt(true)
+					for segIt.Valid() {
+						segment := segIt.Get().GetPayload().(*SegmentEntry)
+						addEntry(segment.BaseEntry, func(cmdType int16) *entryCmd {
+							return newSegmentCmd(0, cmdType, segment)
+						}, CmdCreateSegment, CmdDropSegment, func() {
+							blkIt := segment.MakeBlockIt(true)
+							for blkIt.Valid() {
+								block := blkIt.Get().GetPayload().(*BlockEntry)
+								addEntry(block.BaseEntry, func(cmdType int16) *entryCmd {
+									return newBlockCmd(0, cmdType, block)
+								}, CmdCreateBlock, CmdDropBlock, func() {})
+								blkIt.Next()
+							}
+						})
+						segIt.Next()
+					}
+				})
+				tableIt.Next()
+			}
+		})
+		dbIt.Next()
+	}
+	return composed
+}
+
+// Checkpoint writes a snapshot of all the entries committed at or before ts
+// into the catalog store. All the previous snapshots are checkpointed in the
+// store and can be truncated
+func (catalog *Catalog) Checkpoint(ts txnif.TS) (err error) {
+	catalog.ckpMu.Lock()
+	defer catalog.ckpMu.Unlock()
+	if ts <= catalog.checkpointed {
+		return ErrStaleCheckpoint
+	}
+	snap := &catalogSnapshot{
+		ts:  ts,
+		db:  catalog.CurrDB(),
+		tbl: catalog.CurrTable(),
+		seg: catalog.CurrSegment(),
+		blk: catalog.CurrBlock(),
+		cmd: catalog.collectSnapshotCmds(ts),
+	}
+	var w bytes.Buffer
+	if err = snap.WriteTo(&w); err != nil {
+		return
+	}
+	e := entry.GetBase()
+	e.SetType(ETCatalogCheckpoint)
+	if err = e.Unmarshal(w.Bytes()); err != nil {
+		return
+	}
+	lsn, err := catalog.store.AppendEntry(GroupCatalog, e)
+	if err != nil {
+		return
+	}
+	if err = e.WaitDone(); err != nil {
+		return
+	}
+	catalog.checkpointed = ts
+	logrus.Infof("Catalog checkpointed at %d: LSN=%d, Cmds=%d", ts, lsn, len(snap.cmd.Cmds))
+	if lsn <= 1 {
+		return
+	}
+	ckp := entry.GetBase()
+	ckp.SetType(entry.ETCheckpoint)
+	ckp.SetInfo(&entry.Info{
+		Group: entry.GTCKp,
+		Checkpoints: []entry.CkpRanges{{
+			Group:  GroupCatalog,
+			Ranges: common.NewClosedIntervalsByInterval(&common.ClosedInterval{Start: 1, End: lsn - 1}),
+		}},
+	})
+	if _, err = catalog.store.AppendEntry(entry.GTCKp, ckp); err != nil {
+		return
+	}
+	if err = ckp.WaitDone(); err != nil {
+		return
+	}
+	return catalog.store.TryCompact()
+}
+
+// GetCheckpointed returns the ts of the newest snapshot
+func (catalog *Catalog) GetCheckpointed() txnif.TS {
+	catalog.ckpMu.RLock()
+	defer catalog.ckpMu.RUnlock()
+	return catalog.checkpointed
+}
+
+// ReplayCheckpoint rebuilds the catalog from the newest snapshot in the
+// catalog store
+func (catalog *Catalog) ReplayCheckpoint(dataFactory DataFactory) (err error) {
+	var payload []byte
+	var snapTs txnif.TS
+	if err = catalog.store.Replay(func(group uint32, _ uint64, buf []byte, typ uint16, _ interface{}) error {
+		if group != GroupCatalog || typ != ETCatalogCheckpoint || len(buf) < 8 {
+			return nil
+		}
+		if ts := txnif.UnmarshalTS(buf); ts > snapTs {
+			snapTs = ts
+			payload = make([]byte, len(buf))
+			copy(payload, buf)
+		}
+		return nil
+	}); err != nil {
+		return
+	}
+	if payload == nil {
+		return
+	}
+	snap := new(catalogSnapshot)
+	if err = snap.ReadFrom(bytes.NewBuffer(payload)); err != nil {
+		return
+	}
+	for _, cmd := range snap.cmd.Cmds {
+		if err = catalog.ReplayCmd(cmd, dataFactory); err != nil {
+			return
+		}
+	}
+	catalog.OnReplayDBID(snap.db)
+	catalog.OnReplayTableID(snap.tbl)
+	catalog.OnReplaySegmentID(snap.seg)
+	catalog.OnReplayBlockID(snap.blk)
+	catalog.ckpMu.Lock()
+	catalog.checkpointed = snap.ts
+	catalog.ckpMu.Unlock()
+	logrus.Infof("Replayed catalog checkpoint at %d: Cmds=%d", snap.ts, len(snap.cmd.Cmds))
+	return
+}
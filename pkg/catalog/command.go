@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"io"
+	"sync"
 	"tae/pkg/common"
 	"tae/pkg/iface/txnif"
 	"tae/pkg/txn/txnbase"
@@ -18,6 +19,9 @@ const (
 	CmdDropSegment
 	CmdCreateBlock
 	CmdDropBlock
+	CmdAlterTable
+	CmdUpdateSeq
+	CmdRenameDatabase
 )
 
 func init() {
@@ -45,6 +49,15 @@ func init() {
 	txnif.RegisterCmdFactory(CmdDropBlock, func(cmdType int16) txnif.TxnCmd {
 		return newEmptyEntryCmd(cmdType)
 	})
+	txnif.RegisterCmdFactory(CmdAlterTable, func(cmdType int16) txnif.TxnCmd {
+		return newEmptyEntryCmd(cmdType)
+	})
+	txnif.RegisterCmdFactory(CmdUpdateSeq, func(cmdType int16) txnif.TxnCmd {
+		return newEmptyEntryCmd(cmdType)
+	})
+	txnif.RegisterCmdFactory(CmdRenameDatabase, func(cmdType int16) txnif.TxnCmd {
+		return newEmptyEntryCmd(cmdType)
+	})
 }
 
 type entryCmd struct {
@@ -55,6 +68,15 @@ type entryCmd struct {
 	segment *SegmentEntry
 	block   *BlockEntry
 	cmdType int16
+	// schema is the first schema version of a created table or the version
+	// of an alter committed at alterTS
+	schema  *Schema
+	alterTS txnif.TS
+	// name is the first name of a created database or the name given by a
+	// rename committed at alterTS
+	name string
+	// seq is the high-water mark of the auto-increment values of a table
+	seq uint64
 }
 
 func newEmptyEntryCmd(cmdType int16) *entryCmd {
@@ -92,6 +114,32 @@ func newTableCmd(id uint32, cmdType int16, entry *TableEntry) *entryCmd {
 		table:   entry,
 		cmdType: cmdType,
 		entry:   entry.BaseEntry,
+		schema:  entry.versions[0].schema,
+	}
+	impl.BaseCustomizedCmd = txnbase.NewBaseCustomizedCmd(id, impl)
+	return impl
+}
+
+func newAlterTableCmd(id uint32, entry *TableEntry, schema *Schema, ts txnif.TS) *entryCmd {
+	impl := &entryCmd{
+		db:      entry.GetDB(),
+		table:   entry,
+		cmdType: CmdAlterTable,
+		entry:   entry.BaseEntry,
+		schema:  schema,
+		alterTS: ts,
+	}
+	impl.BaseCustomizedCmd = txnbase.NewBaseCustomizedCmd(id, impl)
+	return impl
+}
+
+func newSeqCmd(id uint32, entry *TableEntry, seq uint64) *entryCmd {
+	impl := &entryCmd{
+		db:      entry.GetDB(),
+		table:   entry,
+		cmdType: CmdUpdateSeq,
+		entry:   entry.BaseEntry,
+		seq:     seq,
 	}
 	impl.BaseCustomizedCmd = txnbase.NewBaseCustomizedCmd(id, impl)
 	return impl
@@ -104,6 +152,19 @@ func newDBCmd(id uint32, cmdType int16, entry *DBEntry) *entryCmd {
 	}
 	if entry != nil {
 		impl.entry = entry.BaseEntry
+		impl.name = entry.names[0].name
+	}
+	impl.BaseCustomizedCmd = txnbase.NewBaseCustomizedCmd(id, impl)
+	return impl
+}
+
+func newRenameDBCmd(id uint32, entry *DBEntry, name string, ts txnif.TS) *entryCmd {
+	impl := &entryCmd{
+		db:      entry,
+		cmdType: CmdRenameDatabase,
+		entry:   entry.BaseEntry,
+		alterTS: ts,
+		name:    name,
 	}
 	impl.BaseCustomizedCmd = txnbase.NewBaseCustomizedCmd(id, impl)
 	return impl
@@ -130,7 +191,14 @@ func (cmd *entryCmd) WriteTo(w io.Writer) (err error) {
 		if err = binary.Write(w, binary.BigEndian, cmd.entry.CreateAt); err != nil {
 			return
 		}
-		if _, err = common.WriteString(cmd.db.name, w); err != nil {
+		if _, err = common.WriteString(cmd.name, w); err != nil {
+			return
+		}
+	case CmdRenameDatabase:
+		if err = binary.Write(w, binary.BigEndian, cmd.alterTS); err != nil {
+			return
+		}
+		if _, err = common.WriteString(cmd.name, w); err != nil {
 			return
 		}
 	case CmdCreateTable:
@@ -141,12 +209,33 @@ func (cmd *entryCmd) WriteTo(w io.Writer) (err error) {
 			return
 		}
 		var schemaBuf []byte
-		if schemaBuf, err = cmd.table.schema.Marshal(); err != nil {
+		if schemaBuf, err = cmd.schema.Marshal(); err != nil {
 			return
 		}
 		if _, err = w.Write(schemaBuf); err != nil {
 			return
 		}
+	case CmdAlterTable:
+		if err = binary.Write(w, binary.BigEndian, cmd.table.db.ID); err != nil {
+			return
+		}
+		if err = binary.Write(w, binary.BigEndian, cmd.alterTS); err != nil {
+			return
+		}
+		var schemaBuf []byte
+		if schemaBuf, err = cmd.schema.Marshal(); err != nil {
+			return
+		}
+		if _, err = w.Write(schemaBuf); err != nil {
+			return
+		}
+	case CmdUpdateSeq:
+		if err = binary.Write(w, binary.BigEndian, cmd.table.db.ID); err != nil {
+			return
+		}
+		if err = binary.Write(w, binary.BigEndian, cmd.seq); err != nil {
+			return
+		}
 	case CmdCreateSegment:
 		if err = binary.Write(w, binary.BigEndian, cmd.db.ID); err != nil {
 			return
@@ -157,6 +246,12 @@ func (cmd *entryCmd) WriteTo(w io.Writer) (err error) {
 		if err = binary.Write(w, binary.BigEndian, cmd.entry.CreateAt); err != nil {
 			return
 		}
+		if err = binary.Write(w, binary.BigEndian, cmd.segment.state); err != nil {
+			return
+		}
+		if err = binary.Write(w, binary.BigEndian, cmd.segment.schema.Version); err != nil {
+			return
+		}
 	case CmdCreateBlock:
 		if err = binary.Write(w, binary.BigEndian, cmd.db.ID); err != nil {
 			return
@@ -170,6 +265,32 @@ func (cmd *entryCmd) WriteTo(w io.Writer) (err error) {
 		if err = binary.Write(w, binary.BigEndian, cmd.entry.CreateAt); err != nil {
 			return
 		}
+		if err = binary.Write(w, binary.BigEndian, cmd.block.state); err != nil {
+			return
+		}
+	case CmdDropSegment:
+		if err = binary.Write(w, binary.BigEndian, cmd.db.ID); err != nil {
+			return
+		}
+		if err = binary.Write(w, binary.BigEndian, cmd.table.ID); err != nil {
+			return
+		}
+		if err = binary.Write(w, binary.BigEndian, cmd.entry.DeleteAt); err != nil {
+			return
+		}
+	case CmdDropBlock:
+		if err = binary.Write(w, binary.BigEndian, cmd.db.ID); err != nil {
+			return
+		}
+		if err = binary.Write(w, binary.BigEndian, cmd.table.ID); err != nil {
+			return
+		}
+		if err = binary.Write(w, binary.BigEndian, cmd.segment.ID); err != nil {
+			return
+		}
+		if err = binary.Write(w, binary.BigEndian, cmd.entry.DeleteAt); err != nil {
+			return
+		}
 	case CmdDropTable:
 		if err = binary.Write(w, binary.BigEndian, cmd.table.db.ID); err != nil {
 			return
@@ -208,7 +329,18 @@ func (cmd *entryCmd) ReadFrom(r io.Reader) (err error) {
 		cmd.db = &DBEntry{
 			BaseEntry: cmd.entry,
 		}
-		if cmd.db.name, err = common.ReadString(r); err != nil {
+		if cmd.name, err = common.ReadString(r); err != nil {
+			return
+		}
+		cmd.db.name = cmd.name
+	case CmdRenameDatabase:
+		cmd.db = &DBEntry{
+			BaseEntry: cmd.entry,
+		}
+		if err = binary.Read(r, binary.BigEndian, &cmd.alterTS); err != nil {
+			return
+		}
+		if cmd.name, err = common.ReadString(r); err != nil {
 			return
 		}
 	case CmdCreateTable:
@@ -219,11 +351,35 @@ func (cmd *entryCmd) ReadFrom(r io.Reader) (err error) {
 		if err = binary.Read(r, binary.BigEndian, &cmd.entry.CreateAt); err != nil {
 			return
 		}
+		cmd.schema = new(Schema)
+		if err = cmd.schema.ReadFrom(r); err != nil {
+			return
+		}
+		// The schema of a table entry is read under its lock
+		cmd.entry.RWMutex = new(sync.RWMutex)
 		cmd.table = &TableEntry{
 			BaseEntry: cmd.entry,
-			schema:    new(Schema),
+			schema:    cmd.schema,
+			versions:  []*schemaVersion{{schema: cmd.schema}},
 		}
-		if err = cmd.table.schema.ReadFrom(r); err != nil {
+	case CmdAlterTable:
+		cmd.db = &DBEntry{BaseEntry: &BaseEntry{}}
+		if err = binary.Read(r, binary.BigEndian, &cmd.db.ID); err != nil {
+			return
+		}
+		if err = binary.Read(r, binary.BigEndian, &cmd.alterTS); err != nil {
+			return
+		}
+		cmd.schema = new(Schema)
+		if err = cmd.schema.ReadFrom(r); err != nil {
+			return
+		}
+	case CmdUpdateSeq:
+		cmd.db = &DBEntry{BaseEntry: &BaseEntry{}}
+		if err = binary.Read(r, binary.BigEndian, &cmd.db.ID); err != nil {
+			return
+		}
+		if err = binary.Read(r, binary.BigEndian, &cmd.seq); err != nil {
 			return
 		}
 	case CmdCreateSegment:
@@ -240,6 +396,13 @@ func (cmd *entryCmd) ReadFrom(r io.Reader) (err error) {
 		}
 		cmd.segment = &SegmentEntry{
 			BaseEntry: cmd.entry,
+			schema:    new(Schema),
+		}
+		if err = binary.Read(r, binary.BigEndian, &cmd.segment.state); err != nil {
+			return
+		}
+		if err = binary.Read(r, binary.BigEndian, &cmd.segment.schema.Version); err != nil {
+			return
 		}
 	case CmdCreateBlock:
 		cmd.db = &DBEntry{BaseEntry: &BaseEntry{}}
@@ -257,9 +420,40 @@ func (cmd *entryCmd) ReadFrom(r io.Reader) (err error) {
 		if err = binary.Read(r, binary.BigEndian, &cmd.entry.CreateAt); err != nil {
 			return
 		}
-		cmd.segment = &SegmentEntry{
+		cmd.block = &BlockEntry{
 			BaseEntry: cmd.entry,
 		}
+		if err = binary.Read(r, binary.BigEndian, &cmd.block.state); err != nil {
+			return
+		}
+	case CmdDropSegment:
+		cmd.db = &DBEntry{BaseEntry: &BaseEntry{}}
+		cmd.table = &TableEntry{BaseEntry: &BaseEntry{}}
+		if err = binary.Read(r, binary.BigEndian, &cmd.db.ID); err != nil {
+			return
+		}
+		if err = binary.Read(r, binary.BigEndian, &cmd.table.ID); err != nil {
+			return
+		}
+		if err = binary.Read(r, binary.BigEndian, &cmd.entry.DeleteAt); err != nil {
+			return
+		}
+	case CmdDropBlock:
+		cmd.db = &DBEntry{BaseEntry: &BaseEntry{}}
+		cmd.table = &TableEntry{BaseEntry: &BaseEntry{}}
+		cmd.segment = &SegmentEntry{BaseEntry: &BaseEntry{}}
+		if err = binary.Read(r, binary.BigEndian, &cmd.db.ID); err != nil {
+			return
+		}
+		if err = binary.Read(r, binary.BigEndian, &cmd.table.ID); err != nil {
+			return
+		}
+		if err = binary.Read(r, binary.BigEndian, &cmd.segment.ID); err != nil {
+			return
+		}
+		if err = binary.Read(r, binary.BigEndian, &cmd.entry.DeleteAt); err != nil {
+			return
+		}
 	case CmdDropTable:
 		cmd.db = &DBEntry{BaseEntry: &BaseEntry{}}
 		if err = binary.Read(r, binary.BigEndian, &cmd.db.ID); err != nil {
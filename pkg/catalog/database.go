@@ -13,7 +13,15 @@ type DBEntry struct {
 	// *BaseEntry
 	*BaseEntry
 	catalog *Catalog
-	name    string
+	// name is the latest committed name
+	name  string
+	names []*dbName
+	// rename is the name given by an uncommitted txn
+	rename    string
+	renameTxn txnif.TxnReader
+	// nameLists are the names of the lists of the catalog holding a node of
+	// the database, guarded by the lock of the catalog
+	nameLists []string
 
 	entries   map[uint64]*common.DLNode
 	nameNodes map[string]*nodeList
@@ -35,6 +43,7 @@ func NewDBEntry(catalog *Catalog, name string, txnCtx txnif.AsyncTxn) *DBEntry {
 		},
 		catalog:   catalog,
 		name:      name,
+		names:     []*dbName{{name: name}},
 		entries:   make(map[uint64]*common.DLNode),
 		nameNodes: make(map[string]*nodeList),
 		link:      new(common.Link),
@@ -144,46 +153,20 @@ func (e *DBEntry) RemoveEntry(table *TableEntry) error {
 	if n, ok := e.entries[table.GetID()]; !ok {
 		return ErrNotFound
 	} else {
-		nn := e.nameNodes[table.GetSchema().Name]
-		nn.DeleteNode(table.GetID())
+		deleteNameNodes(e.nameNodes, table.GetID(), table.nameLists)
 		e.link.Delete(n)
+		delete(e.entries, table.GetID())
 	}
 	return nil
 }
 
 func (e *DBEntry) addEntryLocked(table *TableEntry) error {
-	nn := e.nameNodes[table.schema.Name]
-	if nn == nil {
-		n := e.link.Insert(table)
-		e.entries[table.GetID()] = n
-
-		nn := newNodeList(e, &e.nodesMu, table.schema.Name)
-		e.nameNodes[table.schema.Name] = nn
-
-		nn.CreateNode(table.GetID())
-	} else {
-		node := nn.GetTableNode()
-		record := node.GetPayload().(*TableEntry)
-		record.RLock()
-		err := record.PrepareWrite(table.GetTxn(), record.RWMutex)
-		if err != nil {
-			record.RUnlock()
-			return err
-		}
-		if record.HasActiveTxn() {
-			if !record.IsDroppedUncommitted() {
-				record.RUnlock()
-				return ErrDuplicate
-			}
-		} else if !record.HasDropped() {
-			record.RUnlock()
-			return ErrDuplicate
-		}
-		record.RUnlock()
-		n := e.link.Insert(table)
-		e.entries[table.GetID()] = n
-		nn.CreateNode(table.GetID())
+	if err := e.checkTableNameLocked(table.schema.Name, table.GetTxn()); err != nil {
+		return err
 	}
+	n := e.link.Insert(table)
+	e.entries[table.GetID()] = n
+	addNameNode(e.nameNodes, e, &e.nodesMu, table.schema.Name, table.GetID(), &table.nameLists)
 	return nil
 }
 
@@ -7,4 +7,10 @@ var (
 	ErrDuplicate = errors.New("tae catalog: duplicate")
 
 	ErrValidation = errors.New("tae catalog: validataion")
+
+	ErrStaleCheckpoint = errors.New("tae catalog: stale checkpoint")
+
+	ErrDropPrimaryKey = errors.New("tae catalog: drop primary key")
+
+	ErrReadOnly = errors.New("tae catalog: read only")
 )
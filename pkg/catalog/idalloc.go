@@ -34,3 +34,14 @@ func (alloc *IDAlloctor) CurrDB() uint64      { return alloc.dbAlloc.Get() }
 func (alloc *IDAlloctor) CurrTable() uint64   { return alloc.tblAlloc.Get() }
 func (alloc *IDAlloctor) CurrSegment() uint64 { return alloc.segAlloc.Get() }
 func (alloc *IDAlloctor) CurrBlock() uint64   { return alloc.blkAlloc.Get() }
+
+func (alloc *IDAlloctor) OnReplayDBID(id uint64)      { onReplayID(alloc.dbAlloc, id) }
+func (alloc *IDAlloctor) OnReplayTableID(id uint64)   { onReplayID(alloc.tblAlloc, id) }
+func (alloc *IDAlloctor) OnReplaySegmentID(id uint64) { onReplayID(alloc.segAlloc, id) }
+func (alloc *IDAlloctor) OnReplayBlockID(id uint64)   { onReplayID(alloc.blkAlloc, id) }
+
+func onReplayID(alloc *common.IdAlloctor, id uint64) {
+	if alloc.Get() < id {
+		alloc.SetStart(id)
+	}
+}
@@ -7,7 +7,7 @@ import (
 )
 
 func MockTxnFactory(catalog *Catalog) txnbase.TxnFactory {
-	return func(mgr *txnbase.TxnManager, store txnif.TxnStore, id uint64, ts uint64, info []byte) txnif.AsyncTxn {
+	return func(mgr *txnbase.TxnManager, store txnif.TxnStore, id uint64, ts txnif.TS, info []byte) txnif.AsyncTxn {
 		txn := new(mockTxn)
 		txn.Txn = txnbase.NewTxn(mgr, store, id, ts, info)
 		txn.catalog = catalog
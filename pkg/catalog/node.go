@@ -62,6 +62,14 @@ func (n *nodeList) DeleteNode(id uint64) (deleted *nameNode, empty bool) {
 	return
 }
 
+// IsNewest returns true if the newest node of the list is of id
+func (n *nodeList) IsNewest(id uint64) bool {
+	n.rwlocker.RLock()
+	defer n.rwlocker.RUnlock()
+	curr := n.GetNext()
+	return curr != nil && curr.(*nameNode).Id == id
+}
+
 func (n *nodeList) ForEachNodes(fn func(*nameNode) bool) {
 	n.rwlocker.RLock()
 	defer n.rwlocker.RUnlock()
@@ -127,6 +135,10 @@ func (n *nodeList) TxnGetTableNodeLocked(txnCtx txnif.TxnReader) *common.DLNode
 	fn := func(nn *nameNode) (goNext bool) {
 		dlNode := nn.GetTableNode()
 		entry := dlNode.GetPayload().(*TableEntry)
+		// The entry is renamed to or from the name after the txn started
+		if entry.GetSchemaFor(txnCtx).Name != n.name {
+			return true
+		}
 		entry.RLock()
 		goNext = true
 		// A txn is writing the entry
@@ -191,8 +203,10 @@ func (n *nodeList) TxnGetTableNodeLocked(txnCtx txnif.TxnReader) *common.DLNode
 				entry.RUnlock()
 				return true
 			} else if entry.DeleteBefore(txnCtx.GetStartTS()) {
+				// An entry renamed to the name can be behind the dropped
+				// one after the catalog is replayed from a checkpoint
 				entry.RUnlock()
-				return false
+				return true
 			} else {
 				entry.RUnlock()
 				dn = dlNode
@@ -211,6 +225,9 @@ func (n *nodeList) TxnGetDBNodeLocked(txnCtx txnif.TxnReader) *common.DLNode {
 	fn := func(nn *nameNode) (goNext bool) {
 		dlNode := nn.GetDBNode()
 		entry := dlNode.GetPayload().(*DBEntry)
+		if entry.GetNameFor(txnCtx) != n.name {
+			return true
+		}
 		entry.RLock()
 		goNext = true
 		if entry.HasActiveTxn() {
@@ -265,7 +282,7 @@ func (n *nodeList) TxnGetDBNodeLocked(txnCtx txnif.TxnReader) *common.DLNode {
 				return true
 			} else if entry.DeleteBefore(txnCtx.GetStartTS()) {
 				entry.RUnlock()
-				return false
+				return true
 			} else {
 				entry.RUnlock()
 				dn = dlNode
@@ -301,6 +318,45 @@ func (n *nodeList) PString(level common.PPLevel) string {
 	return s
 }
 
+// addNameNode adds a node of id to the list of name in lists. An entry taking
+// a name again is not added if it is still the newest one of the list. held
+// records the names of the lists holding a node of id
+func addNameNode(lists map[string]*nodeList, host interface{}, rwlocker *sync.RWMutex, name string, id uint64, held *[]string) {
+	nn := lists[name]
+	if nn == nil {
+		nn = newNodeList(host, rwlocker, name)
+		lists[name] = nn
+	} else if nn.IsNewest(id) {
+		return
+	}
+	nn.CreateNode(id)
+	for _, v := range *held {
+		if v == name {
+			return
+		}
+	}
+	*held = append(*held, name)
+}
+
+// deleteNameNodes deletes the nodes of id from the lists of names, which
+// are all the lists holding a node of id
+func deleteNameNodes(lists map[string]*nodeList, id uint64, names []string) {
+	for _, name := range names {
+		nn := lists[name]
+		if nn == nil {
+			continue
+		}
+		for {
+			if deleted, _ := nn.DeleteNode(id); deleted == nil {
+				break
+			}
+		}
+		if nn.Length() == 0 {
+			delete(lists, name)
+		}
+	}
+}
+
 type nameNode struct {
 	common.SSLLNode
 	Id   uint64
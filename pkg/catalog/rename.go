@@ -0,0 +1,288 @@
+package catalog
+
+import (
+	"tae/pkg/iface/txnif"
+)
+
+// dbName is a name of a database committed at ts
+type dbName struct {
+	name string
+	ts   txnif.TS
+}
+
+// GetNameFor returns the name of the database visible to txn, which is the
+// one given by txn or the last one committed before txn started. A rename
+// committing before txn started is waited
+func (e *DBEntry) GetNameFor(txn txnif.TxnReader) string {
+	e.RLock()
+	defer e.RUnlock()
+	if txn == nil {
+		return e.name
+	}
+	for e.renameTxn != nil {
+		renameTxn := e.renameTxn
+		if renameTxn.GetID() == txn.GetID() {
+			return e.rename
+		}
+		if renameTxn.GetCommitTS() > txn.GetStartTS() {
+			break
+		}
+		e.RUnlock()
+		renameTxn.GetTxnState(true)
+		e.RLock()
+		if e.renameTxn == renameTxn {
+			break
+		}
+	}
+	for i := len(e.names) - 1; i > 0; i-- {
+		if e.names[i].ts <= txn.GetStartTS() {
+			return e.names[i].name
+		}
+	}
+	return e.names[0].name
+}
+
+// prepareRename gives the database newName for txn. Only one txn can rename
+// the database at a time, and it should see the latest committed name
+func (e *DBEntry) prepareRename(txn txnif.TxnReader, newName string) error {
+	e.Lock()
+	defer e.Unlock()
+	if e.Txn != nil && !e.IsSameTxn(txn) {
+		return txnif.TxnWWConflictErr
+	}
+	if e.renameTxn != nil {
+		if e.renameTxn.GetID() != txn.GetID() {
+			return txnif.TxnWWConflictErr
+		}
+	} else if e.names[len(e.names)-1].ts > txn.GetStartTS() {
+		return txnif.TxnWWConflictErr
+	}
+	e.rename = newName
+	e.renameTxn = txn
+	return nil
+}
+
+// ApplyCommitRename installs the name given by txn
+func (e *DBEntry) ApplyCommitRename(txn txnif.TxnReader) {
+	e.Lock()
+	defer e.Unlock()
+	if e.renameTxn == nil || e.renameTxn.GetID() != txn.GetID() {
+		return
+	}
+	e.names = append(e.names, &dbName{
+		name: e.rename,
+		ts:   txn.GetCommitTS(),
+	})
+	e.name = e.rename
+	e.rename = ""
+	e.renameTxn = nil
+}
+
+// RollbackRename drops the name given by txn. The node of the name is kept,
+// as no txn finds the database by a name it never had
+func (e *DBEntry) RollbackRename(txn txnif.TxnReader) {
+	e.Lock()
+	defer e.Unlock()
+	if e.renameTxn == nil || e.renameTxn.GetID() != txn.GetID() {
+		return
+	}
+	e.rename = ""
+	e.renameTxn = nil
+}
+
+// RestoreRename restores the name given by txn to name, which was given by
+// txn before
+func (e *DBEntry) RestoreRename(txn txnif.TxnReader, name string) {
+	e.Lock()
+	defer e.Unlock()
+	if e.renameTxn == nil || e.renameTxn.GetID() != txn.GetID() {
+		return
+	}
+	e.rename = name
+}
+
+// MakeRenameCommand makes the command of the name given by the committing txn
+func (e *DBEntry) MakeRenameCommand(id uint32) (cmd txnif.TxnCmd, err error) {
+	e.RLock()
+	defer e.RUnlock()
+	if e.renameTxn == nil {
+		return nil, ErrNotFound
+	}
+	return newRenameDBCmd(id, e, e.rename, e.renameTxn.GetCommitTS()), nil
+}
+
+// replayRename installs a name committed at ts
+func (e *DBEntry) replayRename(name string, ts txnif.TS) {
+	e.Lock()
+	defer e.Unlock()
+	e.names = append(e.names, &dbName{
+		name: name,
+		ts:   ts,
+	})
+	e.name = name
+}
+
+// collectNames returns the names given at or before ts. A rename committing
+// at or before ts is waited
+func (e *DBEntry) collectNames(ts txnif.TS) []*dbName {
+	e.RLock()
+	renameTxn := e.renameTxn
+	e.RUnlock()
+	if renameTxn != nil && renameTxn.GetCommitTS() <= ts {
+		renameTxn.GetTxnState(true)
+	}
+	e.RLock()
+	defer e.RUnlock()
+	names := make([]*dbName, 0)
+	for _, v := range e.names[1:] {
+		if v.ts <= ts {
+			names = append(names, v)
+		}
+	}
+	return names
+}
+
+// holdsNameLocked returns true if the database holds name for txn to take
+// it. A rename of another txn not committed, or committed after txn started,
+// is a conflict
+func (e *DBEntry) holdsNameLocked(name string, txn txnif.TxnReader) (bool, error) {
+	if e.renameTxn != nil {
+		if e.renameTxn.GetID() != txn.GetID() {
+			return false, txnif.TxnWWConflictErr
+		}
+		return e.rename == name, nil
+	}
+	if e.names[len(e.names)-1].ts > txn.GetStartTS() {
+		return false, txnif.TxnWWConflictErr
+	}
+	return e.name == name, nil
+}
+
+func (e *DBEntry) checkNameWrite(name string, txn txnif.TxnReader) (err error) {
+	e.RLock()
+	defer e.RUnlock()
+	holds, err := e.holdsNameLocked(name, txn)
+	if err != nil || !holds {
+		return
+	}
+	return e.checkTakenLocked(txn)
+}
+
+// holdsNameLocked returns true if the table holds name for txn to take it.
+// A rename of another txn not committed, or committed after txn started, is
+// a conflict
+func (entry *TableEntry) holdsNameLocked(name string, txn txnif.TxnReader) (bool, error) {
+	if entry.alter != nil && entry.alter.Name != entry.schema.Name {
+		if entry.alterTxn.GetID() != txn.GetID() {
+			return false, txnif.TxnWWConflictErr
+		}
+		return entry.alter.Name == name, nil
+	}
+	for i := len(entry.versions) - 1; i > 0 && entry.versions[i].ts > txn.GetStartTS(); i-- {
+		if entry.versions[i].schema.Name != entry.versions[i-1].schema.Name {
+			return false, txnif.TxnWWConflictErr
+		}
+	}
+	return entry.schema.Name == name, nil
+}
+
+func (entry *TableEntry) checkNameWrite(name string, txn txnif.TxnReader) (err error) {
+	entry.RLock()
+	defer entry.RUnlock()
+	holds, err := entry.holdsNameLocked(name, txn)
+	if err != nil || !holds {
+		return
+	}
+	return entry.checkTakenLocked(txn)
+}
+
+// checkTakenLocked returns ErrDuplicate if the entry holding a name is not
+// dropped for txn, or txnif.TxnWWConflictErr if another txn is writing it
+func (be *BaseEntry) checkTakenLocked(txn txnif.TxnReader) error {
+	if err := be.PrepareWrite(txn, be.RWMutex); err != nil {
+		return err
+	}
+	if be.HasActiveTxn() {
+		if !be.IsDroppedUncommitted() {
+			return ErrDuplicate
+		}
+	} else if !be.HasDropped() {
+		return ErrDuplicate
+	}
+	return nil
+}
+
+// checkTableNameLocked checks txn can take name for a created or renamed
+// table. A table renamed to the name can be behind the one dropped after a
+// replay, so all the tables in the list of the name are checked
+func (e *DBEntry) checkTableNameLocked(name string, txn txnif.TxnReader) (err error) {
+	nn := e.nameNodes[name]
+	// The names replayed were checked when they were committed
+	if nn == nil || txn == nil {
+		return
+	}
+	nn.ForEachNodes(func(node *nameNode) bool {
+		table := node.GetTableNode().GetPayload().(*TableEntry)
+		err = table.checkNameWrite(name, txn)
+		return err == nil
+	})
+	return
+}
+
+// renameTableEntry renames table by req for txn. The table keeps its node in
+// the list of the previous name for the txns started before the rename
+func (e *DBEntry) renameTableEntry(table *TableEntry, txn txnif.TxnReader, req *AlterTableReq) (schema *Schema, err error) {
+	e.Lock()
+	defer e.Unlock()
+	if err = e.checkTableNameLocked(req.NewName, txn); err != nil {
+		return
+	}
+	table.Lock()
+	schema, err = table.alterSchemaLocked(txn, req)
+	table.Unlock()
+	if err != nil {
+		return
+	}
+	addNameNode(e.nameNodes, e, &e.nodesMu, req.NewName, table.GetID(), &table.nameLists)
+	return
+}
+
+func (catalog *Catalog) checkDBNameLocked(name string, txn txnif.TxnReader) (err error) {
+	nn := catalog.nameNodes[name]
+	if nn == nil || txn == nil {
+		return
+	}
+	nn.ForEachNodes(func(node *nameNode) bool {
+		db := node.GetDBNode().GetPayload().(*DBEntry)
+		err = db.checkNameWrite(name, txn)
+		return err == nil
+	})
+	return
+}
+
+// RenameDBEntry renames the database of name visible to txnCtx. The database
+// keeps its node in the list of the previous name for the txns started
+// before the rename
+func (catalog *Catalog) RenameDBEntry(name, newName string, txnCtx txnif.AsyncTxn) (entry *DBEntry, err error) {
+	if newName == "" {
+		return nil, ErrValidation
+	}
+	if newName == SystemDBName {
+		return nil, ErrDuplicate
+	}
+	catalog.Lock()
+	defer catalog.Unlock()
+	dn := catalog.txnGetNodeByNameLocked(name, txnCtx)
+	if dn == nil {
+		return nil, ErrNotFound
+	}
+	if err = catalog.checkDBNameLocked(newName, txnCtx); err != nil {
+		return nil, err
+	}
+	entry = dn.GetPayload().(*DBEntry)
+	if err = entry.prepareRename(txnCtx, newName); err != nil {
+		return nil, err
+	}
+	addNameNode(catalog.nameNodes, catalog, &catalog.nodesMu, newName, entry.GetID(), &entry.nameLists)
+	return
+}
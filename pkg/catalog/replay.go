@@ -0,0 +1,247 @@
+package catalog
+
+import (
+	"sync"
+	"tae/pkg/common"
+	"tae/pkg/iface/txnif"
+)
+
+func (catalog *Catalog) ReplayCmd(txncmd txnif.TxnCmd, dataFactory DataFactory) (err error) {
+	cmd := txncmd.(*entryCmd)
+	switch cmd.GetType() {
+	case CmdCreateDatabase:
+		err = catalog.onReplayCreateDB(cmd)
+	case CmdDropDatabase:
+		err = catalog.onReplayDropDB(cmd)
+	case CmdCreateTable:
+		err = catalog.onReplayCreateTable(cmd, dataFactory)
+	case CmdDropTable:
+		err = catalog.onReplayDropTable(cmd)
+	case CmdCreateSegment:
+		err = catalog.onReplayCreateSegment(cmd, dataFactory)
+	case CmdDropSegment:
+		err = catalog.onReplayDropSegment(cmd)
+	case CmdCreateBlock:
+		err = catalog.onReplayCreateBlock(cmd, dataFactory)
+	case CmdDropBlock:
+		err = catalog.onReplayDropBlock(cmd)
+	case CmdAlterTable:
+		err = catalog.onReplayAlterTable(cmd)
+	case CmdUpdateSeq:
+		err = catalog.onReplayUpdateSeq(cmd)
+	case CmdRenameDatabase:
+		err = catalog.onReplayRenameDB(cmd)
+	default:
+		panic("unsupported")
+	}
+	return
+}
+
+func newReplayBaseEntry(logged *BaseEntry) *BaseEntry {
+	return &BaseEntry{
+		RWMutex:    new(sync.RWMutex),
+		CommitInfo: CommitInfo{CurrOp: OpCreate},
+		ID:         logged.ID,
+		CreateAt:   logged.CreateAt,
+	}
+}
+
+func replayDrop(entry *BaseEntry, logged *BaseEntry) {
+	entry.Lock()
+	defer entry.Unlock()
+	entry.CurrOp = OpSoftDelete
+	entry.DeleteAt = logged.DeleteAt
+}
+
+func (catalog *Catalog) onReplayCreateDB(cmd *entryCmd) error {
+	db := &DBEntry{
+		BaseEntry: newReplayBaseEntry(cmd.entry),
+		catalog:   catalog,
+		name:      cmd.db.name,
+		names:     []*dbName{{name: cmd.db.name}},
+		entries:   make(map[uint64]*common.DLNode),
+		nameNodes: make(map[string]*nodeList),
+		link:      new(common.Link),
+	}
+	catalog.Lock()
+	err := catalog.addEntryLocked(db)
+	catalog.Unlock()
+	if err != nil {
+		return err
+	}
+	catalog.OnReplayDBID(db.ID)
+	return nil
+}
+
+func (catalog *Catalog) onReplayDropDB(cmd *entryCmd) error {
+	db, err := catalog.GetDatabaseByID(cmd.entry.ID)
+	if err != nil {
+		return err
+	}
+	replayDrop(db.BaseEntry, cmd.entry)
+	return nil
+}
+
+func (catalog *Catalog) onReplayRenameDB(cmd *entryCmd) error {
+	db, err := catalog.GetDatabaseByID(cmd.entry.ID)
+	if err != nil {
+		return err
+	}
+	db.replayRename(cmd.name, cmd.alterTS)
+	catalog.Lock()
+	addNameNode(catalog.nameNodes, catalog, &catalog.nodesMu, cmd.name, db.ID, &db.nameLists)
+	catalog.Unlock()
+	return nil
+}
+
+func (catalog *Catalog) onReplayCreateTable(cmd *entryCmd, dataFactory DataFactory) error {
+	db, err := catalog.GetDatabaseByID(cmd.db.ID)
+	if err != nil {
+		return err
+	}
+	table := &TableEntry{
+		BaseEntry: newReplayBaseEntry(cmd.entry),
+		db:        db,
+		schema:    cmd.schema,
+		versions:  []*schemaVersion{{schema: cmd.schema}},
+		link:      new(common.Link),
+		entries:   make(map[uint64]*common.DLNode),
+	}
+	if dataFactory != nil {
+		table.tableData = dataFactory.MakeTableFactory()(table)
+	}
+	db.Lock()
+	err = db.addEntryLocked(table)
+	db.Unlock()
+	if err != nil {
+		return err
+	}
+	catalog.OnReplayTableID(table.ID)
+	return nil
+}
+
+func (catalog *Catalog) onReplayDropTable(cmd *entryCmd) error {
+	db, err := catalog.GetDatabaseByID(cmd.db.ID)
+	if err != nil {
+		return err
+	}
+	table, err := db.GetTableEntryByID(cmd.entry.ID)
+	if err != nil {
+		return err
+	}
+	replayDrop(table.BaseEntry, cmd.entry)
+	return nil
+}
+
+func (catalog *Catalog) onReplayAlterTable(cmd *entryCmd) error {
+	table, err := catalog.replayGetTable(cmd.db.ID, cmd.entry.ID)
+	if err != nil {
+		return err
+	}
+	if renamed := table.replayAlter(cmd.schema, cmd.alterTS); renamed {
+		db := table.GetDB()
+		db.Lock()
+		addNameNode(db.nameNodes, db, &db.nodesMu, cmd.schema.Name, table.ID, &table.nameLists)
+		db.Unlock()
+	}
+	return nil
+}
+
+func (catalog *Catalog) onReplayUpdateSeq(cmd *entryCmd) error {
+	table, err := catalog.replayGetTable(cmd.db.ID, cmd.entry.ID)
+	if err != nil {
+		return err
+	}
+	table.ObserveSeq(cmd.seq)
+	return nil
+}
+
+func (catalog *Catalog) replayGetTable(dbId, tableId uint64) (table *TableEntry, err error) {
+	db, err := catalog.GetDatabaseByID(dbId)
+	if err != nil {
+		return
+	}
+	return db.GetTableEntryByID(tableId)
+}
+
+func (catalog *Catalog) onReplayCreateSegment(cmd *entryCmd, dataFactory DataFactory) error {
+	table, err := catalog.replayGetTable(cmd.db.ID, cmd.table.ID)
+	if err != nil {
+		return err
+	}
+	schema := table.GetSchemaByVersion(cmd.segment.schema.Version)
+	if schema == nil {
+		return ErrNotFound
+	}
+	segment := &SegmentEntry{
+		BaseEntry: newReplayBaseEntry(cmd.entry),
+		table:     table,
+		schema:    schema,
+		link:      new(common.Link),
+		entries:   make(map[uint64]*common.DLNode),
+		state:     cmd.segment.state,
+	}
+	if dataFactory != nil {
+		segment.segData = dataFactory.MakeSegmentFactory()(segment)
+	}
+	table.Lock()
+	table.addEntryLocked(segment)
+	table.Unlock()
+	catalog.OnReplaySegmentID(segment.ID)
+	return nil
+}
+
+func (catalog *Catalog) onReplayDropSegment(cmd *entryCmd) error {
+	table, err := catalog.replayGetTable(cmd.db.ID, cmd.table.ID)
+	if err != nil {
+		return err
+	}
+	segment, err := table.GetSegmentByID(cmd.entry.ID)
+	if err != nil {
+		return err
+	}
+	replayDrop(segment.BaseEntry, cmd.entry)
+	return nil
+}
+
+func (catalog *Catalog) onReplayCreateBlock(cmd *entryCmd, dataFactory DataFactory) error {
+	table, err := catalog.replayGetTable(cmd.db.ID, cmd.table.ID)
+	if err != nil {
+		return err
+	}
+	segment, err := table.GetSegmentByID(cmd.segment.ID)
+	if err != nil {
+		return err
+	}
+	block := &BlockEntry{
+		BaseEntry: newReplayBaseEntry(cmd.entry),
+		segment:   segment,
+		state:     cmd.block.state,
+	}
+	if dataFactory != nil {
+		segFile := segment.GetSegmentData().GetSegmentFile()
+		block.blkData = dataFactory.MakeBlockFactory(segFile)(block)
+	}
+	segment.Lock()
+	segment.addEntryLocked(block)
+	segment.Unlock()
+	catalog.OnReplayBlockID(block.ID)
+	return nil
+}
+
+func (catalog *Catalog) onReplayDropBlock(cmd *entryCmd) error {
+	table, err := catalog.replayGetTable(cmd.db.ID, cmd.table.ID)
+	if err != nil {
+		return err
+	}
+	segment, err := table.GetSegmentByID(cmd.segment.ID)
+	if err != nil {
+		return err
+	}
+	block, err := segment.GetBlockEntryByID(cmd.entry.ID)
+	if err != nil {
+		return err
+	}
+	replayDrop(block.BaseEntry, cmd.entry)
+	return nil
+}
@@ -7,10 +7,14 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"reflect"
 	"tae/pkg/common"
+	"tae/pkg/index"
+	"tae/pkg/txn/txnbase"
 	"time"
 
 	"github.com/matrixorigin/matrixone/pkg/container/types"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
 	"github.com/matrixorigin/matrixone/pkg/encoding"
 )
 
@@ -39,10 +43,172 @@ func NewIndexInfo(name string, typ IndexT, colIdx ...int) *IndexInfo {
 	return index
 }
 
+func (index *IndexInfo) WriteTo(w io.Writer) (err error) {
+	if err = binary.Write(w, binary.BigEndian, index.Id); err != nil {
+		return
+	}
+	if _, err = common.WriteString(index.Name, w); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, index.Type); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, uint16(len(index.Columns))); err != nil {
+		return
+	}
+	return binary.Write(w, binary.BigEndian, index.Columns)
+}
+
+func (index *IndexInfo) ReadFrom(r io.Reader) (err error) {
+	if err = binary.Read(r, binary.BigEndian, &index.Id); err != nil {
+		return
+	}
+	if index.Name, err = common.ReadString(r); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &index.Type); err != nil {
+		return
+	}
+	cnt := uint16(0)
+	if err = binary.Read(r, binary.BigEndian, &cnt); err != nil {
+		return
+	}
+	index.Columns = make([]uint16, cnt)
+	return binary.Read(r, binary.BigEndian, index.Columns)
+}
+
+type DefaultKind uint8
+
+const (
+	NoDefault DefaultKind = iota
+	ConstantDefault
+	AutoIncrementDefault
+)
+
+// Default is the value expression of a column omitted by an append. Value is
+// the constant of a ConstantDefault
+type Default struct {
+	Kind  DefaultKind
+	Value interface{}
+}
+
+// ColDef is a column of a schema version. Idx is the position of the column
+// in the version and ID identifies the column across all the versions
 type ColDef struct {
-	Name string
-	Idx  int
-	Type types.Type
+	Name     string
+	Idx      int
+	ID       uint16
+	Type     types.Type
+	Nullable bool
+	Default  Default
+	Comment  string
+}
+
+// ColOption sets an attribute of a column appended by AppendCol
+type ColOption func(*ColDef)
+
+func WithNullable() ColOption {
+	return func(colDef *ColDef) { colDef.Nullable = true }
+}
+
+func WithDefault(v interface{}) ColOption {
+	return func(colDef *ColDef) { colDef.Default = Default{Kind: ConstantDefault, Value: v} }
+}
+
+func WithAutoIncrement() ColOption {
+	return func(colDef *ColDef) { colDef.Default = Default{Kind: AutoIncrementDefault} }
+}
+
+func WithComment(comment string) ColOption {
+	return func(colDef *ColDef) { colDef.Comment = comment }
+}
+
+// Valid checks the default of the column matches its type. Only the integer
+// columns can be auto-incremented
+func (colDef *ColDef) Valid() bool {
+	switch colDef.Default.Kind {
+	case NoDefault:
+		return colDef.Default.Value == nil
+	case ConstantDefault:
+		return colDef.Default.Value != nil &&
+			reflect.TypeOf(colDef.Default.Value) == reflect.TypeOf(txnbase.ZeroValue(colDef.Type))
+	case AutoIncrementDefault:
+		switch colDef.Type.Oid {
+		case types.T_int8, types.T_int16, types.T_int32, types.T_int64,
+			types.T_uint8, types.T_uint16, types.T_uint32, types.T_uint64:
+			return colDef.Default.Value == nil
+		}
+	}
+	return false
+}
+
+// HasFill returns true if the column can be omitted by an append, as it
+// takes its constant default or NULL
+func (colDef *ColDef) HasFill() bool {
+	return colDef.Default.Kind == ConstantDefault || colDef.Nullable
+}
+
+// MakeFill returns a vector of rows values the column takes if omitted
+func (colDef *ColDef) MakeFill(rows uint32) *gvec.Vector {
+	if colDef.Default.Kind != ConstantDefault {
+		return txnbase.NewNullVector(colDef.Type, rows)
+	}
+	vec := gvec.New(colDef.Type)
+	for row := uint32(0); row < rows; row++ {
+		txnbase.AppendValue(vec, colDef.Default.Value)
+	}
+	return vec
+}
+
+func (colDef *ColDef) writeAttrs(w io.Writer) (err error) {
+	if err = binary.Write(w, binary.BigEndian, colDef.Nullable); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, colDef.Default.Kind); err != nil {
+		return
+	}
+	if colDef.Default.Kind == ConstantDefault {
+		vec := gvec.New(colDef.Type)
+		txnbase.AppendValue(vec, colDef.Default.Value)
+		var buf []byte
+		if buf, err = vec.Show(); err != nil {
+			return
+		}
+		if err = binary.Write(w, binary.BigEndian, uint32(len(buf))); err != nil {
+			return
+		}
+		if _, err = w.Write(buf); err != nil {
+			return
+		}
+	}
+	_, err = common.WriteString(colDef.Comment, w)
+	return
+}
+
+func (colDef *ColDef) readAttrs(r io.Reader) (err error) {
+	if err = binary.Read(r, binary.BigEndian, &colDef.Nullable); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &colDef.Default.Kind); err != nil {
+		return
+	}
+	if colDef.Default.Kind == ConstantDefault {
+		length := uint32(0)
+		if err = binary.Read(r, binary.BigEndian, &length); err != nil {
+			return
+		}
+		buf := make([]byte, length)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return
+		}
+		vec := gvec.Vector{}
+		if err = vec.Read(buf); err != nil {
+			return
+		}
+		colDef.Default.Value = txnbase.GetValue(&vec, 0)
+	}
+	colDef.Comment, err = common.ReadString(r)
+	return
 }
 
 type Schema struct {
@@ -52,6 +218,12 @@ type Schema struct {
 	BlockMaxRows     uint32         `json:"blkrows"`
 	PrimaryKey       int32          `json:"primarykey"`
 	SegmentMaxBlocks uint16         `json:"segblocks"`
+	Indexes          []*IndexInfo   `json:"indexes"`
+	// Version is increased by each committed alter of the table schema
+	Version uint32 `json:"version"`
+	// CompositeKey is the columns of a primary key spanning several columns,
+	// the first one being PrimaryKey. It is empty for a single column key
+	CompositeKey []int32 `json:"compositekey"`
 }
 
 func NewEmptySchema(name string) *Schema {
@@ -72,6 +244,9 @@ func (s *Schema) ReadFrom(r io.Reader) (err error) {
 	if err = binary.Read(r, binary.BigEndian, &s.SegmentMaxBlocks); err != nil {
 		return
 	}
+	if err = binary.Read(r, binary.BigEndian, &s.Version); err != nil {
+		return
+	}
 	if s.Name, err = common.ReadString(r); err != nil {
 		return
 	}
@@ -89,9 +264,38 @@ func (s *Schema) ReadFrom(r io.Reader) (err error) {
 		if colDef.Name, err = common.ReadString(r); err != nil {
 			return
 		}
+		if err = binary.Read(r, binary.BigEndian, &colDef.ID); err != nil {
+			return
+		}
+		if err = colDef.readAttrs(r); err != nil {
+			return
+		}
 		s.ColDefs = append(s.ColDefs, colDef)
 		colDef.Idx = int(i)
 	}
+	s.NameIndex = make(map[string]int)
+	for _, colDef := range s.ColDefs {
+		s.NameIndex[colDef.Name] = colDef.Idx
+	}
+	indexCnt := uint16(0)
+	if err = binary.Read(r, binary.BigEndian, &indexCnt); err != nil {
+		return
+	}
+	for i := uint16(0); i < indexCnt; i++ {
+		index := new(IndexInfo)
+		if err = index.ReadFrom(r); err != nil {
+			return
+		}
+		s.Indexes = append(s.Indexes, index)
+	}
+	keyCnt := uint16(0)
+	if err = binary.Read(r, binary.BigEndian, &keyCnt); err != nil {
+		return
+	}
+	if keyCnt > 0 {
+		s.CompositeKey = make([]int32, keyCnt)
+		err = binary.Read(r, binary.BigEndian, s.CompositeKey)
+	}
 	return
 }
 
@@ -106,6 +310,9 @@ func (s *Schema) Marshal() (buf []byte, err error) {
 	if err = binary.Write(&w, binary.BigEndian, s.SegmentMaxBlocks); err != nil {
 		return
 	}
+	if err = binary.Write(&w, binary.BigEndian, s.Version); err != nil {
+		return
+	}
 	if _, err = common.WriteString(s.Name, &w); err != nil {
 		return
 	}
@@ -119,21 +326,145 @@ func (s *Schema) Marshal() (buf []byte, err error) {
 		if _, err = common.WriteString(colDef.Name, &w); err != nil {
 			return
 		}
+		if err = binary.Write(&w, binary.BigEndian, colDef.ID); err != nil {
+			return
+		}
+		if err = colDef.writeAttrs(&w); err != nil {
+			return
+		}
+	}
+	if err = binary.Write(&w, binary.BigEndian, uint16(len(s.Indexes))); err != nil {
+		return
+	}
+	for _, index := range s.Indexes {
+		if err = index.WriteTo(&w); err != nil {
+			return
+		}
+	}
+	if err = binary.Write(&w, binary.BigEndian, uint16(len(s.CompositeKey))); err != nil {
+		return
+	}
+	if err = binary.Write(&w, binary.BigEndian, s.CompositeKey); err != nil {
+		return
 	}
 	buf = w.Bytes()
 	return
 }
 
-func (s *Schema) AppendCol(name string, typ types.Type) {
+func (s *Schema) AppendCol(name string, typ types.Type, opts ...ColOption) {
 	colDef := &ColDef{
 		Name: name,
 		Type: typ,
 		Idx:  len(s.ColDefs),
+		ID:   uint16(len(s.ColDefs)),
+	}
+	for _, opt := range opts {
+		opt(colDef)
 	}
 	s.ColDefs = append(s.ColDefs, colDef)
 	s.NameIndex[name] = colDef.Idx
 }
 
+// AppendIndex declares an index of typ on the columns
+func (s *Schema) AppendIndex(name string, typ IndexT, colIdx ...int) error {
+	if len(colIdx) == 0 {
+		return ErrValidation
+	}
+	for _, col := range colIdx {
+		if col < 0 || col >= len(s.ColDefs) {
+			return ErrValidation
+		}
+	}
+	for _, index := range s.Indexes {
+		if index.Name == name {
+			return ErrDuplicate
+		}
+	}
+	index := NewIndexInfo(name, typ, colIdx...)
+	index.Id = uint64(len(s.Indexes)) + 1
+	s.Indexes = append(s.Indexes, index)
+	return nil
+}
+
+// SetCompositeKey makes the columns the primary key. The key is unique on all
+// the columns together
+func (s *Schema) SetCompositeKey(colIdx ...int) error {
+	if len(colIdx) < 2 {
+		return ErrValidation
+	}
+	cols := make([]int32, len(colIdx))
+	for i, col := range colIdx {
+		if col < 0 || col >= len(s.ColDefs) {
+			return ErrValidation
+		}
+		cols[i] = int32(col)
+	}
+	s.PrimaryKey = cols[0]
+	s.CompositeKey = cols
+	return nil
+}
+
+// IsCompositeKey returns true if the primary key spans several columns
+func (s *Schema) IsCompositeKey() bool {
+	return len(s.CompositeKey) > 1
+}
+
+// KeyCols returns the columns of the primary key
+func (s *Schema) KeyCols() []int {
+	if !s.IsCompositeKey() {
+		return []int{int(s.PrimaryKey)}
+	}
+	cols := make([]int, len(s.CompositeKey))
+	for i, col := range s.CompositeKey {
+		cols[i] = int(col)
+	}
+	return cols
+}
+
+// IsKeyCol returns true if the column is a column of the primary key
+func (s *Schema) IsKeyCol(colIdx int) bool {
+	for _, col := range s.KeyCols() {
+		if col == colIdx {
+			return true
+		}
+	}
+	return false
+}
+
+// GetKeys returns the primary keys of the rows, with getCol giving the vector
+// of a key column. The keys of a single column key are the column itself, and
+// the composite keys are built by index.NewCompositeKeys
+func (s *Schema) GetKeys(getCol func(colIdx int) (*gvec.Vector, error)) (keys *gvec.Vector, err error) {
+	if !s.IsCompositeKey() {
+		return getCol(int(s.PrimaryKey))
+	}
+	cols := make([]*gvec.Vector, len(s.CompositeKey))
+	for i, col := range s.CompositeKey {
+		if cols[i], err = getCol(int(col)); err != nil {
+			return
+		}
+	}
+	return index.NewCompositeKeys(cols...)
+}
+
+// GetIndexedCols returns the indexes of the columns covered by any index of typ
+func (s *Schema) GetIndexedCols(typ IndexT) []int {
+	cols := make([]int, 0)
+	seen := make(map[uint16]bool)
+	for _, index := range s.Indexes {
+		if index.Type != typ {
+			continue
+		}
+		for _, col := range index.Columns {
+			if !seen[col] {
+				seen[col] = true
+				cols = append(cols, int(col))
+			}
+		}
+	}
+	return cols
+}
+
 func (s *Schema) String() string {
 	buf, _ := json.Marshal(s)
 	return string(buf)
@@ -147,6 +478,14 @@ func (s *Schema) Types() []types.Type {
 	return ts
 }
 
+func (s *Schema) Attrs() []string {
+	attrs := make([]string, len(s.ColDefs))
+	for i, colDef := range s.ColDefs {
+		attrs[i] = colDef.Name
+	}
+	return attrs
+}
+
 func (s *Schema) Valid() bool {
 	if s == nil {
 		return false
@@ -156,6 +495,7 @@ func (s *Schema) Valid() bool {
 	}
 
 	names := make(map[string]bool)
+	autoIncrements := 0
 	for idx, colDef := range s.ColDefs {
 		if idx != colDef.Idx {
 			return false
@@ -165,6 +505,32 @@ func (s *Schema) Valid() bool {
 			return false
 		}
 		names[colDef.Name] = true
+		if !colDef.Valid() {
+			return false
+		}
+		if colDef.Default.Kind == AutoIncrementDefault {
+			autoIncrements++
+		}
+	}
+	// The auto-increment values are taken from one sequence of the table
+	if autoIncrements > 1 {
+		return false
+	}
+	if s.PrimaryKey < 0 || int(s.PrimaryKey) >= len(s.ColDefs) || s.ColDefs[s.PrimaryKey].Nullable {
+		return false
+	}
+	if len(s.CompositeKey) == 0 {
+		return true
+	}
+	if len(s.CompositeKey) < 2 || s.CompositeKey[0] != s.PrimaryKey {
+		return false
+	}
+	keyCols := make(map[int32]bool)
+	for _, col := range s.CompositeKey {
+		if col < 0 || int(col) >= len(s.ColDefs) || s.ColDefs[col].Nullable || keyCols[col] {
+			return false
+		}
+		keyCols[col] = true
 	}
 	return true
 }
@@ -179,6 +545,52 @@ func (s *Schema) GetColIdx(attr string) int {
 	return idx
 }
 
+// GetColIdxByID returns the index of the column of the given id if found,
+// otherwise returns -1
+func (s *Schema) GetColIdxByID(id uint16) int {
+	for _, colDef := range s.ColDefs {
+		if colDef.ID == id {
+			return colDef.Idx
+		}
+	}
+	return -1
+}
+
+// MaxColID returns the max id of the columns
+func (s *Schema) MaxColID() uint16 {
+	max := uint16(0)
+	for _, colDef := range s.ColDefs {
+		if colDef.ID > max {
+			max = colDef.ID
+		}
+	}
+	return max
+}
+
+// Clone returns a deep copy of the schema
+func (s *Schema) Clone() *Schema {
+	cloned := *s
+	cloned.ColDefs = make([]*ColDef, len(s.ColDefs))
+	for i, colDef := range s.ColDefs {
+		col := *colDef
+		cloned.ColDefs[i] = &col
+	}
+	cloned.NameIndex = make(map[string]int)
+	for name, idx := range s.NameIndex {
+		cloned.NameIndex[name] = idx
+	}
+	cloned.Indexes = make([]*IndexInfo, len(s.Indexes))
+	for i, index := range s.Indexes {
+		info := *index
+		info.Columns = append([]uint16{}, index.Columns...)
+		cloned.Indexes[i] = &info
+	}
+	if s.CompositeKey != nil {
+		cloned.CompositeKey = append([]int32{}, s.CompositeKey...)
+	}
+	return &cloned
+}
+
 func MockSchema(colCnt int) *Schema {
 	rand.Seed(time.Now().UnixNano())
 	schema := NewEmptySchema(fmt.Sprintf("%d", rand.Intn(1000000)))
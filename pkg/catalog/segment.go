@@ -14,14 +14,16 @@ type SegmentDataFactory = func(meta *SegmentEntry) data.Segment
 
 type SegmentEntry struct {
 	*BaseEntry
-	table   *TableEntry
+	table *TableEntry
+	// schema is the schema version the blocks of the segment are written in
+	schema  *Schema
 	entries map[uint64]*com.DLNode
 	link    *com.Link
 	state   EntryState
 	segData data.Segment
 }
 
-func NewSegmentEntry(table *TableEntry, txn txnif.AsyncTxn, state EntryState, dataFactory SegmentDataFactory) *SegmentEntry {
+func NewSegmentEntry(table *TableEntry, schema *Schema, txn txnif.AsyncTxn, state EntryState, dataFactory SegmentDataFactory) *SegmentEntry {
 	id := table.GetDB().catalog.NextSegment()
 	e := &SegmentEntry{
 		BaseEntry: &BaseEntry{
@@ -33,6 +35,7 @@ func NewSegmentEntry(table *TableEntry, txn txnif.AsyncTxn, state EntryState, da
 			ID:      id,
 		},
 		table:   table,
+		schema:  schema,
 		link:    new(com.Link),
 		entries: make(map[uint64]*com.DLNode),
 		state:   state,
@@ -105,6 +108,11 @@ func (entry *SegmentEntry) GetTable() *TableEntry {
 	return entry.table
 }
 
+// GetSchema returns the schema version the segment is written in
+func (entry *SegmentEntry) GetSchema() *Schema {
+	return entry.schema
+}
+
 func (entry *SegmentEntry) Compare(o com.NodePayload) int {
 	oe := o.(*SegmentEntry).BaseEntry
 	return entry.DoCompre(oe)
@@ -151,6 +159,19 @@ func (entry *SegmentEntry) addEntryLocked(block *BlockEntry) {
 	entry.entries[block.GetID()] = n
 }
 
+// RemoveEntry removes an uncommitted block created by a txn
+func (entry *SegmentEntry) RemoveEntry(block *BlockEntry) error {
+	entry.Lock()
+	defer entry.Unlock()
+	n, ok := entry.entries[block.GetID()]
+	if !ok {
+		return ErrNotFound
+	}
+	entry.link.Delete(n)
+	delete(entry.entries, block.GetID())
+	return nil
+}
+
 func (entry *SegmentEntry) AsCommonID() *common.ID {
 	return &common.ID{
 		TableID:   entry.GetTable().GetID(),
@@ -0,0 +1,41 @@
+package catalog
+
+import (
+	"sync/atomic"
+	"tae/pkg/iface/txnif"
+)
+
+// SeqRangeSize is the count of the auto-increment values a txn takes from a
+// table at a time, so the txns appending concurrently don't contend
+const SeqRangeSize = 1024
+
+// AllocSeqRange takes the auto-increment values [start, end] of the table.
+// The values are never taken again, even after restart once end is logged
+// by a command of MakeSeqCommand
+func (entry *TableEntry) AllocSeqRange(n uint64) (start, end uint64) {
+	end = atomic.AddUint64(&entry.seq, n)
+	start = end - n + 1
+	return
+}
+
+// ObserveSeq makes the auto-increment values up to v never taken, e.g. once
+// v is appended explicitly
+func (entry *TableEntry) ObserveSeq(v uint64) {
+	for {
+		curr := atomic.LoadUint64(&entry.seq)
+		if curr >= v || atomic.CompareAndSwapUint64(&entry.seq, curr, v) {
+			return
+		}
+	}
+}
+
+// GetSeq returns the last auto-increment value taken
+func (entry *TableEntry) GetSeq() uint64 {
+	return atomic.LoadUint64(&entry.seq)
+}
+
+// MakeSeqCommand makes the command logging the auto-increment values up to
+// seq were taken
+func (entry *TableEntry) MakeSeqCommand(id uint32, seq uint64) txnif.TxnCmd {
+	return newSeqCmd(id, entry, seq)
+}
@@ -0,0 +1,274 @@
+package catalog
+
+import (
+	"math"
+	"sync"
+	"tae/pkg/common"
+	"tae/pkg/iface/txnif"
+	"tae/pkg/txn/txnbase"
+
+	gbat "github.com/matrixorigin/matrixone/pkg/container/batch"
+	"github.com/matrixorigin/matrixone/pkg/container/types"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+)
+
+// The system tables are read-only views of the catalog entries. They are
+// not logged and take the ids from the top, which are never allocated
+const (
+	SystemDBName = "mo_catalog"
+	SystemDBID   = math.MaxUint64
+
+	SystemTableDatabases = "mo_databases"
+	SystemTableTables    = "mo_tables"
+	SystemTableColumns   = "mo_columns"
+	SystemTableSegments  = "mo_segments"
+	SystemTableBlocks    = "mo_blocks"
+)
+
+var (
+	typUint8   = types.Type{Oid: types.T_uint8, Size: 1, Width: 8}
+	typUint16  = types.Type{Oid: types.T_uint16, Size: 2, Width: 16}
+	typUint32  = types.Type{Oid: types.T_uint32, Size: 4, Width: 32}
+	typUint64  = types.Type{Oid: types.T_uint64, Size: 8, Width: 64}
+	typVarchar = types.Type{Oid: types.T_varchar, Size: 24, Width: 100}
+)
+
+// systemTable collects the rows of a system table visible to a txn
+type systemTable struct {
+	entry   *TableEntry
+	collect func(catalog *Catalog, txn txnif.AsyncTxn, vecs []*gvec.Vector)
+}
+
+var systemTables = map[string]*systemTable{}
+
+func registerSystemTable(id uint64, schema *Schema, collect func(*Catalog, txnif.AsyncTxn, []*gvec.Vector)) {
+	systemTables[schema.Name] = &systemTable{
+		entry: &TableEntry{
+			BaseEntry: &BaseEntry{
+				RWMutex: new(sync.RWMutex),
+				ID:      id,
+			},
+			schema:   schema,
+			versions: []*schemaVersion{{schema: schema}},
+			link:     new(common.Link),
+			entries:  make(map[uint64]*common.DLNode),
+		},
+		collect: collect,
+	}
+}
+
+func init() {
+	schema := NewEmptySchema(SystemTableDatabases)
+	schema.AppendCol("id", typUint64)
+	schema.AppendCol("name", typVarchar)
+	schema.AppendCol("create_at", typUint64)
+	registerSystemTable(SystemDBID-1, schema, func(catalog *Catalog, txn txnif.AsyncTxn, vecs []*gvec.Vector) {
+		walker := &entryWalker{txn: txn}
+		walker.onDB = func(db *DBEntry, createAt txnif.TS) {
+			appendRow(vecs, db.GetID(), []byte(db.GetNameFor(txn)), uint64(createAt))
+		}
+		walker.walk(catalog)
+	})
+
+	schema = NewEmptySchema(SystemTableTables)
+	schema.AppendCol("id", typUint64)
+	schema.AppendCol("name", typVarchar)
+	schema.AppendCol("db_id", typUint64)
+	schema.AppendCol("create_at", typUint64)
+	schema.AppendCol("version", typUint32)
+	registerSystemTable(SystemDBID-2, schema, func(catalog *Catalog, txn txnif.AsyncTxn, vecs []*gvec.Vector) {
+		walker := &entryWalker{txn: txn}
+		walker.onTable = func(table *TableEntry, createAt txnif.TS) {
+			schema := table.GetSchemaFor(txn)
+			appendRow(vecs, table.GetID(), []byte(schema.Name), table.GetDB().GetID(), uint64(createAt), schema.Version)
+		}
+		walker.walk(catalog)
+	})
+
+	schema = NewEmptySchema(SystemTableColumns)
+	schema.AppendCol("table_id", typUint64)
+	schema.AppendCol("id", typUint16)
+	schema.AppendCol("idx", typUint16)
+	schema.AppendCol("name", typVarchar)
+	schema.AppendCol("type", typVarchar)
+	schema.AppendCol("nullable", typUint8)
+	schema.AppendCol("comment", typVarchar)
+	registerSystemTable(SystemDBID-3, schema, func(catalog *Catalog, txn txnif.AsyncTxn, vecs []*gvec.Vector) {
+		walker := &entryWalker{txn: txn}
+		walker.onTable = func(table *TableEntry, _ txnif.TS) {
+			for _, colDef := range table.GetSchemaFor(txn).ColDefs {
+				nullable := uint8(0)
+				if colDef.Nullable {
+					nullable = 1
+				}
+				appendRow(vecs, table.GetID(), colDef.ID, uint16(colDef.Idx), []byte(colDef.Name),
+					[]byte(colDef.Type.String()), nullable, []byte(colDef.Comment))
+			}
+		}
+		walker.walk(catalog)
+	})
+
+	schema = NewEmptySchema(SystemTableSegments)
+	schema.AppendCol("id", typUint64)
+	schema.AppendCol("table_id", typUint64)
+	schema.AppendCol("create_at", typUint64)
+	schema.AppendCol("state", typVarchar)
+	registerSystemTable(SystemDBID-4, schema, func(catalog *Catalog, txn txnif.AsyncTxn, vecs []*gvec.Vector) {
+		walker := &entryWalker{txn: txn}
+		walker.onSegment = func(segment *SegmentEntry, createAt txnif.TS) {
+			appendRow(vecs, segment.GetID(), segment.GetTable().GetID(), uint64(createAt), []byte(segment.state.Repr()))
+		}
+		walker.walk(catalog)
+	})
+
+	schema = NewEmptySchema(SystemTableBlocks)
+	schema.AppendCol("id", typUint64)
+	schema.AppendCol("segment_id", typUint64)
+	schema.AppendCol("table_id", typUint64)
+	schema.AppendCol("create_at", typUint64)
+	schema.AppendCol("state", typVarchar)
+	schema.AppendCol("rows", typUint32)
+	registerSystemTable(SystemDBID-5, schema, func(catalog *Catalog, txn txnif.AsyncTxn, vecs []*gvec.Vector) {
+		walker := &entryWalker{txn: txn}
+		walker.onBlock = func(block *BlockEntry, createAt txnif.TS) {
+			// The rows of the block visible to txn
+			rows := 0
+			if data := block.GetBlockData(); data != nil {
+				rows = data.Rows(txn, false)
+			}
+			segment := block.GetSegment()
+			appendRow(vecs, block.GetID(), segment.GetID(), segment.GetTable().GetID(), uint64(createAt),
+				[]byte(block.state.Repr()), uint32(rows))
+		}
+		walker.walk(catalog)
+	})
+}
+
+func appendRow(vecs []*gvec.Vector, vals ...interface{}) {
+	for i, v := range vals {
+		txnbase.AppendValue(vecs[i], v)
+	}
+}
+
+// GetSystemTableEntry returns the entry of a system table. It has no
+// segments and its schema is never altered
+func GetSystemTableEntry(name string) (*TableEntry, error) {
+	table := systemTables[name]
+	if table == nil {
+		return nil, ErrNotFound
+	}
+	return table.entry, nil
+}
+
+// SystemTableNames returns the names of all the system tables
+func SystemTableNames() []string {
+	return []string{
+		SystemTableDatabases,
+		SystemTableTables,
+		SystemTableColumns,
+		SystemTableSegments,
+		SystemTableBlocks,
+	}
+}
+
+// ReadSystemTable returns the attrs of the rows of a system table visible
+// to txn
+func (catalog *Catalog) ReadSystemTable(name string, txn txnif.AsyncTxn, attrs []string) (bat *gbat.Batch, err error) {
+	table := systemTables[name]
+	if table == nil {
+		return nil, ErrNotFound
+	}
+	schema := table.entry.schema
+	for _, attr := range attrs {
+		if schema.GetColIdx(attr) < 0 {
+			return nil, ErrNotFound
+		}
+	}
+	vecs := make([]*gvec.Vector, len(schema.ColDefs))
+	for i, colDef := range schema.ColDefs {
+		vecs[i] = gvec.New(colDef.Type)
+	}
+	table.collect(catalog, txn, vecs)
+	bat = gbat.New(true, attrs)
+	for i, attr := range attrs {
+		bat.Vecs[i] = vecs[schema.GetColIdx(attr)]
+	}
+	return
+}
+
+// entryWalker walks the entries visible to txn top-down. Only the levels
+// down to the deepest callback set are walked
+type entryWalker struct {
+	txn       txnif.AsyncTxn
+	onDB      func(db *DBEntry, createAt txnif.TS)
+	onTable   func(table *TableEntry, createAt txnif.TS)
+	onSegment func(segment *SegmentEntry, createAt txnif.TS)
+	onBlock   func(block *BlockEntry, createAt txnif.TS)
+}
+
+// visible returns true and the create ts of entry if txn can read it. The
+// create ts of an entry created by txn is 0
+func (w *entryWalker) visible(entry *BaseEntry) (bool, txnif.TS) {
+	entry.RLock()
+	defer entry.RUnlock()
+	ok := entry.TxnCanRead(w.txn, entry.RWMutex)
+	return ok, entry.CreateAt
+}
+
+func (w *entryWalker) walk(catalog *Catalog) {
+	dbIt := catalog.MakeDBIt(true)
+	for ; dbIt.Valid(); dbIt.Next() {
+		db := dbIt.Get().GetPayload().(*DBEntry)
+		ok, createAt := w.visible(db.BaseEntry)
+		if !ok {
+			continue
+		}
+		if w.onDB != nil {
+			w.onDB(db, createAt)
+		}
+		if w.onTable != nil || w.onSegment != nil || w.onBlock != nil {
+			w.walkTables(db)
+		}
+	}
+}
+
+func (w *entryWalker) walkTables(db *DBEntry) {
+	tableIt := db.MakeTableIt(true)
+	for ; tableIt.Valid(); tableIt.Next() {
+		table := tableIt.Get().GetPayload().(*TableEntry)
+		ok, createAt := w.visible(table.BaseEntry)
+		if !ok {
+			continue
+		}
+		if w.onTable != nil {
+			w.onTable(table, createAt)
+		}
+		if w.onSegment != nil || w.onBlock != nil {
+			w.walkSegments(table)
+		}
+	}
+}
+
+func (w *entryWalker) walkSegments(table *TableEntry) {
+	segIt := table.MakeSegmentIt(true)
+	for ; segIt.Valid(); segIt.Next() {
+		segment := segIt.Get().GetPayload().(*SegmentEntry)
+		ok, createAt := w.visible(segment.BaseEntry)
+		if !ok {
+			continue
+		}
+		if w.onSegment != nil {
+			w.onSegment(segment, createAt)
+		}
+		if w.onBlock == nil {
+			continue
+		}
+		blkIt := segment.MakeBlockIt(true)
+		for ; blkIt.Valid(); blkIt.Next() {
+			block := blkIt.Get().GetPayload().(*BlockEntry)
+			if ok, createAt = w.visible(block.BaseEntry); ok {
+				w.onBlock(block, createAt)
+			}
+		}
+	}
+}
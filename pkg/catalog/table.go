@@ -3,6 +3,7 @@ package catalog
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"tae/pkg/common"
 	"tae/pkg/iface/data"
 	"tae/pkg/iface/txnif"
@@ -10,13 +11,31 @@ import (
 
 type TableDataFactory = func(meta *TableEntry) data.Table
 
+// schemaVersion is a version of the table schema committed at ts
+type schemaVersion struct {
+	schema *Schema
+	ts     txnif.TS
+}
+
 type TableEntry struct {
 	*BaseEntry
-	db        *DBEntry
-	schema    *Schema
+	db *DBEntry
+	// schema is the latest committed schema version
+	schema   *Schema
+	versions []*schemaVersion
+	// alter is the schema version altered by an uncommitted txn
+	alter    *Schema
+	alterTxn txnif.TxnReader
+	// nameLists are the names of the lists of the database holding a node of
+	// the table, guarded by the lock of the database
+	nameLists []string
 	entries   map[uint64]*common.DLNode
 	link      *common.Link
 	tableData data.Table
+	// The max commit ts of the txns writing rows into the table
+	lastWriteTS txnif.TS
+	// seq is the last auto-increment value taken
+	seq uint64
 }
 
 func NewTableEntry(db *DBEntry, schema *Schema, txnCtx txnif.AsyncTxn, dataFactory TableDataFactory) *TableEntry {
@@ -30,10 +49,11 @@ func NewTableEntry(db *DBEntry, schema *Schema, txnCtx txnif.AsyncTxn, dataFacto
 			RWMutex: new(sync.RWMutex),
 			ID:      id,
 		},
-		db:      db,
-		schema:  schema,
-		link:    new(common.Link),
-		entries: make(map[uint64]*common.DLNode),
+		db:       db,
+		schema:   schema,
+		versions: []*schemaVersion{{schema: schema}},
+		link:     new(common.Link),
+		entries:  make(map[uint64]*common.DLNode),
 	}
 	if dataFactory != nil {
 		e.tableData = dataFactory(e)
@@ -47,7 +67,8 @@ func MockStaloneTableEntry(id uint64, schema *Schema) *TableEntry {
 			RWMutex: new(sync.RWMutex),
 			ID:      id,
 		},
-		schema: schema,
+		schema:   schema,
+		versions: []*schemaVersion{{schema: schema}},
 	}
 }
 
@@ -65,10 +86,12 @@ func (entry *TableEntry) MakeSegmentIt(reverse bool) *common.LinkIt {
 	return common.NewLinkIt(entry.RWMutex, entry.link, reverse)
 }
 
+// CreateSegment creates a segment of the schema version visible to txn
 func (entry *TableEntry) CreateSegment(txn txnif.AsyncTxn, state EntryState, dataFactory SegmentDataFactory) (created *SegmentEntry, err error) {
+	schema := entry.GetSchemaFor(txn)
 	entry.Lock()
 	defer entry.Unlock()
-	created = NewSegmentEntry(entry, txn, state, dataFactory)
+	created = NewSegmentEntry(entry, schema, txn, state, dataFactory)
 	entry.addEntryLocked(created)
 	return
 }
@@ -93,14 +116,221 @@ func (entry *TableEntry) deleteEntryLocked(segment *SegmentEntry) error {
 		return ErrNotFound
 	} else {
 		entry.link.Delete(n)
+		delete(entry.entries, segment.GetID())
 	}
 	return nil
 }
 
+// RemoveEntry removes an uncommitted segment created by a txn
+func (entry *TableEntry) RemoveEntry(segment *SegmentEntry) error {
+	entry.Lock()
+	defer entry.Unlock()
+	return entry.deleteEntryLocked(segment)
+}
+
+// GetSchema returns the latest committed schema version
 func (entry *TableEntry) GetSchema() *Schema {
+	entry.RLock()
+	defer entry.RUnlock()
 	return entry.schema
 }
 
+// GetSchemaFor returns the schema version visible to txn, which is the one
+// altered by txn or the last one committed before txn started. An alter
+// committing before txn started is waited
+func (entry *TableEntry) GetSchemaFor(txn txnif.TxnReader) *Schema {
+	entry.RLock()
+	defer entry.RUnlock()
+	if txn == nil {
+		return entry.schema
+	}
+	for entry.alter != nil {
+		alterTxn := entry.alterTxn
+		if alterTxn.GetID() == txn.GetID() {
+			return entry.alter
+		}
+		if alterTxn.GetCommitTS() > txn.GetStartTS() {
+			break
+		}
+		entry.RUnlock()
+		alterTxn.GetTxnState(true)
+		entry.RLock()
+		if entry.alterTxn == alterTxn {
+			break
+		}
+	}
+	for i := len(entry.versions) - 1; i > 0; i-- {
+		if entry.versions[i].ts <= txn.GetStartTS() {
+			return entry.versions[i].schema
+		}
+	}
+	return entry.versions[0].schema
+}
+
+// GetSchemaByVersion returns nil if the version is not committed
+func (entry *TableEntry) GetSchemaByVersion(version uint32) *Schema {
+	entry.RLock()
+	defer entry.RUnlock()
+	for _, v := range entry.versions {
+		if v.schema.Version == version {
+			return v.schema
+		}
+	}
+	return nil
+}
+
+// AlterSchema makes a new schema version by req for txn. Only one txn can
+// alter the schema at a time, and it should see the latest committed version
+func (entry *TableEntry) AlterSchema(txn txnif.TxnReader, req *AlterTableReq) (schema *Schema, err error) {
+	if req.Kind == AlterRenameTable {
+		return entry.db.renameTableEntry(entry, txn, req)
+	}
+	entry.Lock()
+	defer entry.Unlock()
+	return entry.alterSchemaLocked(txn, req)
+}
+
+func (entry *TableEntry) alterSchemaLocked(txn txnif.TxnReader, req *AlterTableReq) (schema *Schema, err error) {
+	if entry.Txn != nil && !entry.IsSameTxn(txn) {
+		return nil, txnif.TxnWWConflictErr
+	}
+	if entry.alter != nil {
+		if entry.alterTxn.GetID() != txn.GetID() {
+			return nil, txnif.TxnWWConflictErr
+		}
+		schema = entry.alter.Clone()
+	} else {
+		if entry.versions[len(entry.versions)-1].ts > txn.GetStartTS() {
+			return nil, txnif.TxnWWConflictErr
+		}
+		schema = entry.schema.Clone()
+	}
+	if err = schema.applyAlter(req, entry.maxColIDLocked()+1); err != nil {
+		return nil, err
+	}
+	entry.alter = schema
+	entry.alterTxn = txn
+	return
+}
+
+// maxColIDLocked returns the max column id of all the schema versions. The
+// ids of the dropped columns are not taken again, as the blocks written
+// before the drop still have them
+func (entry *TableEntry) maxColIDLocked() uint16 {
+	max := uint16(0)
+	for _, v := range entry.versions {
+		if id := v.schema.MaxColID(); id > max {
+			max = id
+		}
+	}
+	if entry.alter != nil && entry.alter.MaxColID() > max {
+		max = entry.alter.MaxColID()
+	}
+	return max
+}
+
+// CheckSchemaWrite returns txnif.TxnWWConflictErr if the table was altered by
+// another txn committing or committed after txn took the schema to write
+func (entry *TableEntry) CheckSchemaWrite(txn txnif.TxnReader, schema *Schema) error {
+	entry.RLock()
+	defer entry.RUnlock()
+	if entry.alter != nil {
+		if entry.alterTxn.GetID() == txn.GetID() {
+			return nil
+		}
+		if entry.alterTxn.GetCommitTS() != txnif.UncommitTS {
+			return txnif.TxnWWConflictErr
+		}
+	}
+	if entry.schema != schema {
+		return txnif.TxnWWConflictErr
+	}
+	return nil
+}
+
+// ApplyCommitAlter installs the schema version altered by txn
+func (entry *TableEntry) ApplyCommitAlter(txn txnif.TxnReader) {
+	entry.Lock()
+	defer entry.Unlock()
+	if entry.alter == nil || entry.alterTxn.GetID() != txn.GetID() {
+		return
+	}
+	entry.versions = append(entry.versions, &schemaVersion{
+		schema: entry.alter,
+		ts:     txn.GetCommitTS(),
+	})
+	entry.schema = entry.alter
+	entry.alter = nil
+	entry.alterTxn = nil
+}
+
+// RollbackAlter drops the schema version altered by txn
+func (entry *TableEntry) RollbackAlter(txn txnif.TxnReader) {
+	entry.Lock()
+	defer entry.Unlock()
+	if entry.alter == nil || entry.alterTxn.GetID() != txn.GetID() {
+		return
+	}
+	entry.alter = nil
+	entry.alterTxn = nil
+}
+
+// RestoreAlter restores the schema version altered by txn to schema, which
+// was altered by txn before
+func (entry *TableEntry) RestoreAlter(txn txnif.TxnReader, schema *Schema) {
+	entry.Lock()
+	defer entry.Unlock()
+	if entry.alter == nil || entry.alterTxn.GetID() != txn.GetID() {
+		return
+	}
+	entry.alter = schema
+}
+
+// MakeAlterCommand makes the command of the schema version altered by the
+// committing txn
+func (entry *TableEntry) MakeAlterCommand(id uint32) (cmd txnif.TxnCmd, err error) {
+	entry.RLock()
+	defer entry.RUnlock()
+	if entry.alter == nil {
+		return nil, ErrNotFound
+	}
+	return newAlterTableCmd(id, entry, entry.alter, entry.alterTxn.GetCommitTS()), nil
+}
+
+// replayAlter installs a schema version committed at ts and returns true if
+// the table was renamed by it
+func (entry *TableEntry) replayAlter(schema *Schema, ts txnif.TS) (renamed bool) {
+	entry.Lock()
+	defer entry.Unlock()
+	renamed = schema.Name != entry.schema.Name
+	entry.versions = append(entry.versions, &schemaVersion{
+		schema: schema,
+		ts:     ts,
+	})
+	entry.schema = schema
+	return
+}
+
+// collectVersions returns the schema versions altered at or before ts. An
+// alter committing at or before ts is waited
+func (entry *TableEntry) collectVersions(ts txnif.TS) []*schemaVersion {
+	entry.RLock()
+	alterTxn := entry.alterTxn
+	entry.RUnlock()
+	if alterTxn != nil && alterTxn.GetCommitTS() <= ts {
+		alterTxn.GetTxnState(true)
+	}
+	entry.RLock()
+	defer entry.RUnlock()
+	versions := make([]*schemaVersion, 0)
+	for _, v := range entry.versions[1:] {
+		if v.ts <= ts {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
+
 func (entry *TableEntry) Compare(o common.NodePayload) int {
 	oe := o.(*TableEntry).BaseEntry
 	return entry.DoCompre(oe)
@@ -141,3 +371,19 @@ func (entry *TableEntry) String() string {
 func (entry *TableEntry) GetCatalog() *Catalog { return entry.db.catalog }
 
 func (entry *TableEntry) GetTableData() data.Table { return entry.tableData }
+
+func (entry *TableEntry) GetLastWriteTS() txnif.TS {
+	return txnif.TS(atomic.LoadUint64((*uint64)(&entry.lastWriteTS)))
+}
+
+// UpdateLastWriteTS is called when a txn writing rows into the table prepares
+// its commit
+func (entry *TableEntry) UpdateLastWriteTS(ts txnif.TS) {
+	addr := (*uint64)(&entry.lastWriteTS)
+	for {
+		prev := atomic.LoadUint64(addr)
+		if uint64(ts) <= prev || atomic.CompareAndSwapUint64(addr, prev, uint64(ts)) {
+			return
+		}
+	}
+}
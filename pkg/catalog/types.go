@@ -1,6 +1,10 @@
 package catalog
 
-import "github.com/matrixorigin/matrixone/pkg/container/types"
+import (
+	"tae/pkg/dataio"
+
+	"github.com/matrixorigin/matrixone/pkg/container/types"
+)
 
 type EntryState int8
 
@@ -10,18 +14,36 @@ const (
 	ES_Frozen
 )
 
+func (es EntryState) Repr() string {
+	switch es {
+	case ES_Appendable:
+		return "appendable"
+	case ES_NotAppendable:
+		return "not appendable"
+	case ES_Frozen:
+		return "frozen"
+	}
+	return "unknown"
+}
+
+type DataFactory interface {
+	MakeTableFactory() TableDataFactory
+	MakeSegmentFactory() SegmentDataFactory
+	MakeBlockFactory(segFile dataio.SegmentFile) BlockDataFactory
+}
+
 func EstimateColumnBlockSize(colIdx int, rows uint32, meta *BlockEntry) uint32 {
-	switch meta.GetSegment().GetTable().GetSchema().ColDefs[colIdx].Type.Oid {
+	switch meta.GetSchema().ColDefs[colIdx].Type.Oid {
 	case types.T_json, types.T_char, types.T_varchar:
 		return rows * 2 * 4
 	default:
-		return rows * uint32(meta.GetSegment().GetTable().GetSchema().ColDefs[colIdx].Type.Size)
+		return rows * uint32(meta.GetSchema().ColDefs[colIdx].Type.Size)
 	}
 }
 
 func EstimateBlockSize(meta *BlockEntry, rows uint32) uint32 {
 	size := uint32(0)
-	for colIdx := range meta.GetSegment().GetTable().GetSchema().ColDefs {
+	for colIdx := range meta.GetSchema().ColDefs {
 		size += EstimateColumnBlockSize(colIdx, rows, meta)
 	}
 	return size
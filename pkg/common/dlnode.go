@@ -80,7 +80,10 @@ func (l *DLNode) GetPayload() NodePayload { return l.payload }
 
 func (l *DLNode) Sort() (*DLNode, *DLNode) {
 	curr := l
-	head := curr
+	var head *DLNode
+	if l.prev == nil {
+		head = curr
+	}
 	prev := l.prev
 	next := l.next
 	var tail *DLNode
@@ -39,5 +39,7 @@ func (bf *NoopBlockFile) LoadData() (bat batch.IBatch, err error)
 func (bf *NoopBlockFile) Sync() (err error)                                              { return }
 func (bf *NoopBlockFile) GetMaxIndex() *shard.Index                                      { return nil }
 func (bf *NoopBlockFile) GetTimeStamps() (ts *gvec.Vector, err error)                    { return }
+func (bf *NoopBlockFile) WriteIndex(uint8, uint16, []byte) (err error)                   { return }
+func (bf *NoopBlockFile) LoadIndex(uint8, uint16) (buf []byte, err error)                { return }
 
 func (bf *NoopBlockFile) GetColumnSta(idx uint16) (info common.FileInfo) { return }
@@ -0,0 +1,353 @@
+package dataio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	Magic       uint64 = 0x01346616
+	FileVersion uint16 = 1
+
+	FormatSegmentFile uint16 = 0x10
+
+	// 1 for 1K bytes
+	PartUnit           = 1024
+	DefaultMinPartSize = uint16(4)
+	DefaultMaxPartSize = uint16(1024)
+
+	HeaderSize     = 8 + 2 + 2 + 32 + 2 + 2
+	MetaSize       = 2 + 4 + 128
+	PartHeaderSize = 2 + 4
+
+	PartsStart = HeaderSize + 2*MetaSize
+)
+
+const (
+	CompressNone uint8 = iota
+	CompressLz4
+)
+
+const (
+	IndexTypeTimeStamps uint8 = iota + 1
+	IndexTypeLogIndex
+	IndexTypeZoneMap
+	IndexTypeBloomFilter
+	IndexTypeDeadRows
+)
+
+var (
+	ErrInvalidMagic  = errors.New("tae: invalid segment file magic")
+	ErrInvalidFormat = errors.New("tae: invalid segment file format")
+	ErrCorruptedPart = errors.New("tae: corrupted segment file part")
+)
+
+// +-------------+--------------+-------------+----------------+------------------+------------------+
+// |  Magic (8B) | Version (2B) | Format (2B) | Reserved (32B) | MinPartSize (2B) | MaxPartSize (2B) |
+// +-------------+--------------+-------------+----------------+------------------+------------------+
+type fileHeader struct {
+	magic       uint64
+	version     uint16
+	format      uint16
+	minPartSize uint16
+	maxPartSize uint16
+}
+
+func (h *fileHeader) WriteTo(w io.Writer) (err error) {
+	if err = binary.Write(w, binary.BigEndian, h.magic); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, h.version); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, h.format); err != nil {
+		return
+	}
+	if _, err = w.Write(make([]byte, 32)); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, h.minPartSize); err != nil {
+		return
+	}
+	err = binary.Write(w, binary.BigEndian, h.maxPartSize)
+	return
+}
+
+func (h *fileHeader) ReadFrom(r io.Reader) (err error) {
+	if err = binary.Read(r, binary.BigEndian, &h.magic); err != nil {
+		return
+	}
+	if h.magic != Magic {
+		return ErrInvalidMagic
+	}
+	if err = binary.Read(r, binary.BigEndian, &h.version); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &h.format); err != nil {
+		return
+	}
+	if h.format != FormatSegmentFile {
+		return ErrInvalidFormat
+	}
+	if _, err = io.ReadFull(r, make([]byte, 32)); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &h.minPartSize); err != nil {
+		return
+	}
+	err = binary.Read(r, binary.BigEndian, &h.maxPartSize)
+	return
+}
+
+// +---------------+-----------------+-----------------+
+// |  Version (2B) | PartOffset (4B) | Reserved (128B) |
+// +---------------+-----------------+-----------------+
+type fileMeta struct {
+	version    uint16
+	partOffset uint32
+}
+
+func (m *fileMeta) Marshal() []byte {
+	var w bytes.Buffer
+	binary.Write(&w, binary.BigEndian, m.version)
+	binary.Write(&w, binary.BigEndian, m.partOffset)
+	w.Write(make([]byte, 128))
+	return w.Bytes()
+}
+
+func (m *fileMeta) Unmarshal(buf []byte) {
+	m.version = binary.BigEndian.Uint16(buf[0:2])
+	m.partOffset = binary.BigEndian.Uint32(buf[2:6])
+}
+
+// Newer returns true if m is the active one between m and o. Version wraps
+// around so the comparison is done on the difference
+func (m *fileMeta) Newer(o *fileMeta) bool {
+	if m.partOffset == 0 {
+		return false
+	}
+	if o.partOffset == 0 {
+		return true
+	}
+	return int16(m.version-o.version) > 0
+}
+
+// +------------------+------------+-----------+--------------+
+// |  PartOffset (4B) | Start (4B) | Size (4B) | RawSize (4B) |
+// +------------------+------------+-----------+--------------+
+// Size equals to RawSize if the payload is not compressed
+type extent struct {
+	partOffset uint32
+	start      uint32
+	size       uint32
+	rawSize    uint32
+}
+
+func (e *extent) IsCompressed() bool { return e.size != e.rawSize }
+
+func (e *extent) WriteTo(w io.Writer) (err error) {
+	if err = binary.Write(w, binary.BigEndian, e.partOffset); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, e.start); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, e.size); err != nil {
+		return
+	}
+	err = binary.Write(w, binary.BigEndian, e.rawSize)
+	return
+}
+
+func (e *extent) ReadFrom(r io.Reader) (err error) {
+	if err = binary.Read(r, binary.BigEndian, &e.partOffset); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &e.start); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &e.size); err != nil {
+		return
+	}
+	err = binary.Read(r, binary.BigEndian, &e.rawSize)
+	return
+}
+
+// +----------------+----------------+-----------------+------------+-----------+--------------+
+// | IndexType (1B) | ColumnIdx (2B) | PartOffset (4B) | Start (4B) | Size (4B) | RawSize (4B) |
+// +----------------+----------------+-----------------+------------+-----------+--------------+
+type indexMeta struct {
+	extent
+	typ    uint8
+	colIdx uint16
+}
+
+func (m *indexMeta) WriteTo(w io.Writer) (err error) {
+	if err = binary.Write(w, binary.BigEndian, m.typ); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, m.colIdx); err != nil {
+		return
+	}
+	err = m.extent.WriteTo(w)
+	return
+}
+
+func (m *indexMeta) ReadFrom(r io.Reader) (err error) {
+	if err = binary.Read(r, binary.BigEndian, &m.typ); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &m.colIdx); err != nil {
+		return
+	}
+	err = m.extent.ReadFrom(r)
+	return
+}
+
+// +-------------------+------------+-------------+--...--+
+// | CompressAlgo (1B) | Count (2B) | <IndexMeta> |  ...  |
+// +-------------------+------------+-------------+--...--+
+type indexesMeta struct {
+	algo    uint8
+	indexes []*indexMeta
+}
+
+func (m *indexesMeta) WriteTo(w io.Writer) (err error) {
+	if err = binary.Write(w, binary.BigEndian, m.algo); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, uint16(len(m.indexes))); err != nil {
+		return
+	}
+	for _, index := range m.indexes {
+		if err = index.WriteTo(w); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (m *indexesMeta) ReadFrom(r io.Reader) (err error) {
+	if err = binary.Read(r, binary.BigEndian, &m.algo); err != nil {
+		return
+	}
+	cnt := uint16(0)
+	if err = binary.Read(r, binary.BigEndian, &cnt); err != nil {
+		return
+	}
+	m.indexes = make([]*indexMeta, cnt)
+	for i := range m.indexes {
+		m.indexes[i] = new(indexMeta)
+		if err = m.indexes[i].ReadFrom(r); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (m *indexesMeta) Get(typ uint8) *indexMeta {
+	for _, index := range m.indexes {
+		if index.typ == typ {
+			return index
+		}
+	}
+	return nil
+}
+
+func (m *indexesMeta) GetColumn(typ uint8, colIdx uint16) (int, *indexMeta) {
+	for i, index := range m.indexes {
+		if index.typ == typ && index.colIdx == colIdx {
+			return i, index
+		}
+	}
+	return -1, nil
+}
+
+type blockMeta struct {
+	id      uint64
+	rows    uint32
+	cols    []extent
+	indexes indexesMeta
+}
+
+// Segment file meta part payload
+//
+// +-----------+---------------+--------------+---------------+--------------------+--------------+
+// | Cols (2B) | BlockCnt (2B) | <ColumnMeta> | <IndexesMeta> | <BlockIndexesMeta> | <BlocksInfo> |
+// +-----------+---------------+--------------+---------------+--------------------+--------------+
+//
+// ColumnMeta = CompressAlgo (1B) + BlockCnt * BlockMeta
+// BlocksInfo = BlockCnt * (BlockID (8B) + Rows (4B))
+type segmentMeta struct {
+	algos   []uint8
+	indexes indexesMeta
+	blocks  []*blockMeta
+}
+
+func (m *segmentMeta) Cols() int { return len(m.algos) }
+
+func (m *segmentMeta) Marshal() []byte {
+	var w bytes.Buffer
+	binary.Write(&w, binary.BigEndian, uint16(len(m.algos)))
+	binary.Write(&w, binary.BigEndian, uint16(len(m.blocks)))
+	for colIdx, algo := range m.algos {
+		binary.Write(&w, binary.BigEndian, algo)
+		for _, blk := range m.blocks {
+			blk.cols[colIdx].WriteTo(&w)
+		}
+	}
+	m.indexes.WriteTo(&w)
+	for _, blk := range m.blocks {
+		blk.indexes.WriteTo(&w)
+	}
+	for _, blk := range m.blocks {
+		binary.Write(&w, binary.BigEndian, blk.id)
+		binary.Write(&w, binary.BigEndian, blk.rows)
+	}
+	return w.Bytes()
+}
+
+func (m *segmentMeta) Unmarshal(buf []byte) (err error) {
+	r := bytes.NewBuffer(buf)
+	var cols, blks uint16
+	if err = binary.Read(r, binary.BigEndian, &cols); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &blks); err != nil {
+		return
+	}
+	m.algos = make([]uint8, cols)
+	m.blocks = make([]*blockMeta, blks)
+	for i := range m.blocks {
+		m.blocks[i] = &blockMeta{cols: make([]extent, cols)}
+	}
+	for colIdx := range m.algos {
+		if err = binary.Read(r, binary.BigEndian, &m.algos[colIdx]); err != nil {
+			return
+		}
+		for _, blk := range m.blocks {
+			if err = blk.cols[colIdx].ReadFrom(r); err != nil {
+				return
+			}
+		}
+	}
+	if err = m.indexes.ReadFrom(r); err != nil {
+		return
+	}
+	for _, blk := range m.blocks {
+		if err = blk.indexes.ReadFrom(r); err != nil {
+			return
+		}
+	}
+	for _, blk := range m.blocks {
+		if err = binary.Read(r, binary.BigEndian, &blk.id); err != nil {
+			return
+		}
+		if err = binary.Read(r, binary.BigEndian, &blk.rows); err != nil {
+			return
+		}
+	}
+	return
+}
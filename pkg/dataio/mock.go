@@ -21,6 +21,7 @@ type mockBlockFile struct {
 	data     batch.IBatch
 	logIndex *shard.Index
 	ts       *gvec.Vector
+	indexes  map[[2]uint16][]byte
 }
 
 type mockSegmentFile struct {
@@ -56,9 +57,26 @@ func (bf *mockBlockFile) WriteData(bat batch.IBatch, logIndex *shard.Index, ts *
 	bf.rows = uint32(bat.Length())
 	bf.logIndex = logIndex
 	bf.ts = ts
+	bf.indexes = nil
 	return nil
 }
 
+func (bf *mockBlockFile) WriteIndex(typ uint8, colIdx uint16, buf []byte) error {
+	if bf.data == nil {
+		return ErrBlockNotFound
+	}
+	if bf.indexes == nil {
+		bf.indexes = make(map[[2]uint16][]byte)
+	}
+	bf.indexes[[2]uint16{uint16(typ), colIdx}] = buf
+	return nil
+}
+
+func (bf *mockBlockFile) LoadIndex(typ uint8, colIdx uint16) (buf []byte, err error) {
+	buf = bf.indexes[[2]uint16{uint16(typ), colIdx}]
+	return
+}
+
 func (bf *mockBlockFile) LoadData() (bat batch.IBatch, err error) {
 	bat = bf.data
 	return
@@ -0,0 +1,664 @@
+package dataio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"tae/pkg/common"
+
+	"github.com/matrixorigin/matrixone/pkg/compress"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+	"github.com/matrixorigin/matrixone/pkg/encoding"
+	mocommon "github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/common"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/container/batch"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/container/vector"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/wal/shard"
+)
+
+var (
+	ErrColumnsMismatch = errors.New("tae: segment file columns mismatch")
+	ErrBlockNotFound   = errors.New("tae: segment file block not found")
+)
+
+var SegmentFileIOFactory = func(dir string, id uint64) SegmentFile {
+	sf, err := OpenSegmentFile(dir, id)
+	if err != nil {
+		panic(err)
+	}
+	return sf
+}
+
+type part struct {
+	offset uint32
+	// 1 for 1K bytes
+	size uint16
+	next uint32
+}
+
+func (p *part) End() uint32      { return p.offset + uint32(p.size)*PartUnit }
+func (p *part) Capacity() uint32 { return uint32(p.size)*PartUnit - PartHeaderSize }
+
+type segmentFile struct {
+	mocommon.RefHelper
+	sync.RWMutex
+	id     uint64
+	name   string
+	file   *os.File
+	header fileHeader
+	metas  [2]fileMeta
+	active int
+	meta   *segmentMeta
+	blocks map[uint64]*blockMeta
+	files  map[uint64]*blockFile
+	parts  map[uint32]*part
+	free   []*part
+	end    uint32
+}
+
+func OpenSegmentFile(dir string, id uint64) (sf *segmentFile, err error) {
+	name := common.MakeSegmentFileName(dir, strconv.FormatUint(id, 10), 0, false)
+	if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return
+	}
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	sf = &segmentFile{
+		id:     id,
+		name:   name,
+		file:   file,
+		meta:   new(segmentMeta),
+		blocks: make(map[uint64]*blockMeta),
+		files:  make(map[uint64]*blockFile),
+		parts:  make(map[uint32]*part),
+		free:   make([]*part, 0),
+	}
+	stat, err := file.Stat()
+	if err == nil {
+		if stat.Size() == 0 {
+			err = sf.init()
+		} else {
+			err = sf.replay(stat.Size())
+		}
+	}
+	if err != nil {
+		file.Close()
+		sf = nil
+	}
+	return
+}
+
+func (sf *segmentFile) init() (err error) {
+	sf.header = fileHeader{
+		magic:       Magic,
+		version:     FileVersion,
+		format:      FormatSegmentFile,
+		minPartSize: DefaultMinPartSize,
+		maxPartSize: DefaultMaxPartSize,
+	}
+	var w bytes.Buffer
+	if err = sf.header.WriteTo(&w); err != nil {
+		return
+	}
+	w.Write(sf.metas[0].Marshal())
+	w.Write(sf.metas[1].Marshal())
+	if _, err = sf.file.WriteAt(w.Bytes(), 0); err != nil {
+		return
+	}
+	sf.end = PartsStart
+	return sf.file.Sync()
+}
+
+func (sf *segmentFile) replay(size int64) (err error) {
+	buf := make([]byte, PartsStart)
+	if _, err = sf.file.ReadAt(buf, 0); err != nil {
+		return
+	}
+	if err = sf.header.ReadFrom(bytes.NewBuffer(buf)); err != nil {
+		return
+	}
+	sf.metas[0].Unmarshal(buf[HeaderSize:])
+	sf.metas[1].Unmarshal(buf[HeaderSize+MetaSize:])
+	if sf.metas[1].Newer(&sf.metas[0]) {
+		sf.active = 1
+	}
+
+	// Scan all parts. A garbage part header can only be left by a torn
+	// allocation at the tail, which is never referenced by an active meta
+	sf.end = PartsStart
+	for int64(sf.end)+PartHeaderSize <= size {
+		p, err := sf.readPartHeader(sf.end)
+		if err != nil {
+			return err
+		}
+		if p.size == 0 || int64(p.End()) > size {
+			break
+		}
+		sf.parts[p.offset] = p
+		sf.end = p.End()
+	}
+	if int64(sf.end) != size {
+		if err = sf.file.Truncate(int64(sf.end)); err != nil {
+			return
+		}
+	}
+
+	used := make(map[uint32]bool)
+	active := &sf.metas[sf.active]
+	if active.partOffset != 0 {
+		var payload []byte
+		if payload, err = sf.readChain(active.partOffset, used); err != nil {
+			return
+		}
+		if err = sf.meta.Unmarshal(payload); err != nil {
+			return
+		}
+		for _, blk := range sf.meta.blocks {
+			sf.blocks[blk.id] = blk
+			for _, e := range blk.cols {
+				sf.markChain(e.partOffset, used)
+			}
+			for _, index := range blk.indexes.indexes {
+				sf.markChain(index.partOffset, used)
+			}
+		}
+	}
+	for offset, p := range sf.parts {
+		if !used[offset] {
+			sf.free = append(sf.free, p)
+		}
+	}
+	sort.Slice(sf.free, func(i, j int) bool { return sf.free[i].offset < sf.free[j].offset })
+	return
+}
+
+func (sf *segmentFile) readPartHeader(offset uint32) (p *part, err error) {
+	buf := make([]byte, PartHeaderSize)
+	if _, err = sf.file.ReadAt(buf, int64(offset)); err != nil {
+		return
+	}
+	p = &part{
+		offset: offset,
+		size:   binary.BigEndian.Uint16(buf[0:2]),
+		next:   binary.BigEndian.Uint32(buf[2:6]),
+	}
+	return
+}
+
+func (sf *segmentFile) writePartHeader(p *part) (err error) {
+	buf := make([]byte, PartHeaderSize)
+	binary.BigEndian.PutUint16(buf[0:2], p.size)
+	binary.BigEndian.PutUint32(buf[2:6], p.next)
+	_, err = sf.file.WriteAt(buf, int64(p.offset))
+	return
+}
+
+func (sf *segmentFile) markChain(offset uint32, used map[uint32]bool) {
+	for offset != 0 {
+		p := sf.parts[offset]
+		if p == nil {
+			return
+		}
+		used[offset] = true
+		offset = p.next
+	}
+}
+
+func (sf *segmentFile) readChain(offset uint32, used map[uint32]bool) (payload []byte, err error) {
+	var w bytes.Buffer
+	for offset != 0 {
+		p := sf.parts[offset]
+		if p == nil {
+			return nil, ErrCorruptedPart
+		}
+		used[offset] = true
+		buf := make([]byte, p.Capacity())
+		if _, err = sf.file.ReadAt(buf, int64(offset)+PartHeaderSize); err != nil {
+			return
+		}
+		w.Write(buf)
+		offset = p.next
+	}
+	payload = w.Bytes()
+	return
+}
+
+// allocPart returns a part with at least size units. An unused part is
+// preferred and split if it is too big
+func (sf *segmentFile) allocPart(size uint16) (p *part, err error) {
+	for i, candidate := range sf.free {
+		if candidate.size < size {
+			continue
+		}
+		sf.free = append(sf.free[:i], sf.free[i+1:]...)
+		p = candidate
+		if p.size-size >= sf.header.minPartSize {
+			left := &part{offset: p.offset + uint32(size)*PartUnit, size: p.size - size}
+			if err = sf.writePartHeader(left); err != nil {
+				return
+			}
+			sf.parts[left.offset] = left
+			sf.free = append(sf.free, left)
+			p.size = size
+		}
+		p.next = 0
+		return
+	}
+	p = &part{offset: sf.end, size: size}
+	if err = sf.file.Truncate(int64(p.End())); err != nil {
+		return
+	}
+	sf.end = p.End()
+	sf.parts[p.offset] = p
+	return
+}
+
+func (sf *segmentFile) freeChain(offset uint32) {
+	for offset != 0 {
+		p := sf.parts[offset]
+		if p == nil {
+			return
+		}
+		sf.free = append(sf.free, p)
+		offset = p.next
+	}
+}
+
+func (sf *segmentFile) partSize(payload int) uint16 {
+	min := uint32(sf.header.minPartSize)
+	units := (uint32(payload) + PartHeaderSize + PartUnit - 1) / PartUnit
+	units = (units + min - 1) / min * min
+	if units > uint32(sf.header.maxPartSize) {
+		units = uint32(sf.header.maxPartSize)
+	}
+	return uint16(units)
+}
+
+// writeChain writes the payload into a chain of parts and returns the
+// offset of the first part
+func (sf *segmentFile) writeChain(payload []byte) (offset uint32, err error) {
+	var prev *part
+	for len(payload) > 0 || prev == nil {
+		var p *part
+		if p, err = sf.allocPart(sf.partSize(len(payload))); err != nil {
+			return
+		}
+		n := len(payload)
+		if n > int(p.Capacity()) {
+			n = int(p.Capacity())
+		}
+		if _, err = sf.file.WriteAt(payload[:n], int64(p.offset)+PartHeaderSize); err != nil {
+			return
+		}
+		if err = sf.writePartHeader(p); err != nil {
+			return
+		}
+		payload = payload[n:]
+		if prev == nil {
+			offset = p.offset
+		} else {
+			prev.next = p.offset
+			if err = sf.writePartHeader(prev); err != nil {
+				return
+			}
+		}
+		prev = p
+	}
+	return
+}
+
+func (sf *segmentFile) readExtent(e *extent) (buf []byte, err error) {
+	buf = make([]byte, e.size)
+	offset := e.partOffset
+	skip := e.start
+	pos := uint32(0)
+	for pos < e.size {
+		p := sf.parts[offset]
+		if p == nil {
+			return nil, ErrCorruptedPart
+		}
+		if skip >= p.Capacity() {
+			skip -= p.Capacity()
+			offset = p.next
+			continue
+		}
+		n := p.Capacity() - skip
+		if n > e.size-pos {
+			n = e.size - pos
+		}
+		if _, err = sf.file.ReadAt(buf[pos:pos+n], int64(p.offset)+PartHeaderSize+int64(skip)); err != nil {
+			return
+		}
+		pos += n
+		skip = 0
+		offset = p.next
+	}
+	if !e.IsCompressed() {
+		return
+	}
+	return compress.Decompress(buf, make([]byte, e.rawSize), compress.Lz4)
+}
+
+func (sf *segmentFile) writeExtent(raw []byte, algo uint8) (e extent, err error) {
+	e.rawSize = uint32(len(raw))
+	data := raw
+	if algo == CompressLz4 && len(raw) > 0 {
+		var compressed []byte
+		if compressed, err = compress.Compress(raw, make([]byte, len(raw)+len(raw)/255+16), compress.Lz4); err != nil {
+			return
+		}
+		if len(compressed) > 0 && len(compressed) < len(raw) {
+			data = compressed
+		}
+	}
+	e.size = uint32(len(data))
+	e.partOffset, err = sf.writeChain(data)
+	return
+}
+
+// flushMeta writes the segment meta into new parts and switches the stale
+// meta slot to it. The parts of the previous active meta are freed after
+func (sf *segmentFile) flushMeta() (err error) {
+	sf.meta.blocks = sf.meta.blocks[:0]
+	for _, blk := range sf.blocks {
+		sf.meta.blocks = append(sf.meta.blocks, blk)
+	}
+	sort.Slice(sf.meta.blocks, func(i, j int) bool { return sf.meta.blocks[i].id < sf.meta.blocks[j].id })
+	offset, err := sf.writeChain(sf.meta.Marshal())
+	if err != nil {
+		return
+	}
+	if err = sf.file.Sync(); err != nil {
+		return
+	}
+	prev := &sf.metas[sf.active]
+	stale := 1 - sf.active
+	sf.metas[stale] = fileMeta{version: prev.version + 1, partOffset: offset}
+	if _, err = sf.file.WriteAt(sf.metas[stale].Marshal(), int64(HeaderSize+stale*MetaSize)); err != nil {
+		return
+	}
+	if err = sf.file.Sync(); err != nil {
+		return
+	}
+	sf.freeChain(prev.partOffset)
+	sf.active = stale
+	return
+}
+
+func (sf *segmentFile) freeBlock(blk *blockMeta) {
+	for _, e := range blk.cols {
+		sf.freeChain(e.partOffset)
+	}
+	for _, index := range blk.indexes.indexes {
+		sf.freeChain(index.partOffset)
+	}
+}
+
+func (sf *segmentFile) WriteBlock(id uint64, data batch.IBatch, maxIndex *shard.Index, ts *gvec.Vector) (err error) {
+	if data == nil {
+		return
+	}
+	sf.Lock()
+	defer sf.Unlock()
+	attrs := data.GetAttrs()
+	if sf.meta.Cols() == 0 {
+		sf.meta.algos = make([]uint8, len(attrs))
+		for i := range sf.meta.algos {
+			sf.meta.algos[i] = CompressLz4
+		}
+	} else if sf.meta.Cols() != len(attrs) {
+		return ErrColumnsMismatch
+	}
+	blk := &blockMeta{
+		id:   id,
+		rows: uint32(data.Length()),
+		cols: make([]extent, len(attrs)),
+	}
+	for i, attr := range attrs {
+		var vec vector.IVector
+		if vec, err = data.GetVectorByAttr(attr); err != nil {
+			return
+		}
+		var buf []byte
+		if buf, err = vec.(vector.IVectorNode).Marshal(); err != nil {
+			return
+		}
+		if blk.cols[i], err = sf.writeExtent(buf, sf.meta.algos[i]); err != nil {
+			return
+		}
+	}
+	if ts != nil {
+		var buf []byte
+		if buf, err = ts.Show(); err != nil {
+			return
+		}
+		index := &indexMeta{typ: IndexTypeTimeStamps}
+		if index.extent, err = sf.writeExtent(buf, blk.indexes.algo); err != nil {
+			return
+		}
+		blk.indexes.indexes = append(blk.indexes.indexes, index)
+	}
+	if maxIndex != nil {
+		var buf []byte
+		if buf, err = maxIndex.Marshal(); err != nil {
+			return
+		}
+		index := &indexMeta{typ: IndexTypeLogIndex}
+		if index.extent, err = sf.writeExtent(buf, blk.indexes.algo); err != nil {
+			return
+		}
+		blk.indexes.indexes = append(blk.indexes.indexes, index)
+	}
+	prev := sf.blocks[id]
+	sf.blocks[id] = blk
+	if err = sf.flushMeta(); err != nil {
+		return
+	}
+	if prev != nil {
+		sf.freeBlock(prev)
+	}
+	return
+}
+
+func (sf *segmentFile) LoadBlock(id uint64) (data batch.IBatch, err error) {
+	sf.RLock()
+	defer sf.RUnlock()
+	blk := sf.blocks[id]
+	if blk == nil {
+		return
+	}
+	attrs := make([]int, len(blk.cols))
+	vecs := make([]vector.IVector, len(blk.cols))
+	for i := range blk.cols {
+		var buf []byte
+		if buf, err = sf.readExtent(&blk.cols[i]); err != nil {
+			return
+		}
+		colType := encoding.DecodeType(buf[16 : 16+encoding.TypeSize])
+		vecs[i] = vector.NewVector(colType, uint64(blk.rows))
+		if err = vecs[i].(vector.IVectorNode).Unmarshal(buf); err != nil {
+			return
+		}
+		attrs[i] = i
+	}
+	return batch.NewBatch(attrs, vecs)
+}
+
+func (sf *segmentFile) loadIndex(id uint64, typ uint8) (buf []byte, err error) {
+	sf.RLock()
+	defer sf.RUnlock()
+	blk := sf.blocks[id]
+	if blk == nil {
+		return
+	}
+	index := blk.indexes.Get(typ)
+	if index == nil {
+		return
+	}
+	return sf.readExtent(&index.extent)
+}
+
+// WriteBlockIndex writes the index of typ on the column of the block. The
+// previous index of the same type and column is replaced. The indexes are
+// dropped once the block data is rewritten
+func (sf *segmentFile) WriteBlockIndex(id uint64, typ uint8, colIdx uint16, buf []byte) (err error) {
+	sf.Lock()
+	defer sf.Unlock()
+	blk := sf.blocks[id]
+	if blk == nil {
+		return ErrBlockNotFound
+	}
+	index := &indexMeta{typ: typ, colIdx: colIdx}
+	if index.extent, err = sf.writeExtent(buf, blk.indexes.algo); err != nil {
+		return
+	}
+	pos, prev := blk.indexes.GetColumn(typ, colIdx)
+	if prev != nil {
+		blk.indexes.indexes[pos] = index
+	} else {
+		blk.indexes.indexes = append(blk.indexes.indexes, index)
+	}
+	if err = sf.flushMeta(); err != nil {
+		return
+	}
+	if prev != nil {
+		sf.freeChain(prev.partOffset)
+	}
+	return
+}
+
+// LoadBlockIndex returns nil if the block has no index of typ on the column
+func (sf *segmentFile) LoadBlockIndex(id uint64, typ uint8, colIdx uint16) (buf []byte, err error) {
+	sf.RLock()
+	defer sf.RUnlock()
+	blk := sf.blocks[id]
+	if blk == nil {
+		return
+	}
+	_, index := blk.indexes.GetColumn(typ, colIdx)
+	if index == nil {
+		return
+	}
+	return sf.readExtent(&index.extent)
+}
+
+func (sf *segmentFile) LoadBlockTimeStamps(id uint64) (ts *gvec.Vector, err error) {
+	buf, err := sf.loadIndex(id, IndexTypeTimeStamps)
+	if err != nil || buf == nil {
+		return
+	}
+	ts = new(gvec.Vector)
+	err = ts.Read(buf)
+	return
+}
+
+func (sf *segmentFile) GetBlockMaxIndex(id uint64) *shard.Index {
+	buf, err := sf.loadIndex(id, IndexTypeLogIndex)
+	if err != nil || buf == nil {
+		return nil
+	}
+	index := new(shard.Index)
+	if err = index.UnMarshal(buf); err != nil {
+		return nil
+	}
+	return index
+}
+
+func (sf *segmentFile) GetBlockFile(id uint64) BlockFile {
+	sf.Lock()
+	defer sf.Unlock()
+	bf := sf.files[id]
+	if bf == nil {
+		bf = &blockFile{id: id, seg: sf}
+		sf.files[id] = bf
+	}
+	return bf
+}
+
+func (sf *segmentFile) getBlockRows(id uint64) uint32 {
+	sf.RLock()
+	defer sf.RUnlock()
+	blk := sf.blocks[id]
+	if blk == nil {
+		return 0
+	}
+	return blk.rows
+}
+
+func (sf *segmentFile) hasIndex(id uint64, typ uint8) bool {
+	sf.RLock()
+	defer sf.RUnlock()
+	blk := sf.blocks[id]
+	return blk != nil && blk.indexes.Get(typ) != nil
+}
+
+func (sf *segmentFile) removeBlock(id uint64) (err error) {
+	sf.Lock()
+	defer sf.Unlock()
+	delete(sf.files, id)
+	blk := sf.blocks[id]
+	if blk == nil {
+		return
+	}
+	delete(sf.blocks, id)
+	if err = sf.flushMeta(); err != nil {
+		return
+	}
+	sf.freeBlock(blk)
+	return
+}
+
+func (sf *segmentFile) IsSorted() bool { return false }
+
+func (sf *segmentFile) Close() error {
+	return sf.file.Close()
+}
+
+func (sf *segmentFile) Destory() (err error) {
+	if err = sf.Close(); err != nil {
+		return
+	}
+	return os.Remove(sf.name)
+}
+
+type blockFile struct {
+	mocommon.RefHelper
+	id  uint64
+	seg *segmentFile
+}
+
+func (bf *blockFile) Close() error { return nil }
+func (bf *blockFile) Sync() error  { return bf.seg.file.Sync() }
+func (bf *blockFile) Rows() uint32 { return bf.seg.getBlockRows(bf.id) }
+func (bf *blockFile) IsSorted() bool {
+	return bf.Rows() > 0 && !bf.seg.hasIndex(bf.id, IndexTypeTimeStamps)
+}
+func (bf *blockFile) Destory() error              { return bf.seg.removeBlock(bf.id) }
+func (bf *blockFile) GetSegmentFile() SegmentFile { return bf.seg }
+func (bf *blockFile) GetMaxIndex() *shard.Index   { return bf.seg.GetBlockMaxIndex(bf.id) }
+
+func (bf *blockFile) WriteData(data batch.IBatch, maxIndex *shard.Index, ts *gvec.Vector) error {
+	return bf.seg.WriteBlock(bf.id, data, maxIndex, ts)
+}
+
+func (bf *blockFile) LoadData() (batch.IBatch, error) {
+	return bf.seg.LoadBlock(bf.id)
+}
+
+func (bf *blockFile) GetTimeStamps() (*gvec.Vector, error) {
+	return bf.seg.LoadBlockTimeStamps(bf.id)
+}
+
+func (bf *blockFile) WriteIndex(typ uint8, colIdx uint16, buf []byte) error {
+	return bf.seg.WriteBlockIndex(bf.id, typ, colIdx, buf)
+}
+
+func (bf *blockFile) LoadIndex(typ uint8, colIdx uint16) ([]byte, error) {
+	return bf.seg.LoadBlockIndex(bf.id, typ, colIdx)
+}
@@ -0,0 +1,124 @@
+package dataio
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matrixorigin/matrixone/pkg/container/types"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/container/batch"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/container/vector"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/mock"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/wal/shard"
+	"github.com/stretchr/testify/assert"
+)
+
+func initTestPath(t *testing.T) string {
+	dir := filepath.Join("/tmp", t.Name())
+	os.RemoveAll(dir)
+	return dir
+}
+
+func mockBatch(t *testing.T, colTypes []types.Type, rows uint64) batch.IBatch {
+	src := mock.MockBatch(colTypes, rows)
+	attrs := make([]int, len(colTypes))
+	vecs := make([]vector.IVector, len(colTypes))
+	for i, colType := range colTypes {
+		attrs[i] = i
+		vecs[i] = vector.NewVector(colType, rows)
+		_, err := vecs[i].AppendVector(src.Vecs[i], 0)
+		assert.Nil(t, err)
+	}
+	bat, err := batch.NewBatch(attrs, vecs)
+	assert.Nil(t, err)
+	return bat
+}
+
+func checkBatch(t *testing.T, expected, actual batch.IBatch) {
+	assert.Equal(t, expected.Length(), actual.Length())
+	for _, attr := range expected.GetAttrs() {
+		ev, _ := expected.GetVectorByAttr(attr)
+		av, err := actual.GetVectorByAttr(attr)
+		assert.Nil(t, err)
+		for row := 0; row < expected.Length(); row++ {
+			v1, _ := ev.GetValue(row)
+			v2, err := av.GetValue(row)
+			assert.Nil(t, err)
+			assert.Equal(t, v1, v2)
+		}
+	}
+}
+
+func TestSegmentFile1(t *testing.T) {
+	dir := initTestPath(t)
+	colTypes := []types.Type{
+		{Oid: types.T_int32, Size: 4, Width: 32},
+		{Oid: types.T_varchar, Size: 24, Width: 100},
+	}
+	sf, err := OpenSegmentFile(dir, 1)
+	assert.Nil(t, err)
+
+	bat1 := mockBatch(t, colTypes, 1000)
+	bat2 := mockBatch(t, colTypes, 20000)
+	ts := gvec.New(types.Type{Oid: types.T_uint64, Size: 8, Width: 64})
+	assert.Nil(t, gvec.Append(ts, []uint64{1, 2, 3}))
+	index := &shard.Index{ShardId: 1, Id: shard.SimpleIndexId(10)}
+	assert.Nil(t, sf.WriteBlock(1, bat1, index, ts))
+	assert.Nil(t, sf.GetBlockFile(2).WriteData(bat2, nil, nil))
+
+	loaded, err := sf.LoadBlock(3)
+	assert.Nil(t, err)
+	assert.Nil(t, loaded)
+	assert.True(t, sf.GetBlockFile(2).(*blockFile).IsSorted())
+	assert.Nil(t, sf.Close())
+
+	sf, err = OpenSegmentFile(dir, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1000), sf.GetBlockFile(1).Rows())
+	assert.Equal(t, uint32(20000), sf.GetBlockFile(2).Rows())
+	loaded, err = sf.LoadBlock(1)
+	assert.Nil(t, err)
+	checkBatch(t, bat1, loaded)
+	loaded, err = sf.GetBlockFile(2).LoadData()
+	assert.Nil(t, err)
+	checkBatch(t, bat2, loaded)
+	loadedTs, err := sf.LoadBlockTimeStamps(1)
+	assert.Nil(t, err)
+	assert.Equal(t, ts.Col, loadedTs.Col)
+	assert.Equal(t, index.Id.Id, sf.GetBlockMaxIndex(1).Id.Id)
+	assert.Nil(t, sf.GetBlockMaxIndex(2))
+	assert.Nil(t, sf.GetBlockFile(1).WriteIndex(IndexTypeZoneMap, 1, []byte("zm1")))
+	assert.Nil(t, sf.GetBlockFile(1).WriteIndex(IndexTypeZoneMap, 1, []byte("zm2")))
+	assert.Equal(t, ErrBlockNotFound, sf.GetBlockFile(3).WriteIndex(IndexTypeZoneMap, 1, []byte("zm")))
+	buf, err := sf.GetBlockFile(1).LoadIndex(IndexTypeZoneMap, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("zm2"), buf)
+	buf, err = sf.GetBlockFile(1).LoadIndex(IndexTypeZoneMap, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, buf)
+
+	// Overwrite block 1 and remove block 2. The freed parts should be reused
+	end := sf.end
+	assert.Nil(t, sf.WriteBlock(1, bat2, nil, nil))
+	assert.Nil(t, sf.GetBlockFile(2).Destory())
+	assert.Nil(t, sf.GetBlockFile(1).WriteData(bat1, nil, nil))
+	assert.True(t, sf.end <= end+uint32(len(bat2.GetAttrs())+1)*uint32(DefaultMaxPartSize)*PartUnit)
+	assert.Nil(t, sf.Close())
+
+	sf, err = OpenSegmentFile(dir, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), sf.GetBlockFile(2).Rows())
+	loaded, err = sf.LoadBlock(1)
+	assert.Nil(t, err)
+	checkBatch(t, bat1, loaded)
+	assert.Nil(t, sf.GetBlockMaxIndex(1))
+	buf, err = sf.GetBlockFile(1).LoadIndex(IndexTypeZoneMap, 1)
+	assert.Nil(t, err)
+	assert.Nil(t, buf)
+	t.Logf("file size %d, free parts %d", sf.end, len(sf.free))
+
+	assert.Nil(t, sf.Destory())
+	_, err = os.Stat(sf.name)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -41,6 +41,8 @@ type BlockFile interface {
 	Sync() error
 	GetMaxIndex() *shard.Index
 	GetTimeStamps() (*gvec.Vector, error)
+	WriteIndex(typ uint8, colIdx uint16, buf []byte) error
+	LoadIndex(typ uint8, colIdx uint16) ([]byte, error)
 	// GetColumnFile(idx uint16) common.IVFile
 	// IsSorted() bool
 	// GetColumnStat(idx uint16) common.FileInfo
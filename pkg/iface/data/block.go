@@ -3,6 +3,7 @@ package data
 import (
 	"bytes"
 	"io"
+	"tae/pkg/iface/handle"
 	"tae/pkg/iface/txnif"
 
 	"github.com/matrixorigin/matrixone/pkg/container/batch"
@@ -22,5 +23,15 @@ type Block interface {
 	IsAppendable() bool
 	Rows(txn txnif.AsyncTxn, coarse bool) int
 	GetVectorCopy(txn txnif.AsyncTxn, attr string, compressed, decompressed *bytes.Buffer) (*vector.Vector, error)
+	GetByFilter(txn txnif.AsyncTxn, filter handle.Filter, offsetOnly bool) (*batch.Batch, error)
+	MayMatch(txn txnif.AsyncTxn, filter handle.Filter) (bool, error)
+	CheckRWConflict(txn txnif.AsyncTxn, filter handle.Filter) error
+	BatchDedup(txn txnif.AsyncTxn, pks *vector.Vector) error
+	GetUpdateChain() interface{}
+	RecordTxnAppend(txn txnif.TxnReader, offset uint32)
+	ApplyCommitAppend(txn txnif.TxnReader) error
+	// RollbackAppend marks the rows [start, end] appended by a rolled back
+	// txn as dead
+	RollbackAppend(start, end uint32) error
 	// CopyBatch(cs []uint64, attrs []string, compressed []*bytes.Buffer, deCompressed []*bytes.Buffer) (*batch.Batch, error)
 }
@@ -6,4 +6,5 @@ var (
 	ErrAppendableSegmentNotFound = errors.New("tae: no appendable segment")
 	ErrAppendableBlockNotFound   = errors.New("tae: no appendable block")
 	ErrNotAppendable             = errors.New("tae: not appendable")
+	ErrInvalidFilter             = errors.New("tae: invalid filter")
 )
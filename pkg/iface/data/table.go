@@ -1,6 +1,12 @@
 package data
 
-import "github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/common"
+import (
+	"tae/pkg/iface/handle"
+	"tae/pkg/iface/txnif"
+
+	"github.com/matrixorigin/matrixone/pkg/container/vector"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/common"
+)
 
 func IsSegmentID(id *common.ID) bool {
 	return id.SegmentID != 0 && id.BlockID == 0
@@ -10,10 +16,21 @@ func IsBlockID(id *common.ID) bool {
 	return id.BlockID != 0
 }
 
+// KeyDeduper dedups the keys against the keys it holds
+type KeyDeduper interface {
+	BatchDedup(pks *vector.Vector) error
+}
+
 type Table interface {
 	GetAppender() (*common.ID, BlockAppender, error)
 	SetAppender(id *common.ID) (BlockAppender, error)
 	HasAppendableSegment() bool
+	BatchDedup(txn txnif.AsyncTxn, pks *vector.Vector) error
+	// AddPrepared makes the keys appended by a prepared txn duplicated until
+	// RemovePrepared is called once they are appended or rollbacked
+	AddPrepared(txnId uint64, keys KeyDeduper)
+	RemovePrepared(txnId uint64)
+	CheckRWConflict(txn txnif.AsyncTxn, filter handle.Filter) error
 }
 
 // func append() {
@@ -21,9 +21,17 @@ const (
 	FilterBtw
 )
 
+// OffsetAttr is the attr of the row offsets returned by GetByFilter with
+// offsetOnly
+const OffsetAttr = "$offset"
+
+// Filter matches the rows whose Attr equals one of the values in Col for
+// FilterEq, or is within [Col[0], Col[1]] for FilterBtw. The primary key is
+// used if Attr is empty
 type Filter struct {
-	Op  FilterOp
-	Col *vector.Vector
+	Op   FilterOp
+	Attr string
+	Col  *vector.Vector
 }
 
 type BlockReader interface {
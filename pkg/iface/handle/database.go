@@ -9,6 +9,7 @@ type Database interface {
 	GetName() string
 	CreateRelation(def interface{}) (Relation, error)
 	DropRelationByName(name string) (Relation, error)
+	RenameRelation(name, newName string) error
 
 	GetRelationByName(name string) (Relation, error)
 	RelationCnt() int64
@@ -21,8 +21,19 @@ type Relation interface {
 	MakeSegmentIt() SegmentIt
 	MakeReader() Reader
 
+	// BatchDedup checks the primary keys in col. The keys of a composite
+	// primary key are the ones built by index.NewCompositeKeys
 	BatchDedup(col *vector.Vector) error
 	Append(data *batch.Batch) error
+	// DeleteByFilter deletes the rows visible to the txn that match filter,
+	// including the ones appended by the txn
+	DeleteByFilter(filter Filter) error
+	// UpdateByFilter sets attr of the rows visible to the txn that match
+	// filter to v, including the ones appended by the txn
+	UpdateByFilter(filter Filter, attr string, v interface{}) error
+	// AlterTable alters the columns of the table by req, which is a
+	// *catalog.AlterTableReq
+	AlterTable(req interface{}) error
 	String() string
 
 	GetMeta() interface{}
@@ -1,7 +1,7 @@
 package txnif
 
 const (
-	UncommitTS = ^uint64(0)
+	UncommitTS = TS(^uint64(0))
 )
 
 const (
@@ -10,6 +10,9 @@ const (
 	TxnStateRollbacking
 	TxnStateCommitted
 	TxnStateRollbacked
+	// TxnStatePrepared is the state of a txn prepared for a commit decided by
+	// an external coordinator
+	TxnStatePrepared
 )
 
 func TxnStrState(state int32) string {
@@ -24,6 +27,34 @@ func TxnStrState(state int32) string {
 		return "Committed"
 	case TxnStateRollbacked:
 		return "Rollbacked"
+	case TxnStatePrepared:
+		return "Prepared"
 	}
 	panic("state not support")
 }
+
+// IsolationLevel decides which committed changes a txn reads and which
+// conflicts abort it
+type IsolationLevel int8
+
+const (
+	// SnapshotIsolation reads the changes committed before the txn starts
+	SnapshotIsolation IsolationLevel = iota
+	// ReadCommitted reads the changes committed before each statement starts
+	ReadCommitted
+	// Serializable is SnapshotIsolation and aborts the txn if a concurrent txn
+	// committed a change into what it read
+	Serializable
+)
+
+func (level IsolationLevel) String() string {
+	switch level {
+	case SnapshotIsolation:
+		return "SI"
+	case ReadCommitted:
+		return "RC"
+	case Serializable:
+		return "SSI"
+	}
+	panic("isolation level not support")
+}
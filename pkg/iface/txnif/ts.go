@@ -0,0 +1,71 @@
+package txnif
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// TS is a hybrid logical clock timestamp. The high bits are the physical time
+// in milliseconds since the unix epoch and the low TSLogicalBits bits are a
+// logical counter ordering the timestamps of the same millisecond. A TS is
+// persisted as its 8 bytes in big endian
+type TS uint64
+
+const (
+	TSLogicalBits = 18
+	TSSize        = 8
+
+	tsLogicalMask = 1<<TSLogicalBits - 1
+)
+
+func NewTS(physical int64, logical uint32) TS {
+	return TS(uint64(physical)<<TSLogicalBits | uint64(logical)&tsLogicalMask)
+}
+
+// UnmarshalTS decodes a TS marshalled by TS.Marshal
+func UnmarshalTS(buf []byte) TS { return TS(binary.BigEndian.Uint64(buf)) }
+
+// Physical returns the physical time in milliseconds
+func (ts TS) Physical() int64     { return int64(ts >> TSLogicalBits) }
+func (ts TS) Logical() uint32     { return uint32(ts & tsLogicalMask) }
+func (ts TS) Time() time.Time     { return time.UnixMilli(ts.Physical()) }
+func (ts TS) IsEmpty() bool       { return ts == 0 }
+func (ts TS) Less(o TS) bool      { return ts < o }
+func (ts TS) LessEq(o TS) bool    { return ts <= o }
+func (ts TS) Greater(o TS) bool   { return ts > o }
+func (ts TS) GreaterEq(o TS) bool { return ts >= o }
+
+// Next returns the smallest TS greater than ts. The logical counter carries
+// into the physical time on overflow
+func (ts TS) Next() TS { return ts + 1 }
+func (ts TS) Prev() TS { return ts - 1 }
+
+func (ts TS) Compare(o TS) int {
+	if ts < o {
+		return -1
+	} else if ts > o {
+		return 1
+	}
+	return 0
+}
+
+func (ts TS) Marshal() []byte {
+	buf := make([]byte, TSSize)
+	binary.BigEndian.PutUint64(buf, uint64(ts))
+	return buf
+}
+
+func (ts TS) String() string {
+	if ts == UncommitTS {
+		return "Uncommit"
+	}
+	return fmt.Sprintf("%d-%d", ts.Physical(), ts.Logical())
+}
+
+func MaxTS(a, b TS) TS {
+	if a > b {
+		return a
+	}
+	return b
+}
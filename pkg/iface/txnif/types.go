@@ -23,18 +23,31 @@ type Txn2PC interface {
 	ApplyCommit() error
 }
 
+// TxnPreparer prepares a txn for a commit decided by an external coordinator.
+// PrePrepare validates the txn, LogPrepare logs its prepare record and
+// ApplyPrepare waits until the record is durable
+type TxnPreparer interface {
+	PrePrepare() error
+	LogPrepare() error
+	ApplyPrepare() error
+}
+
 type TxnReader interface {
 	RLock()
 	RUnlock()
 	GetID() uint64
-	GetStartTS() uint64
-	GetCommitTS() uint64
+	GetStartTS() TS
+	GetCommitTS() TS
+	GetPrepareTS() TS
 	GetInfo() []byte
+	GetIsolationLevel() IsolationLevel
+	IsReadOnly() bool
 	IsTerminated(bool) bool
 	IsVisible(o TxnReader) bool
 	// Compare(o TxnReader) int
 	GetTxnState(waitIfcommitting bool) int32
 	GetError() error
+	IsAborted() bool
 	GetStore() TxnStore
 	String() string
 	Repr() string
@@ -47,6 +60,7 @@ type TxnHandle interface {
 	// Append(uint64, *batch.Batch)
 	CreateDatabase(name string) (handle.Database, error)
 	DropDatabase(name string) (handle.Database, error)
+	RenameDatabase(name, newName string) (handle.Database, error)
 	GetDatabase(name string) (handle.Database, error)
 	UseDatabase(name string) error
 }
@@ -56,11 +70,21 @@ type TxnChanger interface {
 	RLock()
 	RUnlock()
 	ToCommittedLocked() error
-	ToCommittingLocked(ts uint64) error
+	ToCommittingLocked(ts TS) error
 	ToRollbackedLocked() error
-	ToRollbackingLocked(ts uint64) error
+	ToRollbackingLocked(ts TS) error
+	ToPreparedLocked(ts TS) error
 	Commit() error
 	Rollback() error
+	Prepare() (TS, error)
+	CommitPrepared(ts TS) error
+	AbortPrepared() error
+	Abort() error
+	BeginOp() error
+	EndOp()
+	Savepoint() (int, error)
+	RollbackToSavepoint(id int) error
+	StartStatement()
 	SetError(error)
 	SetPrepareCommitFn(func(interface{}) error)
 }
@@ -73,12 +97,13 @@ type TxnAsyncer interface {
 }
 
 type TxnTest interface {
-	MockSetCommitTSLocked(ts uint64)
+	MockSetCommitTSLocked(ts TS)
 }
 
 type AsyncTxn interface {
 	TxnTest
 	Txn2PC
+	TxnPreparer
 	TxnHandle
 	TxnAsyncer
 	TxnReader
@@ -112,26 +137,36 @@ type ColumnUpdates interface {
 
 type TxnStore interface {
 	Txn2PC
+	TxnPreparer
 	io.Closer
 	BindTxn(AsyncTxn)
 
+	BatchDedup(id uint64, pks *vector.Vector) error
 	Append(id uint64, data *batch.Batch) error
+	AlterTable(id uint64, req interface{}) error
 	RangeDeleteLocalRows(id uint64, start, end uint32) error
 	UpdateLocalValue(id uint64, row uint32, col uint16, v interface{}) error
 	AddUpdateNode(id uint64, node BlockUpdates) error
+	RangeDelete(id *common.ID, start, end uint32) error
+	Update(id *common.ID, row uint32, col uint16, v interface{}) error
 
 	CreateRelation(def interface{}) (handle.Relation, error)
 	DropRelationByName(name string) (handle.Relation, error)
+	RenameRelation(name, newName string) error
 	GetRelationByName(name string) (handle.Relation, error)
 
 	CreateDatabase(name string) (handle.Database, error)
 	GetDatabase(name string) (handle.Database, error)
 	DropDatabase(name string) (handle.Database, error)
+	RenameDatabase(name, newName string) (handle.Database, error)
 	UseDatabase(name string) error
 
 	CreateSegment(tid uint64) (handle.Segment, error)
 	CreateBlock(tid, sid uint64) (handle.Block, error)
 
+	Savepoint() int
+	RollbackToSavepoint(id int) error
+
 	AddTxnEntry(TxnEntryType, TxnEntry)
 }
 
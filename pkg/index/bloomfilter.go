@@ -0,0 +1,172 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"tae/pkg/txn/txnbase"
+
+	"github.com/matrixorigin/matrixone/pkg/container/types"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+)
+
+var (
+	ErrInvalidBloomFilter = errors.New("tae index: invalid bloom filter")
+)
+
+const DefaultFalsePositiveRate = 0.01
+
+// BloomFilter answers if a key may be in a set of keys. It is not thread-safe
+// and is read-only once built
+type BloomFilter struct {
+	nbits  uint64
+	hashes uint32
+	bits   []uint64
+}
+
+// NewBloomFilter creates a bloom filter sized for n keys with the false
+// positive rate fpRate
+func NewBloomFilter(n int, fpRate float64) *BloomFilter {
+	if n <= 0 {
+		n = 1
+	}
+	nbits := bloomFilterBits(n, fpRate)
+	hashes := uint32(math.Round(float64(nbits) / float64(n) * math.Ln2))
+	if hashes == 0 {
+		hashes = 1
+	}
+	return &BloomFilter{
+		nbits:  nbits,
+		hashes: hashes,
+		bits:   make([]uint64, (nbits+63)/64),
+	}
+}
+
+func bloomFilterBits(n int, fpRate float64) uint64 {
+	nbits := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	if nbits < 64 {
+		nbits = 64
+	}
+	return nbits
+}
+
+// EstimateBloomFilterSize returns the memory size in bytes of a bloom filter
+// created by NewBloomFilter(n, fpRate)
+func EstimateBloomFilterSize(n int, fpRate float64) int {
+	if n <= 0 {
+		n = 1
+	}
+	return int((bloomFilterBits(n, fpRate) + 63) / 64 * 8)
+}
+
+func (bf *BloomFilter) locations(v interface{}) (h1, h2 uint64) {
+	h := fnv.New64a()
+	h.Write(keyBytes(v))
+	sum := h.Sum64()
+	return sum & math.MaxUint32, sum>>32 | 1
+}
+
+func (bf *BloomFilter) Add(v interface{}) {
+	h1, h2 := bf.locations(v)
+	for i := uint64(0); i < uint64(bf.hashes); i++ {
+		pos := (h1 + i*h2) % bf.nbits
+		bf.bits[pos/64] |= 1 << (pos % 64)
+	}
+}
+
+// BatchAdd adds the rows [offset, offset+length) of vec
+func (bf *BloomFilter) BatchAdd(vec *gvec.Vector, offset, length int) {
+	for i := offset; i < offset+length; i++ {
+		bf.Add(txnbase.GetValue(vec, uint32(i)))
+	}
+}
+
+// MayContainsKey returns false if v is definitely not in the set
+func (bf *BloomFilter) MayContainsKey(v interface{}) bool {
+	h1, h2 := bf.locations(v)
+	for i := uint64(0); i < uint64(bf.hashes); i++ {
+		pos := (h1 + i*h2) % bf.nbits
+		if bf.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (bf *BloomFilter) Marshal() ([]byte, error) {
+	var w bytes.Buffer
+	if err := binary.Write(&w, binary.BigEndian, bf.nbits); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&w, binary.BigEndian, bf.hashes); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&w, binary.BigEndian, bf.bits); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+func (bf *BloomFilter) Unmarshal(buf []byte) (err error) {
+	r := bytes.NewBuffer(buf)
+	if err = binary.Read(r, binary.BigEndian, &bf.nbits); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &bf.hashes); err != nil {
+		return
+	}
+	if bf.nbits == 0 || bf.hashes == 0 || uint64(r.Len()) != (bf.nbits+63)/64*8 {
+		return ErrInvalidBloomFilter
+	}
+	bf.bits = make([]uint64, (bf.nbits+63)/64)
+	return binary.Read(r, binary.BigEndian, bf.bits)
+}
+
+// keyBytes encodes a column value for hashing. Bytes can be given either as
+// string or []byte
+func keyBytes(v interface{}) []byte {
+	var buf [8]byte
+	switch k := v.(type) {
+	case int8:
+		return []byte{byte(k)}
+	case uint8:
+		return []byte{k}
+	case int16:
+		binary.BigEndian.PutUint16(buf[:], uint16(k))
+		return buf[:2]
+	case uint16:
+		binary.BigEndian.PutUint16(buf[:], k)
+		return buf[:2]
+	case int32:
+		binary.BigEndian.PutUint32(buf[:], uint32(k))
+		return buf[:4]
+	case uint32:
+		binary.BigEndian.PutUint32(buf[:], k)
+		return buf[:4]
+	case float32:
+		binary.BigEndian.PutUint32(buf[:], math.Float32bits(k))
+		return buf[:4]
+	case types.Date:
+		binary.BigEndian.PutUint32(buf[:], uint32(k))
+		return buf[:4]
+	case int64:
+		binary.BigEndian.PutUint64(buf[:], uint64(k))
+		return buf[:]
+	case uint64:
+		binary.BigEndian.PutUint64(buf[:], k)
+		return buf[:]
+	case float64:
+		binary.BigEndian.PutUint64(buf[:], math.Float64bits(k))
+		return buf[:]
+	case types.Datetime:
+		binary.BigEndian.PutUint64(buf[:], uint64(k))
+		return buf[:]
+	case string:
+		return []byte(k)
+	case []byte:
+		return k
+	}
+	panic(ErrNotSupported)
+}
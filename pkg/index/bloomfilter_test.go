@@ -0,0 +1,49 @@
+package index
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/matrixorigin/matrixone/pkg/container/types"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloomFilter(t *testing.T) {
+	typ := types.Type{Oid: types.T_int32, Size: 4, Width: 32}
+	vec := gvec.New(typ)
+	vals := make([]int32, 1000)
+	for i := range vals {
+		vals[i] = int32(i * 2)
+	}
+	assert.Nil(t, gvec.Append(vec, vals))
+	bf := NewBloomFilter(len(vals), DefaultFalsePositiveRate)
+	bf.BatchAdd(vec, 0, len(vals))
+	for _, v := range vals {
+		assert.True(t, bf.MayContainsKey(v))
+	}
+	falsePositives := 0
+	for _, v := range vals {
+		if bf.MayContainsKey(v + 1) {
+			falsePositives++
+		}
+	}
+	assert.True(t, falsePositives < 50)
+
+	buf, err := bf.Marshal()
+	assert.Nil(t, err)
+	bf2 := new(BloomFilter)
+	assert.Nil(t, bf2.Unmarshal(buf))
+	for _, v := range vals {
+		assert.True(t, bf2.MayContainsKey(v))
+	}
+	assert.Equal(t, ErrInvalidBloomFilter, bf2.Unmarshal(buf[:len(buf)-1]))
+
+	bf = NewBloomFilter(10, DefaultFalsePositiveRate)
+	for i := 0; i < 10; i++ {
+		bf.Add([]byte(fmt.Sprintf("key-%d", i)))
+	}
+	assert.True(t, bf.MayContainsKey("key-1"))
+	assert.True(t, bf.MayContainsKey([]byte("key-9")))
+	assert.Equal(t, len(bf.bits)*8, EstimateBloomFilterSize(10, DefaultFalsePositiveRate))
+}
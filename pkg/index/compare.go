@@ -0,0 +1,95 @@
+package index
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/matrixorigin/matrixone/pkg/container/types"
+)
+
+var (
+	ErrNotSupported = errors.New("tae index: type not supported")
+)
+
+// IsComparable returns true if the values of typ can be compared by Compare
+func IsComparable(typ types.Type) bool {
+	switch typ.Oid {
+	case types.T_int8, types.T_int16, types.T_int32, types.T_int64,
+		types.T_uint8, types.T_uint16, types.T_uint32, types.T_uint64,
+		types.T_float32, types.T_float64, types.T_date, types.T_datetime,
+		types.T_char, types.T_varchar, types.T_json:
+		return true
+	}
+	return false
+}
+
+// Compare returns -1, 0 or 1 if v1 is less than, equal to or greater than v2.
+// Both values should be of the same column type. Bytes can be given either as
+// string or []byte
+func Compare(v1, v2 interface{}) int {
+	switch a := v1.(type) {
+	case int8:
+		return compareInt64(int64(a), int64(v2.(int8)))
+	case int16:
+		return compareInt64(int64(a), int64(v2.(int16)))
+	case int32:
+		return compareInt64(int64(a), int64(v2.(int32)))
+	case int64:
+		return compareInt64(a, v2.(int64))
+	case uint8:
+		return compareUint64(uint64(a), uint64(v2.(uint8)))
+	case uint16:
+		return compareUint64(uint64(a), uint64(v2.(uint16)))
+	case uint32:
+		return compareUint64(uint64(a), uint64(v2.(uint32)))
+	case uint64:
+		return compareUint64(a, v2.(uint64))
+	case float32:
+		return compareFloat64(float64(a), float64(v2.(float32)))
+	case float64:
+		return compareFloat64(a, v2.(float64))
+	case types.Date:
+		return compareInt64(int64(a), int64(v2.(types.Date)))
+	case types.Datetime:
+		return compareInt64(int64(a), int64(v2.(types.Datetime)))
+	case string:
+		return bytes.Compare([]byte(a), toBytes(v2))
+	case []byte:
+		return bytes.Compare(a, toBytes(v2))
+	}
+	panic(ErrNotSupported)
+}
+
+func toBytes(v interface{}) []byte {
+	if s, ok := v.(string); ok {
+		return []byte(s)
+	}
+	return v.([]byte)
+}
+
+func compareInt64(a, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareUint64(a, b uint64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareFloat64(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,146 @@
+package index
+
+import (
+	"encoding/binary"
+
+	"github.com/matrixorigin/matrixone/pkg/container/types"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+	"github.com/matrixorigin/matrixone/pkg/encoding"
+)
+
+var compositeKeyType = types.Type{Oid: types.T_varchar, Size: 24}
+
+// KeyColumn gives the keys of a column as raw bytes without boxing them. The
+// bytes of a fixed size key share the memory of the vector. A key of any type
+// can be used as a map key by string(key)
+type KeyColumn struct {
+	fixed  []byte
+	size   int
+	varlen *types.Bytes
+}
+
+func NewKeyColumn(vec *gvec.Vector) (*KeyColumn, error) {
+	col := new(KeyColumn)
+	switch vec.Typ.Oid {
+	case types.T_int8:
+		col.fixed, col.size = encoding.EncodeInt8Slice(vec.Col.([]int8)), 1
+	case types.T_int16:
+		col.fixed, col.size = encoding.EncodeInt16Slice(vec.Col.([]int16)), 2
+	case types.T_int32:
+		col.fixed, col.size = encoding.EncodeInt32Slice(vec.Col.([]int32)), 4
+	case types.T_int64:
+		col.fixed, col.size = encoding.EncodeInt64Slice(vec.Col.([]int64)), 8
+	case types.T_uint8:
+		col.fixed, col.size = encoding.EncodeUint8Slice(vec.Col.([]uint8)), 1
+	case types.T_uint16:
+		col.fixed, col.size = encoding.EncodeUint16Slice(vec.Col.([]uint16)), 2
+	case types.T_uint32:
+		col.fixed, col.size = encoding.EncodeUint32Slice(vec.Col.([]uint32)), 4
+	case types.T_uint64:
+		col.fixed, col.size = encoding.EncodeUint64Slice(vec.Col.([]uint64)), 8
+	case types.T_float32:
+		col.fixed, col.size = encoding.EncodeFloat32Slice(vec.Col.([]float32)), 4
+	case types.T_float64:
+		col.fixed, col.size = encoding.EncodeFloat64Slice(vec.Col.([]float64)), 8
+	case types.T_date:
+		col.fixed, col.size = encoding.EncodeDateSlice(vec.Col.([]types.Date)), encoding.DateSize
+	case types.T_datetime:
+		col.fixed, col.size = encoding.EncodeDatetimeSlice(vec.Col.([]types.Datetime)), encoding.DatetimeSize
+	case types.T_decimal:
+		col.fixed, col.size = encoding.EncodeDecimalSlice(vec.Col.([]types.Decimal)), encoding.DecimalSize
+	case types.T_char, types.T_varchar, types.T_json:
+		col.varlen = vec.Col.(*types.Bytes)
+		return col, nil
+	default:
+		return nil, ErrNotSupported
+	}
+	return col, nil
+}
+
+func (col *KeyColumn) Length() int {
+	if col.varlen != nil {
+		return len(col.varlen.Offsets)
+	}
+	return len(col.fixed) / col.size
+}
+
+func (col *KeyColumn) IsFixed() bool { return col.varlen == nil }
+
+// Get returns the key of the row. It should not be modified
+func (col *KeyColumn) Get(row int) []byte {
+	if col.varlen != nil {
+		s := col.varlen.Offsets[row]
+		return col.varlen.Data[s : s+col.varlen.Lengths[row]]
+	}
+	return col.fixed[row*col.size : (row+1)*col.size]
+}
+
+// EncodeKey encodes a single value the same way as KeyColumn. Bytes can be
+// given either as string or []byte. An int or uint is not supported as its
+// size is not explicit
+func EncodeKey(v interface{}) ([]byte, error) {
+	switch k := v.(type) {
+	case int8:
+		return encoding.EncodeInt8(k), nil
+	case int16:
+		return encoding.EncodeInt16(k), nil
+	case int32:
+		return encoding.EncodeInt32(k), nil
+	case int64:
+		return encoding.EncodeInt64(k), nil
+	case uint8:
+		return encoding.EncodeUint8(k), nil
+	case uint16:
+		return encoding.EncodeUint16(k), nil
+	case uint32:
+		return encoding.EncodeUint32(k), nil
+	case uint64:
+		return encoding.EncodeUint64(k), nil
+	case float32:
+		return encoding.EncodeFloat32(k), nil
+	case float64:
+		return encoding.EncodeFloat64(k), nil
+	case types.Date:
+		return encoding.EncodeDate(k), nil
+	case types.Datetime:
+		return encoding.EncodeDatetime(k), nil
+	case types.Decimal:
+		return encoding.EncodeDecimalSlice([]types.Decimal{k}), nil
+	case string:
+		return []byte(k), nil
+	case []byte:
+		return k, nil
+	}
+	return nil, ErrNotSupported
+}
+
+// NewCompositeKeys builds a varchar vector of the keys spanning the columns.
+// Each key concatenates the keys of the columns, with the variable length ones
+// prefixed by their lengths, so two keys are equal only if all their columns
+// are equal
+func NewCompositeKeys(cols ...*gvec.Vector) (vec *gvec.Vector, err error) {
+	keyCols := make([]*KeyColumn, len(cols))
+	for i, col := range cols {
+		if keyCols[i], err = NewKeyColumn(col); err != nil {
+			return
+		}
+	}
+	rows := keyCols[0].Length()
+	keys := make([][]byte, rows)
+	var prefix [4]byte
+	for row := 0; row < rows; row++ {
+		key := make([]byte, 0)
+		for _, col := range keyCols {
+			v := col.Get(row)
+			if !col.IsFixed() {
+				binary.BigEndian.PutUint32(prefix[:], uint32(len(v)))
+				key = append(key, prefix[:]...)
+			}
+			key = append(key, v...)
+		}
+		keys[row] = key
+	}
+	vec = gvec.New(compositeKeyType)
+	err = gvec.Append(vec, keys)
+	return
+}
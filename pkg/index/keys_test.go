@@ -0,0 +1,49 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/matrixorigin/matrixone/pkg/container/types"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyColumn(t *testing.T) {
+	vec := gvec.New(types.Type{Oid: types.T_int64, Size: 8, Width: 64})
+	assert.Nil(t, gvec.Append(vec, []int64{1, 2, 3}))
+	keys, err := NewKeyColumn(vec)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, keys.Length())
+	key, err := EncodeKey(int64(2))
+	assert.Nil(t, err)
+	assert.Equal(t, key, keys.Get(1))
+
+	vec = gvec.New(types.Type{Oid: types.T_varchar, Size: 24})
+	assert.Nil(t, gvec.Append(vec, [][]byte{[]byte("ab"), []byte("c")}))
+	keys, err = NewKeyColumn(vec)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, keys.Length())
+	key, err = EncodeKey("c")
+	assert.Nil(t, err)
+	assert.Equal(t, key, keys.Get(1))
+
+	// The lengths keep ("ab", "c") and ("a", "bc") apart
+	col0 := gvec.New(types.Type{Oid: types.T_varchar, Size: 24})
+	assert.Nil(t, gvec.Append(col0, [][]byte{[]byte("ab"), []byte("a")}))
+	col1 := gvec.New(types.Type{Oid: types.T_varchar, Size: 24})
+	assert.Nil(t, gvec.Append(col1, [][]byte{[]byte("c"), []byte("bc")}))
+	composite, err := NewCompositeKeys(col0, col1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, gvec.Length(composite))
+	keys, err = NewKeyColumn(composite)
+	assert.Nil(t, err)
+	assert.NotEqual(t, keys.Get(0), keys.Get(1))
+
+	_, err = EncodeKey(2)
+	assert.Equal(t, ErrNotSupported, err)
+	_, err = EncodeKey(uint(2))
+	assert.Equal(t, ErrNotSupported, err)
+
+	_, err = NewKeyColumn(gvec.New(types.Type{Oid: types.T_sel}))
+	assert.Equal(t, ErrNotSupported, err)
+}
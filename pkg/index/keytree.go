@@ -0,0 +1,88 @@
+package index
+
+import (
+	"tae/pkg/txn/txnbase"
+
+	"github.com/google/btree"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+)
+
+type keyItem struct {
+	key interface{}
+	row uint32
+}
+
+func (item *keyItem) Less(than btree.Item) bool {
+	return Compare(item.key, than.(*keyItem).key) < 0
+}
+
+// KeyTree maps the unique keys of a block to their rows. It is not
+// thread-safe and should be protected by the block lock
+type KeyTree struct {
+	tree *btree.BTree
+}
+
+func NewKeyTree() *KeyTree {
+	return &KeyTree{
+		tree: btree.New(32),
+	}
+}
+
+func normalizeKey(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
+
+func (kt *KeyTree) Len() int { return kt.tree.Len() }
+
+// Insert returns txnbase.ErrDuplicated if the key already exists
+func (kt *KeyTree) Insert(v interface{}, row uint32) error {
+	item := &keyItem{key: normalizeKey(v), row: row}
+	if kt.tree.Has(item) {
+		return txnbase.ErrDuplicated
+	}
+	kt.tree.ReplaceOrInsert(item)
+	return nil
+}
+
+// BatchInsert inserts the rows [offset, offset+length) of vec with the rows
+// starting from row. If dedup is true, txnbase.ErrDuplicated is returned on an
+// existing key and the keys inserted before are kept. Otherwise the row of an
+// existing key is replaced
+func (kt *KeyTree) BatchInsert(vec *gvec.Vector, offset, length int, row uint32, dedup bool) error {
+	for i := offset; i < offset+length; i++ {
+		v := txnbase.GetValue(vec, uint32(i))
+		if dedup {
+			if err := kt.Insert(v, row); err != nil {
+				return err
+			}
+		} else {
+			kt.tree.ReplaceOrInsert(&keyItem{key: normalizeKey(v), row: row})
+		}
+		row++
+	}
+	return nil
+}
+
+// Delete removes the key if it maps to row
+func (kt *KeyTree) Delete(v interface{}, row uint32) {
+	item := kt.tree.Get(&keyItem{key: normalizeKey(v)})
+	if item == nil || item.(*keyItem).row != row {
+		return
+	}
+	kt.tree.Delete(item)
+}
+
+func (kt *KeyTree) Search(v interface{}) (uint32, error) {
+	item := kt.tree.Get(&keyItem{key: normalizeKey(v)})
+	if item == nil {
+		return 0, txnbase.ErrNotFound
+	}
+	return item.(*keyItem).row, nil
+}
+
+func (kt *KeyTree) Contains(v interface{}) bool {
+	return kt.tree.Has(&keyItem{key: normalizeKey(v)})
+}
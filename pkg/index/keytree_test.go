@@ -0,0 +1,40 @@
+package index
+
+import (
+	"tae/pkg/txn/txnbase"
+	"testing"
+
+	"github.com/matrixorigin/matrixone/pkg/container/types"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyTree(t *testing.T) {
+	typ := types.Type{Oid: types.T_varchar, Size: 24}
+	vec := gvec.New(typ)
+	assert.Nil(t, gvec.Append(vec, [][]byte{[]byte("a"), []byte("c"), []byte("b")}))
+	kt := NewKeyTree()
+	assert.Nil(t, kt.BatchInsert(vec, 0, 3, 10, true))
+	assert.Equal(t, 3, kt.Len())
+	row, err := kt.Search([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(12), row)
+	assert.True(t, kt.Contains("c"))
+	assert.False(t, kt.Contains("d"))
+	_, err = kt.Search("d")
+	assert.NotNil(t, err)
+	assert.NotNil(t, kt.Insert("a", 20))
+	assert.Nil(t, kt.Insert("d", 20))
+	assert.Equal(t, txnbase.ErrDuplicated, kt.BatchInsert(vec, 1, 2, 30, true))
+	assert.Nil(t, kt.BatchInsert(vec, 1, 2, 30, false))
+	row, err = kt.Search("b")
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(31), row)
+	assert.Equal(t, 4, kt.Len())
+
+	kt.Delete("b", 12)
+	assert.True(t, kt.Contains("b"))
+	kt.Delete("b", 31)
+	assert.False(t, kt.Contains("b"))
+	assert.Equal(t, 3, kt.Len())
+}
@@ -0,0 +1,96 @@
+package index
+
+import (
+	"tae/pkg/txn/txnbase"
+
+	"github.com/matrixorigin/matrixone/pkg/container/types"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+	"github.com/matrixorigin/matrixone/pkg/encoding"
+)
+
+// ZoneMap keeps the min and max values of a column in a block. It is not
+// thread-safe and should be protected by the block lock
+type ZoneMap struct {
+	typ    types.Type
+	inited bool
+	min    interface{}
+	max    interface{}
+}
+
+func NewZoneMap(typ types.Type) *ZoneMap {
+	return &ZoneMap{typ: typ}
+}
+
+func (zm *ZoneMap) GetType() types.Type { return zm.typ }
+func (zm *ZoneMap) IsEmpty() bool       { return !zm.inited }
+func (zm *ZoneMap) GetMin() interface{} { return zm.min }
+func (zm *ZoneMap) GetMax() interface{} { return zm.max }
+
+func (zm *ZoneMap) Update(v interface{}) {
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+	if !zm.inited {
+		zm.min, zm.max = v, v
+		zm.inited = true
+		return
+	}
+	if Compare(v, zm.min) < 0 {
+		zm.min = v
+	}
+	if Compare(v, zm.max) > 0 {
+		zm.max = v
+	}
+}
+
+// BatchUpdate updates the zone map with the rows [offset, offset+length) of vec
+func (zm *ZoneMap) BatchUpdate(vec *gvec.Vector, offset, length int) {
+	for i := offset; i < offset+length; i++ {
+		zm.Update(txnbase.GetValue(vec, uint32(i)))
+	}
+}
+
+// MayContainsKey returns false if v is definitely not in the zone
+func (zm *ZoneMap) MayContainsKey(v interface{}) bool {
+	if !zm.inited {
+		return false
+	}
+	return Compare(v, zm.min) >= 0 && Compare(v, zm.max) <= 0
+}
+
+// MayContainsRange returns false if no value in [lo, hi] is in the zone
+func (zm *ZoneMap) MayContainsRange(lo, hi interface{}) bool {
+	if !zm.inited {
+		return false
+	}
+	return Compare(hi, zm.min) >= 0 && Compare(lo, zm.max) <= 0
+}
+
+// Marshal encodes the min and max values as a vector of the column type. An
+// empty zone map is encoded as an empty vector
+func (zm *ZoneMap) Marshal() ([]byte, error) {
+	vec := gvec.New(zm.typ)
+	if zm.inited {
+		for _, v := range []interface{}{zm.min, zm.max} {
+			if s, ok := v.(string); ok {
+				v = []byte(s)
+			}
+			txnbase.AppendValue(vec, v)
+		}
+	}
+	return vec.Show()
+}
+
+func (zm *ZoneMap) Unmarshal(buf []byte) (err error) {
+	vec := gvec.New(encoding.DecodeType(buf[:encoding.TypeSize]))
+	if err = vec.Read(buf); err != nil {
+		return
+	}
+	zm.typ = vec.Typ
+	zm.inited = gvec.Length(vec) == 2
+	if zm.inited {
+		zm.min = txnbase.GetValue(vec, 0)
+		zm.max = txnbase.GetValue(vec, 1)
+	}
+	return
+}
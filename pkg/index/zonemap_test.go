@@ -0,0 +1,58 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/matrixorigin/matrixone/pkg/container/types"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZoneMap(t *testing.T) {
+	typ := types.Type{Oid: types.T_int32, Size: 4, Width: 32}
+	zm := NewZoneMap(typ)
+	assert.True(t, zm.IsEmpty())
+	assert.False(t, zm.MayContainsKey(int32(0)))
+
+	vec := gvec.New(typ)
+	assert.Nil(t, gvec.Append(vec, []int32{30, 10, 20, 50, 40}))
+	zm.BatchUpdate(vec, 1, 3)
+	assert.Equal(t, int32(10), zm.GetMin())
+	assert.Equal(t, int32(50), zm.GetMax())
+	assert.True(t, zm.MayContainsKey(int32(10)))
+	assert.True(t, zm.MayContainsKey(int32(30)))
+	assert.False(t, zm.MayContainsKey(int32(9)))
+	assert.False(t, zm.MayContainsKey(int32(51)))
+	assert.True(t, zm.MayContainsRange(int32(0), int32(10)))
+	assert.True(t, zm.MayContainsRange(int32(50), int32(60)))
+	assert.False(t, zm.MayContainsRange(int32(51), int32(60)))
+
+	buf, err := zm.Marshal()
+	assert.Nil(t, err)
+	zm2 := new(ZoneMap)
+	assert.Nil(t, zm2.Unmarshal(buf))
+	assert.Equal(t, zm.GetType().Oid, zm2.GetType().Oid)
+	assert.Equal(t, int32(10), zm2.GetMin())
+	assert.Equal(t, int32(50), zm2.GetMax())
+
+	zm = NewZoneMap(types.Type{Oid: types.T_varchar, Size: 24})
+	zm.Update([]byte("b"))
+	zm.Update("d")
+	assert.True(t, zm.MayContainsKey("c"))
+	assert.True(t, zm.MayContainsKey([]byte("b")))
+	assert.False(t, zm.MayContainsKey("a"))
+	assert.False(t, zm.MayContainsRange("e", "f"))
+
+	buf, err = zm.Marshal()
+	assert.Nil(t, err)
+	zm2 = new(ZoneMap)
+	assert.Nil(t, zm2.Unmarshal(buf))
+	assert.Equal(t, "b", zm2.GetMin())
+	assert.Equal(t, "d", zm2.GetMax())
+
+	buf, err = NewZoneMap(typ).Marshal()
+	assert.Nil(t, err)
+	zm2 = new(ZoneMap)
+	assert.Nil(t, zm2.Unmarshal(buf))
+	assert.True(t, zm2.IsEmpty())
+}
@@ -41,5 +41,8 @@ func (appender *blockAppender) ApplyAppend(bat *gbat.Batch, offset, length uint3
 		from, err = appender.node.ApplyAppend(bat, offset, length, ctx)
 		return err
 	})
+	if err == nil && appender.node.rows == appender.node.meta.GetSchema().BlockMaxRows {
+		err = appender.node.block.freeze()
+	}
 	return
 }
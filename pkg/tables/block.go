@@ -2,45 +2,68 @@ package tables
 
 import (
 	"bytes"
+	"math"
 	"sync"
+	"sync/atomic"
 	"tae/pkg/catalog"
 	"tae/pkg/dataio"
 	"tae/pkg/iface/data"
 	"tae/pkg/iface/txnif"
+	"tae/pkg/txn/txnbase"
 	"tae/pkg/updates"
 
+	"github.com/RoaringBitmap/roaring"
 	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
 	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/mutation/buffer/base"
 )
 
 type dataBlock struct {
 	*sync.RWMutex
-	meta   *catalog.BlockEntry
-	node   *appendableNode
-	file   dataio.BlockFile
-	bufMgr base.INodeManager
-	chain  updates.BlockUpdateChain
+	meta      *catalog.BlockEntry
+	node      *appendableNode
+	file      dataio.BlockFile
+	bufMgr    base.INodeManager
+	chain     *updates.BlockUpdateChain
+	info      *insertInfo
+	indexNode *indexNode
+	// dead is the rows appended by the rolled back txns. They are skipped by
+	// all the reads and dedups
+	dead *roaring.Bitmap
+	// deadBuf is the marshaled dead rows to persist with the block data
+	deadBuf atomic.Value
 }
 
 func newBlock(meta *catalog.BlockEntry, segFile dataio.SegmentFile, bufMgr base.INodeManager) *dataBlock {
 	file := segFile.GetBlockFile(meta.GetID())
-	var node *appendableNode
-	if meta.IsAppendable() {
-		node = newNode(bufMgr, meta, file)
-	}
-	return &dataBlock{
+	block := &dataBlock{
 		RWMutex: new(sync.RWMutex),
 		meta:    meta,
 		file:    file,
-		node:    node,
+		bufMgr:  bufMgr,
+		dead:    roaring.New(),
+	}
+	if err := block.loadDeadRows(); err != nil {
+		panic(err)
+	}
+	block.chain = updates.NewUpdateChain(block.RWMutex, meta)
+	block.info = newInsertInfo(nil, 0, meta.GetSchema().BlockMaxRows)
+	if meta.IsAppendable() {
+		block.node = newNode(bufMgr, block, file)
+		block.info.RecordBaseLocked(block.node.rows)
 	}
+	block.indexNode = newIndexNode(bufMgr, block, file)
+	return block
+}
+
+func (blk *dataBlock) GetUpdateChain() interface{} {
+	return blk.chain
 }
 
 func (blk *dataBlock) IsAppendable() bool {
 	if !blk.meta.IsAppendable() {
 		return false
 	}
-	if blk.node.Rows(nil, true) == blk.meta.GetSegment().GetTable().GetSchema().BlockMaxRows {
+	if blk.node.Rows(nil, true) == blk.meta.GetSchema().BlockMaxRows {
 		return false
 	}
 	return true
@@ -51,7 +74,13 @@ func (blk *dataBlock) Rows(txn txnif.AsyncTxn, coarse bool) int {
 		rows := int(blk.node.Rows(txn, coarse))
 		return rows
 	}
-	return int(blk.file.Rows())
+	rows := blk.file.Rows()
+	if coarse || txn == nil {
+		return int(rows)
+	}
+	blk.RLock()
+	defer blk.RUnlock()
+	return int(rows - blk.deletedRowsLocked(txn, rows))
 }
 
 func (blk *dataBlock) MakeAppender() (appender data.BlockAppender, err error) {
@@ -63,7 +92,139 @@ func (blk *dataBlock) MakeAppender() (appender data.BlockAppender, err error) {
 	return
 }
 
+func (blk *dataBlock) RecordTxnAppend(txn txnif.TxnReader, offset uint32) {
+	blk.info.rwlocker.Lock()
+	defer blk.info.rwlocker.Unlock()
+	blk.info.RecordTxnLocked(offset, txn, nil)
+}
+
+func (blk *dataBlock) ApplyCommitAppend(txn txnif.TxnReader) error {
+	blk.info.rwlocker.Lock()
+	defer blk.info.rwlocker.Unlock()
+	return blk.info.ApplyCommitLocked(txn)
+}
+
+// RollbackAppend marks the rows [start, end] appended by a rolled back txn as
+// dead and removes their keys from the primary key index
+func (blk *dataBlock) RollbackAppend(start, end uint32) (err error) {
+	h := blk.node.mgr.Pin(blk.node)
+	if h == nil {
+		panic("not expected")
+	}
+	defer h.Close()
+	var pks *gvec.Vector
+	if pks, err = blk.node.getKeys(end + 1); err != nil {
+		return
+	}
+	blk.Lock()
+	blk.dead.AddRange(uint64(start), uint64(end)+1)
+	if blk.node.pkIndex != nil {
+		for row := start; row <= end; row++ {
+			blk.node.pkIndex.Delete(txnbase.GetValue(pks, row), row)
+		}
+	}
+	buf, err := blk.dead.ToBytes()
+	blk.Unlock()
+	if err != nil {
+		return
+	}
+	blk.deadBuf.Store(buf)
+	// The rows are flushed already, so the dead rows are persisted now
+	if blk.file.Rows() > start {
+		err = blk.file.WriteIndex(dataio.IndexTypeDeadRows, 0, buf)
+	}
+	return
+}
+
+// loadDeadRows loads the dead rows persisted with the block data
+func (blk *dataBlock) loadDeadRows() (err error) {
+	if blk.file.Rows() == 0 {
+		return
+	}
+	buf, err := blk.file.LoadIndex(dataio.IndexTypeDeadRows, 0)
+	if err != nil || buf == nil {
+		return
+	}
+	if err = blk.dead.UnmarshalBinary(buf); err != nil {
+		return
+	}
+	blk.deadBuf.Store(buf)
+	return
+}
+
+// getDeadRowsBuf returns nil if no row is dead
+func (blk *dataBlock) getDeadRowsBuf() []byte {
+	if buf := blk.deadBuf.Load(); buf != nil {
+		return buf.([]byte)
+	}
+	return nil
+}
+
+// isDeadLocked returns true if the row was appended by a rolled back txn
+func (blk *dataBlock) isDeadLocked(row uint32) bool {
+	return blk.dead.Contains(row)
+}
+
+// collectUpdatesLocked collects the updates visible to txn on the first rows.
+// The dead rows are collected as deletes
+func (blk *dataBlock) collectUpdatesLocked(txn txnif.AsyncTxn, rows uint32) *updates.BlockUpdates {
+	blkUpdates := blk.chain.CollectUpdatesLocked(txn, rows)
+	if blk.dead.IsEmpty() {
+		return blkUpdates
+	}
+	dead := blk.dead.Clone()
+	dead.RemoveRange(uint64(rows), uint64(math.MaxUint32)+1)
+	if dead.IsEmpty() {
+		return blkUpdates
+	}
+	if blkUpdates == nil {
+		blkUpdates = updates.NewMergeBlockUpdates(0, blk.meta, nil, nil)
+	}
+	blkUpdates.AddDeletes(dead)
+	return blkUpdates
+}
+
+// deletedRowsLocked returns the number of the first rows deleted for txn
+func (blk *dataBlock) deletedRowsLocked(txn txnif.AsyncTxn, rows uint32) uint32 {
+	blkUpdates := blk.collectUpdatesLocked(txn, rows)
+	if blkUpdates == nil {
+		return 0
+	}
+	return blkUpdates.GetDeleteCntLocked()
+}
+
+// resolveColumn resolves attr of the schema version visible to txn to the
+// column of the block by the column id. colIdx is -1 if the column was added
+// after the block was written
+func (blk *dataBlock) resolveColumn(txn txnif.AsyncTxn, attr string) (colDef *catalog.ColDef, colIdx int, err error) {
+	blkSchema := blk.meta.GetSchema()
+	schema := blkSchema
+	if txn != nil {
+		schema = blk.meta.GetSegment().GetTable().GetSchemaFor(txn)
+	}
+	idx := schema.GetColIdx(attr)
+	if idx < 0 {
+		err = catalog.ErrNotFound
+		return
+	}
+	colDef = schema.ColDefs[idx]
+	if schema == blkSchema {
+		colIdx = idx
+		return
+	}
+	colIdx = blkSchema.GetColIdxByID(colDef.ID)
+	return
+}
+
+// GetVectorCopy returns a copy of the column visible to txn. The rows are
+// limited to the ones committed before the txn started and the updates and
+// deletes visible to the txn are applied. A column added after the block was
+// written is read as its default or NULLs
 func (blk *dataBlock) GetVectorCopy(txn txnif.AsyncTxn, attr string, compressed, decompressed *bytes.Buffer) (vec *gvec.Vector, err error) {
+	colDef, colIdx, err := blk.resolveColumn(txn, attr)
+	if err != nil {
+		return
+	}
 	h := blk.node.mgr.Pin(blk.node)
 	if h == nil {
 		panic("not expected")
@@ -71,5 +232,51 @@ func (blk *dataBlock) GetVectorCopy(txn txnif.AsyncTxn, attr string, compressed,
 	defer h.Close()
 	blk.RLock()
 	defer blk.RUnlock()
-	return blk.node.GetVectorCopy(txn, attr, compressed, decompressed)
+	rows := blk.getVisibleRowsLocked(txn)
+	blkUpdates := blk.collectUpdatesLocked(txn, rows)
+	if colIdx < 0 {
+		vec = colDef.MakeFill(rows)
+		if blkUpdates != nil {
+			vec = blkUpdates.ApplyDeletes(vec)
+		}
+		return
+	}
+	attr = blk.meta.GetSchema().ColDefs[colIdx].Name
+	if vec, err = blk.node.GetVectorCopy(rows, attr, compressed, decompressed); err != nil {
+		return
+	}
+	if blkUpdates != nil {
+		vec = blkUpdates.ApplyToColumn(uint16(colIdx), vec)
+	}
+	return
+}
+
+// freeze builds the indexes of the block once it is full and drops the
+// primary key index, which is replaced by the bloom filter. The block node
+// should be pinned
+func (blk *dataBlock) freeze() error {
+	rows := blk.node.rows
+	zonemaps, filter, err := blk.node.BuildIndexes()
+	if err != nil {
+		return err
+	}
+	h := blk.bufMgr.Pin(blk.indexNode)
+	if h == nil {
+		return nil
+	}
+	defer h.Close()
+	blk.indexNode.Install(zonemaps, filter, rows)
+	blk.Lock()
+	blk.node.pkIndex = nil
+	blk.Unlock()
+	return nil
+}
+
+func (blk *dataBlock) getVisibleRowsLocked(txn txnif.AsyncTxn) uint32 {
+	if txn == nil {
+		return blk.node.rows
+	}
+	blk.info.rwlocker.RLock()
+	defer blk.info.rwlocker.RUnlock()
+	return uint32(blk.info.GetVisibleOffsetLocked(txn.GetStartTS()) + 1)
 }
@@ -0,0 +1,112 @@
+package tables
+
+import (
+	"tae/pkg/iface/txnif"
+	"tae/pkg/index"
+	"tae/pkg/txn/txnbase"
+
+	"github.com/RoaringBitmap/roaring"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+)
+
+// BatchDedup returns txnbase.ErrDuplicated if any key of pks is already in the
+// block for txn. All the appended rows are checked, including the ones of the
+// txns still committing, except the dead rows and the rows removed by the
+// deletes and key updates of txn or of the committed and committing txns.
+// The keys updated by the txns not rollbacked are treated as existing
+func (blk *dataBlock) BatchDedup(txn txnif.AsyncTxn, pks *gvec.Vector) (err error) {
+	blk.RLock()
+	rows := blk.node.rows
+	removed, updated := blk.chain.CollectKeysLocked(txn, uint16(blk.meta.GetSchema().PrimaryKey))
+	blk.RUnlock()
+	if rows == 0 {
+		return
+	}
+	keys := make([]interface{}, gvec.Length(pks))
+	for i := range keys {
+		keys[i] = txnbase.GetValue(pks, uint32(i))
+		for _, v := range updated {
+			if index.Compare(keys[i], v) == 0 {
+				return txnbase.ErrDuplicated
+			}
+		}
+	}
+	if keys = blk.pruneKeys(keys, rows); len(keys) == 0 {
+		return
+	}
+	return blk.dedupKeys(keys, rows, removed)
+}
+
+// pruneKeys returns the keys that may be in the first rows of the block
+// according to the primary key zone map and bloom filter
+func (blk *dataBlock) pruneKeys(keys []interface{}, rows uint32) []interface{} {
+	h := blk.bufMgr.Pin(blk.indexNode)
+	if h == nil {
+		return keys
+	}
+	defer h.Close()
+	// The zone map of a composite key only covers its first column
+	var zm *index.ZoneMap
+	if schema := blk.meta.GetSchema(); !schema.IsCompositeKey() {
+		zm = blk.indexNode.GetZoneMap(int(schema.PrimaryKey), rows)
+	}
+	filter := blk.indexNode.GetBloomFilter(rows)
+	if zm == nil && filter == nil {
+		return keys
+	}
+	pruned := keys[:0]
+	for _, key := range keys {
+		if zm != nil && !zm.MayContainsKey(key) {
+			continue
+		}
+		if filter != nil && !filter.MayContainsKey(key) {
+			continue
+		}
+		pruned = append(pruned, key)
+	}
+	return pruned
+}
+
+// dedupKeys checks the keys against the primary key index of an appendable
+// block, or against the primary key column of a frozen one. The dead rows and
+// the removed rows do not hold their keys
+func (blk *dataBlock) dedupKeys(keys []interface{}, rows uint32, removed *roaring.Bitmap) (err error) {
+	h := blk.node.mgr.Pin(blk.node)
+	if h == nil {
+		panic("not expected")
+	}
+	defer h.Close()
+	blk.RLock()
+	defer blk.RUnlock()
+	holds := func(row uint32) bool {
+		return !blk.isDeadLocked(row) && !removed.Contains(row)
+	}
+	if blk.node.pkIndex != nil {
+		// The index maps a key to its newest row, and the older rows of the key
+		// were removed before it was appended again
+		for _, key := range keys {
+			if row, err := blk.node.pkIndex.Search(key); err == nil && holds(row) {
+				return txnbase.ErrDuplicated
+			}
+		}
+		return
+	}
+	vec, err := blk.node.getKeys(rows)
+	if err != nil {
+		return
+	}
+	tree := index.NewKeyTree()
+	for _, key := range keys {
+		// Duplicated keys in the batch are deduped by the local index
+		_ = tree.Insert(key, 0)
+	}
+	for row := uint32(0); row < uint32(gvec.Length(vec)); row++ {
+		if !holds(row) {
+			continue
+		}
+		if tree.Contains(txnbase.GetValue(vec, row)) {
+			return txnbase.ErrDuplicated
+		}
+	}
+	return
+}
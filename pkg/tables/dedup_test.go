@@ -0,0 +1,208 @@
+package tables
+
+import (
+	"tae/pkg/catalog"
+	"tae/pkg/dataio"
+	"tae/pkg/iface/txnif"
+	"tae/pkg/index"
+	"tae/pkg/txn/txnbase"
+	"tae/pkg/updates"
+	"testing"
+
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/common"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/mock"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/mutation/buffer"
+	"github.com/stretchr/testify/assert"
+)
+
+type dedupTestContext struct {
+	t       *testing.T
+	c       *catalog.Catalog
+	schema  *catalog.Schema
+	table   *catalog.TableEntry
+	seg     *catalog.SegmentEntry
+	factory *DataFactory
+}
+
+func newDedupTestContext(t *testing.T, schema *catalog.Schema, blockMaxRows uint32) *dedupTestContext {
+	dir := initTestPath(t)
+	schema.BlockMaxRows = blockMaxRows
+	schema.SegmentMaxBlocks = 2
+	ctx := &dedupTestContext{
+		t:       t,
+		c:       catalog.MockCatalog(dir, "mock", nil),
+		schema:  schema,
+		factory: NewDataFactory(dataio.SegmentFileMockFactory, buffer.NewNodeManager(common.G, nil), dir),
+	}
+	db, _ := ctx.c.CreateDBEntry("db", nil)
+	ctx.table, _ = db.CreateTableEntry(schema, nil, ctx.factory.MakeTableFactory())
+	ctx.seg, _ = ctx.table.CreateSegment(nil, catalog.ES_Appendable, ctx.factory.MakeSegmentFactory())
+	return ctx
+}
+
+// appendBlock creates a block with the keys from 0 to rows-1
+func (ctx *dedupTestContext) appendBlock(rows uint32) *dataBlock {
+	segFile := ctx.seg.GetSegmentData().GetSegmentFile()
+	meta, _ := ctx.seg.CreateBlock(nil, catalog.ES_Appendable, ctx.factory.MakeBlockFactory(segFile))
+	appender, err := ctx.table.GetTableData().(*dataTable).SetAppender(meta.AsCommonID())
+	assert.Nil(ctx.t, err)
+	toAppend, err := appender.PrepareAppend(rows)
+	assert.Nil(ctx.t, err)
+	assert.Equal(ctx.t, rows, toAppend)
+	bat := mock.MockBatch(ctx.schema.Types(), uint64(rows))
+	_, err = appender.ApplyAppend(bat, 0, toAppend, nil)
+	assert.Nil(ctx.t, err)
+	appender.Close()
+	return meta.GetBlockData().(*dataBlock)
+}
+
+func (ctx *dedupTestContext) keys(vals ...int32) *gvec.Vector {
+	vec := gvec.New(ctx.schema.ColDefs[ctx.schema.PrimaryKey].Type)
+	assert.Nil(ctx.t, gvec.Append(vec, vals))
+	return vec
+}
+
+// compositeKeys builds the keys of a primary key on the first two columns
+func (ctx *dedupTestContext) compositeKeys(first, second []int32) *gvec.Vector {
+	typ := ctx.schema.ColDefs[0].Type
+	cols := []*gvec.Vector{gvec.New(typ), gvec.New(typ)}
+	assert.Nil(ctx.t, gvec.Append(cols[0], first))
+	assert.Nil(ctx.t, gvec.Append(cols[1], second))
+	keys, err := index.NewCompositeKeys(cols...)
+	assert.Nil(ctx.t, err)
+	return keys
+}
+
+func newDedupTestTxn(start txnif.TS) *txnbase.Txn {
+	txn := new(txnbase.Txn)
+	txn.TxnCtx = txnbase.NewTxnCtx(nil, common.NextGlobalSeqNum(), start, nil)
+	return txn
+}
+
+func commitUpdateNode(t *testing.T, node *updates.BlockUpdateNode, txn *txnbase.Txn) {
+	txn.CommitTS = txn.StartTS + 1
+	assert.Nil(t, node.PrepareCommit())
+	assert.Nil(t, node.ApplyCommit())
+	txn.State = txnif.TxnStateCommitted
+}
+
+func TestBlockDedup(t *testing.T) {
+	ctx := newDedupTestContext(t, catalog.MockSchema(1), 1000)
+	defer ctx.c.Close()
+	txn := newDedupTestTxn(1)
+
+	rows := uint32(100)
+	blk := ctx.appendBlock(rows)
+	assert.Equal(t, txnbase.ErrDuplicated, blk.BatchDedup(txn, ctx.keys(0)))
+	assert.Equal(t, txnbase.ErrDuplicated, blk.BatchDedup(txn, ctx.keys(1000, int32(rows)-1)))
+	// Out of the zone map
+	assert.Nil(t, blk.BatchDedup(txn, ctx.keys(int32(rows), 1000, -1)))
+	table := ctx.table.GetTableData()
+	assert.Equal(t, txnbase.ErrDuplicated, table.BatchDedup(txn, ctx.keys(int32(rows), 50)))
+	assert.Nil(t, table.BatchDedup(txn, ctx.keys(int32(rows))))
+}
+
+func TestPruneKeys(t *testing.T) {
+	ctx := newDedupTestContext(t, catalog.MockSchema(1), 10)
+	defer ctx.c.Close()
+
+	blk := ctx.appendBlock(10)
+	// The indexes are built once the block is full
+	keys := []interface{}{int32(-1), int32(3), int32(10), int32(9)}
+	pruned := blk.pruneKeys(keys, 10)
+	assert.ElementsMatch(t, []interface{}{int32(3), int32(9)}, pruned)
+
+	// The indexes not covering the rows do not prune any key
+	keys = []interface{}{int32(-1), int32(3), int32(10), int32(9)}
+	assert.Equal(t, 4, len(blk.pruneKeys(keys, 11)))
+
+	// The indexes are flushed with the block and loaded from the block file
+	blk.node.Unload()
+	blk.indexNode.Unload()
+	keys = []interface{}{int32(-1), int32(3), int32(10), int32(9)}
+	pruned = blk.pruneKeys(keys, 10)
+	assert.ElementsMatch(t, []interface{}{int32(3), int32(9)}, pruned)
+}
+
+func TestDedupRemovedRows(t *testing.T) {
+	ctx := newDedupTestContext(t, catalog.MockSchema(1), 10)
+	defer ctx.c.Close()
+	blk := ctx.appendBlock(10)
+	pk := uint16(ctx.schema.PrimaryKey)
+	reader := newDedupTestTxn(100)
+
+	// An uncommitted delete only removes the key for its own txn
+	deleter := newDedupTestTxn(10)
+	node := blk.chain.AddNode(deleter)
+	assert.Nil(t, node.DeleteLocked(5, 5))
+	assert.Equal(t, txnbase.ErrDuplicated, blk.BatchDedup(reader, ctx.keys(5)))
+	assert.Nil(t, blk.BatchDedup(deleter, ctx.keys(5)))
+
+	// The key can be inserted again once the delete is committed
+	commitUpdateNode(t, node, deleter)
+	assert.Nil(t, blk.BatchDedup(reader, ctx.keys(5)))
+	assert.Equal(t, txnbase.ErrDuplicated, blk.BatchDedup(reader, ctx.keys(4, 5)))
+
+	// The new key of an active update exists, while the old one still holds
+	updater := newDedupTestTxn(20)
+	node = blk.chain.AddNode(updater)
+	assert.Nil(t, node.UpdateLocked(3, pk, int32(100)))
+	assert.Equal(t, txnbase.ErrDuplicated, blk.BatchDedup(reader, ctx.keys(100)))
+	assert.Equal(t, txnbase.ErrDuplicated, blk.BatchDedup(reader, ctx.keys(3)))
+	assert.Nil(t, blk.BatchDedup(updater, ctx.keys(3)))
+
+	// The new key of an aborted update does not exist
+	updater.State = txnif.TxnStateRollbacked
+	assert.Nil(t, blk.BatchDedup(reader, ctx.keys(100)))
+	assert.Nil(t, node.ApplyRollback())
+	assert.Nil(t, blk.BatchDedup(reader, ctx.keys(100)))
+
+	// A committed update removes the old key
+	updater = newDedupTestTxn(30)
+	node = blk.chain.AddNode(updater)
+	assert.Nil(t, node.UpdateLocked(3, pk, int32(100)))
+	commitUpdateNode(t, node, updater)
+	assert.Nil(t, blk.BatchDedup(reader, ctx.keys(3)))
+	assert.Equal(t, txnbase.ErrDuplicated, blk.BatchDedup(reader, ctx.keys(100)))
+
+	// The full block is reloaded without the primary key index and the column
+	// is scanned instead
+	blk.node.Unload()
+	h := blk.node.mgr.Pin(blk.node)
+	assert.Nil(t, blk.node.pkIndex)
+	h.Close()
+	assert.Nil(t, blk.BatchDedup(reader, ctx.keys(3, 5)))
+	assert.Equal(t, txnbase.ErrDuplicated, blk.BatchDedup(reader, ctx.keys(5, 9)))
+	assert.Equal(t, txnbase.ErrDuplicated, blk.BatchDedup(reader, ctx.keys(100)))
+}
+
+func TestCompositeKeyDedup(t *testing.T) {
+	mockSchema := func() *catalog.Schema {
+		schema := catalog.MockSchema(3)
+		assert.Nil(t, schema.SetCompositeKey(0, 1))
+		return schema
+	}
+	txn := newDedupTestTxn(1)
+
+	// The mock rows hold the same value in every column
+	ctx := newDedupTestContext(t, mockSchema(), 10)
+	blk := ctx.appendBlock(5)
+	assert.NotNil(t, blk.node.pkIndex)
+	assert.Equal(t, txnbase.ErrDuplicated, blk.BatchDedup(txn, ctx.compositeKeys([]int32{3}, []int32{3})))
+	assert.Nil(t, blk.BatchDedup(txn, ctx.compositeKeys([]int32{3, 4, 5}, []int32{4, 3, 5})))
+	ctx.c.Close()
+
+	// The full block is checked with the bloom filter over the composite keys
+	ctx = newDedupTestContext(t, mockSchema(), 10)
+	defer ctx.c.Close()
+	blk = ctx.appendBlock(10)
+	assert.Nil(t, blk.node.pkIndex)
+	assert.Equal(t, txnbase.ErrDuplicated, blk.BatchDedup(txn, ctx.compositeKeys([]int32{4, 9}, []int32{3, 9})))
+	assert.Nil(t, blk.BatchDedup(txn, ctx.compositeKeys([]int32{3, 4, 10}, []int32{4, 3, 10})))
+
+	// The keys are built from the key columns of the unloaded block
+	blk.node.Unload()
+	assert.Equal(t, txnbase.ErrDuplicated, blk.BatchDedup(txn, ctx.compositeKeys([]int32{4, 9}, []int32{3, 9})))
+	assert.Nil(t, blk.BatchDedup(txn, ctx.compositeKeys([]int32{3, 4, 10}, []int32{4, 3, 10})))
+}
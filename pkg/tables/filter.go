@@ -0,0 +1,251 @@
+package tables
+
+import (
+	"bytes"
+	"tae/pkg/catalog"
+	"tae/pkg/iface/data"
+	"tae/pkg/iface/handle"
+	"tae/pkg/iface/txnif"
+	"tae/pkg/index"
+	"tae/pkg/txn/txnbase"
+	"tae/pkg/updates"
+
+	gbat "github.com/matrixorigin/matrixone/pkg/container/batch"
+	"github.com/matrixorigin/matrixone/pkg/container/types"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+)
+
+var offsetType = types.Type{Oid: types.T_uint32, Size: 4, Width: 32}
+
+// filterEvaluator matches the values of a column against a filter
+type filterEvaluator struct {
+	op   handle.FilterOp
+	keys map[interface{}]struct{}
+	lo   interface{}
+	hi   interface{}
+}
+
+func newFilterEvaluator(filter handle.Filter, colDef *catalog.ColDef) (*filterEvaluator, error) {
+	if filter.Col == nil || filter.Col.Typ.Oid != colDef.Type.Oid || !index.IsComparable(colDef.Type) {
+		return nil, data.ErrInvalidFilter
+	}
+	eval := &filterEvaluator{op: filter.Op}
+	switch filter.Op {
+	case handle.FilterEq:
+		eval.keys = make(map[interface{}]struct{})
+		for i := 0; i < gvec.Length(filter.Col); i++ {
+			eval.keys[txnbase.GetValue(filter.Col, uint32(i))] = struct{}{}
+		}
+	case handle.FilterBtw:
+		if gvec.Length(filter.Col) != 2 {
+			return nil, data.ErrInvalidFilter
+		}
+		eval.lo = txnbase.GetValue(filter.Col, 0)
+		eval.hi = txnbase.GetValue(filter.Col, 1)
+	default:
+		return nil, data.ErrInvalidFilter
+	}
+	return eval, nil
+}
+
+// NewRowMatcher returns the function matching the values of the column of
+// colDef against filter
+func NewRowMatcher(filter handle.Filter, colDef *catalog.ColDef) (func(v interface{}) bool, error) {
+	eval, err := newFilterEvaluator(filter, colDef)
+	if err != nil {
+		return nil, err
+	}
+	return eval.Match, nil
+}
+
+func (eval *filterEvaluator) Match(v interface{}) bool {
+	if eval.op == handle.FilterEq {
+		_, ok := eval.keys[v]
+		return ok
+	}
+	return index.Compare(v, eval.lo) >= 0 && index.Compare(v, eval.hi) <= 0
+}
+
+func (eval *filterEvaluator) MayMatch(zm *index.ZoneMap) bool {
+	if eval.op == handle.FilterEq {
+		for key := range eval.keys {
+			if zm.MayContainsKey(key) {
+				return true
+			}
+		}
+		return false
+	}
+	return zm.MayContainsRange(eval.lo, eval.hi)
+}
+
+// newFilterEvaluator returns the evaluator of filter on the column of the
+// block. colIdx is -1 if the column was added after the block was written,
+// so no row matches
+func (blk *dataBlock) newFilterEvaluator(txn txnif.AsyncTxn, filter handle.Filter) (colIdx int, eval *filterEvaluator, err error) {
+	attr := filter.Attr
+	if attr == "" {
+		schema := blk.meta.GetSchema()
+		attr = schema.ColDefs[schema.PrimaryKey].Name
+		txn = nil
+	}
+	colDef, colIdx, err := blk.resolveColumn(txn, attr)
+	if err != nil {
+		return
+	}
+	eval, err = newFilterEvaluator(filter, colDef)
+	return
+}
+
+// GetByFilter returns the rows visible to txn that match filter. Only the
+// offsets of the rows are returned if offsetOnly is true. The columns are of
+// the schema version visible to txn
+func (blk *dataBlock) GetByFilter(txn txnif.AsyncTxn, filter handle.Filter, offsetOnly bool) (bat *gbat.Batch, err error) {
+	colIdx, eval, err := blk.newFilterEvaluator(txn, filter)
+	if err != nil {
+		return
+	}
+	schema := blk.meta.GetSchema()
+	if txn != nil {
+		schema = blk.meta.GetSegment().GetTable().GetSchemaFor(txn)
+	}
+	h := blk.node.mgr.Pin(blk.node)
+	if h == nil {
+		panic("not expected")
+	}
+	defer h.Close()
+	blk.RLock()
+	defer blk.RUnlock()
+	rows := blk.getVisibleRowsLocked(txn)
+	blkUpdates := blk.collectUpdatesLocked(txn, rows)
+	sels := make([]int64, 0)
+	if colIdx >= 0 && blk.mayMatchLocked(eval, colIdx, rows, blkUpdates) {
+		var vec *gvec.Vector
+		if vec, err = blk.getColumnLocked(colIdx, rows, blkUpdates); err != nil {
+			return
+		}
+		for row := uint32(0); row < rows; row++ {
+			if blkUpdates != nil && blkUpdates.IsRowDeleted(row) {
+				continue
+			}
+			if eval.Match(txnbase.GetValue(vec, row)) {
+				sels = append(sels, int64(row))
+			}
+		}
+	}
+	if offsetOnly {
+		bat = gbat.New(true, []string{handle.OffsetAttr})
+		bat.Vecs[0] = gvec.New(offsetType)
+		offsets := make([]uint32, len(sels))
+		for i, row := range sels {
+			offsets[i] = uint32(row)
+		}
+		err = gvec.Append(bat.Vecs[0], offsets)
+		return
+	}
+	blkSchema := blk.meta.GetSchema()
+	bat = gbat.New(true, schema.Attrs())
+	for i, colDef := range schema.ColDefs {
+		if len(sels) == 0 {
+			bat.Vecs[i] = gvec.New(colDef.Type)
+			continue
+		}
+		idx := blkSchema.GetColIdxByID(colDef.ID)
+		if idx < 0 {
+			bat.Vecs[i] = colDef.MakeFill(rows)
+		} else if bat.Vecs[i], err = blk.getColumnLocked(idx, rows, blkUpdates); err != nil {
+			return
+		}
+		gvec.Shrink(bat.Vecs[i], sels)
+	}
+	return
+}
+
+// mayMatchLocked returns false if the zone map proves that none of the first
+// rows matches. The zone maps only cover the appended values, so the block is
+// not pruned if the column is updated
+func (blk *dataBlock) mayMatchLocked(eval *filterEvaluator, colIdx int, rows uint32, blkUpdates *updates.BlockUpdates) bool {
+	if rows == 0 {
+		return false
+	}
+	if blkUpdates != nil && blkUpdates.HasColumnUpdates(uint16(colIdx)) {
+		return true
+	}
+	h := blk.bufMgr.Pin(blk.indexNode)
+	if h == nil {
+		return true
+	}
+	defer h.Close()
+	zm := blk.indexNode.GetZoneMap(colIdx, rows)
+	return zm == nil || eval.MayMatch(zm)
+}
+
+// MayMatch returns false if none of the rows visible to txn matches filter
+// according to the zone maps. It is used to skip blocks in scans
+func (blk *dataBlock) MayMatch(txn txnif.AsyncTxn, filter handle.Filter) (ok bool, err error) {
+	colIdx, eval, err := blk.newFilterEvaluator(txn, filter)
+	if err != nil || colIdx < 0 {
+		return
+	}
+	blk.RLock()
+	defer blk.RUnlock()
+	rows := blk.getVisibleRowsLocked(txn)
+	blkUpdates := blk.collectUpdatesLocked(txn, rows)
+	ok = blk.mayMatchLocked(eval, colIdx, rows, blkUpdates)
+	return
+}
+
+// getColumnLocked returns a copy of the first rows of the column with the
+// updates applied. The deleted rows are kept
+func (blk *dataBlock) getColumnLocked(colIdx int, rows uint32, blkUpdates *updates.BlockUpdates) (vec *gvec.Vector, err error) {
+	attr := blk.meta.GetSchema().ColDefs[colIdx].Name
+	if vec, err = blk.node.GetVectorCopy(rows, attr, new(bytes.Buffer), new(bytes.Buffer)); err != nil {
+		return
+	}
+	if blkUpdates != nil {
+		vec = blkUpdates.ApplyUpdatesToColumn(uint16(colIdx), vec)
+	}
+	return
+}
+
+// CheckRWConflict returns txnif.TxnRWConflictErr if another txn committing
+// after txn started appended a row matching filter, or deleted or updated a
+// row txn read by filter. The txns are checked in the commit order, so all the
+// txns committing before txn are already appended
+func (blk *dataBlock) CheckRWConflict(txn txnif.AsyncTxn, filter handle.Filter) (err error) {
+	colIdx, eval, err := blk.newFilterEvaluator(txn, filter)
+	if err != nil || colIdx < 0 {
+		return
+	}
+	h := blk.node.mgr.Pin(blk.node)
+	if h == nil {
+		panic("not expected")
+	}
+	defer h.Close()
+	blk.RLock()
+	defer blk.RUnlock()
+	rows := blk.node.rows
+	if rows == 0 {
+		return
+	}
+	visible := blk.getVisibleRowsLocked(txn)
+	blkUpdates := blk.collectUpdatesLocked(txn, visible)
+	vec, err := blk.getColumnLocked(colIdx, rows, blkUpdates)
+	if err != nil {
+		return
+	}
+	for row := visible; row < rows; row++ {
+		if blk.isDeadLocked(row) {
+			continue
+		}
+		if eval.Match(txnbase.GetValue(vec, row)) {
+			return txnif.TxnRWConflictErr
+		}
+	}
+	matchRow := func(row uint32) bool {
+		if row >= visible || (blkUpdates != nil && blkUpdates.IsRowDeleted(row)) {
+			return false
+		}
+		return eval.Match(txnbase.GetValue(vec, row))
+	}
+	return blk.chain.CheckReadLocked(txn, uint16(colIdx), matchRow, eval.Match)
+}
@@ -0,0 +1,153 @@
+package tables
+
+import (
+	"sync"
+	"tae/pkg/catalog"
+	"tae/pkg/dataio"
+	"tae/pkg/index"
+
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/mutation/buffer"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/mutation/buffer/base"
+)
+
+const (
+	// The index node shares the id of the block node with a different part
+	indexPartID uint32 = 1
+
+	estimatedZoneMapSize = 64
+)
+
+// zoneMapCols returns the columns with a zone map. The primary key always has
+// one to prune the blocks in dedup
+func zoneMapCols(schema *catalog.Schema) []int {
+	cols := make([]int, 0)
+	hasPK := false
+	for _, colIdx := range schema.GetIndexedCols(catalog.ZoneMap) {
+		if !index.IsComparable(schema.ColDefs[colIdx].Type) {
+			continue
+		}
+		hasPK = hasPK || colIdx == int(schema.PrimaryKey)
+		cols = append(cols, colIdx)
+	}
+	if !hasPK && index.IsComparable(schema.ColDefs[schema.PrimaryKey].Type) {
+		cols = append(cols, int(schema.PrimaryKey))
+	}
+	return cols
+}
+
+func estimateIndexSize(schema *catalog.Schema) uint64 {
+	size := len(zoneMapCols(schema))*estimatedZoneMapSize + index.EstimateBloomFilterSize(int(schema.BlockMaxRows), index.DefaultFalsePositiveRate)
+	return uint64(size)
+}
+
+// indexNode holds the zone maps and the primary key bloom filter of a block.
+// They are built when the block is frozen or flushed and are loaded from the
+// block file on demand. They cover the first rows of the block
+type indexNode struct {
+	*buffer.Node
+	rwlocker *sync.RWMutex
+	file     dataio.BlockFile
+	meta     *catalog.BlockEntry
+	zonemaps map[int]*index.ZoneMap
+	filter   *index.BloomFilter
+	rows     uint32
+}
+
+func newIndexNode(mgr base.INodeManager, block *dataBlock, file dataio.BlockFile) *indexNode {
+	impl := new(indexNode)
+	id := *block.meta.AsCommonID()
+	id.PartID = indexPartID
+	schema := block.meta.GetSchema()
+	impl.Node = buffer.NewNode(impl, mgr, id, estimateIndexSize(schema))
+	impl.LoadFunc = impl.OnLoad
+	impl.UnloadFunc = impl.OnUnload
+	impl.rwlocker = new(sync.RWMutex)
+	impl.file = file
+	impl.meta = block.meta
+	mgr.RegisterNode(impl)
+	return impl
+}
+
+func (node *indexNode) OnLoad() {
+	node.rwlocker.Lock()
+	defer node.rwlocker.Unlock()
+	node.zonemaps = make(map[int]*index.ZoneMap)
+	node.filter = nil
+	node.rows = 0
+	rows := node.file.Rows()
+	if rows == 0 {
+		return
+	}
+	schema := node.meta.GetSchema()
+	zonemaps := make(map[int]*index.ZoneMap)
+	for _, colIdx := range zoneMapCols(schema) {
+		buf, err := node.file.LoadIndex(dataio.IndexTypeZoneMap, uint16(colIdx))
+		if err != nil {
+			panic(err)
+		}
+		// Blocks flushed without the indexes are never pruned
+		if buf == nil {
+			return
+		}
+		zm := new(index.ZoneMap)
+		if err = zm.Unmarshal(buf); err != nil {
+			panic(err)
+		}
+		zonemaps[colIdx] = zm
+	}
+	buf, err := node.file.LoadIndex(dataio.IndexTypeBloomFilter, uint16(schema.PrimaryKey))
+	if err != nil {
+		panic(err)
+	}
+	if buf == nil {
+		return
+	}
+	filter := new(index.BloomFilter)
+	if err = filter.Unmarshal(buf); err != nil {
+		panic(err)
+	}
+	node.zonemaps = zonemaps
+	node.filter = filter
+	node.rows = rows
+}
+
+func (node *indexNode) OnUnload() {
+	node.rwlocker.Lock()
+	defer node.rwlocker.Unlock()
+	node.zonemaps = nil
+	node.filter = nil
+	node.rows = 0
+}
+
+// Install replaces the indexes with the ones covering more rows
+func (node *indexNode) Install(zonemaps map[int]*index.ZoneMap, filter *index.BloomFilter, rows uint32) {
+	node.rwlocker.Lock()
+	defer node.rwlocker.Unlock()
+	if rows <= node.rows {
+		return
+	}
+	node.zonemaps = zonemaps
+	node.filter = filter
+	node.rows = rows
+}
+
+// GetZoneMap returns nil if the column has no zone map covering the first rows
+func (node *indexNode) GetZoneMap(colIdx int, rows uint32) *index.ZoneMap {
+	node.rwlocker.RLock()
+	defer node.rwlocker.RUnlock()
+	if node.rows < rows {
+		return nil
+	}
+	return node.zonemaps[colIdx]
+}
+
+// GetBloomFilter returns nil if the primary key bloom filter does not cover
+// the first rows
+func (node *indexNode) GetBloomFilter(rows uint32) *index.BloomFilter {
+	node.rwlocker.RLock()
+	defer node.rwlocker.RUnlock()
+	if node.rows < rows {
+		return nil
+	}
+	return node.filter
+}
@@ -16,12 +16,13 @@ type insertInfo struct {
 	offsetTxns  map[uint32]txnif.TxnReader
 	txnMap      map[uint64]uint32
 	maxLogIndex *shard.Index
-	maxTs       uint64
-	minTs       uint64
+	maxTs       txnif.TS
+	minTs       txnif.TS
 	maxOffset   uint32
+	baseRows    uint32
 }
 
-func newInsertInfo(rwlocker *sync.RWMutex, maxTs uint64, capacity uint32) *insertInfo {
+func newInsertInfo(rwlocker *sync.RWMutex, maxTs txnif.TS, capacity uint32) *insertInfo {
 	if rwlocker == nil {
 		rwlocker = new(sync.RWMutex)
 	}
@@ -48,7 +49,7 @@ func newInsertInfo(rwlocker *sync.RWMutex, maxTs uint64, capacity uint32) *inser
 func (info *insertInfo) RecordTxnLocked(offset uint32, txn txnif.TxnReader, index *shard.Index) {
 	pos := uint32(info.offsets.Length())
 	info.offsets.Append(1, []uint32{offset})
-	info.ts.Append(1, []uint64{txn.GetCommitTS()})
+	info.ts.Append(1, []uint64{uint64(txn.GetCommitTS())})
 	info.txnMap[txn.GetID()] = pos
 	info.offsetTxns[pos] = txn
 	info.maxLogIndex = index
@@ -63,11 +64,20 @@ func (info *insertInfo) ApplyCommitLocked(txn txnif.TxnReader) error {
 	return nil
 }
 
-func (info *insertInfo) GetVisibleOffsetLocked(ts uint64) int {
+// RecordBaseLocked records the rows appended without a txn, such as the
+// persisted or replayed rows. They are visible to all txns
+func (info *insertInfo) RecordBaseLocked(rows uint32) {
+	info.baseRows = rows
+}
+
+func (info *insertInfo) GetVisibleOffsetLocked(ts txnif.TS) int {
+	offset := int(info.baseRows) - 1
+	if info.offsets.Length() == 0 {
+		return offset
+	}
 	if ts >= info.maxTs {
 		return int(info.maxOffset)
 	}
-	offset := -1
 	pos := -1
 	l := 0
 	h := info.ts.Length() - 1
@@ -77,7 +87,7 @@ func (info *insertInfo) GetVisibleOffsetLocked(ts uint64) int {
 		}
 		m := (l + h) / 2
 		v, _ := info.ts.GetValue(m)
-		vv := v.(uint64)
+		vv := txnif.TS(v.(uint64))
 		// logrus.Infof("vv=%d,ts=%d,pos=%d", vv, ts, pos)
 		if vv < ts {
 			l = m + 1
@@ -14,11 +14,12 @@ type mockTxn struct {
 func newMockTxn() *mockTxn {
 	return &mockTxn{
 		TxnCtx: txnbase.NewTxnCtx(nil, common.NextGlobalSeqNum(),
-			common.NextGlobalSeqNum(), nil),
+			txnif.TS(common.NextGlobalSeqNum()), nil),
 	}
 }
 
 func (txn *mockTxn) GetError() error          { return nil }
+func (txn *mockTxn) IsAborted() bool          { return false }
 func (txn *mockTxn) GetStore() txnif.TxnStore { return nil }
 func (txn *mockTxn) GetTxnState(bool) int32   { return 0 }
 func (txn *mockTxn) IsTerminated(bool) bool   { return false }
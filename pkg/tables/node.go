@@ -5,7 +5,9 @@ import (
 	"tae/pkg/catalog"
 	"tae/pkg/dataio"
 	"tae/pkg/iface/txnif"
+	"tae/pkg/index"
 
+	"github.com/RoaringBitmap/roaring"
 	gbat "github.com/matrixorigin/matrixone/pkg/container/batch"
 	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
 	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/container/batch"
@@ -17,35 +19,44 @@ import (
 
 type appendableNode struct {
 	*buffer.Node
-	file dataio.BlockFile
-	meta *catalog.BlockEntry
-	data batch.IBatch
-	rows uint32
-	mgr  base.INodeManager
+	file  dataio.BlockFile
+	block *dataBlock
+	meta  *catalog.BlockEntry
+	data  batch.IBatch
+	rows  uint32
+	mgr   base.INodeManager
+	// pkIndex maps the primary keys to the rows until the block is frozen
+	pkIndex *index.KeyTree
 }
 
-func newNode(mgr base.INodeManager, meta *catalog.BlockEntry, file dataio.BlockFile) *appendableNode {
+func newNode(mgr base.INodeManager, block *dataBlock, file dataio.BlockFile) *appendableNode {
 	impl := new(appendableNode)
+	meta := block.meta
 	id := meta.AsCommonID()
-	impl.Node = buffer.NewNode(impl, mgr, *id, uint64(catalog.EstimateBlockSize(meta, meta.GetSegment().GetTable().GetSchema().BlockMaxRows)))
+	impl.Node = buffer.NewNode(impl, mgr, *id, uint64(catalog.EstimateBlockSize(meta, meta.GetSchema().BlockMaxRows)))
 	impl.UnloadFunc = impl.OnUnload
 	impl.LoadFunc = impl.OnLoad
 	impl.DestroyFunc = impl.OnDestory
 	impl.file = file
 	impl.mgr = mgr
+	impl.block = block
 	impl.meta = meta
+	impl.rows = file.Rows()
 	mgr.RegisterNode(impl)
 	return impl
 }
 
+// Rows returns the rows appended to the node if coarse. Otherwise it returns
+// the rows visible to txn, which are committed before the txn started and not
+// deleted at its snapshot
 func (node *appendableNode) Rows(txn txnif.AsyncTxn, coarse bool) uint32 {
-	if coarse {
+	if coarse || txn == nil {
 		return node.rows
 	}
-	// TODO: fine row count
-	// 1. Load txn ts zonemap
-	// 2. Calculate fine row count
-	return 0
+	node.block.RLock()
+	defer node.block.RUnlock()
+	rows := node.block.getVisibleRowsLocked(txn)
+	return rows - node.block.deletedRowsLocked(txn, rows)
 }
 
 func (node *appendableNode) OnDestory() {
@@ -54,22 +65,101 @@ func (node *appendableNode) OnDestory() {
 	}
 }
 
-// TODO: Apply updates and txn sels
-func (node *appendableNode) GetVectorCopy(txn txnif.AsyncTxn, attr string, compressed, decompressed *bytes.Buffer) (vec *gvec.Vector, err error) {
-	colIdx := node.meta.GetSegment().GetTable().GetSchema().GetColIdx(attr)
+func (node *appendableNode) GetVectorCopy(rows uint32, attr string, compressed, decompressed *bytes.Buffer) (vec *gvec.Vector, err error) {
+	schema := node.meta.GetSchema()
+	colIdx := schema.GetColIdx(attr)
+	if rows == 0 || node.data == nil {
+		return gvec.New(schema.ColDefs[colIdx].Type), nil
+	}
 	ivec, err := node.data.GetVectorByAttr(colIdx)
 	if err != nil {
 		return nil, err
 	}
-	ro := ivec.GetLatestView()
+	ro, err := ivec.GetLatestView().SliceReference(0, int(rows))
+	if err != nil {
+		return nil, err
+	}
 	return ro.CopyToVectorWithBuffer(compressed, decompressed)
 }
 
+// getKeys returns the primary keys of the first rows. The node should be pinned
+func (node *appendableNode) getKeys(rows uint32) (*gvec.Vector, error) {
+	schema := node.meta.GetSchema()
+	return schema.GetKeys(func(colIdx int) (*gvec.Vector, error) {
+		return node.GetVectorCopy(rows, schema.ColDefs[colIdx].Name, new(bytes.Buffer), new(bytes.Buffer))
+	})
+}
+
 func (node *appendableNode) OnLoad() {
 	var err error
 	if node.data, err = node.file.LoadData(); err != nil {
 		panic(err)
 	}
+	if node.data == nil || node.data.Length() == 0 {
+		return
+	}
+	// Loaded vectors are sized to the persisted rows. Copy them into vectors
+	// of the block capacity to accept more appends
+	maxRows := uint64(node.meta.GetSchema().BlockMaxRows)
+	attrs := node.data.GetAttrs()
+	vecs := make([]vector.IVector, len(attrs))
+	for i, attr := range attrs {
+		loaded, err := node.data.GetVectorByAttr(attr)
+		if err != nil {
+			panic(err)
+		}
+		ro, err := loaded.GetLatestView().CopyToVector()
+		if err != nil {
+			panic(err)
+		}
+		vecs[i] = vector.NewVector(ro.Typ, maxRows)
+		if _, err = vecs[i].AppendVector(ro, 0); err != nil {
+			panic(err)
+		}
+	}
+	if node.data, err = batch.NewBatch(attrs, vecs); err != nil {
+		panic(err)
+	}
+	if node.rows < uint32(maxRows) {
+		if err = node.buildPKIndex(); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// buildPKIndex rebuilds the primary key index of a loaded appendable block. The
+// index is only accessed with the node pinned, so no lock is needed here
+func (node *appendableNode) buildPKIndex() (err error) {
+	vec, err := node.getKeys(uint32(node.data.Length()))
+	if err != nil {
+		return
+	}
+	// The keys of the dead rows are not indexed. The marshaled copy of the
+	// dead rows is read, as the node may be loaded under the block lock
+	dead := roaring.New()
+	if buf := node.block.getDeadRowsBuf(); buf != nil {
+		if err = dead.UnmarshalBinary(buf); err != nil {
+			return
+		}
+	}
+	pkIndex := index.NewKeyTree()
+	rows := uint32(gvec.Length(vec))
+	for start := uint32(0); start < rows; {
+		if dead.Contains(start) {
+			start++
+			continue
+		}
+		end := start + 1
+		for end < rows && !dead.Contains(end) {
+			end++
+		}
+		if err = pkIndex.BatchInsert(vec, int(start), int(end-start), start, false); err != nil {
+			return
+		}
+		start = end
+	}
+	node.pkIndex = pkIndex
+	return
 }
 
 func (node *appendableNode) OnUnload() {
@@ -77,13 +167,76 @@ func (node *appendableNode) OnUnload() {
 	if err := node.file.WriteData(node.data, nil, nil); err != nil {
 		panic(err)
 	}
+	if buf := node.block.getDeadRowsBuf(); buf != nil {
+		if err := node.file.WriteIndex(dataio.IndexTypeDeadRows, 0, buf); err != nil {
+			panic(err)
+		}
+	}
+	// The indexes are rebuilt for the flushed rows and stored with the block
+	zonemaps, filter, err := node.BuildIndexes()
+	if err != nil {
+		panic(err)
+	}
+	if filter != nil {
+		buf, err := filter.Marshal()
+		if err != nil {
+			panic(err)
+		}
+		pk := node.meta.GetSchema().PrimaryKey
+		if err = node.file.WriteIndex(dataio.IndexTypeBloomFilter, uint16(pk), buf); err != nil {
+			panic(err)
+		}
+	}
+	for colIdx, zm := range zonemaps {
+		buf, err := zm.Marshal()
+		if err != nil {
+			panic(err)
+		}
+		if err = node.file.WriteIndex(dataio.IndexTypeZoneMap, uint16(colIdx), buf); err != nil {
+			panic(err)
+		}
+	}
 	if err := node.file.Sync(); err != nil {
 		panic(err)
 	}
+	node.pkIndex = nil
+}
+
+// BuildIndexes builds the zone maps and the primary key bloom filter from all
+// the rows in the node. The node should be pinned
+func (node *appendableNode) BuildIndexes() (zonemaps map[int]*index.ZoneMap, filter *index.BloomFilter, err error) {
+	if node.data == nil || node.data.Length() == 0 {
+		return
+	}
+	schema := node.meta.GetSchema()
+	zonemaps = make(map[int]*index.ZoneMap)
+	for _, colIdx := range zoneMapCols(schema) {
+		var ivec vector.IVector
+		if ivec, err = node.data.GetVectorByAttr(colIdx); err != nil {
+			return
+		}
+		var vec *gvec.Vector
+		if vec, err = ivec.GetLatestView().CopyToVector(); err != nil {
+			return
+		}
+		zm := index.NewZoneMap(schema.ColDefs[colIdx].Type)
+		zm.BatchUpdate(vec, 0, gvec.Length(vec))
+		zonemaps[colIdx] = zm
+	}
+	if !schema.IsCompositeKey() && !index.IsComparable(schema.ColDefs[schema.PrimaryKey].Type) {
+		return
+	}
+	vec, err := node.getKeys(uint32(node.data.Length()))
+	if err != nil {
+		return
+	}
+	filter = index.NewBloomFilter(gvec.Length(vec), index.DefaultFalsePositiveRate)
+	filter.BatchAdd(vec, 0, gvec.Length(vec))
+	return
 }
 
 func (node *appendableNode) PrepareAppend(rows uint32) (n uint32, err error) {
-	left := node.meta.GetSegment().GetTable().GetSchema().BlockMaxRows - node.rows
+	left := node.meta.GetSchema().BlockMaxRows - node.rows
 	if left == 0 {
 		return
 	}
@@ -107,14 +260,15 @@ func (node *appendableNode) ApplyAppend(bat *gbat.Batch, offset, length uint32,
 		attrs := make([]int, len(bat.Vecs))
 		for i, vec := range bat.Vecs {
 			attrs[i] = i
-			vecs[i] = vector.NewVector(vec.Typ, uint64(node.meta.GetSegment().GetTable().GetSchema().BlockMaxRows))
+			vecs[i] = vector.NewVector(vec.Typ, uint64(node.meta.GetSchema().BlockMaxRows))
 		}
 		node.data, _ = batch.NewBatch(attrs, vecs)
 	}
+	schema := node.meta.GetSchema()
 	from = node.rows
 	for idx, attr := range node.data.GetAttrs() {
 		for i, a := range bat.Attrs {
-			if a == node.meta.GetSegment().GetTable().GetSchema().ColDefs[idx].Name {
+			if a == schema.ColDefs[idx].Name {
 				vec, err := node.data.GetVectorByAttr(attr)
 				if err != nil {
 					return 0, err
@@ -125,6 +279,33 @@ func (node *appendableNode) ApplyAppend(bat *gbat.Batch, offset, length uint32,
 			}
 		}
 	}
-	node.rows += length
+	pks, err := schema.GetKeys(func(colIdx int) (*gvec.Vector, error) {
+		for i, a := range bat.Attrs {
+			if a == schema.ColDefs[colIdx].Name {
+				return bat.Vecs[i], nil
+			}
+		}
+		return nil, catalog.ErrNotFound
+	})
+	if err != nil {
+		return
+	}
+	node.block.Lock()
+	if node.pkIndex == nil {
+		node.pkIndex = index.NewKeyTree()
+	}
+	if err = node.pkIndex.BatchInsert(pks, int(offset), int(length), from, false); err == nil {
+		node.rows += length
+	}
+	node.block.Unlock()
+	if err != nil {
+		return
+	}
+	// Appends without a txn come from replay and are visible to all txns
+	if ctx == nil {
+		node.block.info.rwlocker.Lock()
+		node.block.info.RecordBaseLocked(node.rows)
+		node.block.info.rwlocker.Unlock()
+	}
 	return
 }
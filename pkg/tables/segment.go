@@ -16,8 +16,8 @@ type dataSegment struct {
 	bufMgr base.INodeManager
 }
 
-func newSegment(meta *catalog.SegmentEntry, factory dataio.SegmentFileFactory, bufMgr base.INodeManager) *dataSegment {
-	segFile := factory("xxx", meta.GetID())
+func newSegment(meta *catalog.SegmentEntry, factory dataio.SegmentFileFactory, bufMgr base.INodeManager, dir string) *dataSegment {
+	segFile := factory(dir, meta.GetID())
 	blkMeta := meta.LastAppendableBlock()
 	var blk data.Block
 	if blkMeta != nil {
@@ -46,7 +46,7 @@ func (segment *dataSegment) IsAppendable() bool {
 		}
 	}
 	blkCnt := segment.meta.GetAppendableBlockCnt()
-	if blkCnt >= int(segment.meta.GetTable().GetSchema().SegmentMaxBlocks) {
+	if blkCnt >= int(segment.meta.GetSchema().SegmentMaxBlocks) {
 		return false
 	}
 	return true
@@ -66,7 +66,7 @@ func (segment *dataSegment) GetAppender() (id *common.ID, appender data.BlockApp
 	}
 	appender, err = segment.aBlk.MakeAppender()
 	if err != nil {
-		if segment.meta.GetAppendableBlockCnt() >= int(segment.meta.GetTable().GetSchema().SegmentMaxBlocks) {
+		if segment.meta.GetAppendableBlockCnt() >= int(segment.meta.GetSchema().SegmentMaxBlocks) {
 			err = data.ErrAppendableSegmentNotFound
 		} else {
 			err = data.ErrAppendableBlockNotFound
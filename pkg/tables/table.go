@@ -1,10 +1,14 @@
 package tables
 
 import (
+	"sync"
 	"tae/pkg/catalog"
 	"tae/pkg/dataio"
 	"tae/pkg/iface/data"
+	"tae/pkg/iface/handle"
+	"tae/pkg/iface/txnif"
 
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
 	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/common"
 	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/mutation/buffer/base"
 )
@@ -14,6 +18,8 @@ type dataTable struct {
 	aSeg        data.Segment
 	fileFactory dataio.SegmentFileFactory
 	bufMgr      base.INodeManager
+	preparedMu  sync.RWMutex
+	prepared    map[uint64]data.KeyDeduper
 }
 
 func newTable(meta *catalog.TableEntry, fileFactory dataio.SegmentFileFactory, bufMgr base.INodeManager) *dataTable {
@@ -21,6 +27,7 @@ func newTable(meta *catalog.TableEntry, fileFactory dataio.SegmentFileFactory, b
 		meta:        meta,
 		fileFactory: fileFactory,
 		bufMgr:      bufMgr,
+		prepared:    make(map[uint64]data.KeyDeduper),
 	}
 }
 
@@ -47,6 +54,65 @@ func (table *dataTable) setAppendableSegment(id uint64) {
 	}
 }
 
+func (table *dataTable) AddPrepared(txnId uint64, keys data.KeyDeduper) {
+	table.preparedMu.Lock()
+	defer table.preparedMu.Unlock()
+	table.prepared[txnId] = keys
+}
+
+func (table *dataTable) RemovePrepared(txnId uint64) {
+	table.preparedMu.Lock()
+	defer table.preparedMu.Unlock()
+	delete(table.prepared, txnId)
+}
+
+// BatchDedup returns txnbase.ErrDuplicated if any key of pks is in any block
+// of the table for txn, committed or committing, or was appended by a
+// prepared txn
+func (table *dataTable) BatchDedup(txn txnif.AsyncTxn, pks *gvec.Vector) (err error) {
+	table.preparedMu.RLock()
+	for _, keys := range table.prepared {
+		if err = keys.BatchDedup(pks); err != nil {
+			table.preparedMu.RUnlock()
+			return
+		}
+	}
+	table.preparedMu.RUnlock()
+	segIt := table.meta.MakeSegmentIt(false)
+	for segIt.Valid() {
+		seg := segIt.Get().GetPayload().(*catalog.SegmentEntry)
+		blkIt := seg.MakeBlockIt(false)
+		for blkIt.Valid() {
+			blk := blkIt.Get().GetPayload().(*catalog.BlockEntry)
+			if err = blk.GetBlockData().BatchDedup(txn, pks); err != nil {
+				return
+			}
+			blkIt.Next()
+		}
+		segIt.Next()
+	}
+	return
+}
+
+// CheckRWConflict checks filter against all the blocks of the table, including
+// the ones created after txn started
+func (table *dataTable) CheckRWConflict(txn txnif.AsyncTxn, filter handle.Filter) (err error) {
+	segIt := table.meta.MakeSegmentIt(false)
+	for segIt.Valid() {
+		seg := segIt.Get().GetPayload().(*catalog.SegmentEntry)
+		blkIt := seg.MakeBlockIt(false)
+		for blkIt.Valid() {
+			blk := blkIt.Get().GetPayload().(*catalog.BlockEntry)
+			if err = blk.GetBlockData().CheckRWConflict(txn, filter); err != nil {
+				return
+			}
+			blkIt.Next()
+		}
+		segIt.Next()
+	}
+	return
+}
+
 func (table *dataTable) SetAppender(id *common.ID) (appender data.BlockAppender, err error) {
 	if table.aSeg == nil || table.aSeg.GetID() != id.SegmentID {
 		table.setAppendableSegment(id.SegmentID)
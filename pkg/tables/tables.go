@@ -11,12 +11,14 @@ import (
 type DataFactory struct {
 	fileFactory  dataio.SegmentFileFactory
 	appendBufMgr base.INodeManager
+	dir          string
 }
 
-func NewDataFactory(fileFactory dataio.SegmentFileFactory, appendBufMgr base.INodeManager) *DataFactory {
+func NewDataFactory(fileFactory dataio.SegmentFileFactory, appendBufMgr base.INodeManager, dir string) *DataFactory {
 	return &DataFactory{
 		fileFactory:  fileFactory,
 		appendBufMgr: appendBufMgr,
+		dir:          dir,
 	}
 }
 
@@ -28,7 +30,7 @@ func (factory *DataFactory) MakeTableFactory() catalog.TableDataFactory {
 
 func (factory *DataFactory) MakeSegmentFactory() catalog.SegmentDataFactory {
 	return func(meta *catalog.SegmentEntry) data.Segment {
-		return newSegment(meta, factory.fileFactory, factory.appendBufMgr)
+		return newSegment(meta, factory.fileFactory, factory.appendBufMgr, factory.dir)
 	}
 }
 
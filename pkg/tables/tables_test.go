@@ -18,7 +18,7 @@ func initTestPath(t *testing.T) string {
 }
 
 func TestInsertInfo(t *testing.T) {
-	ts := common.NextGlobalSeqNum()
+	ts := txnif.TS(common.NextGlobalSeqNum())
 	capacity := uint32(10000)
 	info := newInsertInfo(nil, ts, capacity)
 	cnt := int(capacity) - 1
@@ -26,7 +26,7 @@ func TestInsertInfo(t *testing.T) {
 	txns := make([]txnif.TxnReader, 0)
 	for i := 0; i < cnt; i++ {
 		txn := newMockTxn()
-		txn.TxnCtx.CommitTS = common.NextGlobalSeqNum()
+		txn.TxnCtx.CommitTS = txnif.TS(common.NextGlobalSeqNum())
 		txn.TxnCtx.State = txnif.TxnStateCommitted
 		info.RecordTxnLocked(uint32(i), txn, nil)
 		txns = append(txns, txn)
@@ -34,7 +34,7 @@ func TestInsertInfo(t *testing.T) {
 	t.Logf("Record takes %s", time.Since(now))
 	{
 		txn := newMockTxn()
-		txn.TxnCtx.CommitTS = common.NextGlobalSeqNum()
+		txn.TxnCtx.CommitTS = txnif.TS(common.NextGlobalSeqNum())
 		txn.TxnCtx.State = txnif.TxnStateCommitted
 		info.RecordTxnLocked(uint32(cnt), txn, nil)
 		txns = append(txns, txn)
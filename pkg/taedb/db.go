@@ -1,11 +1,21 @@
 package taedb
 
 import (
+	"io"
+	"tae/pkg/catalog"
+	"tae/pkg/iface/handle"
 	"tae/pkg/iface/txnif"
+	"tae/pkg/tables"
 	"tae/pkg/txn/txnbase"
+	"tae/pkg/txn/txnimpl"
+	"tae/pkg/updates"
 
+	"github.com/jiangxinmeng1/logstore/pkg/store"
 	"github.com/matrixorigin/matrixone/pkg/container/batch"
-	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/metadata/v1"
+	"github.com/matrixorigin/matrixone/pkg/container/nulls"
+	"github.com/matrixorigin/matrixone/pkg/container/vector"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/mutation/buffer"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/mutation/buffer/base"
 )
 
 type TxnCtx interface {
@@ -13,13 +23,37 @@ type TxnCtx interface {
 }
 
 type TAE interface {
+	io.Closer
 	// TODO: DB should be specified during StartTxn
-	StartTxn() (TxnCtx, error)
+	StartTxn(opts ...txnbase.TxnOption) (TxnCtx, error)
+	// StartTxnAt starts a read-only txn reading the snapshot at ts, which must
+	// be in the retention window
+	StartTxnAt(ts txnif.TS, opts ...txnbase.TxnOption) (TxnCtx, error)
 	CommitTxn(TxnCtx) error
 	RollbackTxn(TxnCtx) error
+	Savepoint(TxnCtx) (int, error)
+	RollbackToSavepoint(TxnCtx, int) error
+	ActiveTxns() []txnbase.TxnInfo
+	// MergeUpdates merges the committed updates of every block up to the
+	// watermark of the txn manager. The versions in the retention window or
+	// read by an active txn are kept
+	MergeUpdates() error
+	// Checkpoint checkpoints the catalog at the watermark of the txn manager
+	// and truncates the catalog records of the txn log covered by it
+	Checkpoint() error
+
+	// PrepareTxn prepares a txn for a commit decided by an external
+	// coordinator and returns the prepare ts
+	PrepareTxn(TxnCtx) (txnif.TS, error)
+	CommitPreparedTxn(TxnCtx, txnif.TS) error
+	AbortPreparedTxn(TxnCtx) error
+	// PreparedTxns returns the prepared txns, including the ones recovered on
+	// open, waiting for their coordinator
+	PreparedTxns() []TxnCtx
 
 	CreateTable(desc *CreateTableDesc, txnCtx TxnCtx) (uint64, error)
 	DropTable(desc *DropTableDesc, txnCtx TxnCtx) (uint64, error)
+	AlterTable(desc *AlterTableDesc, txnCtx TxnCtx) error
 
 	AppendRows(desc *AppendDesc, txnCtx TxnCtx) error
 	BatchDedup(desc *BatchDedupDesc, txnCtx TxnCtx) error
@@ -31,29 +65,143 @@ type TAE interface {
 	DeleteRows(desc *DeleteRowsDesc, txnCtx TxnCtx) error
 }
 
-type taeTnxCtx struct {
-	id uint64
+type tae struct {
+	Dir       string
+	Opts      *Options
+	Catalog   *catalog.Catalog
+	LogDriver *txnimpl.LogTracker
+	TxnBufMgr base.INodeManager
+	MutBufMgr base.INodeManager
+	TxnMgr    *txnbase.TxnManager
 }
 
-func (ctx *taeTnxCtx) GetID() uint64 { return ctx.id }
+func Open(dir string, opts *Options) (TAE, error) {
+	opts = opts.FillDefaults()
+	c, err := catalog.OpenCatalog(dir, catalogStoreName, opts.CatalogCfg)
+	if err != nil {
+		return nil, err
+	}
+	impl, err := store.NewBaseStore(dir, txnStoreName, opts.LogCfg)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	db := &tae{
+		Dir:       dir,
+		Opts:      opts,
+		Catalog:   c,
+		LogDriver: txnimpl.NewLogTracker(txnbase.NewNodeDriverWithStore(impl, true)),
+		TxnBufMgr: buffer.NewNodeManager(opts.TxnBufSize, nil),
+		MutBufMgr: buffer.NewNodeManager(opts.MutBufSize, nil),
+	}
+	factory := tables.NewDataFactory(opts.FileFactory, db.MutBufMgr, dir)
+	if err = c.ReplayCheckpoint(factory); err != nil {
+		db.LogDriver.Close()
+		c.Close()
+		return nil, err
+	}
+	replayer := txnimpl.NewReplayer(c, db.LogDriver, factory)
+	if err = replayer.Replay(); err != nil {
+		db.LogDriver.Close()
+		c.Close()
+		return nil, err
+	}
+	db.TxnMgr = txnbase.NewTxnManager(txnimpl.TxnStoreFactory(c, db.LogDriver, db.TxnBufMgr, factory), txnimpl.TxnFactory(c))
+	db.TxnMgr.ReapInterval = opts.ReapInterval
+	db.TxnMgr.Retention = opts.Retention
+	db.TxnMgr.Init(replayer.GetMaxTxnID(), replayer.GetMaxTS())
+	if err = replayer.RecoverPrepared(db.TxnMgr); err != nil {
+		db.LogDriver.Close()
+		c.Close()
+		return nil, err
+	}
+	db.TxnMgr.Start()
+	return db, nil
+}
 
-type tae struct {
-	TxnMgr *txnbase.TxnManager
+func (db *tae) Close() error {
+	db.TxnMgr.Stop()
+	db.LogDriver.Close()
+	return db.Catalog.Close()
 }
 
 func (db *tae) GetTxn(ctx TxnCtx) (txnif.AsyncTxn, error) {
+	if ctx == nil {
+		return nil, ErrTxnNotFound
+	}
 	transaction := db.TxnMgr.GetTxn(ctx.GetID())
 	if transaction == nil {
+		// The txn is the ctx returned by StartTxn
+		if reader, ok := ctx.(txnif.TxnReader); ok && reader.IsAborted() {
+			return nil, ErrTxnAborted
+		}
 		return nil, ErrTxnNotFound
 	}
 	return transaction, nil
 }
 
-func (db *tae) StartTxn() (TxnCtx, error) {
-	ctx := db.TxnMgr.StartTxn(nil)
+func (db *tae) StartTxn(opts ...txnbase.TxnOption) (TxnCtx, error) {
+	ctx := db.TxnMgr.StartTxn(nil, opts...)
+	return ctx, nil
+}
+
+func (db *tae) StartTxnAt(ts txnif.TS, opts ...txnbase.TxnOption) (TxnCtx, error) {
+	ctx, err := db.TxnMgr.StartTxnAt(nil, ts, opts...)
+	if err != nil {
+		return nil, err
+	}
 	return ctx, nil
 }
 
+// startStatement returns the txn of ctx for a new statement. The txn cannot
+// be aborted until EndOp is called
+func (db *tae) startStatement(ctx TxnCtx) (txnif.AsyncTxn, error) {
+	transaction, err := db.GetTxn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = transaction.BeginOp(); err != nil {
+		return nil, err
+	}
+	transaction.StartStatement()
+	return transaction, nil
+}
+
+func (db *tae) ActiveTxns() []txnbase.TxnInfo {
+	return db.TxnMgr.ActiveTxns()
+}
+
+func (db *tae) MergeUpdates() error {
+	watermark := db.TxnMgr.Watermark()
+	for dbIt := db.Catalog.MakeDBIt(true); dbIt.Valid(); dbIt.Next() {
+		database := dbIt.Get().GetPayload().(*catalog.DBEntry)
+		for tableIt := database.MakeTableIt(true); tableIt.Valid(); tableIt.Next() {
+			table := tableIt.Get().GetPayload().(*catalog.TableEntry)
+			for segIt := table.MakeSegmentIt(true); segIt.Valid(); segIt.Next() {
+				segment := segIt.Get().GetPayload().(*catalog.SegmentEntry)
+				for blkIt := segment.MakeBlockIt(true); blkIt.Valid(); blkIt.Next() {
+					block := blkIt.Get().GetPayload().(*catalog.BlockEntry)
+					if data := block.GetBlockData(); data != nil {
+						data.GetUpdateChain().(*updates.BlockUpdateChain).AddMergeNodeBefore(watermark)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (db *tae) Checkpoint() (err error) {
+	ts := db.TxnMgr.Watermark()
+	if ts <= db.Catalog.GetCheckpointed() {
+		return
+	}
+	if err = db.Catalog.Checkpoint(ts); err != nil {
+		return
+	}
+	return db.LogDriver.Truncate(ts)
+}
+
 func (db *tae) CommitTxn(ctx TxnCtx) error {
 	transaction, err := db.GetTxn(ctx)
 	if err != nil {
@@ -70,47 +218,399 @@ func (db *tae) RollbackTxn(ctx TxnCtx) error {
 	return transaction.Rollback()
 }
 
-func (db *tae) AppendRows(desc *AppendDesc, ctx TxnCtx) error {
-	// transaction, err := db.GetTxn(ctx)
-	// if err != nil {
-	// 	return err
-	// }
-	// entry, err := db.getOrSetTable(transaction, desc.DB, desc.Table)
-	// if err != nil {
-	// 	return err
-	// }
-	// if err = entry.Store.BatchDedup(desc.Data, transaction); err != nil {
-	// 	return err
-	// }
-	// if err = transaction.BatchDedup(desc.Data); err != nil {
-	//  return err
-	// }
-
-	// return transaction.AppendRows(desc.Data)
-	return nil
+func (db *tae) PrepareTxn(ctx TxnCtx) (txnif.TS, error) {
+	transaction, err := db.GetTxn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return transaction.Prepare()
 }
 
-func (db *tae) getOrSetTable(transaction txnif.AsyncTxn, dbName, tableName string) *metadata.Table {
-	// entry := transaction.GetTableByName(desc.DB, desc.Table)
-	// if entry == nil {
-	// 	if entry, err = db.Catalog.GetTableByName(desc.DB, desc.Table, transaction); err != nil {
-	// 		return err
-	// 	}
-	// 	transaction.RegisterTable(entry)
-	// }
-	// return entry
-	return nil
+func (db *tae) CommitPreparedTxn(ctx TxnCtx, ts txnif.TS) error {
+	transaction, err := db.GetTxn(ctx)
+	if err != nil {
+		return err
+	}
+	return transaction.CommitPrepared(ts)
+}
+
+func (db *tae) AbortPreparedTxn(ctx TxnCtx) error {
+	transaction, err := db.GetTxn(ctx)
+	if err != nil {
+		return err
+	}
+	return transaction.AbortPrepared()
+}
+
+func (db *tae) PreparedTxns() []TxnCtx {
+	txns := db.TxnMgr.PreparedTxns()
+	ctxs := make([]TxnCtx, len(txns))
+	for i, txn := range txns {
+		ctxs[i] = txn
+	}
+	return ctxs
+}
+
+func (db *tae) Savepoint(ctx TxnCtx) (int, error) {
+	transaction, err := db.GetTxn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return transaction.Savepoint()
+}
+
+func (db *tae) RollbackToSavepoint(ctx TxnCtx, id int) error {
+	transaction, err := db.GetTxn(ctx)
+	if err != nil {
+		return err
+	}
+	return transaction.RollbackToSavepoint(id)
+}
+
+func (db *tae) getDatabase(transaction txnif.AsyncTxn, name string) (database handle.Database, err error) {
+	database, err = transaction.GetDatabase(name)
+	if err == catalog.ErrNotFound {
+		err = ErrDBNotFound
+	}
+	return
+}
+
+func (db *tae) getRelation(transaction txnif.AsyncTxn, dbName, tableName string) (rel handle.Relation, err error) {
+	database, err := db.getDatabase(transaction, dbName)
+	if err != nil {
+		return
+	}
+	rel, err = database.GetRelationByName(tableName)
+	if err == catalog.ErrNotFound {
+		err = ErrTableNotFound
+	}
+	return
+}
+
+func (db *tae) CreateTable(desc *CreateTableDesc, ctx TxnCtx) (id uint64, err error) {
+	if desc == nil || !desc.Schema.Valid() {
+		err = ErrInvalidDesc
+		return
+	}
+	transaction, err := db.startStatement(ctx)
+	if err != nil {
+		return
+	}
+	defer transaction.EndOp()
+	database, err := db.getDatabase(transaction, desc.DB)
+	if err == ErrDBNotFound {
+		database, err = transaction.CreateDatabase(desc.DB)
+	}
+	if err != nil {
+		return
+	}
+	rel, err := database.CreateRelation(desc.Schema)
+	if err != nil {
+		return
+	}
+	id = rel.ID()
+	return
+}
+
+func (db *tae) DropTable(desc *DropTableDesc, ctx TxnCtx) (id uint64, err error) {
+	if desc == nil {
+		err = ErrInvalidDesc
+		return
+	}
+	transaction, err := db.startStatement(ctx)
+	if err != nil {
+		return
+	}
+	defer transaction.EndOp()
+	database, err := db.getDatabase(transaction, desc.DB)
+	if err != nil {
+		return
+	}
+	rel, err := database.DropRelationByName(desc.Name)
+	if err == catalog.ErrNotFound {
+		err = ErrTableNotFound
+	}
+	if err != nil {
+		return
+	}
+	id = rel.ID()
+	return
+}
+
+func (db *tae) AlterTable(desc *AlterTableDesc, ctx TxnCtx) error {
+	if desc == nil || desc.Req == nil {
+		return ErrInvalidDesc
+	}
+	transaction, err := db.startStatement(ctx)
+	if err != nil {
+		return err
+	}
+	defer transaction.EndOp()
+	rel, err := db.getRelation(transaction, desc.DB, desc.Table)
+	if err != nil {
+		return err
+	}
+	return rel.AlterTable(desc.Req)
+}
+
+func (db *tae) AppendRows(desc *AppendDesc, ctx TxnCtx) error {
+	if desc == nil || desc.Data == nil {
+		return ErrInvalidDesc
+	}
+	transaction, err := db.startStatement(ctx)
+	if err != nil {
+		return err
+	}
+	defer transaction.EndOp()
+	rel, err := db.getRelation(transaction, desc.DB, desc.Table)
+	if err != nil {
+		return err
+	}
+	if desc.Dedup {
+		schema := rel.GetMeta().(*catalog.TableEntry).GetSchemaFor(transaction)
+		keys, err := getDedupKeys(schema, desc.Data)
+		if err != nil {
+			return err
+		}
+		if keys != nil {
+			if err = rel.BatchDedup(keys); err != nil {
+				return err
+			}
+		}
+	}
+	return rel.Append(desc.Data)
 }
 
 func (db *tae) BatchDedup(desc *BatchDedupDesc, ctx TxnCtx) error {
-	// transaction, err := db.GetTxn(ctx)
-	// if err != nil {
-	// 	return err
-	// }
-	// entry := db.getOrSetTable(transaction, desc.DB, desc.Table)
-	// if err = entry.Store.BatchDedup(desc.Col, transaction); err != nil {
-	// 	return err
-	// }
-	// return transaction.BatchDedup(desc.Col)
-	return nil
+	if desc == nil || desc.Col == nil {
+		return ErrInvalidDesc
+	}
+	transaction, err := db.startStatement(ctx)
+	if err != nil {
+		return err
+	}
+	defer transaction.EndOp()
+	rel, err := db.getRelation(transaction, desc.DB, desc.Table)
+	if err != nil {
+		return err
+	}
+	return rel.BatchDedup(desc.Col)
+}
+
+func (db *tae) GetByFilter(desc *FilterDesc, ctx TxnCtx) (bat *batch.Batch, err error) {
+	if desc == nil || desc.ColOperand == nil {
+		err = ErrInvalidDesc
+		return
+	}
+	transaction, err := db.startStatement(ctx)
+	if err != nil {
+		return
+	}
+	defer transaction.EndOp()
+	rel, err := db.getRelation(transaction, desc.DB, desc.Table)
+	if err != nil {
+		return
+	}
+	schema := rel.GetMeta().(*catalog.TableEntry).GetSchemaFor(transaction)
+	filter := desc.ToFilter()
+	if desc.DB == catalog.SystemDBName {
+		return getSystemRowsByFilter(rel, schema, filter)
+	}
+	bat = batch.New(true, schema.Attrs())
+	for i := range bat.Vecs {
+		bat.Vecs[i] = vector.New(schema.ColDefs[i].Type)
+	}
+	it := rel.MakeSegmentIt()
+	for it.Valid() {
+		var bats map[uint64]*batch.Batch
+		if bats, err = it.GetSegment().GetByFilter(filter, false); err != nil {
+			return
+		}
+		for _, part := range bats {
+			appendBatch(bat, part)
+		}
+		it.Next()
+	}
+	return
+}
+
+// getSystemRowsByFilter returns the rows of a system table that match filter.
+// The rows are not stored in segments, so they are read from the catalog and
+// filtered one by one
+func getSystemRowsByFilter(rel handle.Relation, schema *catalog.Schema, filter handle.Filter) (bat *batch.Batch, err error) {
+	attr := filter.Attr
+	if attr == "" {
+		attr = schema.ColDefs[schema.PrimaryKey].Name
+	}
+	colIdx := schema.GetColIdx(attr)
+	if colIdx < 0 {
+		return nil, ErrInvalidDesc
+	}
+	match, err := tables.NewRowMatcher(filter, schema.ColDefs[colIdx])
+	if err != nil {
+		return
+	}
+	if bat, err = rel.MakeReader().Next(nil, schema.Attrs()); err != nil {
+		return
+	}
+	if bat == nil {
+		bat = batch.New(true, schema.Attrs())
+		for i := range bat.Vecs {
+			bat.Vecs[i] = vector.New(schema.ColDefs[i].Type)
+		}
+		return
+	}
+	sels := make([]int64, 0)
+	col := bat.Vecs[colIdx]
+	for row := 0; row < vector.Length(col); row++ {
+		if match(txnbase.GetValue(col, uint32(row))) {
+			sels = append(sels, int64(row))
+		}
+	}
+	for _, vec := range bat.Vecs {
+		vector.Shrink(vec, sels)
+	}
+	return
+}
+
+func (db *tae) DeleteByFilter(desc *FilterDesc, ctx TxnCtx) error {
+	if desc == nil || desc.ColOperand == nil {
+		return ErrInvalidDesc
+	}
+	transaction, err := db.startStatement(ctx)
+	if err != nil {
+		return err
+	}
+	defer transaction.EndOp()
+	rel, err := db.getRelation(transaction, desc.DB, desc.Table)
+	if err != nil {
+		return err
+	}
+	return rel.DeleteByFilter(desc.ToFilter())
+}
+
+func (db *tae) UpdateByFilter(desc *UpdateDesc, ctx TxnCtx) error {
+	if desc == nil || desc.Filter == nil || desc.Filter.ColOperand == nil {
+		return ErrInvalidDesc
+	}
+	transaction, err := db.startStatement(ctx)
+	if err != nil {
+		return err
+	}
+	defer transaction.EndOp()
+	rel, err := db.getRelation(transaction, desc.Filter.DB, desc.Filter.Table)
+	if err != nil {
+		return err
+	}
+	err = rel.UpdateByFilter(desc.Filter.ToFilter(), desc.Attr, desc.Value)
+	if err == catalog.ErrNotFound || err == catalog.ErrValidation {
+		err = ErrInvalidDesc
+	}
+	return err
+}
+
+func (db *tae) DeleteRows(desc *DeleteRowsDesc, ctx TxnCtx) error {
+	if desc == nil || desc.Rows == nil {
+		return ErrInvalidDesc
+	}
+	transaction, err := db.startStatement(ctx)
+	if err != nil {
+		return err
+	}
+	defer transaction.EndOp()
+	rel, err := db.getRelation(transaction, desc.DB, desc.Table)
+	if err != nil {
+		return err
+	}
+	schema := rel.GetMeta().(*catalog.TableEntry).GetSchemaFor(transaction)
+	// The filters match the values of a single column
+	if schema.IsCompositeKey() {
+		return ErrCompositeKey
+	}
+	keys, err := getColumnByName(desc.Rows, schema.ColDefs[schema.PrimaryKey].Name)
+	if err != nil {
+		return err
+	}
+	return rel.DeleteByFilter(handle.Filter{
+		Op:  handle.FilterEq,
+		Col: keys,
+	})
+}
+
+// getColumnByName returns the column attr of bat. The columns of bat can be in
+// any order but should be of the same length
+func getColumnByName(bat *batch.Batch, attr string) (col *vector.Vector, err error) {
+	if len(bat.Attrs) != len(bat.Vecs) {
+		return nil, ErrInvalidDesc
+	}
+	for i, name := range bat.Attrs {
+		if vector.Length(bat.Vecs[i]) != vector.Length(bat.Vecs[0]) {
+			return nil, ErrInvalidDesc
+		}
+		if name == attr {
+			col = bat.Vecs[i]
+		}
+	}
+	if col == nil {
+		err = ErrInvalidDesc
+	}
+	return
+}
+
+// getDedupKeys returns the primary keys of the rows of bat, or nil if bat
+// omits a key column. The rows with a NULL key column are skipped, as the
+// NULLs of an auto-increment key take new values
+func getDedupKeys(schema *catalog.Schema, bat *batch.Batch) (*vector.Vector, error) {
+	cols := make(map[int]*vector.Vector)
+	for i, attr := range bat.Attrs {
+		if idx := schema.GetColIdx(attr); idx >= 0 && schema.IsKeyCol(idx) {
+			cols[idx] = bat.Vecs[i]
+		}
+	}
+	if len(cols) < len(schema.KeyCols()) {
+		return nil, nil
+	}
+	var nullRows map[int]bool
+	for _, vec := range cols {
+		if !nulls.Any(vec.Nsp) {
+			continue
+		}
+		if nullRows == nil {
+			nullRows = make(map[int]bool)
+		}
+		for row := 0; row < vector.Length(vec); row++ {
+			if nulls.Contains(vec.Nsp, uint64(row)) {
+				nullRows[row] = true
+			}
+		}
+	}
+	return schema.GetKeys(func(colIdx int) (*vector.Vector, error) {
+		return skipRows(cols[colIdx], nullRows), nil
+	})
+}
+
+// skipRows returns the values of vec except the rows
+func skipRows(vec *vector.Vector, rows map[int]bool) *vector.Vector {
+	if len(rows) == 0 {
+		return vec
+	}
+	ret := vector.New(vec.Typ)
+	for row := 0; row < vector.Length(vec); row++ {
+		if !rows[row] {
+			txnbase.AppendValue(ret, txnbase.GetValue(vec, uint32(row)))
+		}
+	}
+	return ret
+}
+
+func appendBatch(dest, src *batch.Batch) {
+	for i, vec := range src.Vecs {
+		rows := vector.Length(vec)
+		for row := 0; row < rows; row++ {
+			if nulls.Contains(vec.Nsp, uint64(row)) {
+				nulls.Add(dest.Vecs[i].Nsp, uint64(vector.Length(dest.Vecs[i])))
+			}
+			txnbase.AppendValue(dest.Vecs[i], txnbase.GetValue(vec, uint32(row)))
+		}
+	}
 }
@@ -0,0 +1,1481 @@
+package taedb
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"tae/pkg/catalog"
+	"tae/pkg/common"
+	"tae/pkg/iface/data"
+	"tae/pkg/iface/handle"
+	"tae/pkg/iface/txnif"
+	"tae/pkg/txn/txnbase"
+	"testing"
+	"time"
+
+	gbat "github.com/matrixorigin/matrixone/pkg/container/batch"
+	gnulls "github.com/matrixorigin/matrixone/pkg/container/nulls"
+	"github.com/matrixorigin/matrixone/pkg/container/types"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func initTestPath(t *testing.T) string {
+	dir := filepath.Join("/tmp", t.Name())
+	os.RemoveAll(dir)
+	return dir
+}
+
+// testTable appends and reads the rows of a table in the database "db". All
+// the columns of its schema are int32 columns
+type testTable struct {
+	t      *testing.T
+	db     TAE
+	schema *catalog.Schema
+}
+
+func newTestTable(t *testing.T, db TAE, schema *catalog.Schema) *testTable {
+	return &testTable{t: t, db: db, schema: schema}
+}
+
+// makeBatch returns the rows in [start, end). The columns of a row all hold
+// the row number
+func (tbl *testTable) makeBatch(start, end int32) *gbat.Batch {
+	bat := gbat.New(true, tbl.schema.Attrs())
+	for i, colDef := range tbl.schema.ColDefs {
+		vals := make([]int32, 0)
+		for v := start; v < end; v++ {
+			vals = append(vals, v)
+		}
+		bat.Vecs[i] = gvec.New(colDef.Type)
+		assert.Nil(tbl.t, gvec.Append(bat.Vecs[i], vals))
+	}
+	return bat
+}
+
+// appendRows appends the rows in [start, end), deduped by the primary key
+func (tbl *testTable) appendRows(txn TxnCtx, start, end int32) error {
+	return tbl.db.AppendRows(&AppendDesc{DB: "db", Table: tbl.schema.Name, Data: tbl.makeBatch(start, end), Dedup: true}, txn)
+}
+
+// operand returns the vector of vals used as the operand of a filter
+func (tbl *testTable) operand(vals ...int32) *gvec.Vector {
+	vec := gvec.New(tbl.schema.ColDefs[0].Type)
+	assert.Nil(tbl.t, gvec.Append(vec, vals))
+	return vec
+}
+
+func (tbl *testTable) getRelation(ctx TxnCtx) handle.Relation {
+	impl := tbl.db.(*tae)
+	txn, err := impl.GetTxn(ctx)
+	assert.Nil(tbl.t, err)
+	rel, err := impl.getRelation(txn, "db", tbl.schema.Name)
+	assert.Nil(tbl.t, err)
+	return rel
+}
+
+func (tbl *testTable) firstBlock(ctx TxnCtx) handle.Block {
+	return tbl.getRelation(ctx).MakeSegmentIt().GetSegment().MakeBlockIt().GetBlock()
+}
+
+// readCol returns the values of the column col visible to the txn
+func (tbl *testTable) readCol(ctx TxnCtx, col int) []int32 {
+	reader := tbl.getRelation(ctx).MakeReader()
+	vals := make([]int32, 0)
+	for {
+		bat, err := reader.Next(nil, []string{tbl.schema.ColDefs[col].Name})
+		assert.Nil(tbl.t, err)
+		if bat == nil {
+			break
+		}
+		vals = append(vals, bat.Vecs[0].Col.([]int32)...)
+	}
+	return vals
+}
+
+// countRows returns the number of the rows visible to the txn
+func (tbl *testTable) countRows(ctx TxnCtx) int {
+	return len(tbl.readCol(ctx, 0))
+}
+
+func TestDB1(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, nil)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	schema := catalog.MockSchema(2)
+	schema.PrimaryKey = 1
+	schema.BlockMaxRows = 1000
+	schema.SegmentMaxBlocks = 2
+	bat := mock.MockBatch(schema.Types(), 100)
+	{
+		txn, _ := db.StartTxn()
+		id, err := db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+		assert.Nil(t, err)
+		assert.NotEqual(t, uint64(0), id)
+		err = db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: bat, Dedup: true}, txn)
+		assert.Nil(t, err)
+		err = db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: bat, Dedup: true}, txn)
+		assert.Equal(t, txnbase.ErrDuplicated, err)
+		err = db.BatchDedup(&BatchDedupDesc{DB: "db", Table: schema.Name, Col: bat.Vecs[schema.PrimaryKey]}, txn)
+		assert.Equal(t, txnbase.ErrDuplicated, err)
+		assert.Nil(t, db.CommitTxn(txn))
+		assert.Equal(t, ErrTxnNotFound, db.CommitTxn(txn))
+	}
+	{
+		txn, _ := db.StartTxn()
+		err := db.AppendRows(&AppendDesc{DB: "db", Table: "xx", Data: bat}, txn)
+		assert.Equal(t, ErrTableNotFound, err)
+		_, err = db.DropTable(&DropTableDesc{DB: "db", Name: "xx"}, txn)
+		assert.Equal(t, ErrTableNotFound, err)
+		assert.Nil(t, db.RollbackTxn(txn))
+	}
+	{
+		txn, _ := db.StartTxn()
+		err := db.AppendRows(&AppendDesc{DB: "xx", Table: schema.Name, Data: bat}, txn)
+		assert.Equal(t, ErrDBNotFound, err)
+		assert.Nil(t, db.RollbackTxn(txn))
+	}
+	{
+		txn, _ := db.StartTxn()
+		_, err := db.GetByFilter(&FilterDesc{DB: "db", Table: schema.Name, Op: FilterEq}, txn)
+		assert.Equal(t, ErrInvalidDesc, err)
+		filter := &FilterDesc{DB: "db", Table: schema.Name, Op: FilterEq, ColOperand: bat.Vecs[schema.PrimaryKey]}
+		res, err := db.GetByFilter(filter, txn)
+		assert.Nil(t, err)
+		assert.Equal(t, len(schema.ColDefs), len(res.Vecs))
+		err = db.UpdateByFilter(&UpdateDesc{Filter: filter, Attr: "xx"}, txn)
+		assert.Equal(t, ErrInvalidDesc, err)
+		id, err := db.DropTable(&DropTableDesc{DB: "db", Name: schema.Name}, txn)
+		assert.Nil(t, err)
+		assert.NotEqual(t, uint64(0), id)
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+	{
+		txn, _ := db.StartTxn()
+		err := db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: bat}, txn)
+		assert.Equal(t, ErrTableNotFound, err)
+		assert.Nil(t, db.RollbackTxn(txn))
+	}
+}
+
+func TestReplay1(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, nil)
+	assert.Nil(t, err)
+
+	schema := catalog.MockSchema(2)
+	schema.PrimaryKey = 1
+	schema.BlockMaxRows = 1000
+	schema.SegmentMaxBlocks = 2
+	schema2 := catalog.MockSchema(2)
+	schema2.BlockMaxRows = 1000
+	schema2.SegmentMaxBlocks = 2
+	rows := int(txnbase.MaxNodeRows) + 2500
+	bat := mock.MockBatch(schema.Types(), uint64(rows))
+	{
+		txn, _ := db.StartTxn()
+		_, err = db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+		assert.Nil(t, err)
+		_, err = db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema2}, txn)
+		assert.Nil(t, err)
+		assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: bat}, txn))
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+	prevTxn, _ := db.StartTxn()
+	_, err = db.DropTable(&DropTableDesc{DB: "db", Name: schema2.Name}, prevTxn)
+	assert.Nil(t, err)
+	assert.Nil(t, db.CommitTxn(prevTxn))
+	prevCatalog := db.(*tae).Catalog
+	prevBlk := prevCatalog.CurrBlock()
+	assert.Nil(t, db.Close())
+
+	db, err = Open(dir, nil)
+	assert.Nil(t, err)
+	defer db.Close()
+	c := db.(*tae).Catalog
+	t.Log(c.PPString(common.PPL1, 0, ""))
+	assert.Equal(t, prevBlk, c.CurrBlock())
+
+	txn, _ := db.StartTxn()
+	assert.True(t, txn.GetID() > prevTxn.GetID())
+	// The timestamps go on after the replayed ones
+	assert.True(t, txn.(txnif.AsyncTxn).GetStartTS() > prevTxn.(txnif.AsyncTxn).GetCommitTS())
+	err = db.AppendRows(&AppendDesc{DB: "db", Table: schema2.Name, Data: bat}, txn)
+	assert.Equal(t, ErrTableNotFound, err)
+	_, err = db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+	assert.Equal(t, catalog.ErrDuplicate, err)
+	assert.Nil(t, db.RollbackTxn(txn))
+
+	dbEntry := c.MakeDBIt(true).Get().GetPayload().(*catalog.DBEntry)
+	replayed := 0
+	tblIt := dbEntry.MakeTableIt(true)
+	for tblIt.Valid() {
+		table := tblIt.Get().GetPayload().(*catalog.TableEntry)
+		if table.GetSchema().Name == schema.Name {
+			segIt := table.MakeSegmentIt(true)
+			for segIt.Valid() {
+				blkIt := segIt.Get().GetPayload().(*catalog.SegmentEntry).MakeBlockIt(true)
+				for blkIt.Valid() {
+					blk := blkIt.Get().GetPayload().(*catalog.BlockEntry)
+					replayed += blk.GetBlockData().Rows(nil, true)
+					blkIt.Next()
+				}
+				segIt.Next()
+			}
+		}
+		tblIt.Next()
+	}
+	assert.Equal(t, rows, replayed)
+}
+
+func TestReplay2(t *testing.T) {
+	dir := initTestPath(t)
+	schema := catalog.MockSchema(2)
+	schema.PrimaryKey = 1
+	schema.BlockMaxRows = 1000
+	schema.SegmentMaxBlocks = 2
+	blkSize := uint64(0)
+	for _, def := range schema.ColDefs {
+		blkSize += uint64(def.Type.Size) * uint64(schema.BlockMaxRows)
+	}
+	// Only one block fits in the buffer and the others are unloaded into
+	// the segment files
+	opts := &Options{MutBufSize: blkSize}
+	db, err := Open(dir, opts)
+	assert.Nil(t, err)
+
+	rows := 3500
+	bat := mock.MockBatch(schema.Types(), uint64(rows))
+	var tableId uint64
+	{
+		txn, _ := db.StartTxn()
+		tableId, err = db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+		assert.Nil(t, err)
+		assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: bat}, txn))
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+	assert.Nil(t, db.Close())
+
+	db, err = Open(dir, opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	dbEntry := db.(*tae).Catalog.MakeDBIt(true).Get().GetPayload().(*catalog.DBEntry)
+	table, err := dbEntry.GetTableEntryByID(tableId)
+	assert.Nil(t, err)
+	replayed := 0
+	var lastBlk *catalog.BlockEntry
+	segIt := table.MakeSegmentIt(true)
+	for segIt.Valid() {
+		blkIt := segIt.Get().GetPayload().(*catalog.SegmentEntry).MakeBlockIt(true)
+		for blkIt.Valid() {
+			blk := blkIt.Get().GetPayload().(*catalog.BlockEntry)
+			replayed += blk.GetBlockData().Rows(nil, true)
+			if blk.GetBlockData().IsAppendable() {
+				lastBlk = blk
+			}
+			blkIt.Next()
+		}
+		segIt.Next()
+	}
+	assert.Equal(t, rows, replayed)
+
+	// The last block was persisted with 500 rows and is still appendable
+	txn, _ := db.StartTxn()
+	more := mock.MockBatch(schema.Types(), 100)
+	assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: more}, txn))
+	assert.Nil(t, db.CommitTxn(txn))
+	assert.Equal(t, 600, lastBlk.GetBlockData().Rows(nil, true))
+}
+
+func TestGroupCommit(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, nil)
+	assert.Nil(t, err)
+
+	schema := catalog.MockSchema(2)
+	schema.BlockMaxRows = 1000
+	schema.SegmentMaxBlocks = 2
+	var tableId uint64
+	{
+		txn, _ := db.StartTxn()
+		tableId, err = db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+		assert.Nil(t, err)
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+	// Many small txns committing concurrently are logged in groups
+	txns, rows := 200, 5
+	var wg sync.WaitGroup
+	for i := 0; i < txns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			txn, _ := db.StartTxn()
+			bat := mock.MockBatch(schema.Types(), uint64(rows))
+			assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: bat}, txn))
+			assert.Nil(t, db.CommitTxn(txn))
+		}()
+	}
+	wg.Wait()
+	assert.Nil(t, db.Close())
+
+	db, err = Open(dir, nil)
+	assert.Nil(t, err)
+	defer db.Close()
+	dbEntry := db.(*tae).Catalog.MakeDBIt(true).Get().GetPayload().(*catalog.DBEntry)
+	table, err := dbEntry.GetTableEntryByID(tableId)
+	assert.Nil(t, err)
+	replayed := 0
+	segIt := table.MakeSegmentIt(true)
+	for segIt.Valid() {
+		blkIt := segIt.Get().GetPayload().(*catalog.SegmentEntry).MakeBlockIt(true)
+		for blkIt.Valid() {
+			replayed += blkIt.Get().GetPayload().(*catalog.BlockEntry).GetBlockData().Rows(nil, true)
+			blkIt.Next()
+		}
+		segIt.Next()
+	}
+	assert.Equal(t, txns*rows, replayed)
+}
+
+func TestCheckpointCatalog(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, nil)
+	assert.Nil(t, err)
+	c := db.(*tae).Catalog
+	mgr := db.(*tae).TxnMgr
+
+	schemas := make([]*catalog.Schema, 4)
+	for i := range schemas {
+		schemas[i] = catalog.MockSchema(2)
+		schemas[i].BlockMaxRows = 1000
+		schemas[i].SegmentMaxBlocks = 2
+	}
+	bat := mock.MockBatch(schemas[0].Types(), 1500)
+	{
+		txn, _ := db.StartTxn()
+		for _, schema := range schemas[:3] {
+			_, err = db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+			assert.Nil(t, err)
+		}
+		assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schemas[0].Name, Data: bat}, txn))
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+	{
+		txn, _ := db.StartTxn()
+		_, err = db.DropTable(&DropTableDesc{DB: "db", Name: schemas[1].Name}, txn)
+		assert.Nil(t, err)
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+	assert.Nil(t, c.Checkpoint(mgr.Clock.Last()))
+	assert.Equal(t, catalog.ErrStaleCheckpoint, c.Checkpoint(mgr.Clock.Last()))
+	{
+		txn, _ := db.StartTxn()
+		_, err = db.DropTable(&DropTableDesc{DB: "db", Name: schemas[2].Name}, txn)
+		assert.Nil(t, err)
+		_, err = db.CreateTable(&CreateTableDesc{DB: "db", Schema: schemas[3]}, txn)
+		assert.Nil(t, err)
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+	// An uncommitted txn is excluded from the checkpoint
+	active, _ := db.StartTxn()
+	_, err = db.DropTable(&DropTableDesc{DB: "db", Name: schemas[0].Name}, active)
+	assert.Nil(t, err)
+	ckpTs := mgr.Clock.Last()
+	assert.Nil(t, c.Checkpoint(ckpTs))
+	assert.Nil(t, db.RollbackTxn(active))
+	prevBlk := c.CurrBlock()
+	assert.Nil(t, db.Close())
+
+	db, err = Open(dir, nil)
+	assert.Nil(t, err)
+	defer db.Close()
+	c = db.(*tae).Catalog
+	t.Log(c.PPString(common.PPL1, 0, ""))
+	assert.Equal(t, ckpTs, c.GetCheckpointed())
+	assert.Equal(t, prevBlk, c.CurrBlock())
+
+	txn, _ := db.StartTxn()
+	for i, schema := range schemas {
+		err = db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: bat}, txn)
+		if i == 1 || i == 2 {
+			assert.Equal(t, ErrTableNotFound, err)
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+	assert.Nil(t, db.RollbackTxn(txn))
+}
+
+func TestCheckpointTruncate(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, &Options{Retention: 10 * time.Millisecond})
+	assert.Nil(t, err)
+	tracker := db.(*tae).LogDriver
+
+	schemas := make([]*catalog.Schema, 3)
+	for i := range schemas {
+		schemas[i] = catalog.MockSchema(2)
+		schemas[i].BlockMaxRows = 1000
+		schemas[i].SegmentMaxBlocks = 2
+	}
+	createTable := func(schema *catalog.Schema) {
+		txn, _ := db.StartTxn()
+		_, err := db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+		assert.Nil(t, err)
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+	// The watermark passes the txns committed before
+	checkpoint := func() {
+		time.Sleep(20 * time.Millisecond)
+		txn, _ := db.StartTxn()
+		assert.Nil(t, db.RollbackTxn(txn))
+		assert.Nil(t, db.Checkpoint())
+	}
+	createTable(schemas[0])
+	createTable(schemas[1])
+	checkpoint()
+	truncated := tracker.GetTruncated()
+	assert.True(t, truncated >= 1)
+	assert.Equal(t, truncated, tracker.GetCheckpointed(txnbase.GroupC))
+
+	// The records after a data record are kept
+	txn, _ := db.StartTxn()
+	bat := mock.MockBatch(schemas[0].Types(), 10)
+	assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schemas[0].Name, Data: bat}, txn))
+	assert.Nil(t, db.CommitTxn(txn))
+	createTable(schemas[2])
+	checkpoint()
+	assert.Equal(t, truncated, tracker.GetTruncated())
+	assert.Nil(t, db.Close())
+
+	db, err = Open(dir, nil)
+	assert.Nil(t, err)
+	defer db.Close()
+	assert.Equal(t, truncated, db.(*tae).LogDriver.GetTruncated())
+	txn, _ = db.StartTxn()
+	for _, schema := range schemas {
+		assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: mock.MockBatch(schema.Types(), 1)}, txn))
+	}
+	res, err := db.GetByFilter(&FilterDesc{DB: "db", Table: schemas[0].Name, Op: FilterEq, ColOperand: bat.Vecs[0]}, txn)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, gvec.Length(res.Vecs[0]))
+	assert.Nil(t, db.RollbackTxn(txn))
+}
+
+func TestRelationReader(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, nil)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	schema := catalog.MockSchema(2)
+	schema.PrimaryKey = 1
+	schema.BlockMaxRows = 100
+	schema.SegmentMaxBlocks = 2
+	impl := db.(*tae)
+	readRows := func(ctx TxnCtx) int {
+		txn, err := impl.GetTxn(ctx)
+		assert.Nil(t, err)
+		rel, err := impl.getRelation(txn, "db", schema.Name)
+		assert.Nil(t, err)
+		reader := rel.MakeReader()
+		_, err = reader.Next(nil, []string{"xx"})
+		assert.Equal(t, catalog.ErrNotFound, err)
+		rows := 0
+		for {
+			bat, err := reader.Next(nil, []string{schema.ColDefs[1].Name})
+			assert.Nil(t, err)
+			if bat == nil {
+				break
+			}
+			rows += gvec.Length(bat.Vecs[0])
+		}
+		return rows
+	}
+	{
+		txn, _ := db.StartTxn()
+		_, err := db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+		assert.Nil(t, err)
+		bat := mock.MockBatch(schema.Types(), 250)
+		assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: bat}, txn))
+		assert.Equal(t, 250, readRows(txn))
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+	txn1, _ := db.StartTxn()
+	{
+		txn, _ := db.StartTxn()
+		bat := mock.MockBatch(schema.Types(), 120)
+		assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: bat}, txn))
+		assert.Equal(t, 370, readRows(txn))
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+	// Rows committed after txn1 started are not visible to txn1
+	assert.Equal(t, 250, readRows(txn1))
+	bat := mock.MockBatch(schema.Types(), 10)
+	assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: bat}, txn1))
+	assert.Equal(t, 260, readRows(txn1))
+	assert.Nil(t, db.RollbackTxn(txn1))
+
+	txn2, _ := db.StartTxn()
+	assert.Equal(t, 370, readRows(txn2))
+	assert.Nil(t, db.CommitTxn(txn2))
+}
+
+func TestBlockUpdates(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, nil)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	schema := catalog.MockSchema(2)
+	schema.PrimaryKey = 1
+	schema.BlockMaxRows = 100
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, db, schema)
+	{
+		txn, _ := db.StartTxn()
+		_, err := db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+		assert.Nil(t, err)
+		bat := mock.MockBatch(schema.Types(), 250)
+		assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: bat}, txn))
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+	txn1, _ := db.StartTxn()
+	txn2, _ := db.StartTxn()
+	blk1 := tbl.firstBlock(txn1)
+	assert.Nil(t, blk1.RangeDelete(0, 9))
+	assert.Nil(t, blk1.Update(20, 0, int32(9999)))
+	assert.Equal(t, txnif.TxnWWConflictErr, blk1.RangeDelete(5, 15))
+	vals := tbl.readCol(txn1, 0)
+	assert.Equal(t, 240, len(vals))
+	assert.Equal(t, int32(9999), vals[10])
+
+	blk2 := tbl.firstBlock(txn2)
+	assert.Equal(t, txnif.TxnWWConflictErr, blk2.RangeDelete(5, 15))
+	assert.Equal(t, txnif.TxnWWConflictErr, blk2.Update(20, 0, int32(8888)))
+	assert.Equal(t, txnif.TxnWWConflictErr, blk2.RangeDelete(15, 25))
+	assert.Nil(t, blk2.Update(20, 1, int32(8888)))
+	assert.Nil(t, blk2.RangeDelete(50, 59))
+	assert.Nil(t, db.CommitTxn(txn1))
+
+	// The deletes and updates of txn1 are not visible to txn2
+	vals = tbl.readCol(txn2, 0)
+	assert.Equal(t, 240, len(vals))
+	assert.Equal(t, int32(0), vals[0])
+	assert.Nil(t, db.CommitTxn(txn2))
+
+	txn3, _ := db.StartTxn()
+	assert.Nil(t, tbl.firstBlock(txn3).RangeDelete(60, 69))
+	assert.Nil(t, db.RollbackTxn(txn3))
+
+	txn4, _ := db.StartTxn()
+	vals = tbl.readCol(txn4, 0)
+	assert.Equal(t, 230, len(vals))
+	assert.Equal(t, int32(9999), vals[10])
+	assert.Equal(t, txnif.TxnWWConflictErr, tbl.firstBlock(txn4).RangeDelete(0, 0))
+	assert.Nil(t, tbl.firstBlock(txn4).RangeDelete(60, 69))
+	assert.Nil(t, db.CommitTxn(txn4))
+}
+
+func TestGetByFilter(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, nil)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	schema := catalog.MockSchema(2)
+	schema.PrimaryKey = 0
+	schema.BlockMaxRows = 100
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, db, schema)
+	getByFilter := func(ctx TxnCtx, attr string, op FilterOp, vals ...int32) []int32 {
+		desc := &FilterDesc{DB: "db", Table: schema.Name, Attr: attr, Op: op, ColOperand: tbl.operand(vals...)}
+		bat, err := db.GetByFilter(desc, ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, len(schema.ColDefs), len(bat.Vecs))
+		return bat.Vecs[0].Col.([]int32)
+	}
+	{
+		txn, _ := db.StartTxn()
+		_, err := db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+		assert.Nil(t, err)
+		bat := mock.MockBatch(schema.Types(), 250)
+		assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: bat}, txn))
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+	txn1, _ := db.StartTxn()
+	assert.ElementsMatch(t, []int32{5, 150}, getByFilter(txn1, "", FilterEq, 5, 150, 1000))
+	assert.Equal(t, 10, len(getByFilter(txn1, schema.ColDefs[1].Name, FilterBtw, 95, 104)))
+	assert.Equal(t, 0, len(getByFilter(txn1, "", FilterBtw, 300, 400)))
+
+	segIt := tbl.getRelation(txn1).MakeSegmentIt()
+	offsets := make([]uint32, 0)
+	for segIt.Valid() {
+		bats, err := segIt.GetSegment().GetByFilter(handle.Filter{Op: FilterEq, Col: tbl.operand(150)}, true)
+		assert.Nil(t, err)
+		for _, bat := range bats {
+			assert.Equal(t, handle.OffsetAttr, bat.Attrs[0])
+			offsets = append(offsets, bat.Vecs[0].Col.([]uint32)...)
+		}
+		segIt.Next()
+	}
+	assert.Equal(t, []uint32{50}, offsets)
+
+	blk := tbl.getRelation(txn1).MakeSegmentIt().GetSegment().MakeBlockIt().GetBlock()
+	_, err = blk.GetByFilter(handle.Filter{Op: FilterBtw, Col: tbl.operand(1)}, false)
+	assert.Equal(t, data.ErrInvalidFilter, err)
+	_, err = blk.GetByFilter(handle.Filter{Op: FilterEq, Attr: "xx", Col: tbl.operand(1)}, false)
+	assert.Equal(t, catalog.ErrNotFound, err)
+
+	{
+		txn, _ := db.StartTxn()
+		blk := tbl.getRelation(txn).MakeSegmentIt().GetSegment().MakeBlockIt().GetBlock()
+		assert.Nil(t, blk.RangeDelete(5, 5))
+		assert.Nil(t, blk.Update(20, 1, int32(9999)))
+		assert.Equal(t, []int32{150}, getByFilter(txn, "", FilterEq, 5, 150))
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+	// The changes committed after txn1 started are not visible to txn1
+	assert.ElementsMatch(t, []int32{5, 150}, getByFilter(txn1, "", FilterEq, 5, 150))
+	assert.Equal(t, 0, len(getByFilter(txn1, schema.ColDefs[1].Name, FilterEq, 9999)))
+	assert.Nil(t, db.CommitTxn(txn1))
+
+	txn2, _ := db.StartTxn()
+	assert.Equal(t, []int32{150}, getByFilter(txn2, "", FilterEq, 5, 150))
+	// The updated value is out of the zone map of the block
+	assert.Equal(t, []int32{20}, getByFilter(txn2, schema.ColDefs[1].Name, FilterEq, 9999))
+	assert.Nil(t, db.CommitTxn(txn2))
+}
+
+func TestFilterDML(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, nil)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	schema := catalog.MockSchema(2)
+	schema.PrimaryKey = 0
+	schema.BlockMaxRows = 40
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, db, schema)
+	filter := func(op FilterOp, vals ...int32) *FilterDesc {
+		return &FilterDesc{DB: "db", Table: schema.Name, Op: op, ColOperand: tbl.operand(vals...)}
+	}
+	{
+		txn, _ := db.StartTxn()
+		_, err := db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+		assert.Nil(t, err)
+		assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: mock.MockBatch(schema.Types(), 100)}, txn))
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+
+	txn, _ := db.StartTxn()
+	// The local rows are deleted and updated with the committed ones
+	local := gbat.New(true, schema.Attrs())
+	for i := range local.Vecs {
+		local.Vecs[i] = tbl.operand(100, 101, 102)
+	}
+	assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: local}, txn))
+	assert.Nil(t, db.DeleteByFilter(filter(FilterBtw, 0, 49), txn))
+	// The rows are deleted by a batch of the primary key only
+	rows := gbat.New(true, []string{schema.ColDefs[0].Name})
+	rows.Vecs[0] = tbl.operand(50, 51, 101)
+	assert.Nil(t, db.DeleteRows(&DeleteRowsDesc{DB: "db", Table: schema.Name, Rows: rows}, txn))
+	rows = gbat.New(true, []string{schema.ColDefs[1].Name})
+	rows.Vecs[0] = tbl.operand(52)
+	assert.Equal(t, ErrInvalidDesc, db.DeleteRows(&DeleteRowsDesc{DB: "db", Table: schema.Name, Rows: rows}, txn))
+	rows = gbat.New(true, []string{schema.ColDefs[1].Name, schema.ColDefs[0].Name})
+	rows.Vecs[0], rows.Vecs[1] = tbl.operand(52), tbl.operand(52, 53)
+	assert.Equal(t, ErrInvalidDesc, db.DeleteRows(&DeleteRowsDesc{DB: "db", Table: schema.Name, Rows: rows}, txn))
+	update := &UpdateDesc{Filter: filter(FilterEq, 60, 102), Attr: schema.ColDefs[1].Name, Value: int32(7777)}
+	assert.Nil(t, db.UpdateByFilter(update, txn))
+	assert.Equal(t, ErrInvalidDesc, db.UpdateByFilter(&UpdateDesc{Filter: filter(FilterEq, 60), Attr: "xx", Value: int32(0)}, txn))
+	assert.Equal(t, ErrInvalidDesc, db.UpdateByFilter(&UpdateDesc{Filter: filter(FilterEq, 60), Attr: schema.ColDefs[1].Name, Value: 0}, txn))
+	check := func(ctx TxnCtx) {
+		keys := tbl.readCol(ctx, 0)
+		vals := tbl.readCol(ctx, 1)
+		assert.Equal(t, 50, len(keys))
+		assert.NotContains(t, keys, int32(0))
+		assert.NotContains(t, keys, int32(51))
+		assert.NotContains(t, keys, int32(101))
+		for i, key := range keys {
+			if key == 60 || key == 102 {
+				assert.Equal(t, int32(7777), vals[i])
+			} else {
+				assert.Equal(t, key, vals[i])
+			}
+		}
+	}
+	check(txn)
+	assert.Nil(t, db.CommitTxn(txn))
+
+	txn, _ = db.StartTxn()
+	check(txn)
+	assert.Nil(t, db.CommitTxn(txn))
+}
+
+func TestZoneMapIndex(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, nil)
+	assert.Nil(t, err)
+
+	schema := catalog.MockSchema(2)
+	schema.PrimaryKey = 0
+	schema.BlockMaxRows = 100
+	schema.SegmentMaxBlocks = 2
+	assert.Nil(t, schema.AppendIndex("zm", catalog.ZoneMap, 0))
+	tbl := newTestTable(t, db, schema)
+	scan := func(db TAE, filter handle.Filter) []int32 {
+		txn, _ := db.StartTxn()
+		impl := db.(*tae)
+		transaction, err := impl.GetTxn(txn)
+		assert.Nil(t, err)
+		rel, err := impl.getRelation(transaction, "db", schema.Name)
+		assert.Nil(t, err)
+		reader := rel.MakeReader()
+		vals := make([]int32, 0)
+		for {
+			bat, err := reader.Next(filter, []string{schema.ColDefs[0].Name})
+			assert.Nil(t, err)
+			if bat == nil {
+				break
+			}
+			vals = append(vals, bat.Vecs[0].Col.([]int32)...)
+		}
+		assert.Nil(t, db.CommitTxn(txn))
+		return vals
+	}
+	{
+		txn, _ := db.StartTxn()
+		_, err := db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+		assert.Nil(t, err)
+		bat := mock.MockBatch(schema.Types(), 250)
+		assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: bat}, txn))
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+	// The two full blocks are frozen with zone maps and the first one is
+	// skipped. The last block is still appendable and has no zone map
+	filter := handle.Filter{Op: FilterBtw, Col: tbl.operand(150, 160)}
+	assert.Equal(t, 150, len(scan(db, filter)))
+	// The filter on a column without a zone map index skips nothing
+	assert.Equal(t, 250, len(scan(db, handle.Filter{Op: FilterEq, Attr: schema.ColDefs[1].Name, Col: tbl.operand(150)})))
+
+	// Flush all the blocks. The zone maps of the last block are built
+	impl := db.(*tae)
+	assert.False(t, impl.MutBufMgr.MakeRoom(impl.Opts.MutBufSize+1))
+	assert.Equal(t, 100, len(scan(db, filter)))
+	assert.Equal(t, 0, len(scan(db, handle.Filter{Op: FilterEq, Col: tbl.operand(1000)})))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(dir, nil)
+	assert.Nil(t, err)
+	defer db.Close()
+	assert.Equal(t, 100, len(scan(db, filter)))
+	{
+		// The zone map of the updated column does not prune the block
+		txn, _ := db.StartTxn()
+		transaction, _ := db.(*tae).GetTxn(txn)
+		rel, err := db.(*tae).getRelation(transaction, "db", schema.Name)
+		assert.Nil(t, err)
+		blk := rel.MakeSegmentIt().GetSegment().MakeBlockIt().GetBlock()
+		assert.Nil(t, blk.Update(10, 0, int32(155)))
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+	assert.Equal(t, 200, len(scan(db, filter)))
+	txn, _ := db.StartTxn()
+	res, err := db.GetByFilter(&FilterDesc{DB: "db", Table: schema.Name, Op: FilterBtw, ColOperand: tbl.operand(150, 160)}, txn)
+	assert.Nil(t, err)
+	assert.Equal(t, 12, gvec.Length(res.Vecs[0]))
+	assert.Nil(t, db.CommitTxn(txn))
+}
+
+func TestDedupAcrossTxns(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, nil)
+	assert.Nil(t, err)
+
+	schema := catalog.MockSchema(2)
+	schema.PrimaryKey = 0
+	schema.BlockMaxRows = 100
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, db, schema)
+	dedup := func(db TAE, start, end int32) error {
+		txn, _ := db.StartTxn()
+		defer db.RollbackTxn(txn)
+		return db.BatchDedup(&BatchDedupDesc{DB: "db", Table: schema.Name, Col: tbl.makeBatch(start, end).Vecs[0]}, txn)
+	}
+	{
+		txn, _ := db.StartTxn()
+		_, err := db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+		assert.Nil(t, err)
+		assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: tbl.makeBatch(0, 150), Dedup: true}, txn))
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+	// The first block is frozen and checked by its bloom filter. The second
+	// one is checked by its primary key index
+	assert.Equal(t, txnbase.ErrDuplicated, dedup(db, 10, 11))
+	assert.Equal(t, txnbase.ErrDuplicated, dedup(db, 149, 151))
+	assert.Nil(t, dedup(db, 150, 200))
+	{
+		// Both txns pass the dedup but only the first one can commit
+		txn1, _ := db.StartTxn()
+		txn2, _ := db.StartTxn()
+		assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: tbl.makeBatch(150, 160), Dedup: true}, txn1))
+		assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: tbl.makeBatch(155, 165), Dedup: true}, txn2))
+		assert.Nil(t, db.CommitTxn(txn1))
+		assert.Equal(t, txnbase.ErrDuplicated, db.CommitTxn(txn2))
+
+		// Nothing of the failed txn is appended
+		txn, _ := db.StartTxn()
+		operand := gvec.New(schema.ColDefs[0].Type)
+		assert.Nil(t, gvec.Append(operand, []int32{155, 164}))
+		res, err := db.GetByFilter(&FilterDesc{DB: "db", Table: schema.Name, Op: FilterBtw, ColOperand: operand}, txn)
+		assert.Nil(t, err)
+		assert.Equal(t, 5, gvec.Length(res.Vecs[0]))
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+	assert.Equal(t, txnbase.ErrDuplicated, dedup(db, 159, 160))
+	assert.Nil(t, dedup(db, 160, 165))
+
+	// Flush all the blocks and check again after a restart
+	impl := db.(*tae)
+	assert.False(t, impl.MutBufMgr.MakeRoom(impl.Opts.MutBufSize+1))
+	assert.Equal(t, txnbase.ErrDuplicated, dedup(db, 99, 100))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(dir, nil)
+	assert.Nil(t, err)
+	defer db.Close()
+	assert.Equal(t, txnbase.ErrDuplicated, dedup(db, 0, 1))
+	assert.Equal(t, txnbase.ErrDuplicated, dedup(db, 155, 156))
+	assert.Nil(t, dedup(db, 160, 300))
+	{
+		txn, _ := db.StartTxn()
+		assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: tbl.makeBatch(160, 170), Dedup: true}, txn))
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+	assert.Equal(t, txnbase.ErrDuplicated, dedup(db, 165, 166))
+}
+
+func TestTxnDeadline(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, &Options{ReapInterval: time.Millisecond * 5})
+	assert.Nil(t, err)
+	defer db.Close()
+
+	schema := catalog.MockSchema(2)
+	schema.BlockMaxRows = 1000
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, db, schema)
+	ctx, cancel := context.WithCancel(context.Background())
+	txn, _ := db.StartTxn(txnbase.WithContext(ctx))
+	_, err = db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+	assert.Nil(t, err)
+	cancel()
+	for i := 0; i < 400 && !txn.(txnif.AsyncTxn).IsAborted(); i++ {
+		time.Sleep(time.Millisecond * 5)
+	}
+	// The aborted txn is no longer active
+	assert.Equal(t, ErrTxnAborted, tbl.appendRows(txn, 0, 10))
+	assert.Equal(t, ErrTxnAborted, db.CommitTxn(txn))
+	assert.Equal(t, ErrTxnAborted, db.RollbackTxn(txn))
+	assert.Equal(t, 0, len(db.ActiveTxns()))
+}
+
+func TestTxnPrepareReplay(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, nil)
+	assert.Nil(t, err)
+
+	schema := catalog.MockSchema(2)
+	schema.PrimaryKey = 0
+	schema.BlockMaxRows = 1000
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, db, schema)
+	getBlock := func(ctx TxnCtx, id uint64) handle.Block {
+		segIt := tbl.getRelation(ctx).MakeSegmentIt()
+		for segIt.Valid() {
+			blkIt := segIt.GetSegment().MakeBlockIt()
+			for blkIt.Valid() {
+				if blk := blkIt.GetBlock(); blk.Fingerprint().BlockID == id {
+					return blk
+				}
+				blkIt.Next()
+			}
+			segIt.Next()
+		}
+		return nil
+	}
+	countRows := func() int {
+		txn, _ := db.StartTxn()
+		defer db.CommitTxn(txn)
+		return tbl.countRows(txn)
+	}
+	{
+		txn, _ := db.StartTxn()
+		_, err = db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+		assert.Nil(t, err)
+		assert.Nil(t, tbl.appendRows(txn, 0, 30))
+		assert.Nil(t, db.CommitTxn(txn))
+	}
+
+	// A prepared txn is recovered on restart and waits for its coordinator
+	txn, _ := db.StartTxn()
+	assert.Nil(t, tbl.appendRows(txn, 40, 50))
+	blk := tbl.getRelation(txn).MakeSegmentIt().GetSegment().MakeBlockIt().GetBlock()
+	assert.Nil(t, blk.RangeDelete(0, 0))
+	ts, err := db.PrepareTxn(txn)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	db, err = Open(dir, nil)
+	assert.Nil(t, err)
+	tbl = newTestTable(t, db, schema)
+	defer db.Close()
+	prepared := db.PreparedTxns()
+	assert.Equal(t, 1, len(prepared))
+	assert.Equal(t, txn.GetID(), prepared[0].GetID())
+	assert.Equal(t, ts, prepared[0].(txnif.AsyncTxn).GetPrepareTS())
+	assert.Equal(t, 30, countRows())
+	{
+		txn2, _ := db.StartTxn()
+		assert.Equal(t, txnbase.ErrDuplicated, tbl.appendRows(txn2, 45, 46))
+		// The deletes of the prepared txn are redone
+		assert.Equal(t, txnif.TxnWWConflictErr, getBlock(txn2, blk.Fingerprint().BlockID).RangeDelete(0, 0))
+		assert.Nil(t, db.RollbackTxn(txn2))
+	}
+	assert.Nil(t, db.CommitPreparedTxn(prepared[0], ts))
+	assert.Equal(t, 39, countRows())
+	txn2, _ := db.StartTxn()
+	assert.True(t, txn2.(txnif.AsyncTxn).GetStartTS() > ts)
+	assert.Nil(t, db.CommitTxn(txn2))
+}
+
+func TestStartTxnAt(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, nil)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	schema := catalog.MockSchema(2)
+	schema.BlockMaxRows = 1000
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, db, schema)
+	txn, _ := db.StartTxn()
+	_, err = db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+	assert.Nil(t, err)
+	assert.Nil(t, tbl.appendRows(txn, 0, 10))
+	assert.Nil(t, db.CommitTxn(txn))
+	firstCommit := txn.(txnif.AsyncTxn).GetCommitTS()
+	txn, _ = db.StartTxn()
+	assert.Nil(t, db.DeleteByFilter(&FilterDesc{DB: "db", Table: schema.Name, Op: FilterBtw, ColOperand: tbl.operand(0, 4)}, txn))
+	assert.Nil(t, db.CommitTxn(txn))
+
+	// The updates are merged up to the snapshot of the active txns
+	txn, err = db.StartTxnAt(firstCommit)
+	assert.Nil(t, err)
+	assert.Nil(t, db.MergeUpdates())
+	assert.Equal(t, 10, tbl.countRows(txn))
+	assert.Equal(t, ErrTxnReadOnly, tbl.appendRows(txn, 20, 30))
+	assert.Nil(t, db.CommitTxn(txn))
+	assert.Nil(t, db.MergeUpdates())
+	txn, _ = db.StartTxn()
+	assert.Equal(t, 5, tbl.countRows(txn))
+	assert.Nil(t, db.CommitTxn(txn))
+}
+
+func TestRollbackAppends(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, nil)
+	assert.Nil(t, err)
+
+	schema := catalog.MockSchema(2)
+	schema.PrimaryKey = 0
+	schema.BlockMaxRows = 1000
+	schema.SegmentMaxBlocks = 2
+	schema2 := catalog.MockSchema(2)
+	schema2.BlockMaxRows = 1000
+	schema2.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, db, schema)
+	countRows := func() int {
+		txn, _ := db.StartTxn()
+		defer db.CommitTxn(txn)
+		return tbl.countRows(txn)
+	}
+	getRows := func(start, end int32) int {
+		txn, _ := db.StartTxn()
+		defer db.CommitTxn(txn)
+		operand := gvec.New(schema.ColDefs[0].Type)
+		assert.Nil(t, gvec.Append(operand, []int32{start, end - 1}))
+		res, err := db.GetByFilter(&FilterDesc{DB: "db", Table: schema.Name, Op: FilterBtw, ColOperand: operand}, txn)
+		assert.Nil(t, err)
+		return gvec.Length(res.Vecs[0])
+	}
+	dedup := func(start, end int32) error {
+		txn, _ := db.StartTxn()
+		defer db.RollbackTxn(txn)
+		return db.BatchDedup(&BatchDedupDesc{DB: "db", Table: schema.Name, Col: tbl.makeBatch(start, end).Vecs[0]}, txn)
+	}
+
+	txn, _ := db.StartTxn()
+	_, err = db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+	assert.Nil(t, err)
+	_, err = db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema2}, txn)
+	assert.Nil(t, err)
+	assert.Nil(t, tbl.appendRows(txn, 0, 10))
+	assert.Nil(t, db.AppendRows(&AppendDesc{DB: "db", Table: schema2.Name, Data: tbl.makeBatch(0, 10)}, txn))
+	assert.Nil(t, db.CommitTxn(txn))
+
+	// txn1 reads the dropped table, so it fails after its rows are appended
+	txn1, _ := db.StartTxn(txnbase.WithIsolation(txnif.Serializable))
+	assert.Nil(t, tbl.appendRows(txn1, 10, 20))
+	_, err = db.GetByFilter(&FilterDesc{DB: "db", Table: schema2.Name, Op: FilterEq, ColOperand: tbl.makeBatch(0, 1).Vecs[0]}, txn1)
+	assert.Nil(t, err)
+	txn2, _ := db.StartTxn()
+	_, err = db.DropTable(&DropTableDesc{DB: "db", Name: schema2.Name}, txn2)
+	assert.Nil(t, err)
+	assert.Nil(t, db.CommitTxn(txn2))
+	assert.Equal(t, txnif.TxnRollbacked, db.CommitTxn(txn1))
+
+	// The rows after the dead ones are visible
+	txn, _ = db.StartTxn()
+	assert.Nil(t, tbl.appendRows(txn, 20, 30))
+	assert.Nil(t, db.CommitTxn(txn))
+	assert.Equal(t, 20, countRows())
+	assert.Equal(t, 0, getRows(10, 20))
+	assert.Nil(t, dedup(10, 20))
+	assert.Equal(t, txnbase.ErrDuplicated, dedup(25, 26))
+
+	assert.Nil(t, db.Close())
+	db, err = Open(dir, nil)
+	assert.Nil(t, err)
+	tbl = newTestTable(t, db, schema)
+	assert.Equal(t, 20, countRows())
+	assert.Equal(t, 0, getRows(10, 20))
+	assert.Equal(t, 10, getRows(20, 30))
+	txn, _ = db.StartTxn()
+	assert.Nil(t, tbl.appendRows(txn, 10, 15))
+	assert.Nil(t, db.CommitTxn(txn))
+	assert.Equal(t, 25, countRows())
+
+	// The dead rows are persisted with the flushed blocks
+	impl := db.(*tae)
+	assert.False(t, impl.MutBufMgr.MakeRoom(impl.Opts.MutBufSize+1))
+	assert.Nil(t, db.Close())
+	db, err = Open(dir, nil)
+	assert.Nil(t, err)
+	tbl = newTestTable(t, db, schema)
+	defer db.Close()
+	assert.Equal(t, 25, countRows())
+	assert.Equal(t, 5, getRows(10, 20))
+	assert.Nil(t, dedup(15, 20))
+	assert.Equal(t, txnbase.ErrDuplicated, dedup(12, 13))
+}
+
+func TestAlterTable(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, nil)
+	assert.Nil(t, err)
+
+	schema := catalog.MockSchema(3)
+	schema.PrimaryKey = 0
+	schema.BlockMaxRows = 10
+	schema.SegmentMaxBlocks = 2
+	typ := schema.ColDefs[0].Type
+	appendRows := func(txn TxnCtx, attrs []string, start, end int32) error {
+		bat := gbat.New(true, attrs)
+		for i := range attrs {
+			vals := make([]int32, 0)
+			for v := start; v < end; v++ {
+				vals = append(vals, v)
+			}
+			bat.Vecs[i] = gvec.New(typ)
+			assert.Nil(t, gvec.Append(bat.Vecs[i], vals))
+		}
+		return db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: bat, Dedup: true}, txn)
+	}
+	getRows := func(txn TxnCtx, start, end int32) *gbat.Batch {
+		operand := gvec.New(typ)
+		assert.Nil(t, gvec.Append(operand, []int32{start, end - 1}))
+		res, err := db.GetByFilter(&FilterDesc{DB: "db", Table: schema.Name, Op: FilterBtw, ColOperand: operand}, txn)
+		assert.Nil(t, err)
+		return res
+	}
+	alter := func(txn TxnCtx, req *catalog.AlterTableReq) error {
+		return db.AlterTable(&AlterTableDesc{DB: "db", Table: schema.Name, Req: req}, txn)
+	}
+	oldAttrs := schema.Attrs()
+	newAttrs := []string{oldAttrs[0], "renamed", "added"}
+	checkRows := func() {
+		txn, _ := db.StartTxn()
+		defer db.CommitTxn(txn)
+		bat := getRows(txn, 0, 30)
+		assert.Equal(t, newAttrs, bat.Attrs)
+		assert.Equal(t, 25, gvec.Length(bat.Vecs[0]))
+		assert.Equal(t, 15, gnulls.Length(bat.Vecs[2].Nsp))
+	}
+
+	txn, _ := db.StartTxn()
+	_, err = db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+	assert.Nil(t, err)
+	assert.Nil(t, appendRows(txn, oldAttrs, 0, 15))
+	assert.Nil(t, db.CommitTxn(txn))
+
+	// txn1 appends under the schema altered by txn2 and fails
+	txn1, _ := db.StartTxn()
+	assert.Nil(t, appendRows(txn1, oldAttrs, 100, 101))
+	txn2, _ := db.StartTxn()
+	assert.Nil(t, alter(txn2, catalog.NewAddColumnReq("added", typ, catalog.WithNullable())))
+	assert.Nil(t, alter(txn2, catalog.NewDropColumnReq(oldAttrs[2])))
+	assert.Nil(t, alter(txn2, catalog.NewRenameColumnReq(oldAttrs[1], "renamed")))
+	assert.Equal(t, catalog.ErrDropPrimaryKey, alter(txn2, catalog.NewDropColumnReq(oldAttrs[0])))
+	assert.Equal(t, oldAttrs, getRows(txn1, 0, 30).Attrs)
+	assert.Equal(t, newAttrs, getRows(txn2, 0, 30).Attrs)
+	txn3, _ := db.StartTxn()
+	assert.Equal(t, txnif.TxnWWConflictErr, alter(txn3, catalog.NewAddColumnReq("other", typ)))
+	assert.Nil(t, db.RollbackTxn(txn3))
+	assert.Nil(t, db.CommitTxn(txn2))
+	assert.Equal(t, txnif.TxnWWConflictErr, db.CommitTxn(txn1))
+
+	txn, _ = db.StartTxn()
+	assert.Nil(t, appendRows(txn, newAttrs, 15, 25))
+	assert.Nil(t, db.CommitTxn(txn))
+	checkRows()
+
+	// A rolled back alter leaves the schema unchanged
+	txn, _ = db.StartTxn()
+	assert.Nil(t, alter(txn, catalog.NewDropColumnReq("added")))
+	assert.Nil(t, db.RollbackTxn(txn))
+	checkRows()
+
+	assert.Nil(t, db.Close())
+	db, err = Open(dir, nil)
+	assert.Nil(t, err)
+	checkRows()
+
+	c := db.(*tae).Catalog
+	assert.Nil(t, c.Checkpoint(db.(*tae).TxnMgr.Clock.Last()))
+	assert.Nil(t, db.Close())
+	db, err = Open(dir, nil)
+	assert.Nil(t, err)
+	defer db.Close()
+	checkRows()
+	txn, _ = db.StartTxn()
+	assert.Nil(t, appendRows(txn, newAttrs, 25, 30))
+	assert.Nil(t, db.CommitTxn(txn))
+}
+
+func TestColumnDefaults(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, nil)
+	assert.Nil(t, err)
+
+	schema := catalog.MockSchema(1)
+	schema.PrimaryKey = 0
+	schema.BlockMaxRows = 10
+	schema.SegmentMaxBlocks = 2
+	typ := schema.ColDefs[0].Type
+	schema.AppendCol("nullable", typ, catalog.WithNullable())
+	schema.AppendCol("constant", typ, catalog.WithDefault(int32(7)))
+	schema.AppendCol("required", typ)
+	makeBatch := func(attrs []string, start, end int32) *gbat.Batch {
+		bat := gbat.New(true, attrs)
+		for i := range attrs {
+			vals := make([]int32, 0)
+			for v := start; v < end; v++ {
+				vals = append(vals, v)
+			}
+			bat.Vecs[i] = gvec.New(typ)
+			assert.Nil(t, gvec.Append(bat.Vecs[i], vals))
+		}
+		return bat
+	}
+	appendRows := func(bat *gbat.Batch) error {
+		txn, _ := db.StartTxn()
+		if err := db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: bat, Dedup: true}, txn); err != nil {
+			assert.Nil(t, db.RollbackTxn(txn))
+			return err
+		}
+		return db.CommitTxn(txn)
+	}
+	checkRows := func() {
+		txn, _ := db.StartTxn()
+		defer db.CommitTxn(txn)
+		operand := gvec.New(typ)
+		assert.Nil(t, gvec.Append(operand, []int32{0, 24}))
+		bat, err := db.GetByFilter(&FilterDesc{DB: "db", Table: schema.Name, Op: FilterBtw, ColOperand: operand}, txn)
+		assert.Nil(t, err)
+		assert.Equal(t, 25, gvec.Length(bat.Vecs[0]))
+		assert.Equal(t, 22, gnulls.Length(bat.Vecs[1].Nsp))
+		for row := 0; row < 25; row++ {
+			pk := bat.Vecs[0].Col.([]int32)[row]
+			isNull := pk < 20 || pk == 21 || pk == 23
+			assert.Equal(t, isNull, gnulls.Contains(bat.Vecs[1].Nsp, uint64(row)))
+			if pk < 20 {
+				assert.Equal(t, int32(7), bat.Vecs[2].Col.([]int32)[row])
+			} else {
+				assert.Equal(t, pk, bat.Vecs[2].Col.([]int32)[row])
+			}
+		}
+	}
+
+	txn, _ := db.StartTxn()
+	_, err = db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+	assert.Nil(t, err)
+	assert.Nil(t, db.CommitTxn(txn))
+
+	attrs := []string{schema.ColDefs[0].Name, "required"}
+	assert.Nil(t, appendRows(makeBatch(attrs, 5, 20)))
+	bat := makeBatch(schema.Attrs(), 20, 25)
+	gnulls.Add(bat.Vecs[1].Nsp, 1, 3)
+	assert.Nil(t, appendRows(bat))
+	bat = makeBatch([]string{"required", "nullable", schema.ColDefs[0].Name}, 0, 5)
+	gnulls.Add(bat.Vecs[1].Nsp, 0, 1, 2, 3, 4)
+	assert.Nil(t, appendRows(bat))
+
+	// The rejected appends name the column and row
+	bat = makeBatch(schema.Attrs(), 30, 35)
+	gnulls.Add(bat.Vecs[3].Nsp, 2)
+	err = appendRows(bat)
+	assert.True(t, errors.Is(err, ErrNotNull))
+	assert.Equal(t, &ColumnError{Err: ErrNotNull, Col: "required", Row: 2}, err)
+	err = appendRows(makeBatch(attrs[:1], 30, 35))
+	assert.Equal(t, &ColumnError{Err: ErrMissingColumn, Col: "required", Row: -1}, err)
+	err = appendRows(makeBatch(append(attrs, "xx"), 30, 35))
+	assert.True(t, errors.Is(err, ErrUnknownColumn))
+	checkRows()
+
+	assert.Nil(t, db.Close())
+	db, err = Open(dir, nil)
+	assert.Nil(t, err)
+	defer db.Close()
+	checkRows()
+}
+
+func TestAutoIncrement(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, nil)
+	assert.Nil(t, err)
+
+	typ := types.Type{Oid: types.T_int32, Size: 4, Width: 4}
+	schema := catalog.NewEmptySchema("seq")
+	schema.AppendCol("id", typ, catalog.WithAutoIncrement())
+	schema.AppendCol("val", typ)
+	schema.BlockMaxRows = 10
+	schema.SegmentMaxBlocks = 2
+	makeBatch := func(ids []int32, vals ...int32) *gbat.Batch {
+		attrs := []string{"val"}
+		if ids != nil {
+			attrs = append(attrs, "id")
+		}
+		bat := gbat.New(true, attrs)
+		bat.Vecs[0] = gvec.New(typ)
+		assert.Nil(t, gvec.Append(bat.Vecs[0], vals))
+		if ids != nil {
+			bat.Vecs[1] = gvec.New(typ)
+			assert.Nil(t, gvec.Append(bat.Vecs[1], ids))
+		}
+		return bat
+	}
+	appendRows := func(txn TxnCtx, bat *gbat.Batch) error {
+		return db.AppendRows(&AppendDesc{DB: "db", Table: schema.Name, Data: bat, Dedup: true}, txn)
+	}
+	getIDs := func() map[int32]int32 {
+		txn, _ := db.StartTxn()
+		defer db.CommitTxn(txn)
+		operand := gvec.New(typ)
+		assert.Nil(t, gvec.Append(operand, []int32{0, math.MaxInt32}))
+		bat, err := db.GetByFilter(&FilterDesc{DB: "db", Table: schema.Name, Op: FilterBtw, ColOperand: operand}, txn)
+		assert.Nil(t, err)
+		ids := make(map[int32]int32)
+		for i, id := range bat.Vecs[0].Col.([]int32) {
+			ids[id] = bat.Vecs[1].Col.([]int32)[i]
+		}
+		return ids
+	}
+
+	txn, _ := db.StartTxn()
+	_, err = db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+	assert.Nil(t, err)
+	assert.Nil(t, db.CommitTxn(txn))
+
+	// A rejected append takes no value
+	txn, _ = db.StartTxn()
+	bat := makeBatch(nil, 1, 2)
+	gnulls.Add(bat.Vecs[0].Nsp, 1)
+	assert.Equal(t, &ColumnError{Err: ErrNotNull, Col: "val", Row: 1}, appendRows(txn, bat))
+	assert.Nil(t, db.RollbackTxn(txn))
+
+	// Concurrent txns take their values from different ranges
+	txn1, _ := db.StartTxn()
+	txn2, _ := db.StartTxn()
+	assert.Nil(t, appendRows(txn1, makeBatch(nil, 1, 2, 3)))
+	assert.Nil(t, appendRows(txn2, makeBatch(nil, 4, 5)))
+	assert.Nil(t, appendRows(txn1, makeBatch(nil, 6)))
+	assert.Nil(t, db.CommitTxn(txn2))
+	assert.Nil(t, db.CommitTxn(txn1))
+	rangeSize := int32(catalog.SeqRangeSize)
+	assert.Equal(t, map[int32]int32{1: 1, 2: 2, 3: 3, 4: 6, rangeSize + 1: 4, rangeSize + 2: 5}, getIDs())
+
+	// The NULLs take new values, while the explicit values are skipped later
+	txn, _ = db.StartTxn()
+	bat = makeBatch([]int32{0, 5000, 0}, 7, 8, 9)
+	gnulls.Add(bat.Vecs[1].Nsp, 0, 2)
+	assert.Nil(t, appendRows(txn, bat))
+	assert.Equal(t, txnbase.ErrDuplicated, appendRows(txn, makeBatch([]int32{5000}, 10)))
+	assert.Nil(t, db.CommitTxn(txn))
+	ids := getIDs()
+	assert.Equal(t, int32(7), ids[2*rangeSize+1])
+	assert.Equal(t, int32(8), ids[5000])
+	assert.Equal(t, int32(9), ids[2*rangeSize+2])
+
+	// The values taken are never taken again after restart
+	checkNext := func(val, min int32) int32 {
+		txn, _ := db.StartTxn()
+		assert.Nil(t, appendRows(txn, makeBatch(nil, val)))
+		assert.Nil(t, db.CommitTxn(txn))
+		for id, v := range getIDs() {
+			if v == val {
+				assert.True(t, id > min)
+				return id
+			}
+		}
+		assert.Fail(t, "not found")
+		return 0
+	}
+	assert.Nil(t, db.Close())
+	db, err = Open(dir, nil)
+	assert.Nil(t, err)
+	last := checkNext(100, 5000)
+	assert.Nil(t, db.(*tae).Catalog.Checkpoint(db.(*tae).TxnMgr.Clock.Last()))
+	assert.Nil(t, db.Close())
+	db, err = Open(dir, nil)
+	assert.Nil(t, err)
+	defer db.Close()
+	checkNext(101, last)
+
+	// The values out of the range of the column type are rejected
+	small := catalog.NewEmptySchema("small")
+	small.AppendCol("id", types.Type{Oid: types.T_int8, Size: 1, Width: 8}, catalog.WithAutoIncrement())
+	small.AppendCol("val", typ)
+	txn, _ = db.StartTxn()
+	_, err = db.CreateTable(&CreateTableDesc{DB: "db", Schema: small}, txn)
+	assert.Nil(t, err)
+	vals := make([]int32, 200)
+	bat = gbat.New(true, []string{"val"})
+	bat.Vecs[0] = gvec.New(typ)
+	assert.Nil(t, gvec.Append(bat.Vecs[0], vals))
+	err = db.AppendRows(&AppendDesc{DB: "db", Table: small.Name, Data: bat}, txn)
+	assert.Equal(t, &ColumnError{Err: ErrSeqOverflow, Col: "id", Row: 127}, err)
+	assert.Nil(t, db.RollbackTxn(txn))
+}
+
+func TestRenameReplay(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, nil)
+	assert.Nil(t, err)
+
+	schema := catalog.MockSchema(2)
+	schema.BlockMaxRows = 10
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, db, schema)
+	count := func(txn TxnCtx, dbName, table string) (int, error) {
+		res, err := db.GetByFilter(&FilterDesc{DB: dbName, Table: table, Op: FilterBtw, ColOperand: tbl.operand(0, 100)}, txn)
+		if err != nil {
+			return 0, err
+		}
+		return gvec.Length(res.Vecs[0]), nil
+	}
+	rename := func(txn TxnCtx, table, newName string) error {
+		return db.AlterTable(&AlterTableDesc{DB: "db", Table: table, Req: catalog.NewRenameTableReq(newName)}, txn)
+	}
+	renameDB := func(txn TxnCtx, name, newName string) error {
+		transaction, err := db.(*tae).GetTxn(txn)
+		assert.Nil(t, err)
+		_, err = transaction.RenameDatabase(name, newName)
+		return err
+	}
+
+	txn, _ := db.StartTxn()
+	_, err = db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+	assert.Nil(t, err)
+	assert.Nil(t, tbl.appendRows(txn, 0, 3))
+	assert.Nil(t, db.CommitTxn(txn))
+	txn, _ = db.StartTxn()
+	assert.Nil(t, rename(txn, schema.Name, "renamed"))
+	assert.Nil(t, db.CommitTxn(txn))
+	// A rolled back rename is not replayed
+	txn, _ = db.StartTxn()
+	assert.Nil(t, rename(txn, "renamed", "dropped"))
+	assert.Nil(t, db.RollbackTxn(txn))
+	txn, _ = db.StartTxn()
+	assert.Nil(t, renameDB(txn, "db", "db2"))
+	assert.Nil(t, db.CommitTxn(txn))
+
+	checkNames := func() {
+		txn, _ := db.StartTxn()
+		defer db.CommitTxn(txn)
+		_, err := count(txn, "db", "renamed")
+		assert.Equal(t, ErrDBNotFound, err)
+		_, err = count(txn, "db2", "dropped")
+		assert.Equal(t, ErrTableNotFound, err)
+		_, err = count(txn, "db2", schema.Name)
+		assert.Equal(t, ErrTableNotFound, err)
+		rows, err := count(txn, "db2", "renamed")
+		assert.Nil(t, err)
+		assert.Equal(t, 3, rows)
+	}
+	checkNames()
+
+	assert.Nil(t, db.Close())
+	db, err = Open(dir, nil)
+	assert.Nil(t, err)
+	checkNames()
+
+	c := db.(*tae).Catalog
+	assert.Nil(t, c.Checkpoint(db.(*tae).TxnMgr.Clock.Last()))
+	assert.Nil(t, db.Close())
+	db, err = Open(dir, nil)
+	assert.Nil(t, err)
+	defer db.Close()
+	checkNames()
+	txn, _ = db.StartTxn()
+	assert.Nil(t, renameDB(txn, "db2", "db"))
+	assert.Nil(t, rename(txn, "renamed", schema.Name))
+	assert.Nil(t, db.CommitTxn(txn))
+}
+
+func TestSystemTables(t *testing.T) {
+	dir := initTestPath(t)
+	db, err := Open(dir, nil)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	schemas := []*catalog.Schema{catalog.MockSchema(2), catalog.MockSchema(1)}
+	txn, _ := db.StartTxn()
+	for _, schema := range schemas {
+		_, err = db.CreateTable(&CreateTableDesc{DB: "db", Schema: schema}, txn)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.CommitTxn(txn))
+
+	// The system tables are filtered row by row
+	txn, _ = db.StartTxn()
+	meta, err := catalog.GetSystemTableEntry(catalog.SystemTableTables)
+	assert.Nil(t, err)
+	operand := gvec.New(meta.GetSchema().ColDefs[1].Type)
+	assert.Nil(t, gvec.Append(operand, [][]byte{[]byte(schemas[1].Name)}))
+	bat, err := db.GetByFilter(&FilterDesc{DB: catalog.SystemDBName, Table: catalog.SystemTableTables, Attr: "name", Op: FilterEq, ColOperand: operand}, txn)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, gvec.Length(bat.Vecs[0]))
+	assert.Equal(t, []byte(schemas[1].Name), bat.Vecs[1].Col.(*types.Bytes).Get(0))
+	_, err = db.GetByFilter(&FilterDesc{DB: catalog.SystemDBName, Table: "xx", Op: FilterEq, ColOperand: operand}, txn)
+	assert.Equal(t, ErrTableNotFound, err)
+	assert.Nil(t, db.CommitTxn(txn))
+}
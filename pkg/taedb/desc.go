@@ -1,15 +1,16 @@
 package taedb
 
 import (
+	"tae/pkg/catalog"
+	"tae/pkg/iface/handle"
+
+	"github.com/matrixorigin/matrixone/pkg/container/batch"
 	"github.com/matrixorigin/matrixone/pkg/container/vector"
-	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/container/batch"
-	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/metadata/v1"
 )
 
 type CreateTableDesc struct {
 	DB     string
-	Schema *metadata.Schema
-	Index  *metadata.IndexSchema
+	Schema *catalog.Schema
 }
 
 type DropTableDesc struct {
@@ -17,6 +18,14 @@ type DropTableDesc struct {
 	Name string
 }
 
+// AlterTableDesc alters the columns of a table by Req. The rows written
+// before are read with the defaults or NULLs for the added columns
+type AlterTableDesc struct {
+	DB    string
+	Table string
+	Req   *catalog.AlterTableReq
+}
+
 type AppendDesc struct {
 	DB    string
 	Table string
@@ -24,24 +33,37 @@ type AppendDesc struct {
 	Dedup bool
 }
 
+// BatchDedupDesc checks the primary keys in Col. The keys of a composite
+// primary key are the ones built by index.NewCompositeKeys
 type BatchDedupDesc struct {
 	DB    string
 	Table string
 	Col   *vector.Vector
 }
 
-type FilterOp int16
+type FilterOp = handle.FilterOp
 
 const (
-	FilterEq FilterOp = iota
-	FilterBtw
+	FilterEq  = handle.FilterEq
+	FilterBtw = handle.FilterBtw
 )
 
 type FilterDesc struct {
+	DB         string
+	Table      string
+	Attr       string
 	Op         FilterOp
 	ColOperand *vector.Vector
 }
 
+func (desc *FilterDesc) ToFilter() handle.Filter {
+	return handle.Filter{
+		Op:   desc.Op,
+		Attr: desc.Attr,
+		Col:  desc.ColOperand,
+	}
+}
+
 type UpdateDesc struct {
 	Filter *FilterDesc
 	Attr   string
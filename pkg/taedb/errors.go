@@ -1,7 +1,37 @@
 package taedb
 
-import "errors"
+import (
+	"errors"
+	"tae/pkg/txn/txnbase"
+	"tae/pkg/txn/txnimpl"
+)
 
 var (
-	ErrTxnNotFound = errors.New("tae: txn not found")
+	ErrTxnNotFound   = errors.New("tae: txn not found")
+	ErrDBNotFound    = errors.New("tae: database not found")
+	ErrTableNotFound = errors.New("tae: table not found")
+	ErrInvalidDesc   = errors.New("tae: invalid desc")
+	// ErrTxnAborted is returned by the operations on a txn aborted by the
+	// system, e.g. once its deadline is exceeded
+	ErrTxnAborted = txnbase.ErrTxnAborted
+	// ErrTxnPrepared is returned by the operations on a prepared txn other
+	// than CommitPreparedTxn and AbortPreparedTxn
+	ErrTxnPrepared    = txnbase.ErrTxnPrepared
+	ErrTxnNotPrepared = txnbase.ErrTxnNotPrepared
+	// ErrTxnReadOnly is returned by the writes of a read-only txn
+	ErrTxnReadOnly = txnbase.ErrTxnReadOnly
+	// ErrTSOutOfRetention is returned by StartTxnAt for a ts older than the
+	// retention window
+	ErrTSOutOfRetention = txnbase.ErrTSOutOfRetention
+	// ErrMissingColumn, ErrNotNull, ErrUnknownColumn and ErrSeqOverflow are
+	// wrapped in a ColumnError naming the column and row of a rejected append
+	ErrMissingColumn = txnimpl.ErrMissingColumn
+	ErrNotNull       = txnimpl.ErrNotNull
+	ErrUnknownColumn = txnimpl.ErrUnknownColumn
+	ErrSeqOverflow   = txnimpl.ErrSeqOverflow
+	// ErrCompositeKey is returned by the operations not supported on a table
+	// with a composite primary key
+	ErrCompositeKey = txnimpl.ErrCompositeKey
 )
+
+type ColumnError = txnimpl.ColumnError
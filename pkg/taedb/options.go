@@ -0,0 +1,55 @@
+package taedb
+
+import (
+	"tae/pkg/dataio"
+	"tae/pkg/txn/txnbase"
+	"time"
+
+	"github.com/jiangxinmeng1/logstore/pkg/store"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/common"
+)
+
+const (
+	DefaultTxnBufSize = common.G
+	DefaultMutBufSize = common.G
+
+	catalogStoreName = "catalog"
+	txnStoreName     = "store"
+)
+
+type Options struct {
+	// Buffer size for the transaction local insert nodes
+	TxnBufSize uint64
+	// Buffer size for the appendable blocks
+	MutBufSize uint64
+	// How often the txns are checked for an exceeded deadline
+	ReapInterval time.Duration
+	// How long the committed versions are kept for StartTxnAt
+	Retention time.Duration
+
+	FileFactory dataio.SegmentFileFactory
+	CatalogCfg  *store.StoreCfg
+	LogCfg      *store.StoreCfg
+}
+
+func (o *Options) FillDefaults() *Options {
+	if o == nil {
+		o = new(Options)
+	}
+	if o.TxnBufSize == 0 {
+		o.TxnBufSize = DefaultTxnBufSize
+	}
+	if o.MutBufSize == 0 {
+		o.MutBufSize = DefaultMutBufSize
+	}
+	if o.ReapInterval == 0 {
+		o.ReapInterval = txnbase.DefaultReapInterval
+	}
+	if o.Retention == 0 {
+		o.Retention = txnbase.DefaultRetention
+	}
+	if o.FileFactory == nil {
+		o.FileFactory = dataio.SegmentFileIOFactory
+	}
+	return o
+}
@@ -33,7 +33,7 @@ func initTestContext(t *testing.T, dir string, txnBufSize, mutBufSize uint64) (*
 	driver := txnbase.NewNodeDriver(dir, "store", nil)
 	txnBufMgr := buffer.NewNodeManager(txnBufSize, nil)
 	mutBufMgr := buffer.NewNodeManager(mutBufSize, nil)
-	factory := tables.NewDataFactory(dataio.SegmentFileMockFactory, mutBufMgr)
+	factory := tables.NewDataFactory(dataio.SegmentFileMockFactory, mutBufMgr, dir)
 	mgr := txnbase.NewTxnManager(txnimpl.TxnStoreFactory(c, driver, txnBufMgr, factory), txnimpl.TxnFactory(c))
 	mgr.Start()
 	return c, mgr, driver, txnBufMgr, mutBufMgr
@@ -53,7 +53,7 @@ func TestTables1(t *testing.T) {
 	rel, _ := db.CreateRelation(schema)
 	tableMeta := rel.GetMeta().(*catalog.TableEntry)
 
-	dataFactory := tables.NewDataFactory(dataio.SegmentFileMockFactory, txnBufMgr)
+	dataFactory := tables.NewDataFactory(dataio.SegmentFileMockFactory, txnBufMgr, dir)
 	tableFactory := dataFactory.MakeTableFactory()
 	table := tableFactory(tableMeta)
 	_, _, err := table.GetAppender()
@@ -0,0 +1,57 @@
+package txnbase
+
+import (
+	"sync"
+	"tae/pkg/iface/txnif"
+	"time"
+)
+
+// HLClock is a hybrid logical clock. The timestamps it returns are unique,
+// increasing and follow the wall clock as long as it does not go backwards
+type HLClock struct {
+	sync.Mutex
+	last txnif.TS
+	// physical returns the wall clock in milliseconds
+	physical func() int64
+}
+
+func NewHLClock() *HLClock {
+	return &HLClock{
+		physical: func() int64 { return time.Now().UnixMilli() },
+	}
+}
+
+// NewMockHLClock returns a clock whose physical time is given by physical
+func NewMockHLClock(physical func() int64) *HLClock {
+	return &HLClock{physical: physical}
+}
+
+// Now returns a new timestamp greater than all the ones returned or observed
+// before
+func (clock *HLClock) Now() txnif.TS {
+	clock.Lock()
+	defer clock.Unlock()
+	ts := txnif.NewTS(clock.physical(), 0)
+	if ts <= clock.last {
+		ts = clock.last.Next()
+	}
+	clock.last = ts
+	return ts
+}
+
+// Update makes the clock return timestamps greater than ts, e.g. the ones
+// replayed after a restart
+func (clock *HLClock) Update(ts txnif.TS) {
+	clock.Lock()
+	defer clock.Unlock()
+	if ts > clock.last && ts != txnif.UncommitTS {
+		clock.last = ts
+	}
+}
+
+// Last returns the last timestamp returned or observed
+func (clock *HLClock) Last() txnif.TS {
+	clock.Lock()
+	defer clock.Unlock()
+	return clock.last
+}
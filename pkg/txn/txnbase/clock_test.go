@@ -0,0 +1,55 @@
+package txnbase
+
+import (
+	"tae/pkg/iface/txnif"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTS(t *testing.T) {
+	ts := txnif.NewTS(1000, 5)
+	assert.Equal(t, int64(1000), ts.Physical())
+	assert.Equal(t, uint32(5), ts.Logical())
+	assert.Equal(t, ts, txnif.UnmarshalTS(ts.Marshal()))
+	assert.Equal(t, "1000-5", ts.String())
+	assert.True(t, ts.Less(ts.Next()))
+	assert.True(t, ts.Less(txnif.NewTS(1001, 0)))
+	assert.Equal(t, 1, ts.Compare(txnif.NewTS(999, 100)))
+	assert.Equal(t, 0, ts.Compare(txnif.NewTS(1000, 5)))
+	assert.True(t, ts.Less(txnif.UncommitTS))
+
+	// The logical counter carries into the physical time
+	ts = txnif.NewTS(1000, 1<<txnif.TSLogicalBits-1)
+	assert.Equal(t, txnif.NewTS(1001, 0), ts.Next())
+}
+
+func TestHLClock(t *testing.T) {
+	physical := int64(1000)
+	clock := NewMockHLClock(func() int64 { return physical })
+	ts1 := clock.Now()
+	assert.Equal(t, txnif.NewTS(1000, 0), ts1)
+	ts2 := clock.Now()
+	assert.Equal(t, txnif.NewTS(1000, 1), ts2)
+
+	// The wall clock going backwards does not make the clock go backwards
+	physical = 900
+	ts3 := clock.Now()
+	assert.Equal(t, txnif.NewTS(1000, 2), ts3)
+
+	physical = 1100
+	assert.Equal(t, txnif.NewTS(1100, 0), clock.Now())
+
+	// A timestamp observed from ahead, e.g. a replayed one
+	clock.Update(txnif.NewTS(2000, 7))
+	assert.Equal(t, txnif.NewTS(2000, 8), clock.Now())
+	clock.Update(txnif.NewTS(1500, 0))
+	clock.Update(txnif.UncommitTS)
+	assert.Equal(t, txnif.NewTS(2000, 9), clock.Now())
+
+	clock = NewHLClock()
+	ts := clock.Now()
+	assert.True(t, time.Since(ts.Time()) < time.Minute)
+	assert.True(t, ts.Less(clock.Now()))
+}
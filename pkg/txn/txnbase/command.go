@@ -20,6 +20,7 @@ const (
 	CmdUpdate
 	CmdDelete
 	CmdComposed
+	CmdTxn
 	CmdCustomized
 )
 
@@ -36,6 +37,9 @@ func init() {
 	txnif.RegisterCmdFactory(CmdComposed, func(int16) txnif.TxnCmd {
 		return new(ComposedCmd)
 	})
+	txnif.RegisterCmdFactory(CmdTxn, func(int16) txnif.TxnCmd {
+		return NewTxnCmd(0, 0, 0)
+	})
 }
 
 type CustomizedCmd interface {
@@ -71,6 +75,13 @@ type ComposedCmd struct {
 	Cmds []txnif.TxnCmd
 }
 
+type TxnCmd struct {
+	*ComposedCmd
+	TxnID    uint64
+	StartTS  txnif.TS
+	CommitTS txnif.TS
+}
+
 type BaseCustomizedCmd struct {
 	ID   uint32
 	Impl txnif.TxnCmd
@@ -102,6 +113,15 @@ func NewComposedCmd() *ComposedCmd {
 	}
 }
 
+func NewTxnCmd(txnId uint64, startTs, commitTs txnif.TS) *TxnCmd {
+	return &TxnCmd{
+		ComposedCmd: NewComposedCmd(),
+		TxnID:       txnId,
+		StartTS:     startTs,
+		CommitTS:    commitTs,
+	}
+}
+
 func (c *BaseCustomizedCmd) GetID() uint32 {
 	return c.ID
 }
@@ -300,6 +320,66 @@ func (cc *ComposedCmd) String() string {
 	return cc.ToString("")
 }
 
+func (c *TxnCmd) GetType() int16 {
+	return CmdTxn
+}
+
+func (c *TxnCmd) Marshal() (buf []byte, err error) {
+	var bbuf bytes.Buffer
+	if err = c.WriteTo(&bbuf); err != nil {
+		return
+	}
+	buf = bbuf.Bytes()
+	return
+}
+
+func (c *TxnCmd) Unmarshal(buf []byte) (err error) {
+	bbuf := bytes.NewBuffer(buf)
+	err = c.ReadFrom(bbuf)
+	return err
+}
+
+func (c *TxnCmd) WriteTo(w io.Writer) (err error) {
+	if err = binary.Write(w, binary.BigEndian, c.GetType()); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, c.TxnID); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, c.StartTS); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, c.CommitTS); err != nil {
+		return
+	}
+	err = c.ComposedCmd.WriteTo(w)
+	return
+}
+
+func (c *TxnCmd) ReadFrom(r io.Reader) (err error) {
+	if err = binary.Read(r, binary.BigEndian, &c.TxnID); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &c.StartTS); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &c.CommitTS); err != nil {
+		return
+	}
+	var cmdType int16
+	if err = binary.Read(r, binary.BigEndian, &cmdType); err != nil {
+		return
+	}
+	err = c.ComposedCmd.ReadFrom(r)
+	return
+}
+
+func (c *TxnCmd) String() string {
+	s := fmt.Sprintf("TxnCmd: ID=%d, StartTS=%d, CommitTS=%d", c.TxnID, c.StartTS, c.CommitTS)
+	s = fmt.Sprintf("%s\n%s", s, c.ComposedCmd.ToString("\t"))
+	return s
+}
+
 func BuildCommandFrom(r io.Reader) (cmd txnif.TxnCmd, err error) {
 	var cmdType int16
 	if err = binary.Read(r, binary.BigEndian, &cmdType); err != nil {
@@ -3,6 +3,8 @@ package txnbase
 import (
 	"sync"
 
+	"github.com/jiangxinmeng1/logstore/pkg/common"
+	"github.com/jiangxinmeng1/logstore/pkg/entry"
 	"github.com/jiangxinmeng1/logstore/pkg/store"
 )
 
@@ -14,6 +16,11 @@ const (
 type NodeDriver interface {
 	AppendEntry(uint32, NodeEntry) (uint64, error)
 	LoadEntry(groupId uint32, lsn uint64) (NodeEntry, error)
+	Replay(store.ApplyHandle) error
+	// Checkpoint marks the entries of group up to lsn as checkpointed, so
+	// they are skipped on replay and compacted
+	Checkpoint(group uint32, lsn uint64) error
+	GetCheckpointed(group uint32) uint64
 	Close() error
 }
 
@@ -48,6 +55,33 @@ func (nd *nodeDriver) AppendEntry(group uint32, e NodeEntry) (uint64, error) {
 	return id, err
 }
 
+func (nd *nodeDriver) Replay(handle store.ApplyHandle) error {
+	return nd.impl.Replay(handle)
+}
+
+func (nd *nodeDriver) Checkpoint(group uint32, lsn uint64) (err error) {
+	e := entry.GetBase()
+	e.SetType(entry.ETCheckpoint)
+	e.SetInfo(&entry.Info{
+		Group: entry.GTCKp,
+		Checkpoints: []entry.CkpRanges{{
+			Group:  group,
+			Ranges: common.NewClosedIntervalsByInterval(&common.ClosedInterval{Start: 1, End: lsn}),
+		}},
+	})
+	if _, err = nd.impl.AppendEntry(entry.GTCKp, e); err != nil {
+		return
+	}
+	if err = e.WaitDone(); err != nil {
+		return
+	}
+	return nd.impl.TryCompact()
+}
+
+func (nd *nodeDriver) GetCheckpointed(group uint32) uint64 {
+	return nd.impl.GetCheckpointed(group)
+}
+
 func (nd *nodeDriver) Close() error {
 	if nd.own {
 		return nd.impl.Close()
@@ -9,6 +9,14 @@ var (
 	ErrTxnNotActive         = errors.New("tae: txn not active")
 	ErrTxnCannotRollback    = errors.New("tae: txn cannot txn rollback")
 	ErrTxnDifferentDatabase = errors.New("tae: different database used")
+	ErrSavepointNotFound    = errors.New("tae: savepoint not found")
+	ErrTxnAborted           = errors.New("tae: txn aborted")
+	ErrTxnPrepared          = errors.New("tae: txn prepared")
+	ErrTxnNotPrepared       = errors.New("tae: txn not prepared")
+	ErrTxnNotFound          = errors.New("tae: txn not found")
+	ErrStaleCommitTS        = errors.New("tae: commit ts less than prepare ts")
+	ErrTxnReadOnly          = errors.New("tae: txn read only")
+	ErrTSOutOfRetention     = errors.New("tae: ts older than retention")
 
 	ErrNotFound   = errors.New("tae: not found")
 	ErrDuplicated = errors.New("tae: duplicated ")
@@ -35,6 +35,7 @@ func (db *TxnDatabase) String() string
 func (db *TxnDatabase) Close() error                                                    { return nil }
 func (db *TxnDatabase) CreateRelation(def interface{}) (rel handle.Relation, err error) { return }
 func (db *TxnDatabase) DropRelationByName(name string) (rel handle.Relation, err error) { return }
+func (db *TxnDatabase) RenameRelation(name, newName string) (err error)                 { return }
 func (db *TxnDatabase) GetRelationByName(name string) (rel handle.Relation, err error)  { return }
 func (db *TxnDatabase) RelationCnt() int64                                              { return 0 }
 func (db *TxnDatabase) Relations() (rels []handle.Relation)                             { return }
@@ -52,9 +53,13 @@ func (rel *TxnRelation) MakeSegmentIt() handle.SegmentIt                { return
 func (rel *TxnRelation) MakeReader() handle.Reader                      { return nil }
 func (rel *TxnRelation) BatchDedup(col *vector.Vector) error            { return nil }
 func (rel *TxnRelation) Append(data *batch.Batch) error                 { return nil }
+func (rel *TxnRelation) AlterTable(req interface{}) error               { return nil }
 func (rel *TxnRelation) GetMeta() interface{}                           { return nil }
 func (rel *TxnRelation) CreateSegment() (seg handle.Segment, err error) { return }
 
+func (rel *TxnRelation) DeleteByFilter(handle.Filter) (err error)                      { return }
+func (rel *TxnRelation) UpdateByFilter(handle.Filter, string, interface{}) (err error) { return }
+
 func (seg *TxnSegment) GetMeta() interface{}               { return nil }
 func (seg *TxnSegment) String() string                     { return "" }
 func (seg *TxnSegment) Close() error                       { return nil }
@@ -6,6 +6,7 @@ import (
 	"io"
 
 	gbat "github.com/matrixorigin/matrixone/pkg/container/batch"
+	gnulls "github.com/matrixorigin/matrixone/pkg/container/nulls"
 	"github.com/matrixorigin/matrixone/pkg/container/types"
 	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
 	"github.com/matrixorigin/matrixone/pkg/encoding"
@@ -184,6 +185,74 @@ func AppendValue(vec *gvec.Vector, v interface{}) {
 	}
 }
 
+// ZeroValue returns the zero value of typ
+func ZeroValue(typ types.Type) interface{} {
+	switch typ.Oid {
+	case types.T_int8:
+		return int8(0)
+	case types.T_int16:
+		return int16(0)
+	case types.T_int32:
+		return int32(0)
+	case types.T_int64:
+		return int64(0)
+	case types.T_uint8:
+		return uint8(0)
+	case types.T_uint16:
+		return uint16(0)
+	case types.T_uint32:
+		return uint32(0)
+	case types.T_uint64:
+		return uint64(0)
+	case types.T_decimal:
+		return types.Decimal{}
+	case types.T_float32:
+		return float32(0)
+	case types.T_float64:
+		return float64(0)
+	case types.T_date:
+		return types.Date(0)
+	case types.T_datetime:
+		return types.Datetime(0)
+	case types.T_char, types.T_varchar, types.T_json:
+		return []byte{}
+	default:
+		panic("not expected")
+	}
+}
+
+// NewNullVector returns a vector of rows NULLs of typ
+func NewNullVector(typ types.Type, rows uint32) *gvec.Vector {
+	vec := gvec.New(typ)
+	if rows == 0 {
+		return vec
+	}
+	zero := ZeroValue(typ)
+	nulls := make([]uint64, rows)
+	for row := uint32(0); row < rows; row++ {
+		AppendValue(vec, zero)
+		nulls[row] = uint64(row)
+	}
+	gnulls.Add(vec.Nsp, nulls...)
+	return vec
+}
+
+// WindowVector returns the rows [start, end) of vec. Unlike gvec.Window, the
+// NULLs are shifted to the rows of the window
+func WindowVector(vec *gvec.Vector, start, end int) *gvec.Vector {
+	window := gvec.New(vec.Typ)
+	gvec.Window(vec, start, end, window)
+	window.Nsp = &gnulls.Nulls{}
+	if gnulls.Any(vec.Nsp) {
+		for row := start; row < end; row++ {
+			if gnulls.Contains(vec.Nsp, uint64(row)) {
+				gnulls.Add(window.Nsp, uint64(row-start))
+			}
+		}
+	}
+	return window
+}
+
 func GetValue(col *gvec.Vector, row uint32) interface{} {
 	vals := col.Col
 	switch col.Typ.Oid {
@@ -229,7 +298,7 @@ func GetValue(col *gvec.Vector, row uint32) interface{} {
 	case types.T_char, types.T_varchar, types.T_json:
 		data := vals.(*types.Bytes)
 		s := data.Offsets[row]
-		e := data.Lengths[row]
+		e := s + data.Lengths[row]
 		return string(data.Data[s:e])
 	default:
 		return vector.VecTypeNotSupportErr
@@ -388,9 +457,7 @@ func SplitBatch(bat *gbat.Batch, cnt int) []*gbat.Batch {
 		for i := 0; i < cnt; i++ {
 			newBat := gbat.New(true, bat.Attrs)
 			for j := 0; j < len(bat.Vecs); j++ {
-				window := gvec.New(bat.Vecs[j].Typ)
-				gvec.Window(bat.Vecs[j], i*rows, (i+1)*rows, window)
-				newBat.Vecs[j] = window
+				newBat.Vecs[j] = WindowVector(bat.Vecs[j], i*rows, (i+1)*rows)
 			}
 			bats = append(bats, newBat)
 		}
@@ -417,9 +484,7 @@ func SplitBatch(bat *gbat.Batch, cnt int) []*gbat.Batch {
 	for _, row := range rowArray {
 		newBat := gbat.New(true, bat.Attrs)
 		for j := 0; j < len(bat.Vecs); j++ {
-			window := gvec.New(bat.Vecs[j].Typ)
-			gvec.Window(bat.Vecs[j], start, start+row, window)
-			newBat.Vecs[j] = window
+			newBat.Vecs[j] = WindowVector(bat.Vecs[j], start, start+row)
 		}
 		start += row
 		bats = append(bats, newBat)
@@ -3,6 +3,7 @@ package txnbase
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"tae/pkg/iface/handle"
 	"tae/pkg/iface/txnif"
 
@@ -14,22 +15,28 @@ type OpType int8
 const (
 	OpCommit = iota
 	OpRollback
+	OpPrepare
 )
 
 type OpTxn struct {
 	Txn txnif.AsyncTxn
 	Op  OpType
+	// TS is the commit ts decided by the coordinator of a prepared txn
+	TS txnif.TS
 }
 
 func (txn *OpTxn) Repr() string {
-	if txn.Op == OpCommit {
+	switch txn.Op {
+	case OpCommit:
 		return fmt.Sprintf("[Commit][Txn-%d]", txn.Txn.GetID())
-	} else {
+	case OpPrepare:
+		return fmt.Sprintf("[Prepare][Txn-%d]", txn.Txn.GetID())
+	default:
 		return fmt.Sprintf("[Rollback][Txn-%d]", txn.Txn.GetID())
 	}
 }
 
-var DefaultTxnFactory = func(mgr *TxnManager, store txnif.TxnStore, id, startTS uint64, info []byte) txnif.AsyncTxn {
+var DefaultTxnFactory = func(mgr *TxnManager, store txnif.TxnStore, id uint64, startTS txnif.TS, info []byte) txnif.AsyncTxn {
 	return NewTxn(mgr, store, id, startTS, info)
 }
 
@@ -42,9 +49,13 @@ type Txn struct {
 	Err             error
 	DoneCond        sync.Cond
 	PrepareCommitFn func(interface{}) error
+	// opMu is held shared by the operations of the user and exclusively by
+	// the ones ending the txn, so an abort never runs in the middle of one
+	opMu    sync.RWMutex
+	aborted int32
 }
 
-func NewTxn(mgr *TxnManager, store txnif.TxnStore, txnId uint64, start uint64, info []byte) *Txn {
+func NewTxn(mgr *TxnManager, store txnif.TxnStore, txnId uint64, start txnif.TS, info []byte) *Txn {
 	txn := &Txn{
 		Mgr:   mgr,
 		Store: store,
@@ -60,6 +71,17 @@ func (txn *Txn) GetError() error    { return txn.Err }
 func (txn *Txn) SetPrepareCommitFn(fn func(interface{}) error) { txn.PrepareCommitFn = fn }
 
 func (txn *Txn) Commit() error {
+	txn.opMu.Lock()
+	defer txn.opMu.Unlock()
+	if txn.IsAborted() {
+		return ErrTxnAborted
+	}
+	if txn.GetTxnState(false) == txnif.TxnStatePrepared {
+		return ErrTxnPrepared
+	}
+	if txn.IsReadOnly() {
+		return txn.endReadOnly(txnif.TxnStateCommitted)
+	}
 	txn.Add(1)
 	txn.Mgr.OnOpTxn(&OpTxn{
 		Txn: txn,
@@ -70,11 +92,101 @@ func (txn *Txn) Commit() error {
 	return txn.Err
 }
 
+// StartStatement is called before each statement of the txn. A read committed
+// txn then reads the changes committed before the statement
+func (txn *Txn) StartStatement() {
+	if txn.Isolation != txnif.ReadCommitted || txn.Mgr == nil {
+		return
+	}
+	txn.Mgr.refreshStartTS(txn.TxnCtx)
+}
+
 func (txn *Txn) GetStore() txnif.TxnStore {
 	return txn.Store
 }
 
 func (txn *Txn) Rollback() error {
+	txn.opMu.Lock()
+	defer txn.opMu.Unlock()
+	if txn.IsAborted() {
+		return ErrTxnAborted
+	}
+	if txn.GetTxnState(false) == txnif.TxnStatePrepared {
+		return ErrTxnPrepared
+	}
+	return txn.rollback()
+}
+
+// Prepare validates the txn and logs its changes for a commit decided by an
+// external coordinator. A prepared txn survives a restart and is only ended
+// by CommitPrepared or AbortPrepared
+func (txn *Txn) Prepare() (ts txnif.TS, err error) {
+	txn.opMu.Lock()
+	defer txn.opMu.Unlock()
+	if txn.IsAborted() {
+		err = ErrTxnAborted
+		return
+	}
+	if txn.GetTxnState(false) != txnif.TxnStateActive {
+		err = ErrTxnNotActive
+		return
+	}
+	if txn.IsReadOnly() {
+		err = ErrTxnReadOnly
+		return
+	}
+	txn.Add(1)
+	txn.Mgr.OnOpTxn(&OpTxn{
+		Txn: txn,
+		Op:  OpPrepare,
+	})
+	txn.Wait()
+	// The txn is rollbacked if it cannot be prepared
+	if txn.GetTxnState(false) != txnif.TxnStatePrepared {
+		txn.Mgr.DeleteTxn(txn.GetID())
+		err = txn.Err
+		return
+	}
+	ts = txn.GetPrepareTS()
+	return
+}
+
+// CommitPrepared commits a prepared txn at the ts decided by the coordinator.
+// It cannot be less than the prepare ts. It is raised if a txn started at or
+// after it, and GetCommitTS returns the ts committed at
+func (txn *Txn) CommitPrepared(ts txnif.TS) error {
+	txn.opMu.Lock()
+	defer txn.opMu.Unlock()
+	if txn.GetTxnState(false) != txnif.TxnStatePrepared {
+		return ErrTxnNotPrepared
+	}
+	if ts < txn.GetPrepareTS() {
+		return ErrStaleCommitTS
+	}
+	txn.Add(1)
+	txn.Mgr.OnOpTxn(&OpTxn{
+		Txn: txn,
+		Op:  OpCommit,
+		TS:  ts,
+	})
+	txn.Wait()
+	txn.Mgr.DeleteTxn(txn.GetID())
+	return txn.Err
+}
+
+func (txn *Txn) AbortPrepared() error {
+	txn.opMu.Lock()
+	defer txn.opMu.Unlock()
+	if txn.GetTxnState(false) != txnif.TxnStatePrepared {
+		return ErrTxnNotPrepared
+	}
+	return txn.rollback()
+}
+
+func (txn *Txn) rollback() error {
+	if txn.IsReadOnly() {
+		return txn.endReadOnly(txnif.TxnStateRollbacked)
+	}
 	txn.Add(1)
 	txn.Mgr.OnOpTxn(&OpTxn{
 		Txn: txn,
@@ -85,6 +197,82 @@ func (txn *Txn) Rollback() error {
 	return txn.Err
 }
 
+// endReadOnly ends a read-only txn at once. It wrote nothing, so there is
+// nothing to validate, log or apply
+func (txn *Txn) endReadOnly(state int32) error {
+	txn.Lock()
+	txn.State = state
+	txn.Unlock()
+	txn.Mgr.DeleteTxn(txn.GetID())
+	if err := txn.Store.Close(); err != nil {
+		return err
+	}
+	return txn.Err
+}
+
+// Abort rolls back an active txn on behalf of the system, e.g. once its
+// deadline is exceeded. The operations on the txn then return ErrTxnAborted
+func (txn *Txn) Abort() error {
+	txn.opMu.Lock()
+	defer txn.opMu.Unlock()
+	if txn.GetTxnState(false) != txnif.TxnStateActive {
+		return ErrTxnNotActive
+	}
+	atomic.StoreInt32(&txn.aborted, 1)
+	txn.SetError(ErrTxnAborted)
+	if err := txn.rollback(); err != ErrTxnAborted {
+		return err
+	}
+	return nil
+}
+
+func (txn *Txn) IsAborted() bool { return atomic.LoadInt32(&txn.aborted) == 1 }
+
+// BeginOp is called before an operation on the txn. The txn cannot be
+// aborted until EndOp is called
+func (txn *Txn) BeginOp() error {
+	txn.opMu.RLock()
+	if txn.IsAborted() {
+		txn.opMu.RUnlock()
+		return ErrTxnAborted
+	}
+	if txn.GetTxnState(false) == txnif.TxnStatePrepared {
+		txn.opMu.RUnlock()
+		return ErrTxnPrepared
+	}
+	return nil
+}
+
+func (txn *Txn) EndOp() { txn.opMu.RUnlock() }
+
+// Savepoint marks the current state of the active txn. The changes made after
+// it can be undone by RollbackToSavepoint while the txn goes on
+func (txn *Txn) Savepoint() (id int, err error) {
+	if err = txn.BeginOp(); err != nil {
+		return
+	}
+	defer txn.EndOp()
+	if txn.GetTxnState(false) != txnif.TxnStateActive {
+		err = ErrTxnNotActive
+		return
+	}
+	id = txn.Store.Savepoint()
+	return
+}
+
+// RollbackToSavepoint undoes the changes made after the savepoint. The
+// savepoints made after it are released and the savepoint itself is kept
+func (txn *Txn) RollbackToSavepoint(id int) error {
+	if err := txn.BeginOp(); err != nil {
+		return err
+	}
+	defer txn.EndOp()
+	if txn.GetTxnState(false) != txnif.TxnStateActive {
+		return ErrTxnNotActive
+	}
+	return txn.Store.RollbackToSavepoint(id)
+}
+
 func (txn *Txn) Done() {
 	txn.DoneCond.L.Lock()
 	if txn.State == txnif.TxnStateCommitting {
@@ -158,6 +346,22 @@ func (txn *Txn) PreCommit() error {
 	return txn.Store.PreCommit()
 }
 
+func (txn *Txn) PrePrepare() error {
+	return txn.Store.PrePrepare()
+}
+
+func (txn *Txn) LogPrepare() error {
+	return txn.Store.LogPrepare()
+}
+
+// ApplyPrepare waits until the prepare record is durable and wakes up the
+// caller of Prepare
+func (txn *Txn) ApplyPrepare() error {
+	err := txn.Store.ApplyPrepare()
+	txn.WaitGroup.Done()
+	return err
+}
+
 func (txn *Txn) PrepareRollback() error {
 	logrus.Debugf("Prepare Rollbacking %d", txn.ID)
 	return txn.Store.PrepareRollback()
@@ -177,6 +381,10 @@ func (txn *Txn) DropDatabase(name string) (db handle.Database, err error) {
 	return
 }
 
+func (txn *Txn) RenameDatabase(name, newName string) (db handle.Database, err error) {
+	return
+}
+
 func (txn *Txn) GetDatabase(name string) (db handle.Database, err error) {
 	return
 }
@@ -1,49 +1,94 @@
 package txnbase
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"tae/pkg/iface/txnif"
+	"time"
 )
 
 type TxnCtx struct {
 	*sync.RWMutex
 	ID                uint64
-	StartTS, CommitTS uint64
-	Info              []byte
-	State             int32
+	StartTS, CommitTS txnif.TS
+	// PrepareTS is set once the txn is prepared by a coordinator. The commit
+	// ts is decided by the coordinator later
+	PrepareTS txnif.TS
+	Info      []byte
+	State     int32
+	Isolation txnif.IsolationLevel
+	// Context bounds the lifetime of the txn. It is nil if the txn has no
+	// deadline
+	Context   context.Context
+	CreatedAt time.Time
+	// ReadOnly is set if the txn cannot write
+	ReadOnly bool
 }
 
-func NewTxnCtx(rwlocker *sync.RWMutex, id, start uint64, info []byte) *TxnCtx {
+func NewTxnCtx(rwlocker *sync.RWMutex, id uint64, start txnif.TS, info []byte) *TxnCtx {
 	if rwlocker == nil {
 		rwlocker = new(sync.RWMutex)
 	}
 	return &TxnCtx{
-		ID:       id,
-		RWMutex:  rwlocker,
-		StartTS:  start,
-		CommitTS: txnif.UncommitTS,
-		Info:     info,
+		ID:        id,
+		RWMutex:   rwlocker,
+		StartTS:   start,
+		CommitTS:  txnif.UncommitTS,
+		Info:      info,
+		CreatedAt: time.Now(),
 	}
 }
 
 func (ctx *TxnCtx) Repr() string {
 	ctx.RLock()
 	defer ctx.RUnlock()
-	repr := fmt.Sprintf("Txn[%d][%d->%d][%s]", ctx.ID, ctx.StartTS, ctx.CommitTS, txnif.TxnStrState(ctx.State))
+	repr := fmt.Sprintf("Txn[%d][%s->%s][%s]", ctx.ID, ctx.StartTS, ctx.CommitTS, txnif.TxnStrState(ctx.State))
 	return repr
 }
 
-func (ctx *TxnCtx) String() string     { return ctx.Repr() }
-func (ctx *TxnCtx) GetID() uint64      { return ctx.ID }
-func (ctx *TxnCtx) GetInfo() []byte    { return ctx.Info }
-func (ctx *TxnCtx) GetStartTS() uint64 { return ctx.StartTS }
-func (ctx *TxnCtx) GetCommitTS() uint64 {
+func (ctx *TxnCtx) String() string                          { return ctx.Repr() }
+func (ctx *TxnCtx) GetID() uint64                           { return ctx.ID }
+func (ctx *TxnCtx) GetInfo() []byte                         { return ctx.Info }
+func (ctx *TxnCtx) GetIsolationLevel() txnif.IsolationLevel { return ctx.Isolation }
+func (ctx *TxnCtx) getTxnCtx() *TxnCtx                      { return ctx }
+func (ctx *TxnCtx) IsReadOnly() bool                        { return ctx.ReadOnly }
+
+// Age returns how long the txn has been started
+func (ctx *TxnCtx) Age() time.Duration { return time.Since(ctx.CreatedAt) }
+
+// GetDeadline returns the deadline of the context of the txn
+func (ctx *TxnCtx) GetDeadline() (deadline time.Time, ok bool) {
+	if ctx.Context == nil {
+		return
+	}
+	return ctx.Context.Deadline()
+}
+
+// IsExpired returns true if the context of the txn is done
+func (ctx *TxnCtx) IsExpired() bool {
+	return ctx.Context != nil && ctx.Context.Err() != nil
+}
+
+// GetStartTS returns the read ts of the txn. It is moved forward per statement
+// by a read committed txn
+func (ctx *TxnCtx) GetStartTS() txnif.TS {
+	return txnif.TS(atomic.LoadUint64((*uint64)(&ctx.StartTS)))
+}
+
+func (ctx *TxnCtx) GetCommitTS() txnif.TS {
 	ctx.RLock()
 	defer ctx.RUnlock()
 	return ctx.CommitTS
 }
 
+func (ctx *TxnCtx) GetPrepareTS() txnif.TS {
+	ctx.RLock()
+	defer ctx.RUnlock()
+	return ctx.PrepareTS
+}
+
 func (ctx *TxnCtx) IsVisible(o txnif.TxnReader) bool {
 	ostart := o.GetStartTS()
 	ctx.RLock()
@@ -51,13 +96,21 @@ func (ctx *TxnCtx) IsVisible(o txnif.TxnReader) bool {
 	return ostart <= ctx.StartTS
 }
 
+// refreshStartTSLocked moves the read ts of an active txn forward
+func (ctx *TxnCtx) refreshStartTSLocked(ts txnif.TS) {
+	if ctx.State != txnif.TxnStateActive || ts <= ctx.StartTS {
+		return
+	}
+	atomic.StoreUint64((*uint64)(&ctx.StartTS), uint64(ts))
+}
+
 func (ctx *TxnCtx) IsActiveLocked() bool {
 	return ctx.CommitTS == txnif.UncommitTS
 }
 
-func (ctx *TxnCtx) ToCommittingLocked(ts uint64) error {
+func (ctx *TxnCtx) ToCommittingLocked(ts txnif.TS) error {
 	if ts <= ctx.StartTS {
-		panic(fmt.Sprintf("start ts %d should be less than commit ts %d", ctx.StartTS, ts))
+		panic(fmt.Sprintf("start ts %s should be less than commit ts %s", ctx.StartTS, ts))
 	}
 	if ctx.CommitTS != txnif.UncommitTS {
 		return ErrTxnNotActive
@@ -67,6 +120,21 @@ func (ctx *TxnCtx) ToCommittingLocked(ts uint64) error {
 	return nil
 }
 
+// ToPreparedLocked prepares an active txn. It is neither committing nor
+// rollbacking until the coordinator decides, so its changes are seen by the
+// others as the ones of an active txn
+func (ctx *TxnCtx) ToPreparedLocked(ts txnif.TS) error {
+	if ts <= ctx.StartTS {
+		panic(fmt.Sprintf("start ts %s should be less than prepare ts %s", ctx.StartTS, ts))
+	}
+	if ctx.State != txnif.TxnStateActive {
+		return ErrTxnNotActive
+	}
+	ctx.PrepareTS = ts
+	ctx.State = txnif.TxnStatePrepared
+	return nil
+}
+
 func (ctx *TxnCtx) ToCommittedLocked() error {
 	if ctx.State != txnif.TxnStateCommitting {
 		return ErrTxnNotCommitting
@@ -75,11 +143,11 @@ func (ctx *TxnCtx) ToCommittedLocked() error {
 	return nil
 }
 
-func (ctx *TxnCtx) ToRollbackingLocked(ts uint64) error {
+func (ctx *TxnCtx) ToRollbackingLocked(ts txnif.TS) error {
 	if ts <= ctx.StartTS {
-		panic(fmt.Sprintf("start ts %d should be less than commit ts %d", ctx.StartTS, ts))
+		panic(fmt.Sprintf("start ts %s should be less than commit ts %s", ctx.StartTS, ts))
 	}
-	if (ctx.State != txnif.TxnStateActive) && (ctx.State != txnif.TxnStateCommitting) {
+	if (ctx.State != txnif.TxnStateActive) && (ctx.State != txnif.TxnStateCommitting) && (ctx.State != txnif.TxnStatePrepared) {
 		return ErrTxnCannotRollback
 	}
 	ctx.CommitTS = ts
@@ -96,4 +164,4 @@ func (ctx *TxnCtx) ToRollbackedLocked() error {
 }
 
 // For testing
-func (ctx *TxnCtx) MockSetCommitTSLocked(ts uint64) { ctx.CommitTS = ts }
+func (ctx *TxnCtx) MockSetCommitTSLocked(ts txnif.TS) { ctx.CommitTS = ts }
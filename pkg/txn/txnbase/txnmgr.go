@@ -1,6 +1,8 @@
 package txnbase
 
 import (
+	"context"
+	"sort"
 	"sync"
 	"tae/pkg/iface/txnif"
 	"time"
@@ -11,16 +13,96 @@ import (
 )
 
 type TxnStoreFactory = func() txnif.TxnStore
-type TxnFactory = func(*TxnManager, txnif.TxnStore, uint64, uint64, []byte) txnif.AsyncTxn
+type TxnFactory = func(*TxnManager, txnif.TxnStore, uint64, txnif.TS, []byte) txnif.AsyncTxn
+
+// TxnOption configures a txn started by TxnManager.StartTxn
+type TxnOption func(*TxnOptions)
+
+type TxnOptions struct {
+	Isolation  txnif.IsolationLevel
+	Context    context.Context
+	ReadOnly   bool
+	SnapshotTS txnif.TS
+}
+
+// WithIsolation sets the isolation level of the txn. It is SnapshotIsolation
+// by default
+func WithIsolation(level txnif.IsolationLevel) TxnOption {
+	return func(opts *TxnOptions) {
+		opts.Isolation = level
+	}
+}
+
+// WithContext bounds the txn by ctx. The txn is aborted by the reaper of the
+// manager once ctx is done, e.g. when its deadline is exceeded
+func WithContext(ctx context.Context) TxnOption {
+	return func(opts *TxnOptions) {
+		opts.Context = ctx
+	}
+}
+
+// WithReadOnly starts a read-only txn. It rejects the writes and ends at once
+// without going through the commit pipeline
+func WithReadOnly() TxnOption {
+	return func(opts *TxnOptions) {
+		opts.ReadOnly = true
+	}
+}
+
+// WithSnapshotTS starts a read-only txn reading the data committed at ts for a
+// time travel query. A ts not in the past reads the latest data
+func WithSnapshotTS(ts txnif.TS) TxnOption {
+	return func(opts *TxnOptions) {
+		opts.ReadOnly = true
+		opts.SnapshotTS = ts
+	}
+}
+
+// DefaultReapInterval is how often the txns are checked for expiration
+const DefaultReapInterval = 100 * time.Millisecond
+
+// DefaultRetention is how long the committed versions are kept by default
+const DefaultRetention = 10 * time.Minute
+
+// TxnInfo describes an active txn
+type TxnInfo struct {
+	ID      uint64
+	StartTS txnif.TS
+	State   int32
+	Age     time.Duration
+	// Deadline is zero if the txn has no deadline
+	Deadline time.Time
+}
+
+// GroupLogger is implemented by the txn stores writing a commit record. The
+// records of all the txns prepared in one batch are logged together
+type GroupLogger interface {
+	LogGroup(txns []txnif.AsyncTxn) error
+}
+
+// txnCtxHolder is implemented by the txns embedding a TxnCtx
+type txnCtxHolder interface {
+	getTxnCtx() *TxnCtx
+}
 
 type TxnManager struct {
 	sync.RWMutex
 	sm.ClosedState
 	sm.StateMachine
-	Active           map[uint64]txnif.AsyncTxn
-	IdAlloc, TsAlloc *common.IdAlloctor
-	TxnStoreFactory  TxnStoreFactory
-	TxnFactory       TxnFactory
+	Active          map[uint64]txnif.AsyncTxn
+	IdAlloc         *common.IdAlloctor
+	Clock           *HLClock
+	TxnStoreFactory TxnStoreFactory
+	TxnFactory      TxnFactory
+	// ReapInterval is the period of the reaper. It is set before Start
+	ReapInterval time.Duration
+	// Retention is how long the committed versions are kept for the time
+	// travel queries started by StartTxnAt
+	Retention time.Duration
+	// maxStartTS is the largest read ts issued. It is guarded by the lock
+	maxStartTS txnif.TS
+	reaperStop chan struct{}
+	reaperWg   sync.WaitGroup
 }
 
 func NewTxnManager(txnStoreFactory TxnStoreFactory, txnFactory TxnFactory) *TxnManager {
@@ -30,9 +112,11 @@ func NewTxnManager(txnStoreFactory TxnStoreFactory, txnFactory TxnFactory) *TxnM
 	mgr := &TxnManager{
 		Active:          make(map[uint64]txnif.AsyncTxn),
 		IdAlloc:         common.NewIdAlloctor(1),
-		TsAlloc:         common.NewIdAlloctor(1),
+		Clock:           NewHLClock(),
 		TxnStoreFactory: txnStoreFactory,
 		TxnFactory:      txnFactory,
+		ReapInterval:    DefaultReapInterval,
+		Retention:       DefaultRetention,
 	}
 	pqueue := sm.NewSafeQueue(10000, 200, mgr.onPreparing)
 	cqueue := sm.NewSafeQueue(10000, 200, mgr.onCommit)
@@ -40,25 +124,240 @@ func NewTxnManager(txnStoreFactory TxnStoreFactory, txnFactory TxnFactory) *TxnM
 	return mgr
 }
 
-func (mgr *TxnManager) Init(prevTxnId uint64, prevTs uint64) error {
+// Init makes the manager allocate the txn ids and timestamps after the ones
+// replayed
+func (mgr *TxnManager) Init(prevTxnId uint64, prevTs txnif.TS) error {
 	mgr.IdAlloc.SetStart(prevTxnId)
-	mgr.TsAlloc.SetStart(prevTs)
+	mgr.Clock.Update(prevTs)
 	return nil
 }
 
-func (mgr *TxnManager) StartTxn(info []byte) txnif.AsyncTxn {
+func (mgr *TxnManager) StartTxn(info []byte, opts ...TxnOption) txnif.AsyncTxn {
+	options := new(TxnOptions)
+	for _, opt := range opts {
+		opt(options)
+	}
+	mgr.Lock()
+	defer mgr.Unlock()
+	return mgr.startTxnLocked(info, options)
+}
+
+// StartTxnAt starts a read-only txn reading the data committed at ts. It
+// returns ErrTSOutOfRetention if ts is older than the retention window, as
+// the versions it reads may be merged already
+func (mgr *TxnManager) StartTxnAt(info []byte, ts txnif.TS, opts ...TxnOption) (txnif.AsyncTxn, error) {
+	options := new(TxnOptions)
+	for _, opt := range opts {
+		opt(options)
+	}
+	WithSnapshotTS(ts)(options)
+	mgr.Lock()
+	defer mgr.Unlock()
+	if ts < mgr.retentionHorizonLocked() {
+		return nil, ErrTSOutOfRetention
+	}
+	return mgr.startTxnLocked(info, options), nil
+}
+
+// retentionHorizonLocked returns the oldest ts in the retention window. The
+// window ends at the last issued ts, so reading it does not move the clock
+func (mgr *TxnManager) retentionHorizonLocked() txnif.TS {
+	physical := mgr.Clock.Last().Physical() - mgr.Retention.Milliseconds()
+	if physical <= 0 {
+		return 0
+	}
+	return txnif.NewTS(physical, 0)
+}
+
+// Watermark returns the ts before which no version is read by any txn, active
+// or started later by StartTxnAt. The versions committed before it can be
+// merged
+func (mgr *TxnManager) Watermark() txnif.TS {
 	mgr.Lock()
 	defer mgr.Unlock()
+	watermark := mgr.retentionHorizonLocked()
+	for _, txn := range mgr.Active {
+		if ts := txn.GetStartTS(); ts < watermark {
+			watermark = ts
+		}
+	}
+	return watermark
+}
+
+func (mgr *TxnManager) startTxnLocked(info []byte, options *TxnOptions) txnif.AsyncTxn {
 	txnId := mgr.IdAlloc.Alloc()
-	startTs := mgr.TsAlloc.Alloc()
+	startTs := mgr.Clock.Now()
+	snapshot := !options.SnapshotTS.IsEmpty() && options.SnapshotTS < startTs
+	if snapshot {
+		startTs = options.SnapshotTS
+	}
+	if startTs > mgr.maxStartTS {
+		mgr.maxStartTS = startTs
+	}
 
 	store := mgr.TxnStoreFactory()
 	txn := mgr.TxnFactory(mgr, store, txnId, startTs, info)
+	if holder, ok := txn.(txnCtxHolder); ok {
+		ctx := holder.getTxnCtx()
+		ctx.Isolation = options.Isolation
+		ctx.Context = options.Context
+		ctx.ReadOnly = options.ReadOnly
+		// The read ts of a time travel query is not moved forward
+		if snapshot {
+			ctx.Isolation = txnif.SnapshotIsolation
+		}
+	}
 	store.BindTxn(txn)
 	mgr.Active[txnId] = txn
 	return txn
 }
 
+// RestoreTxn starts a txn with the id and the start ts of a txn logged before
+// a restart, such as a prepared txn to be redone
+func (mgr *TxnManager) RestoreTxn(id uint64, startTs txnif.TS, info []byte) txnif.AsyncTxn {
+	mgr.Lock()
+	defer mgr.Unlock()
+	store := mgr.TxnStoreFactory()
+	txn := mgr.TxnFactory(mgr, store, id, startTs, info)
+	store.BindTxn(txn)
+	mgr.Active[id] = txn
+	return txn
+}
+
+// Prepare prepares the txn for a commit decided by an external coordinator
+// and returns the prepare ts
+func (mgr *TxnManager) Prepare(id uint64) (ts txnif.TS, err error) {
+	txn := mgr.GetTxn(id)
+	if txn == nil {
+		err = ErrTxnNotFound
+		return
+	}
+	return txn.Prepare()
+}
+
+func (mgr *TxnManager) CommitPrepared(id uint64, ts txnif.TS) error {
+	txn := mgr.GetTxn(id)
+	if txn == nil {
+		return ErrTxnNotFound
+	}
+	return txn.CommitPrepared(ts)
+}
+
+func (mgr *TxnManager) AbortPrepared(id uint64) error {
+	txn := mgr.GetTxn(id)
+	if txn == nil {
+		return ErrTxnNotFound
+	}
+	return txn.AbortPrepared()
+}
+
+// PreparedTxns returns the prepared txns waiting for their coordinator,
+// ordered by id
+func (mgr *TxnManager) PreparedTxns() []txnif.AsyncTxn {
+	mgr.RLock()
+	txns := make([]txnif.AsyncTxn, 0)
+	for _, txn := range mgr.Active {
+		if txn.GetTxnState(false) == txnif.TxnStatePrepared {
+			txns = append(txns, txn)
+		}
+	}
+	mgr.RUnlock()
+	sort.Slice(txns, func(i, j int) bool {
+		return txns[i].GetID() < txns[j].GetID()
+	})
+	return txns
+}
+
+func (mgr *TxnManager) Start() {
+	mgr.StateMachine.Start()
+	mgr.reaperStop = make(chan struct{})
+	mgr.reaperWg.Add(1)
+	go mgr.reapLoop(mgr.reaperStop)
+}
+
+func (mgr *TxnManager) Stop() {
+	if mgr.reaperStop != nil {
+		close(mgr.reaperStop)
+		mgr.reaperWg.Wait()
+		mgr.reaperStop = nil
+	}
+	mgr.StateMachine.Stop()
+}
+
+func (mgr *TxnManager) reapLoop(stop chan struct{}) {
+	defer mgr.reaperWg.Done()
+	ticker := time.NewTicker(mgr.ReapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			mgr.reapExpired()
+		}
+	}
+}
+
+// reapExpired aborts the active txns whose context is done
+func (mgr *TxnManager) reapExpired() {
+	mgr.RLock()
+	expired := make([]txnif.AsyncTxn, 0)
+	for _, txn := range mgr.Active {
+		if holder, ok := txn.(txnCtxHolder); ok && holder.getTxnCtx().IsExpired() {
+			expired = append(expired, txn)
+		}
+	}
+	mgr.RUnlock()
+	for _, txn := range expired {
+		err := txn.Abort()
+		// The txn may be ending at the same time
+		if err == ErrTxnNotActive {
+			continue
+		}
+		if err != nil {
+			logrus.Warnf("Abort expired %s: %v", txn.String(), err)
+			continue
+		}
+		logrus.Infof("Aborted expired %s", txn.String())
+	}
+}
+
+// ActiveTxns returns the active txns, the oldest first
+func (mgr *TxnManager) ActiveTxns() []TxnInfo {
+	mgr.RLock()
+	infos := make([]TxnInfo, 0, len(mgr.Active))
+	for _, txn := range mgr.Active {
+		info := TxnInfo{
+			ID:      txn.GetID(),
+			StartTS: txn.GetStartTS(),
+			State:   txn.GetTxnState(false),
+		}
+		if holder, ok := txn.(txnCtxHolder); ok {
+			ctx := holder.getTxnCtx()
+			info.Age = ctx.Age()
+			info.Deadline, _ = ctx.GetDeadline()
+		}
+		infos = append(infos, info)
+	}
+	mgr.RUnlock()
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Age > infos[j].Age
+	})
+	return infos
+}
+
+// refreshStartTS allocates a new read ts the same way as StartTxn, so all the
+// txns committing before it are visible
+func (mgr *TxnManager) refreshStartTS(ctx *TxnCtx) {
+	mgr.Lock()
+	defer mgr.Unlock()
+	ts := mgr.Clock.Now()
+	mgr.maxStartTS = ts
+	ctx.Lock()
+	ctx.refreshStartTSLocked(ts)
+	ctx.Unlock()
+}
+
 func (mgr *TxnManager) DeleteTxn(id uint64) {
 	mgr.Lock()
 	defer mgr.Unlock()
@@ -85,20 +384,33 @@ func (mgr *TxnManager) onPreparCommit(txn txnif.AsyncTxn) {
 	txn.SetError(txn.PrepareCommit())
 }
 
+// onPreparRollback keeps the error that failed the commit
 func (mgr *TxnManager) onPreparRollback(txn txnif.AsyncTxn) {
-	txn.SetError(txn.PrepareRollback())
+	if err := txn.PrepareRollback(); err != nil {
+		txn.SetError(err)
+	}
 }
 
 // TODO
 func (mgr *TxnManager) onPreparing(items ...interface{}) {
 	now := time.Now()
+	ops := make([]*OpTxn, 0, len(items))
 	for _, item := range items {
 		op := item.(*OpTxn)
+		ops = append(ops, op)
+		if op.Op == OpPrepare {
+			mgr.onPrepare(op)
+			continue
+		}
 		if op.Op == OpCommit {
 			mgr.onPreCommit(op.Txn)
+			// Nothing is applied if the pre-commit failed
+			if op.Txn.GetError() != nil {
+				op.Op = OpRollback
+			}
 		}
 		mgr.Lock()
-		ts := mgr.TsAlloc.Alloc()
+		ts := mgr.commitTS(op)
 		op.Txn.Lock()
 		if op.Op == OpCommit {
 			op.Txn.ToCommittingLocked(ts)
@@ -120,11 +432,74 @@ func (mgr *TxnManager) onPreparing(items ...interface{}) {
 		} else {
 			mgr.onPreparRollback(op.Txn)
 		}
+	}
+	mgr.logGroup(ops)
+	for _, op := range ops {
 		mgr.EnqueueCheckpoint(op)
 	}
 	logrus.Infof("PrepareCommit %d Txns Takes: %s", len(items), time.Since(now))
 }
 
+// commitTS allocates the ts of op. A prepared txn commits at the ts decided by
+// its coordinator, and the clock is moved past it. The txns started after the
+// prepare saw the prepared txn as an active one, so the ts is raised above the
+// largest read ts issued to keep it invisible to them
+func (mgr *TxnManager) commitTS(op *OpTxn) txnif.TS {
+	if op.Op != OpCommit || op.TS.IsEmpty() {
+		return mgr.Clock.Now()
+	}
+	if op.TS <= mgr.maxStartTS {
+		return mgr.Clock.Now()
+	}
+	mgr.Clock.Update(op.TS)
+	return op.TS
+}
+
+// onPrepare prepares the txn at a new ts if it is validated and logs its
+// prepare record. Otherwise the txn is rollbacked
+func (mgr *TxnManager) onPrepare(op *OpTxn) {
+	txn := op.Txn
+	txn.SetError(txn.PrePrepare())
+	mgr.Lock()
+	ts := mgr.Clock.Now()
+	txn.Lock()
+	if txn.GetError() == nil {
+		txn.ToPreparedLocked(ts)
+	} else {
+		op.Op = OpRollback
+		txn.ToRollbackingLocked(ts)
+	}
+	txn.Unlock()
+	mgr.Unlock()
+	if op.Op == OpRollback {
+		mgr.onPreparRollback(txn)
+		return
+	}
+	if err := txn.LogPrepare(); err != nil {
+		panic(err)
+	}
+}
+
+// logGroup writes the commit records of the prepared txns in one log entry
+func (mgr *TxnManager) logGroup(ops []*OpTxn) {
+	txns := make([]txnif.AsyncTxn, 0, len(ops))
+	for _, op := range ops {
+		if op.Op == OpCommit {
+			txns = append(txns, op.Txn)
+		}
+	}
+	if len(txns) == 0 {
+		return
+	}
+	logger, ok := txns[0].GetStore().(GroupLogger)
+	if !ok {
+		return
+	}
+	if err := logger.LogGroup(txns); err != nil {
+		panic(err)
+	}
+}
+
 // TODO
 func (mgr *TxnManager) onCommit(items ...interface{}) {
 	now := time.Now()
@@ -139,6 +514,13 @@ func (mgr *TxnManager) onCommit(items ...interface{}) {
 			if err := op.Txn.ApplyRollback(); err != nil {
 				panic(err)
 			}
+		case OpPrepare:
+			// The txn goes on until its coordinator decides
+			if err := op.Txn.ApplyPrepare(); err != nil {
+				panic(err)
+			}
+			logrus.Debugf("%s Prepared", op.Repr())
+			continue
 		}
 		op.Txn.WaitDone()
 		logrus.Debugf("%s Done", op.Repr())
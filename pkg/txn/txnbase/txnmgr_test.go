@@ -0,0 +1,76 @@
+package txnbase
+
+import (
+	"context"
+	"tae/pkg/iface/txnif"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestTxnManager starts a txn manager of txns without any change. The
+// options are applied to the manager before it starts
+func newTestTxnManager(opts ...func(*TxnManager)) *TxnManager {
+	mgr := NewTxnManager(func() txnif.TxnStore { return new(NoopTxnStore) }, nil)
+	for _, opt := range opts {
+		opt(mgr)
+	}
+	mgr.Start()
+	return mgr
+}
+
+func TestTxnDeadline(t *testing.T) {
+	mgr := newTestTxnManager(func(mgr *TxnManager) {
+		mgr.ReapInterval = 5 * time.Millisecond
+	})
+	defer mgr.Stop()
+	waitAborted := func(txn txnif.AsyncTxn) {
+		for i := 0; i < 400; i++ {
+			if txn.IsAborted() {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("txn %d not aborted", txn.GetID())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	txn1 := mgr.StartTxn(nil, WithContext(ctx))
+	txn2 := mgr.StartTxn(nil)
+	infos := mgr.ActiveTxns()
+	assert.Equal(t, 2, len(infos))
+	assert.Equal(t, txn1.GetID(), infos[0].ID)
+	assert.True(t, infos[0].Age >= infos[1].Age)
+	assert.False(t, infos[0].Deadline.IsZero())
+	assert.True(t, infos[1].Deadline.IsZero())
+	assert.Nil(t, txn2.Commit())
+
+	// An operation in progress holds off the abort until it ends
+	assert.Nil(t, txn1.BeginOp())
+	time.Sleep(150 * time.Millisecond)
+	assert.False(t, txn1.IsAborted())
+	txn1.EndOp()
+	waitAborted(txn1)
+	assert.Equal(t, ErrTxnAborted, txn1.BeginOp())
+	assert.Equal(t, ErrTxnAborted, txn1.Commit())
+	assert.Equal(t, ErrTxnAborted, txn1.Rollback())
+	assert.Equal(t, txnif.TxnStateRollbacked, txn1.GetTxnState(true))
+	assert.Equal(t, 0, len(mgr.ActiveTxns()))
+
+	// A cancelled txn is aborted too
+	ctx, cancel = context.WithCancel(context.Background())
+	txn3 := mgr.StartTxn(nil, WithContext(ctx))
+	cancel()
+	waitAborted(txn3)
+	assert.Equal(t, ErrTxnAborted, txn3.Commit())
+
+	// A txn ending before its deadline is not aborted
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	txn4 := mgr.StartTxn(nil, WithContext(ctx))
+	assert.Nil(t, txn4.Commit())
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, txn4.IsAborted())
+}
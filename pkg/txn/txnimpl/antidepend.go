@@ -2,32 +2,84 @@ package txnimpl
 
 import (
 	"tae/pkg/catalog"
+	"tae/pkg/iface/handle"
 	"tae/pkg/iface/txnif"
 	"tae/pkg/txn/txnbase"
 
 	"github.com/sirupsen/logrus"
 )
 
+// tableReads is the read set of a serializable txn in a table. All the rows
+// are read if the table was scanned. Otherwise only the rows matching the
+// filters, which are primary keys or key ranges, are read
+type tableReads struct {
+	entry   *catalog.TableEntry
+	scanned bool
+	filters map[handle.Filter]bool
+}
+
 type warChecker struct {
 	txn      txnif.AsyncTxn
 	db       *catalog.DBEntry
 	symTable map[string]bool
+	reads    map[uint64]*tableReads
 }
 
 func newWarChecker(txn txnif.AsyncTxn, db *catalog.DBEntry) *warChecker {
 	return &warChecker{
 		symTable: make(map[string]bool),
+		reads:    make(map[uint64]*tableReads),
 		db:       db,
 		txn:      txn,
 	}
 }
 
+// observeTableRead records the scan of the committed rows of the table if the
+// txn is serializable. A read-only txn is never validated
+func observeTableRead(txn txnif.AsyncTxn, tb *catalog.TableEntry) {
+	if txn.GetIsolationLevel() != txnif.Serializable || txn.IsReadOnly() {
+		return
+	}
+	if store, ok := txn.GetStore().(*txnStore); ok {
+		store.getWarChecker(tb.GetDB()).readTableRows(tb)
+	}
+}
+
+// observeFilterRead records the read of the committed rows of the table
+// matching filter if the txn is serializable
+func observeFilterRead(txn txnif.AsyncTxn, tb *catalog.TableEntry, filter handle.Filter) {
+	if txn.GetIsolationLevel() != txnif.Serializable || txn.IsReadOnly() {
+		return
+	}
+	if store, ok := txn.GetStore().(*txnStore); ok {
+		store.getWarChecker(tb.GetDB()).readFilterRows(tb, filter)
+	}
+}
+
 func (checker *warChecker) readSymbol(symbol string) {
 	if _, ok := checker.symTable[symbol]; !ok {
 		checker.symTable[symbol] = false
 	}
 }
 
+// copySymbols returns the catalog entries read so far for a savepoint
+func (checker *warChecker) copySymbols() map[string]bool {
+	symbols := make(map[string]bool, len(checker.symTable))
+	for symbol, v := range checker.symTable {
+		symbols[symbol] = v
+	}
+	return symbols
+}
+
+// restoreSymbols forgets the catalog entries read after the savepoint, as
+// those created after it are removed
+func (checker *warChecker) restoreSymbols(symbols map[string]bool) {
+	checker.symTable = make(map[string]bool, len(symbols))
+	for symbol, v := range symbols {
+		checker.symTable[symbol] = v
+	}
+}
+
 func (checker *warChecker) readDBVar(db *catalog.DBEntry) {
 	buf := txnbase.KeyEncoder.EncodeDB(db.GetID())
 	checker.readSymbol(string(buf))
@@ -60,6 +112,60 @@ func (checker *warChecker) readBlockVar(blk *catalog.BlockEntry) {
 	checker.readSymbol(string(buf))
 }
 
+func (checker *warChecker) getTableReads(tb *catalog.TableEntry) *tableReads {
+	reads := checker.reads[tb.GetID()]
+	if reads == nil {
+		checker.readTableVar(tb)
+		reads = &tableReads{
+			entry:   tb,
+			filters: make(map[handle.Filter]bool),
+		}
+		checker.reads[tb.GetID()] = reads
+	}
+	return reads
+}
+
+func (checker *warChecker) readTableRows(tb *catalog.TableEntry) {
+	reads := checker.getTableReads(tb)
+	reads.scanned = true
+	reads.filters = nil
+}
+
+func (checker *warChecker) readFilterRows(tb *catalog.TableEntry, filter handle.Filter) {
+	reads := checker.getTableReads(tb)
+	if !reads.scanned {
+		reads.filters[filter] = true
+	}
+}
+
+// checkReadSet returns txnif.TxnRWConflictErr if a txn committing after the
+// txn started wrote into its read set. It is called on pre-commit, where the
+// txns are checked one by one in the commit order before the rows of the txn
+// are appended
+func (checker *warChecker) checkReadSet() (err error) {
+	startTs := checker.txn.GetStartTS()
+	for _, reads := range checker.reads {
+		// No txn wrote into the table after the txn started
+		if reads.entry.GetLastWriteTS() <= startTs {
+			continue
+		}
+		if reads.scanned {
+			err = txnif.TxnRWConflictErr
+		} else {
+			for filter := range reads.filters {
+				if err = reads.entry.GetTableData().CheckRWConflict(checker.txn, filter); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			logrus.Infof("TxnRWConflictErr Found:[%s]<===RW===[%s]", reads.entry.String(), checker.txn.String())
+			return
+		}
+	}
+	return
+}
+
 func (checker *warChecker) check() (err error) {
 	var entry *catalog.BaseEntry
 	for key, _ := range checker.symTable {
@@ -113,8 +219,11 @@ func (checker *warChecker) check() (err error) {
 				}
 				eTxn := entry.GetTxn()
 				entry.RUnlock()
-				state := eTxn.GetTxnState(true)
-				if state != txnif.TxnStateRollbacked {
+				// The txns are prepared one by one, so a committing txn was
+				// already prepared and is only waiting for its group to be
+				// logged. Waiting for it here would block the group forever
+				state := eTxn.GetTxnState(false)
+				if state == txnif.TxnStateCommitting || state == txnif.TxnStateCommitted {
 					logrus.Infof("TxnRWConflictErr Found:[%s]<===RW===[%s]", eTxn.String(), checker.txn.String())
 					return txnif.TxnRWConflictErr
 				}
@@ -0,0 +1,145 @@
+package txnimpl
+
+import (
+	"tae/pkg/catalog"
+	"tae/pkg/iface/handle"
+	"tae/pkg/iface/txnif"
+	"tae/pkg/txn/txnbase"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsolationLevels(t *testing.T) {
+	schema := catalog.MockSchema(2)
+	schema.BlockMaxRows = 1000
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, schema)
+	defer tbl.close()
+	count := func(txn txnif.AsyncTxn) int {
+		return tbl.countByFilter(txn, handle.Filter{Op: handle.FilterBtw, Col: tbl.operand(0, 1000)})
+	}
+	{
+		txn := tbl.mgr.StartTxn(nil)
+		assert.Nil(t, tbl.appendRows(txn, 0, 10))
+		assert.Nil(t, txn.Commit())
+	}
+
+	si := tbl.mgr.StartTxn(nil)
+	rc := tbl.mgr.StartTxn(nil, txnbase.WithIsolation(txnif.ReadCommitted))
+	assert.Equal(t, txnif.SnapshotIsolation, si.GetIsolationLevel())
+	assert.Equal(t, txnif.ReadCommitted, rc.GetIsolationLevel())
+	assert.Equal(t, 10, count(si))
+	assert.Equal(t, 10, count(rc))
+	{
+		txn := tbl.mgr.StartTxn(nil)
+		assert.Nil(t, tbl.appendRows(txn, 10, 20))
+		assert.Nil(t, txn.Commit())
+	}
+	// Only a new statement of the read committed txn reads the rows committed
+	// after the txn started
+	rc.StartStatement()
+	assert.Equal(t, 10, count(si))
+	assert.Equal(t, 20, count(rc))
+	assert.Nil(t, si.Commit())
+	assert.Nil(t, rc.Commit())
+
+	// Write skew: both txns read the table and then write into it
+	opt := txnbase.WithIsolation(txnif.Serializable)
+	txn1 := tbl.mgr.StartTxn(nil, opt)
+	txn2 := tbl.mgr.StartTxn(nil, opt)
+	assert.Equal(t, txnif.Serializable, txn1.GetIsolationLevel())
+	assert.Equal(t, 20, count(txn1))
+	assert.Equal(t, 20, count(txn2))
+	assert.Nil(t, tbl.appendRows(txn1, 20, 30))
+	assert.Nil(t, tbl.appendRows(txn2, 30, 40))
+	assert.Nil(t, txn1.Commit())
+	assert.NotNil(t, txn2.Commit())
+	assert.Equal(t, txnif.TxnStateRollbacked, txn2.GetTxnState(true))
+
+	// The same write skew is allowed by snapshot isolation
+	txn1 = tbl.mgr.StartTxn(nil)
+	txn2 = tbl.mgr.StartTxn(nil)
+	assert.Equal(t, 30, count(txn1))
+	assert.Equal(t, 30, count(txn2))
+	assert.Nil(t, tbl.appendRows(txn1, 40, 50))
+	assert.Nil(t, tbl.appendRows(txn2, 50, 60))
+	assert.Nil(t, txn1.Commit())
+	assert.Nil(t, txn2.Commit())
+
+	// A serializable txn not reading the table is not aborted
+	txn1 = tbl.mgr.StartTxn(nil, opt)
+	txn2 = tbl.mgr.StartTxn(nil, opt)
+	assert.Nil(t, tbl.appendRows(txn1, 60, 70))
+	assert.Nil(t, tbl.appendRows(txn2, 70, 80))
+	assert.Nil(t, txn1.Commit())
+	assert.Nil(t, txn2.Commit())
+
+	txn := tbl.mgr.StartTxn(nil)
+	assert.Equal(t, 70, count(txn))
+	assert.Nil(t, txn.Commit())
+}
+
+func TestSerializableReadSet(t *testing.T) {
+	schema := catalog.MockSchema(2)
+	schema.BlockMaxRows = 1000
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, schema)
+	defer tbl.close()
+	getByFilter := func(attr string, op handle.FilterOp, vals ...int32) func(txnif.AsyncTxn) {
+		return func(txn txnif.AsyncTxn) {
+			tbl.countByFilter(txn, handle.Filter{Op: op, Attr: attr, Col: tbl.operand(vals...)})
+		}
+	}
+	scan := func(txn txnif.AsyncTxn) {
+		tbl.readCol(txn, 0)
+	}
+	appendRows := func(start, end int32) func(txnif.AsyncTxn) {
+		return func(txn txnif.AsyncTxn) {
+			assert.Nil(t, tbl.getRelation(txn).Append(tbl.makeBatch(start, end)))
+		}
+	}
+	update := func(row uint32, v int32) func(txnif.AsyncTxn) {
+		return func(txn txnif.AsyncTxn) {
+			assert.Nil(t, tbl.firstBlock(txn).Update(row, 1, v))
+		}
+	}
+	deleteRow := func(row uint32) func(txnif.AsyncTxn) {
+		return func(txn txnif.AsyncTxn) {
+			assert.Nil(t, tbl.firstBlock(txn).RangeDelete(row, row))
+		}
+	}
+	// txn1 reads and txn2 writes and commits. Then txn1 commits
+	check := func(read, write func(txnif.AsyncTxn), expected error) {
+		txn1 := tbl.mgr.StartTxn(nil, txnbase.WithIsolation(txnif.Serializable))
+		read(txn1)
+		txn2 := tbl.mgr.StartTxn(nil)
+		write(txn2)
+		assert.Nil(t, txn2.Commit())
+		assert.Equal(t, expected, txn1.Commit())
+	}
+	{
+		txn := tbl.mgr.StartTxn(nil)
+		appendRows(0, 100)(txn)
+		assert.Nil(t, txn.Commit())
+	}
+	pk := schema.ColDefs[0].Name
+	col := schema.ColDefs[1].Name
+
+	// Primary key reads
+	check(getByFilter(pk, handle.FilterEq, 5), update(7, 700), nil)
+	check(getByFilter(pk, handle.FilterEq, 5), update(5, 500), txnif.TxnRWConflictErr)
+	check(getByFilter(pk, handle.FilterEq, 5, 6), deleteRow(6), txnif.TxnRWConflictErr)
+	check(getByFilter(pk, handle.FilterEq, 6), appendRows(100, 101), nil)
+
+	// Key range reads
+	check(getByFilter(pk, handle.FilterBtw, 200, 300), appendRows(250, 251), txnif.TxnRWConflictErr)
+	check(getByFilter(pk, handle.FilterBtw, 400, 500), appendRows(600, 601), nil)
+	check(getByFilter(col, handle.FilterBtw, 1000, 2000), update(10, 1500), txnif.TxnRWConflictErr)
+	check(getByFilter(col, handle.FilterBtw, 5000, 6000), update(11, 1600), nil)
+	check(getByFilter(col, handle.FilterBtw, 1000, 2000), update(10, 20), txnif.TxnRWConflictErr)
+
+	// A scan reads all the rows
+	check(scan, appendRows(700, 701), txnif.TxnRWConflictErr)
+	check(scan, func(txnif.AsyncTxn) {}, nil)
+}
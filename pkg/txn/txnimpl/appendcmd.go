@@ -13,6 +13,8 @@ const (
 	CmdAppend int16 = txnbase.CmdCustomized + iota
 	CmdUpdate
 	CmdDelete
+	CmdPreparedDB
+	CmdPreparedTable
 )
 
 func init() {
@@ -24,7 +26,8 @@ func init() {
 type AppendCmd struct {
 	*txnbase.BaseCustomizedCmd
 	txnbase.ComposedCmd
-	Node InsertNode
+	Infos []*appendInfo
+	Node  InsertNode
 }
 
 func NewEmptyAppendCmd() *AppendCmd {
@@ -42,6 +45,9 @@ func NewAppendCmd(id uint32, node InsertNode) *AppendCmd {
 
 func (c *AppendCmd) String() string {
 	s := fmt.Sprintf("AppendCmd: ID=%d", c.ID)
+	for _, info := range c.Infos {
+		s = fmt.Sprintf("%s\n%s", s, info.String())
+	}
 	s = fmt.Sprintf("%s\n%s", s, c.ComposedCmd.ToString("\t"))
 	return s
 }
@@ -54,6 +60,14 @@ func (c *AppendCmd) WriteTo(w io.Writer) (err error) {
 	if err = binary.Write(w, binary.BigEndian, c.ID); err != nil {
 		return
 	}
+	if err = binary.Write(w, binary.BigEndian, uint32(len(c.Infos))); err != nil {
+		return
+	}
+	for _, info := range c.Infos {
+		if err = info.WriteTo(w); err != nil {
+			return
+		}
+	}
 	err = c.ComposedCmd.WriteTo(w)
 	return err
 }
@@ -62,6 +76,21 @@ func (c *AppendCmd) ReadFrom(r io.Reader) (err error) {
 	if err = binary.Read(r, binary.BigEndian, &c.ID); err != nil {
 		return
 	}
+	length := uint32(0)
+	if err = binary.Read(r, binary.BigEndian, &length); err != nil {
+		return
+	}
+	c.Infos = make([]*appendInfo, length)
+	for i := 0; i < int(length); i++ {
+		c.Infos[i] = new(appendInfo)
+		if err = c.Infos[i].ReadFrom(r); err != nil {
+			return
+		}
+	}
+	cmdType := int16(0)
+	if err = binary.Read(r, binary.BigEndian, &cmdType); err != nil {
+		return
+	}
 	err = c.ComposedCmd.ReadFrom(r)
 	return
 }
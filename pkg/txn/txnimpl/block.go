@@ -9,6 +9,7 @@ import (
 	"tae/pkg/iface/txnif"
 	"tae/pkg/txn/txnbase"
 
+	"github.com/matrixorigin/matrixone/pkg/container/batch"
 	"github.com/matrixorigin/matrixone/pkg/container/vector"
 	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/common"
 )
@@ -32,7 +33,15 @@ func newBlockIt(txn txnif.AsyncTxn, meta *catalog.SegmentEntry) *blockIt {
 		linkIt: meta.MakeBlockIt(true),
 	}
 	if it.linkIt.Valid() {
-		it.curr = it.linkIt.Get().GetPayload().(*catalog.BlockEntry)
+		curr := it.linkIt.Get().GetPayload().(*catalog.BlockEntry)
+		curr.RLock()
+		valid := curr.TxnCanRead(txn, curr.RWMutex)
+		curr.RUnlock()
+		if valid {
+			it.curr = curr
+		} else {
+			it.Next()
+		}
 	}
 	return it
 }
@@ -84,5 +93,67 @@ func (blk *txnBlock) Fingerprint() *common.ID { return blk.entry.AsCommonID() }
 func (blk *txnBlock) Rows() int { return blk.entry.GetBlockData().Rows(blk.Txn, true) }
 
 func (blk *txnBlock) GetVectorCopy(attr string, compressed, decompressed *bytes.Buffer) (vec *vector.Vector, err error) {
+	observeTableRead(blk.Txn, blk.entry.GetSegment().GetTable())
 	return blk.entry.GetBlockData().GetVectorCopy(blk.Txn, attr, compressed, decompressed)
 }
+
+func (blk *txnBlock) GetByFilter(filter handle.Filter, offsetOnly bool) (*batch.Batch, error) {
+	observeFilterRead(blk.Txn, blk.entry.GetSegment().GetTable(), filter)
+	return blk.entry.GetBlockData().GetByFilter(blk.Txn, filter, offsetOnly)
+}
+
+func (blk *txnBlock) RangeDelete(start, end uint32) (err error) {
+	return blk.Txn.GetStore().RangeDelete(blk.entry.AsCommonID(), start, end)
+}
+
+func (blk *txnBlock) Update(row uint32, col uint16, v interface{}) (err error) {
+	return blk.Txn.GetStore().Update(blk.entry.AsCommonID(), row, col, v)
+}
+
+// filterOffsets returns the offsets of the rows visible to the txn that
+// match filter
+func (blk *txnBlock) filterOffsets(filter handle.Filter) (offsets []uint32, err error) {
+	bat, err := blk.GetByFilter(filter, true)
+	if err != nil {
+		return
+	}
+	return bat.Vecs[0].Col.([]uint32), nil
+}
+
+// PushDeleteOp deletes the rows of the block matching filter. The matched
+// rows are deleted by ranges of consecutive offsets
+func (blk *txnBlock) PushDeleteOp(filter handle.Filter) (err error) {
+	offsets, err := blk.filterOffsets(filter)
+	if err != nil {
+		return
+	}
+	for i := 0; i < len(offsets); {
+		j := i + 1
+		for j < len(offsets) && offsets[j] == offsets[j-1]+1 {
+			j++
+		}
+		if err = blk.RangeDelete(offsets[i], offsets[j-1]); err != nil {
+			return
+		}
+		i = j
+	}
+	return
+}
+
+// PushUpdateOp sets attr of the rows of the block matching filter to v
+func (blk *txnBlock) PushUpdateOp(filter handle.Filter, attr string, v interface{}) (err error) {
+	col := blk.entry.GetSegment().GetTable().GetSchemaFor(blk.Txn).GetColIdx(attr)
+	if col < 0 {
+		return catalog.ErrNotFound
+	}
+	offsets, err := blk.filterOffsets(filter)
+	if err != nil {
+		return
+	}
+	for _, row := range offsets {
+		if err = blk.Update(row, uint16(col), v); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -3,9 +3,6 @@ package txnimpl
 import (
 	"tae/pkg/iface/txnif"
 	"tae/pkg/txn/txnbase"
-
-	"github.com/jiangxinmeng1/logstore/pkg/entry"
-	"github.com/sirupsen/logrus"
 )
 
 type commandManager struct {
@@ -30,24 +27,14 @@ func (mgr *commandManager) AddCmd(cmd txnif.TxnCmd) {
 	mgr.csn++
 }
 
-func (mgr *commandManager) ApplyTxnRecord() (logEntry entry.Entry, err error) {
-	// schema := catalog.MockSchema(13)
-	// bat := mock.MockBatch(schema.Types(), 100)
-	// data, _ := txnbase.CopyToIBatch(bat)
-	// batCmd := txnbase.NewBatchCmd(data, schema.Types())
-	// mgr.cmd.AddCmd(batCmd)
+// MakeTxnRecord marshals the commit record of the txn. It is logged later
+// together with the records of the other txns prepared in the same batch
+func (mgr *commandManager) MakeTxnRecord(txn txnif.AsyncTxn) (buf []byte, err error) {
 	if mgr.driver == nil {
 		return
 	}
-	var buf []byte
-	if buf, err = mgr.cmd.Marshal(); err != nil {
-		panic(err)
-	}
-	logEntry = entry.GetBase()
-	logEntry.SetType(ETTxnRecord)
-	logEntry.Unmarshal(buf)
-
-	lsn, err := mgr.driver.AppendEntry(txnbase.GroupC, logEntry)
-	logrus.Debugf("ApplyTxnRecord LSN=%d, Size=%d", lsn, len(buf))
+	cmd := txnbase.NewTxnCmd(txn.GetID(), txn.GetStartTS(), txn.GetCommitTS())
+	cmd.ComposedCmd = mgr.cmd
+	buf, err = cmd.Marshal()
 	return
 }
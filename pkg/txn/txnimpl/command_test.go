@@ -2,10 +2,12 @@ package txnimpl
 
 import (
 	"bytes"
+	"tae/pkg/iface/txnif"
 	"tae/pkg/txn/txnbase"
 	"testing"
 
 	"github.com/RoaringBitmap/roaring"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/common"
 	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/metadata/v1"
 	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/mock"
 	"github.com/stretchr/testify/assert"
@@ -124,3 +126,65 @@ func TestComposedCmd(t *testing.T) {
 		}
 	}
 }
+
+func TestTxnCmd(t *testing.T) {
+	txnCmd := txnbase.NewTxnCmd(3, 4, 5)
+	appendCmd := NewEmptyAppendCmd()
+	for i := 0; i < 3; i++ {
+		dest := &common.ID{TableID: 1, SegmentID: 2, BlockID: uint64(i) + 3}
+		appendCmd.Infos = append(appendCmd.Infos, &appendInfo{
+			seq:     uint32(i),
+			srcOff:  uint32(i) * 10,
+			srcLen:  10,
+			dest:    dest,
+			destOff: 5,
+			destLen: 10,
+		})
+	}
+	ptr := new(txnbase.PointerCmd)
+	ptr.Group = txnbase.GroupUC
+	ptr.Lsn = 8
+	appendCmd.AddCmd(ptr)
+	txnCmd.AddCmd(appendCmd)
+
+	buf, err := txnCmd.Marshal()
+	assert.Nil(t, err)
+	cmd, err := txnbase.BuildCommandFrom(bytes.NewBuffer(buf))
+	assert.Nil(t, err)
+	txnCmd2 := cmd.(*txnbase.TxnCmd)
+	assert.Equal(t, txnCmd.TxnID, txnCmd2.TxnID)
+	assert.Equal(t, txnCmd.StartTS, txnCmd2.StartTS)
+	assert.Equal(t, txnCmd.CommitTS, txnCmd2.CommitTS)
+	assert.Equal(t, 1, len(txnCmd2.Cmds))
+	appendCmd2 := txnCmd2.Cmds[0].(*AppendCmd)
+	assert.Equal(t, len(appendCmd.Infos), len(appendCmd2.Infos))
+	for i, info := range appendCmd.Infos {
+		assert.Equal(t, *info.dest, *appendCmd2.Infos[i].dest)
+		assert.Equal(t, info.srcOff, appendCmd2.Infos[i].srcOff)
+		assert.Equal(t, info.destOff, appendCmd2.Infos[i].destOff)
+	}
+	assert.Equal(t, ptr.Lsn, appendCmd2.Cmds[0].(*txnbase.PointerCmd).Lsn)
+}
+
+func TestTxnGroup(t *testing.T) {
+	records := make([][]byte, 0)
+	for id := uint64(1); id <= 10; id++ {
+		cmd := txnbase.NewTxnCmd(id, txnif.TS(id*2), txnif.TS(id*2+1))
+		cmd.ComposedCmd = txnbase.NewComposedCmd()
+		buf, err := cmd.Marshal()
+		assert.Nil(t, err)
+		records = append(records, buf)
+	}
+	buf := marshalTxnGroup(records)
+	unpacked, err := unmarshalTxnGroup(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, len(records), len(unpacked))
+	for i, record := range unpacked {
+		cmd, err := txnbase.BuildCommandFrom(bytes.NewBuffer(record))
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(i+1), cmd.(*txnbase.TxnCmd).TxnID)
+		assert.Equal(t, txnif.TS(i+1)*2+1, cmd.(*txnbase.TxnCmd).CommitTS)
+	}
+	_, err = unmarshalTxnGroup(buf[:len(buf)-1])
+	assert.Equal(t, ErrBadTxnGroup, err)
+}
@@ -23,7 +23,7 @@ func newDatabase(txn txnif.AsyncTxn, meta *catalog.DBEntry) *txnDatabase {
 
 }
 func (db *txnDatabase) GetID() uint64   { return db.entry.GetID() }
-func (db *txnDatabase) GetName() string { return db.entry.GetName() }
+func (db *txnDatabase) GetName() string { return db.entry.GetNameFor(db.Txn) }
 func (db *txnDatabase) String() string  { return db.entry.String() }
 
 func (db *txnDatabase) CreateRelation(def interface{}) (rel handle.Relation, err error) {
@@ -41,6 +41,10 @@ func (db *txnDatabase) DropRelationByName(name string) (rel handle.Relation, err
 	return db.Txn.GetStore().DropRelationByName(name)
 }
 
+func (db *txnDatabase) RenameRelation(name, newName string) (err error) {
+	return db.Txn.GetStore().RenameRelation(name, newName)
+}
+
 func (db *txnDatabase) GetRelationByName(name string) (rel handle.Relation, err error) {
 	return db.Txn.GetStore().GetRelationByName(name)
 }
@@ -0,0 +1,130 @@
+package txnimpl
+
+import (
+	"tae/pkg/catalog"
+	"tae/pkg/iface/handle"
+	"tae/pkg/iface/txnif"
+	"testing"
+
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRename(t *testing.T) {
+	schema := catalog.MockSchema(2)
+	schema.BlockMaxRows = 10
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, schema)
+	defer tbl.close()
+	count := func(txn txnif.AsyncTxn, dbName, table string) (int, error) {
+		db, err := txn.GetDatabase(dbName)
+		if err != nil {
+			return 0, err
+		}
+		rel, err := db.GetRelationByName(table)
+		if err != nil {
+			return 0, err
+		}
+		cnt := 0
+		for it := rel.MakeSegmentIt(); it.Valid(); it.Next() {
+			bats, err := it.GetSegment().GetByFilter(handle.Filter{Op: handle.FilterBtw, Col: tbl.operand(0, 100)}, false)
+			assert.Nil(t, err)
+			for _, bat := range bats {
+				cnt += gvec.Length(bat.Vecs[0])
+			}
+		}
+		return cnt, nil
+	}
+	rename := func(txn txnif.AsyncTxn, table, newName string) error {
+		db, err := txn.GetDatabase("db")
+		assert.Nil(t, err)
+		rel, err := db.GetRelationByName(table)
+		if err != nil {
+			return err
+		}
+		return rel.AlterTable(catalog.NewRenameTableReq(newName))
+	}
+	createTable := func(txn txnif.AsyncTxn, dbName string, schema *catalog.Schema) error {
+		db, err := txn.GetDatabase(dbName)
+		assert.Nil(t, err)
+		_, err = db.CreateRelation(schema)
+		return err
+	}
+
+	txn := tbl.mgr.StartTxn(nil)
+	assert.Nil(t, tbl.appendRows(txn, 0, 3))
+	assert.Nil(t, txn.Commit())
+
+	// txn1 started before the rename still finds the table by its old name
+	txn1 := tbl.mgr.StartTxn(nil)
+	txn2 := tbl.mgr.StartTxn(nil)
+	assert.Nil(t, rename(txn2, schema.Name, "renamed"))
+	_, err := count(txn2, "db", schema.Name)
+	assert.Equal(t, catalog.ErrNotFound, err)
+	rows, err := count(txn2, "db", "renamed")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, rows)
+
+	// The new name is taken by txn2 until it commits
+	txn3 := tbl.mgr.StartTxn(nil)
+	renamed := catalog.MockSchema(1)
+	renamed.Name = "renamed"
+	assert.Equal(t, txnif.TxnWWConflictErr, createTable(txn3, "db", renamed))
+	assert.Equal(t, txnif.TxnWWConflictErr, rename(txn3, schema.Name, "other"))
+	assert.Nil(t, txn3.Rollback())
+	assert.Nil(t, txn2.Commit())
+
+	rows, err = count(txn1, "db", schema.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, rows)
+	_, err = count(txn1, "db", "renamed")
+	assert.Equal(t, catalog.ErrNotFound, err)
+	// The old name is still held for txn1
+	assert.Equal(t, txnif.TxnWWConflictErr, createTable(txn1, "db", schema))
+	assert.Nil(t, txn1.Rollback())
+
+	// The old name is free for the txns started after the rename
+	txn = tbl.mgr.StartTxn(nil)
+	_, err = count(txn, "db", schema.Name)
+	assert.Equal(t, catalog.ErrNotFound, err)
+	assert.Nil(t, createTable(txn, "db", schema))
+	assert.Equal(t, catalog.ErrDuplicate, createTable(txn, "db", renamed))
+	assert.Nil(t, txn.Commit())
+
+	// A rolled back rename leaves the name unchanged
+	txn = tbl.mgr.StartTxn(nil)
+	assert.Nil(t, rename(txn, "renamed", "dropped"))
+	assert.Nil(t, txn.Rollback())
+	txn = tbl.mgr.StartTxn(nil)
+	_, err = count(txn, "db", "dropped")
+	assert.Equal(t, catalog.ErrNotFound, err)
+	assert.Nil(t, txn.Commit())
+
+	txn1 = tbl.mgr.StartTxn(nil)
+	txn2 = tbl.mgr.StartTxn(nil)
+	_, err = txn2.RenameDatabase("db", "db2")
+	assert.Nil(t, err)
+	rows, err = count(txn2, "db2", "renamed")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, rows)
+	txn3 = tbl.mgr.StartTxn(nil)
+	_, err = txn3.CreateDatabase("db2")
+	assert.Equal(t, txnif.TxnWWConflictErr, err)
+	assert.Nil(t, txn3.Rollback())
+	assert.Nil(t, txn2.Commit())
+	rows, err = count(txn1, "db", "renamed")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, rows)
+	assert.Nil(t, txn1.Commit())
+
+	txn = tbl.mgr.StartTxn(nil)
+	_, err = count(txn, "db", "renamed")
+	assert.Equal(t, catalog.ErrNotFound, err)
+	rows, err = count(txn, "db2", "renamed")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, rows)
+	rows, err = count(txn, "db2", schema.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, rows)
+	assert.Nil(t, txn.Commit())
+}
@@ -7,4 +7,7 @@ import (
 const (
 	ETInsertNode = entry.ETCustomizedStart + 1 + iota
 	ETTxnRecord
+	ETTxnGroup
+	ETTxnPrepare
+	ETTxnAbortPrepared
 )
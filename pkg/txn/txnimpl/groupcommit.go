@@ -0,0 +1,107 @@
+package txnimpl
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"tae/pkg/iface/txnif"
+	"tae/pkg/txn/txnbase"
+
+	"github.com/jiangxinmeng1/logstore/pkg/entry"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrBadTxnGroup = errors.New("tae: bad txn group entry")
+
+// groupEntry is a log entry shared by all the txns of a group. It is freed
+// once every txn of the group has applied its commit
+type groupEntry struct {
+	entry.Entry
+	refs int32
+}
+
+func (e *groupEntry) Free() {
+	if atomic.AddInt32(&e.refs, int32(-1)) == 0 {
+		e.Entry.Free()
+	}
+}
+
+// marshalTxnGroup packs the txn records as: count, the end offset of each
+// record and then all the records
+func marshalTxnGroup(records [][]byte) []byte {
+	size := 4 + 4*len(records)
+	for _, record := range records {
+		size += len(record)
+	}
+	buf := make([]byte, size)
+	binary.BigEndian.PutUint32(buf, uint32(len(records)))
+	pos := 4 + 4*len(records)
+	end := 0
+	for i, record := range records {
+		end += len(record)
+		binary.BigEndian.PutUint32(buf[4+4*i:], uint32(end))
+		copy(buf[pos:], record)
+		pos += len(record)
+	}
+	return buf
+}
+
+func unmarshalTxnGroup(buf []byte) (records [][]byte, err error) {
+	if len(buf) < 4 {
+		return nil, ErrBadTxnGroup
+	}
+	cnt := int(binary.BigEndian.Uint32(buf))
+	if len(buf) < 4+4*cnt {
+		return nil, ErrBadTxnGroup
+	}
+	data := buf[4+4*cnt:]
+	records = make([][]byte, cnt)
+	start := uint32(0)
+	for i := 0; i < cnt; i++ {
+		end := binary.BigEndian.Uint32(buf[4+4*i:])
+		if end < start || int(end) > len(data) {
+			return nil, ErrBadTxnGroup
+		}
+		records[i] = data[start:end]
+		start = end
+	}
+	return
+}
+
+// LogGroup writes the records of the committing txns in one log entry
+func (store *txnStore) LogGroup(txns []txnif.AsyncTxn) (err error) {
+	stores := make([]*txnStore, 0, len(txns))
+	records := make([][]byte, 0, len(txns))
+	for _, txn := range txns {
+		s := txn.GetStore().(*txnStore)
+		if s.record == nil {
+			continue
+		}
+		stores = append(stores, s)
+		records = append(records, s.record)
+		s.record = nil
+	}
+	if len(records) == 0 {
+		return
+	}
+	buf := marshalTxnGroup(records)
+	e := &groupEntry{Entry: entry.GetBase(), refs: int32(len(stores))}
+	e.SetType(ETTxnGroup)
+	if err = e.Unmarshal(buf); err != nil {
+		return
+	}
+	lsn, err := store.driver.AppendEntry(txnbase.GroupC, e)
+	if err != nil {
+		return
+	}
+	record := new(logRecord)
+	for _, s := range stores {
+		s.logs = append(s.logs, e)
+		record.add(s.cmdMgr.cmd.Cmds, s.txn.GetCommitTS())
+	}
+	if tracker, ok := store.driver.(*LogTracker); ok {
+		tracker.onRecord(lsn, record)
+	}
+	logrus.Debugf("LogGroup LSN=%d, Txns=%d, Size=%d", lsn, len(stores), len(buf))
+	return
+}
@@ -3,9 +3,9 @@ package txnimpl
 import (
 	"io"
 	"sync"
+	"tae/pkg/index"
 	"tae/pkg/txn/txnbase"
 
-	"github.com/matrixorigin/matrixone/pkg/container/types"
 	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
 	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/container/vector"
 )
@@ -19,24 +19,36 @@ type TableIndex interface {
 	Find(interface{}) (uint32, error)
 	Name() string
 	Count() int
+	Savepoint() int
+	RollbackToSavepoint(int)
 }
 
-// TODO
-type artTableIndex struct{}
+// indexUndo is the previous row of a key changed after a savepoint
+type indexUndo struct {
+	key     string
+	row     uint32
+	existed bool
+}
 
+// simpleTableIndex maps the keys encoded as bytes to the rows, so a key of any
+// type is stored without boxing
 type simpleTableIndex struct {
 	sync.RWMutex
-	tree map[interface{}]uint32
+	tree map[string]uint32
+	// The changes are journaled once a savepoint is made
+	journaled bool
+	undo      []indexUndo
 }
 
 func NewSimpleTableIndex() *simpleTableIndex {
 	return &simpleTableIndex{
-		tree: make(map[interface{}]uint32),
+		tree: make(map[string]uint32),
 	}
 }
 
 func (idx *simpleTableIndex) Close() error {
 	idx.tree = nil
+	idx.undo = nil
 	return nil
 }
 func (idx *simpleTableIndex) Name() string { return "SimpleIndex" }
@@ -47,373 +59,139 @@ func (idx *simpleTableIndex) Count() int {
 	return cnt
 }
 
+// Savepoint returns the position of the journal to roll back to
+func (idx *simpleTableIndex) Savepoint() int {
+	idx.Lock()
+	defer idx.Unlock()
+	idx.journaled = true
+	return len(idx.undo)
+}
+
+// RollbackToSavepoint reverts the changes journaled after pos
+func (idx *simpleTableIndex) RollbackToSavepoint(pos int) {
+	idx.Lock()
+	defer idx.Unlock()
+	for i := len(idx.undo) - 1; i >= pos; i-- {
+		u := idx.undo[i]
+		if u.existed {
+			idx.tree[u.key] = u.row
+		} else {
+			delete(idx.tree, u.key)
+		}
+	}
+	idx.undo = idx.undo[:pos]
+}
+
+func (idx *simpleTableIndex) setLocked(key string, row uint32) {
+	if idx.journaled {
+		prev, existed := idx.tree[key]
+		idx.undo = append(idx.undo, indexUndo{key: key, row: prev, existed: existed})
+	}
+	idx.tree[key] = row
+}
+
+func encodeKey(v interface{}) ([]byte, error) {
+	key, err := index.EncodeKey(v)
+	if err == index.ErrNotSupported {
+		err = vector.VecTypeNotSupportErr
+	}
+	return key, err
+}
+
+func newKeyColumn(col *gvec.Vector) (*index.KeyColumn, error) {
+	keys, err := index.NewKeyColumn(col)
+	if err == index.ErrNotSupported {
+		err = vector.VecTypeNotSupportErr
+	}
+	return keys, err
+}
+
 func (idx *simpleTableIndex) Insert(v interface{}, row uint32) error {
+	key, err := encodeKey(v)
+	if err != nil {
+		return err
+	}
 	idx.Lock()
 	defer idx.Unlock()
-	_, ok := idx.tree[v]
+	_, ok := idx.tree[string(key)]
 	if ok {
 		return txnbase.ErrDuplicated
 	}
-	idx.tree[v] = row
+	idx.setLocked(string(key), row)
 	return nil
 }
 
 func (idx *simpleTableIndex) Delete(v interface{}) error {
+	key, err := encodeKey(v)
+	if err != nil {
+		return err
+	}
 	idx.Lock()
 	defer idx.Unlock()
-	_, ok := idx.tree[v]
+	row, ok := idx.tree[string(key)]
 	if !ok {
 		return txnbase.ErrNotFound
 	}
-	delete(idx.tree, v)
+	if idx.journaled {
+		idx.undo = append(idx.undo, indexUndo{key: string(key), row: row, existed: true})
+	}
+	delete(idx.tree, string(key))
 	return nil
 }
 
 func (idx *simpleTableIndex) Find(v interface{}) (uint32, error) {
+	key, err := encodeKey(v)
+	if err != nil {
+		return 0, err
+	}
 	idx.RLock()
 	defer idx.RUnlock()
-	row, ok := idx.tree[v]
+	row, ok := idx.tree[string(key)]
 	if !ok {
 		return 0, txnbase.ErrNotFound
 	}
 	return uint32(row), nil
 }
 
+// BatchInsert inserts the keys [start, start+count) of col with the rows
+// starting from row. If dedupCol is true, txnbase.ErrDuplicated is returned if
+// the inserted keys are not unique and nothing is inserted
 func (idx *simpleTableIndex) BatchInsert(col *gvec.Vector, start, count int, row uint32, dedupCol bool) error {
-	idx.Lock()
-	defer idx.Unlock()
-	vals := col.Col
-	switch col.Typ.Oid {
-	case types.T_int8:
-		data := vals.([]int8)
-		if dedupCol {
-			set := make(map[int8]bool)
-			for _, v := range data[start : start+count] {
-				if _, ok := set[v]; ok {
-					return txnbase.ErrDuplicated
-				}
-				set[v] = true
-			}
-		}
-		for _, v := range data[start : start+count] {
-			idx.tree[v] = row
-			row++
-		}
-	case types.T_int16:
-		data := vals.([]int16)
-		if dedupCol {
-			set := make(map[int16]bool)
-			for _, v := range data[start : start+count] {
-				if _, ok := set[v]; ok {
-					return txnbase.ErrDuplicated
-				}
-				set[v] = true
-			}
-		}
-		for _, v := range data[start : start+count] {
-			idx.tree[v] = row
-			row++
-		}
-	case types.T_int32:
-		data := vals.([]int32)
-		if dedupCol {
-			set := make(map[int32]bool)
-			for _, v := range data[start : start+count] {
-				if _, ok := set[v]; ok {
-					return txnbase.ErrDuplicated
-				}
-				set[v] = true
-			}
-		}
-		for _, v := range data[start : start+count] {
-			idx.tree[v] = row
-			row++
-		}
-	case types.T_int64:
-		data := vals.([]int64)
-		if dedupCol {
-			set := make(map[int64]bool)
-			for _, v := range data[start : start+count] {
-				if _, ok := set[v]; ok {
-					return txnbase.ErrDuplicated
-				}
-				set[v] = true
-			}
-		}
-		for _, v := range data[start : start+count] {
-			idx.tree[v] = row
-			row++
-		}
-	case types.T_uint8:
-		data := vals.([]uint8)
-		if dedupCol {
-			set := make(map[uint8]bool)
-			for _, v := range data[start : start+count] {
-				if _, ok := set[v]; ok {
-					return txnbase.ErrDuplicated
-				}
-				set[v] = true
-			}
-		}
-		for _, v := range data[start : start+count] {
-			idx.tree[v] = row
-			row++
-		}
-	case types.T_uint16:
-		data := vals.([]uint16)
-		if dedupCol {
-			set := make(map[uint16]bool)
-			for _, v := range data[start : start+count] {
-				if _, ok := set[v]; ok {
-					return txnbase.ErrDuplicated
-				}
-				set[v] = true
-			}
-		}
-		for _, v := range data[start : start+count] {
-			idx.tree[v] = row
-			row++
-		}
-	case types.T_uint32:
-		data := vals.([]uint32)
-		if dedupCol {
-			set := make(map[uint32]bool)
-			for _, v := range data[start : start+count] {
-				if _, ok := set[v]; ok {
-					return txnbase.ErrDuplicated
-				}
-				set[v] = true
-			}
-		}
-		for _, v := range data[start : start+count] {
-			idx.tree[v] = row
-			row++
-		}
-	case types.T_uint64:
-		data := vals.([]uint64)
-		if dedupCol {
-			set := make(map[uint64]bool)
-			for _, v := range data[start : start+count] {
-				if _, ok := set[v]; ok {
-					return txnbase.ErrDuplicated
-				}
-				set[v] = true
-			}
-		}
-		for _, v := range data[start : start+count] {
-			idx.tree[v] = row
-			row++
-		}
-	case types.T_decimal:
-		data := vals.([]types.Decimal)
-		if dedupCol {
-			set := make(map[types.Decimal]bool)
-			for _, v := range data[start : start+count] {
-				if _, ok := set[v]; ok {
-					return txnbase.ErrDuplicated
-				}
-				set[v] = true
-			}
-		}
-		for _, v := range data[start : start+count] {
-			idx.tree[v] = row
-			row++
-		}
-	case types.T_float32:
-		data := vals.([]float32)
-		if dedupCol {
-			set := make(map[float32]bool)
-			for _, v := range data[start : start+count] {
-				if _, ok := set[v]; ok {
-					return txnbase.ErrDuplicated
-				}
-				set[v] = true
-			}
-		}
-		for _, v := range data[start : start+count] {
-			idx.tree[v] = row
-			row++
-		}
-	case types.T_float64:
-		data := vals.([]float64)
-		if dedupCol {
-			set := make(map[float64]bool)
-			for _, v := range data[start : start+count] {
-				if _, ok := set[v]; ok {
-					return txnbase.ErrDuplicated
-				}
-				set[v] = true
-			}
-		}
-		for _, v := range data[start : start+count] {
-			idx.tree[v] = row
-			row++
-		}
-	case types.T_date:
-		data := vals.([]types.Date)
-		if dedupCol {
-			set := make(map[types.Date]bool)
-			for _, v := range data[start : start+count] {
-				if _, ok := set[v]; ok {
-					return txnbase.ErrDuplicated
-				}
-				set[v] = true
-			}
-		}
-		for _, v := range data[start : start+count] {
-			idx.tree[v] = row
-			row++
-		}
-	case types.T_datetime:
-		data := vals.([]types.Datetime)
-		if dedupCol {
-			set := make(map[types.Datetime]bool)
-			for _, v := range data[start : start+count] {
-				if _, ok := set[v]; ok {
-					return txnbase.ErrDuplicated
-				}
-				set[v] = true
-			}
-		}
-		for _, v := range data[start : start+count] {
-			idx.tree[v] = row
-			row++
-		}
-	case types.T_char, types.T_varchar, types.T_json:
-		data := vals.(*types.Bytes)
-		if dedupCol {
-			set := make(map[string]bool)
-			for i, s := range data.Offsets[start : start+count] {
-				e := s + data.Lengths[i+start]
-				v := string(data.Data[s:e])
-				if _, ok := set[v]; ok {
-					return txnbase.ErrDuplicated
-				}
-				set[v] = true
+	keys, err := newKeyColumn(col)
+	if err != nil {
+		return err
+	}
+	if dedupCol {
+		set := make(map[string]struct{}, count)
+		for i := start; i < start+count; i++ {
+			key := keys.Get(i)
+			if _, ok := set[string(key)]; ok {
+				return txnbase.ErrDuplicated
 			}
+			set[string(key)] = struct{}{}
 		}
-		for i, s := range data.Offsets[start : start+count] {
-			e := s + data.Lengths[i+start]
-			v := string(data.Data[s:e])
-			idx.tree[v] = row
-			row++
-		}
-	default:
-		return vector.VecTypeNotSupportErr
+	}
+	idx.Lock()
+	defer idx.Unlock()
+	for i := start; i < start+count; i++ {
+		idx.setLocked(string(keys.Get(i)), row)
+		row++
 	}
 	return nil
 }
 
-// TODO: rewrite
+// BatchDedup returns txnbase.ErrDuplicated if any key of col is in the index
 func (idx *simpleTableIndex) BatchDedup(col *gvec.Vector) error {
+	keys, err := newKeyColumn(col)
+	if err != nil {
+		return err
+	}
 	idx.RLock()
 	defer idx.RUnlock()
-	vals := col.Col
-	switch col.Typ.Oid {
-	case types.T_int8:
-		data := vals.([]int8)
-		for _, v := range data {
-			if _, ok := idx.tree[v]; ok {
-				return txnbase.ErrDuplicated
-			}
-		}
-	case types.T_int16:
-		data := vals.([]int16)
-		for _, v := range data {
-			if _, ok := idx.tree[v]; ok {
-				return txnbase.ErrDuplicated
-			}
-		}
-	case types.T_int32:
-		data := vals.([]int32)
-		for _, v := range data {
-			if _, ok := idx.tree[v]; ok {
-				return txnbase.ErrDuplicated
-			}
-		}
-	case types.T_int64:
-		data := vals.([]int64)
-		for _, v := range data {
-			if _, ok := idx.tree[v]; ok {
-				return txnbase.ErrDuplicated
-			}
-		}
-	case types.T_uint8:
-		data := vals.([]uint8)
-		for _, v := range data {
-			if _, ok := idx.tree[v]; ok {
-				return txnbase.ErrDuplicated
-			}
-		}
-	case types.T_uint16:
-		data := vals.([]uint16)
-		for _, v := range data {
-			if _, ok := idx.tree[v]; ok {
-				return txnbase.ErrDuplicated
-			}
-		}
-	case types.T_uint32:
-		data := vals.([]uint32)
-		for _, v := range data {
-			if _, ok := idx.tree[v]; ok {
-				return txnbase.ErrDuplicated
-			}
-		}
-	case types.T_uint64:
-		data := vals.([]uint64)
-		for _, v := range data {
-			if _, ok := idx.tree[v]; ok {
-				return txnbase.ErrDuplicated
-			}
-		}
-	case types.T_decimal:
-		data := vals.([]types.Decimal)
-		for _, v := range data {
-			if _, ok := idx.tree[v]; ok {
-				return txnbase.ErrDuplicated
-			}
-		}
-	case types.T_float32:
-		data := vals.([]float32)
-		for _, v := range data {
-			if _, ok := idx.tree[v]; ok {
-				return txnbase.ErrDuplicated
-			}
-		}
-	case types.T_float64:
-		data := vals.([]float64)
-		for _, v := range data {
-			if _, ok := idx.tree[v]; ok {
-				return txnbase.ErrDuplicated
-			}
-		}
-	case types.T_date:
-		data := vals.([]types.Date)
-		for _, v := range data {
-			if _, ok := idx.tree[v]; ok {
-				return txnbase.ErrDuplicated
-			}
-		}
-	case types.T_datetime:
-		data := vals.([]types.Datetime)
-		for _, v := range data {
-			if _, ok := idx.tree[v]; ok {
-				return txnbase.ErrDuplicated
-			}
-		}
-	case types.T_char, types.T_varchar, types.T_json:
-		data := vals.(*types.Bytes)
-		// bytes := make([]string, 0, len(data.Lengths))
-		for i, s := range data.Offsets {
-			e := s + data.Lengths[i]
-			v := string(data.Data[s:e])
-			// bytes = append(bytes, v)
-			if _, ok := idx.tree[v]; ok {
-				return txnbase.ErrDuplicated
-			}
+	for i := 0; i < keys.Length(); i++ {
+		if _, ok := idx.tree[string(keys.Get(i))]; ok {
+			return txnbase.ErrDuplicated
 		}
-	default:
-		return vector.VecTypeNotSupportErr
 	}
 	return nil
 }
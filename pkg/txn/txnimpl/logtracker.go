@@ -0,0 +1,134 @@
+package txnimpl
+
+import (
+	"sync"
+	"tae/pkg/iface/txnif"
+	"tae/pkg/txn/txnbase"
+	"tae/pkg/updates"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LogTracker is a NodeDriver tracking the commit records of GroupC. The
+// catalog commands of a record are redundant once the catalog is checkpointed
+// after their commit, and the appends and updates once the data is. The log
+// store checkpoints a group by a prefix of LSNs, so only the longest prefix of
+// the redundant records is truncated
+type LogTracker struct {
+	txnbase.NodeDriver
+	sync.Mutex
+	truncated uint64
+	// catalogTs and dataTs are the ts of the newest catalog and data
+	// checkpoints
+	catalogTs txnif.TS
+	dataTs    txnif.TS
+	records   map[uint64]*logRecord
+}
+
+// logRecord is the max commit ts of the txns of a record changing the catalog
+// and of the ones changing the data. A ts is 0 if no txn changes it
+type logRecord struct {
+	catalogTs txnif.TS
+	dataTs    txnif.TS
+}
+
+func NewLogTracker(driver txnbase.NodeDriver) *LogTracker {
+	return &LogTracker{
+		NodeDriver: driver,
+		truncated:  driver.GetCheckpointed(txnbase.GroupC),
+		records:    make(map[uint64]*logRecord),
+	}
+}
+
+// getCmdKinds returns whether the commands change the catalog and the data
+func getCmdKinds(cmds []txnif.TxnCmd) (catalog, data bool) {
+	for _, cmd := range cmds {
+		switch cmd.(type) {
+		case *AppendCmd, *updates.UpdateCmd:
+			data = true
+		default:
+			catalog = true
+		}
+	}
+	return
+}
+
+// add takes the commands of a txn committed at ts into the record
+func (record *logRecord) add(cmds []txnif.TxnCmd, ts txnif.TS) {
+	catalog, data := getCmdKinds(cmds)
+	if catalog && ts > record.catalogTs {
+		record.catalogTs = ts
+	}
+	if data && ts > record.dataTs {
+		record.dataTs = ts
+	}
+}
+
+// onReplayed takes the LSN truncated before, which is known once the log is
+// replayed
+func (tracker *LogTracker) onReplayed() {
+	tracker.Lock()
+	defer tracker.Unlock()
+	tracker.truncated = tracker.GetCheckpointed(txnbase.GroupC)
+}
+
+// onRecord tracks the record at lsn
+func (tracker *LogTracker) onRecord(lsn uint64, record *logRecord) {
+	tracker.Lock()
+	defer tracker.Unlock()
+	if lsn > tracker.truncated {
+		tracker.records[lsn] = record
+	}
+}
+
+// GetTruncated returns the last LSN of GroupC truncated
+func (tracker *LogTracker) GetTruncated() uint64 {
+	tracker.Lock()
+	defer tracker.Unlock()
+	return tracker.truncated
+}
+
+// Truncate truncates the prefix of the records redundant once the catalog is
+// checkpointed at ts
+func (tracker *LogTracker) Truncate(ts txnif.TS) (err error) {
+	tracker.Lock()
+	defer tracker.Unlock()
+	if ts > tracker.catalogTs {
+		tracker.catalogTs = ts
+	}
+	return tracker.truncateLocked()
+}
+
+// TruncateData truncates the prefix of the records redundant once the data
+// committed at or before ts is persisted out of the log
+func (tracker *LogTracker) TruncateData(ts txnif.TS) (err error) {
+	tracker.Lock()
+	defer tracker.Unlock()
+	if ts > tracker.dataTs {
+		tracker.dataTs = ts
+	}
+	return tracker.truncateLocked()
+}
+
+func (tracker *LogTracker) truncateLocked() (err error) {
+	end := tracker.truncated
+	for {
+		record, ok := tracker.records[end+1]
+		if !ok || record.catalogTs > tracker.catalogTs || record.dataTs > tracker.dataTs {
+			break
+		}
+		end++
+	}
+	if end == tracker.truncated {
+		return
+	}
+	if err = tracker.Checkpoint(txnbase.GroupC, end); err != nil {
+		return
+	}
+	for lsn := tracker.truncated + 1; lsn <= end; lsn++ {
+		delete(tracker.records, lsn)
+	}
+	logrus.Infof("Truncated records of the txn log: LSN=(%d, %d]", tracker.truncated, end)
+	tracker.truncated = end
+	return
+}
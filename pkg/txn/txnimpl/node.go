@@ -2,7 +2,9 @@ package txnimpl
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"sync/atomic"
 	"tae/pkg/iface/txnif"
 	"tae/pkg/txn/txnbase"
@@ -37,6 +39,8 @@ type InsertNode interface {
 	Append(data *gbat.Batch, offset uint32) (appended uint32, err error)
 	RangeDelete(start, end uint32) error
 	IsRowDeleted(row uint32) bool
+	CloneDeletes() *roaring.Bitmap
+	SetDeletes(deletes *roaring.Bitmap)
 	PrintDeletes() string
 	Window(start, end uint32) (*gbat.Batch, error)
 	GetSpace() uint32
@@ -54,6 +58,48 @@ type appendInfo struct {
 	destOff, destLen uint32
 }
 
+func (info *appendInfo) WriteTo(w io.Writer) (err error) {
+	if err = binary.Write(w, binary.BigEndian, info.seq); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, info.srcOff); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, info.srcLen); err != nil {
+		return
+	}
+	if _, err = w.Write(txnbase.MarshalID(info.dest)); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, info.destOff); err != nil {
+		return
+	}
+	err = binary.Write(w, binary.BigEndian, info.destLen)
+	return
+}
+
+func (info *appendInfo) ReadFrom(r io.Reader) (err error) {
+	if err = binary.Read(r, binary.BigEndian, &info.seq); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &info.srcOff); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &info.srcLen); err != nil {
+		return
+	}
+	buf := make([]byte, txnbase.IDSize)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return
+	}
+	info.dest = txnbase.UnmarshalID(buf)
+	if err = binary.Read(r, binary.BigEndian, &info.destOff); err != nil {
+		return
+	}
+	err = binary.Read(r, binary.BigEndian, &info.destLen)
+	return
+}
+
 func (info *appendInfo) String() string {
 	s := fmt.Sprintf("[%d]: Append from [%d:%d] to blk %s[%d:%d]",
 		info.seq, info.srcOff, info.srcLen+info.srcOff, info.dest.ToBlockFileName(), info.destOff, info.destLen+info.destOff)
@@ -105,6 +151,7 @@ func (n *insertNode) MakeCommand(id uint32, forceFlush bool) (cmd txnif.TxnCmd,
 		return
 	}
 	composedCmd := NewAppendCmd(id, n)
+	composedCmd.Infos = n.appends
 	if n.lsn == 0 && forceFlush {
 		entry = n.execUnload()
 	}
@@ -290,6 +337,15 @@ func (n *insertNode) IsRowDeleted(row uint32) bool {
 	return n.deletes.Contains(row)
 }
 
+func (n *insertNode) CloneDeletes() *roaring.Bitmap {
+	if n.deletes == nil {
+		return nil
+	}
+	return n.deletes.Clone()
+}
+
+func (n *insertNode) SetDeletes(deletes *roaring.Bitmap) { n.deletes = deletes }
+
 func (n *insertNode) PrintDeletes() string {
 	if n.deletes == nil {
 		return fmt.Sprintf("NoDeletes")
@@ -299,20 +355,18 @@ func (n *insertNode) PrintDeletes() string {
 
 // TODO: Rewrite later
 func (n *insertNode) Window(start, end uint32) (*gbat.Batch, error) {
-	attrs := make([]string, len(n.table.GetSchema().ColDefs))
-	for i, _ := range attrs {
-		attrs[i] = n.table.GetSchema().ColDefs[i].Name
-	}
+	return windowBatch(n.table.GetSchema().Attrs(), n.data, start, end)
+}
+
+func windowBatch(attrs []string, data batch.IBatch, start, end uint32) (*gbat.Batch, error) {
 	ret := gbat.New(true, attrs)
-	for i, attr := range n.data.GetAttrs() {
-		src, err := n.data.GetVectorByAttr(attr)
+	for i, attr := range data.GetAttrs() {
+		src, err := data.GetVectorByAttr(attr)
 		if err != nil {
 			return nil, err
 		}
 		srcVec, _ := src.GetLatestView().CopyToVector()
-		destVec := gvec.New(srcVec.Typ)
-		gvec.Window(srcVec, int(start), int(end)+1, destVec)
-		ret.Vecs[i] = destVec
+		ret.Vecs[i] = txnbase.WindowVector(srcVec, int(start), int(end)+1)
 	}
 	return ret, nil
 }
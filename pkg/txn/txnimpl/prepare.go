@@ -0,0 +1,401 @@
+package txnimpl
+
+import (
+	"encoding/binary"
+	"sort"
+	"tae/pkg/catalog"
+	"tae/pkg/iface/data"
+	"tae/pkg/iface/handle"
+	"tae/pkg/iface/txnif"
+	"tae/pkg/txn/txnbase"
+	"tae/pkg/updates"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/jiangxinmeng1/logstore/pkg/entry"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/common"
+	"github.com/sirupsen/logrus"
+)
+
+func (store *txnStore) isPrepared() bool {
+	return !store.txn.GetPrepareTS().IsEmpty()
+}
+
+// validate checks the read set and the deduped keys of the txn against the
+// txns committed after it started
+func (store *txnStore) validate() (err error) {
+	if store.warChecker != nil {
+		if err = store.warChecker.checkReadSet(); err != nil {
+			return
+		}
+	}
+	for _, table := range store.tables {
+		if err = table.CheckSchema(); err != nil {
+			return
+		}
+		if err = table.PreCommitDedup(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// PrePrepare validates the txn as on commit. A prepared txn is not validated
+// again when it commits
+func (store *txnStore) PrePrepare() (err error) {
+	// The prepare record does not carry the schema versions
+	for _, table := range store.tables {
+		if table.IsAltered() {
+			return ErrPrepareAlter
+		}
+	}
+	if err = store.validate(); err != nil {
+		return
+	}
+	if store.warChecker != nil {
+		err = store.warChecker.check()
+	}
+	return
+}
+
+// LogPrepare registers the keys appended by the txn to be deduped by the other
+// txns and logs the local changes of the txn, so it can be redone on restart
+func (store *txnStore) LogPrepare() (err error) {
+	for _, table := range store.tables {
+		table.AddPrepared()
+	}
+	if store.driver == nil {
+		return
+	}
+	cmd := txnbase.NewTxnCmd(store.txn.GetID(), store.txn.GetStartTS(), store.txn.GetPrepareTS())
+	if store.database != nil {
+		op := PreparedUse
+		if store.createEntry != nil {
+			op = PreparedCreate
+		} else if store.dropEntry != nil {
+			op = PreparedDrop
+		}
+		cmd.AddCmd(NewPreparedDBCmd(uint32(len(cmd.Cmds)), op, store.database.GetName()))
+	}
+	// The tables are redone by name, so a dropped table goes before a table
+	// created with the same name
+	created := make([]txnif.TxnCmd, 0)
+	for _, table := range store.tables {
+		var tableCmd *PreparedTableCmd
+		if tableCmd, err = table.MakePrepareCmd(uint32(len(cmd.Cmds))); err != nil {
+			return
+		}
+		if tableCmd == nil {
+			continue
+		}
+		if tableCmd.Op == PreparedCreate {
+			created = append(created, tableCmd)
+			continue
+		}
+		cmd.AddCmd(tableCmd)
+	}
+	for _, tableCmd := range created {
+		cmd.AddCmd(tableCmd)
+	}
+	buf, err := cmd.Marshal()
+	if err != nil {
+		return
+	}
+	return store.logEntry(ETTxnPrepare, buf)
+}
+
+// ApplyPrepare waits until the prepare record is durable
+func (store *txnStore) ApplyPrepare() (err error) {
+	for _, e := range store.logs {
+		e.WaitDone()
+		e.Free()
+	}
+	store.logs = store.logs[:0]
+	return
+}
+
+// logAbortPrepared logs that the prepared txn is aborted, so it is not redone
+// on restart
+func (store *txnStore) logAbortPrepared() (err error) {
+	if store.driver == nil {
+		return
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, store.txn.GetID())
+	return store.logEntry(ETTxnAbortPrepared, buf)
+}
+
+func (store *txnStore) logEntry(typ uint16, buf []byte) (err error) {
+	e := entry.GetBase()
+	e.SetType(typ)
+	if err = e.Unmarshal(buf); err != nil {
+		return
+	}
+	if _, err = store.driver.AppendEntry(txnbase.GroupC, e); err != nil {
+		return
+	}
+	store.logs = append(store.logs, e)
+	return
+}
+
+func (tbl *txnTable) isPrepared() bool {
+	return !tbl.txn.GetPrepareTS().IsEmpty()
+}
+
+// AddPrepared registers the keys appended by the prepared txn to the table.
+// They are deduped by the other txns until the txn ends
+func (tbl *txnTable) AddPrepared() {
+	if tbl.index.Count() == 0 || tbl.entry.GetTableData() == nil {
+		return
+	}
+	tbl.entry.GetTableData().AddPrepared(tbl.txn.GetID(), tbl.index)
+}
+
+func (tbl *txnTable) removePrepared() {
+	if !tbl.isPrepared() || tbl.entry.GetTableData() == nil {
+		return
+	}
+	tbl.entry.GetTableData().RemovePrepared(tbl.txn.GetID())
+}
+
+// MakePrepareCmd makes the command to redo the changes of the txn to the
+// table. A nil command is returned if there is nothing to redo
+func (tbl *txnTable) MakePrepareCmd(id uint32) (cmd *PreparedTableCmd, err error) {
+	tbl.entry.RLock()
+	createAndDrop := tbl.entry.CreateAndDropInSameTxn()
+	tbl.entry.RUnlock()
+	if createAndDrop || (tbl.createEntry != nil && tbl.dropEntry != nil) {
+		return
+	}
+	op := PreparedUse
+	var schema *catalog.Schema
+	if tbl.createEntry != nil {
+		op = PreparedCreate
+		schema = tbl.GetSchema()
+	} else if tbl.dropEntry != nil {
+		op = PreparedDrop
+	}
+	cmd = NewPreparedTableCmd(id, op, tbl.GetSchema().Name, schema)
+	for i, node := range tbl.inodes {
+		h := tbl.nodesMgr.Pin(node)
+		if h == nil {
+			panic("not expected")
+		}
+		var appendCmd txnif.TxnCmd
+		appendCmd, _, err = node.MakeCommand(uint32(i), false)
+		h.Close()
+		if err != nil {
+			return
+		}
+		if appendCmd != nil {
+			cmd.AddCmd(appendCmd)
+		}
+	}
+	for _, node := range tbl.updateNodes {
+		var updateCmd txnif.TxnCmd
+		if updateCmd, _, err = node.MakeCommand(uint32(len(cmd.Cmds)), false); err != nil {
+			return
+		}
+		cmd.AddCmd(updateCmd)
+	}
+	return
+}
+
+// RedoUpdates merges the updates of a prepared txn logged before a restart
+// into the updates of the txn
+func (tbl *txnTable) RedoUpdates(blkUpdates *updates.BlockUpdates) (err error) {
+	node, err := tbl.getOrSetUpdateNode(blkUpdates.GetID())
+	if err != nil {
+		return
+	}
+	chain := node.GetChain()
+	chain.Lock()
+	defer chain.Unlock()
+	node.Lock()
+	defer node.Unlock()
+	return node.MergeLocked(blkUpdates)
+}
+
+// applyPreparedInode appends the rows of a prepared txn to blocks created by
+// the txn. The commit ts decided by the coordinator may be less than the ts
+// of the rows appended to the shared blocks since the txn was prepared, and
+// the rows of a block are visible by offset
+func (tbl *txnTable) applyPreparedInode(node InsertNode) (err error) {
+	appended := uint32(0)
+	// applyOne appends the next rows of node to a block and closes the appender
+	// whatever the result
+	applyOne := func() (toAppend, destOff uint32, id *common.ID, err error) {
+		appender, err := tbl.getPreparedAppender()
+		if err != nil {
+			return
+		}
+		defer appender.Close()
+		id = appender.GetID()
+		if toAppend, err = appender.PrepareAppend(node.Rows() - appended); err != nil {
+			return
+		}
+		bat, err := node.Window(appended, appended+toAppend-1)
+		if err != nil {
+			return
+		}
+		destOff, err = appender.ApplyAppend(bat, 0, toAppend, tbl.txn)
+		return
+	}
+	for appended < node.Rows() {
+		var toAppend, destOff uint32
+		var id *common.ID
+		if toAppend, destOff, id, err = applyOne(); err != nil {
+			return
+		}
+		tbl.appended[*id] = destOff + toAppend - 1
+		info := node.AddApplyInfo(appended, toAppend, destOff, toAppend, id)
+		logrus.Debug(info.String())
+		if err = tbl.applyLocalDeletes(node, appended, toAppend, destOff, id); err != nil {
+			return
+		}
+		appended += toAppend
+	}
+	return
+}
+
+// getPreparedAppender returns an appender of the last block created by the
+// txn, creating a new block if it is full. The block is never set as the
+// appender of the table, so no other txn appends to it
+func (tbl *txnTable) getPreparedAppender() (appender data.BlockAppender, err error) {
+	if len(tbl.cblks) > 0 {
+		appender, err = tbl.cblks[len(tbl.cblks)-1].GetBlockData().MakeAppender()
+		if err != data.ErrNotAppendable {
+			return
+		}
+	}
+	var blk handle.Block
+	maxBlocks := int(tbl.GetSchema().SegmentMaxBlocks)
+	if len(tbl.csegs) > 0 && tbl.csegs[len(tbl.csegs)-1].GetAppendableBlockCnt() < maxBlocks {
+		blk, err = tbl.CreateBlock(tbl.csegs[len(tbl.csegs)-1].GetID())
+	} else {
+		var seg handle.Segment
+		if seg, err = tbl.CreateSegment(); err != nil {
+			return
+		}
+		blk, err = seg.CreateBlock()
+	}
+	if err != nil {
+		return
+	}
+	return blk.GetMeta().(*catalog.BlockEntry).GetBlockData().MakeAppender()
+}
+
+// RecoverPrepared redoes the txns prepared but not ended before the restart.
+// They are prepared again and wait for their coordinator to decide
+func (replayer *Replayer) RecoverPrepared(mgr *txnbase.TxnManager) (err error) {
+	records := make([]*txnbase.TxnCmd, 0, len(replayer.prepared))
+	for _, record := range replayer.prepared {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].TxnID < records[j].TxnID
+	})
+	for _, record := range records {
+		txn := mgr.RestoreTxn(record.TxnID, record.StartTS, nil)
+		store := txn.GetStore().(*txnStore)
+		if err = replayer.redoPrepared(store, record); err != nil {
+			return
+		}
+		txn.Lock()
+		err = txn.ToPreparedLocked(record.CommitTS)
+		txn.Unlock()
+		if err != nil {
+			return
+		}
+		for _, table := range store.tables {
+			table.AddPrepared()
+		}
+		logrus.Infof("Recovered prepared %s", txn.String())
+	}
+	replayer.prepared = nil
+	return
+}
+
+func (replayer *Replayer) redoPrepared(store *txnStore, record *txnbase.TxnCmd) (err error) {
+	for _, cmd := range record.Cmds {
+		switch c := cmd.(type) {
+		case *PreparedDBCmd:
+			switch c.Op {
+			case PreparedCreate:
+				_, err = store.CreateDatabase(c.Name)
+			case PreparedDrop:
+				_, err = store.DropDatabase(c.Name)
+			default:
+				_, err = store.GetDatabase(c.Name)
+			}
+		case *PreparedTableCmd:
+			err = replayer.redoPreparedTable(store, c)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// redoPreparedTable redoes the changes of a prepared txn to a table. The rows
+// appended and then deleted by the txn are skipped
+func (replayer *Replayer) redoPreparedTable(store *txnStore, cmd *PreparedTableCmd) (err error) {
+	var rel handle.Relation
+	if cmd.Op == PreparedCreate {
+		rel, err = store.CreateRelation(cmd.Schema)
+	} else {
+		rel, err = store.GetRelationByName(cmd.Name)
+	}
+	if err != nil {
+		return
+	}
+	table, err := store.getOrSetTable(rel.ID())
+	if err != nil {
+		return
+	}
+	attrs := table.GetSchema().Attrs()
+	for _, subCmd := range cmd.Cmds {
+		switch c := subCmd.(type) {
+		case *AppendCmd:
+			var batCmd *txnbase.BatchCmd
+			var deletes *roaring.Bitmap
+			if batCmd, deletes, err = replayer.loadAppend(c); err != nil {
+				return
+			}
+			rows := uint32(batCmd.Bat.Length())
+			if rows == 0 {
+				continue
+			}
+			bat, err := windowBatch(attrs, batCmd.Bat, 0, rows-1)
+			if err != nil {
+				return err
+			}
+			if deletes != nil && !deletes.IsEmpty() {
+				sels := make([]int64, 0, rows)
+				for row := uint32(0); row < rows; row++ {
+					if !deletes.Contains(row) {
+						sels = append(sels, int64(row))
+					}
+				}
+				if len(sels) == 0 {
+					continue
+				}
+				for _, vec := range bat.Vecs {
+					gvec.Shrink(vec, sels)
+				}
+			}
+			if err = table.Append(bat); err != nil {
+				return err
+			}
+		case *updates.UpdateCmd:
+			if err = table.RedoUpdates(c.GetUpdates()); err != nil {
+				return
+			}
+		}
+	}
+	if cmd.Op == PreparedDrop {
+		_, err = store.DropRelationByName(cmd.Name)
+	}
+	return
+}
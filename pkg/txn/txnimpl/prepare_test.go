@@ -0,0 +1,115 @@
+package txnimpl
+
+import (
+	"tae/pkg/catalog"
+	"tae/pkg/iface/handle"
+	"tae/pkg/txn/txnbase"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxnPrepare(t *testing.T) {
+	schema := catalog.MockSchema(2)
+	schema.BlockMaxRows = 1000
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, schema)
+	defer tbl.close()
+	countRows := func() int {
+		txn := tbl.mgr.StartTxn(nil)
+		defer txn.Commit()
+		return tbl.countRows(txn)
+	}
+	{
+		txn := tbl.mgr.StartTxn(nil)
+		assert.Nil(t, tbl.appendRows(txn, 0, 10))
+		assert.Equal(t, txnbase.ErrTxnNotPrepared, txn.CommitPrepared(0))
+		ts, err := txn.Prepare()
+		assert.Nil(t, err)
+		assert.Equal(t, txnbase.ErrTxnPrepared, txn.BeginOp())
+		assert.Equal(t, txnbase.ErrTxnPrepared, txn.Commit())
+		assert.Equal(t, txnbase.ErrTxnPrepared, txn.Rollback())
+		assert.Equal(t, 1, len(tbl.mgr.PreparedTxns()))
+
+		// The keys of the prepared txn are deduped by the other txns
+		txn2 := tbl.mgr.StartTxn(nil)
+		assert.Equal(t, txnbase.ErrDuplicated, tbl.appendRows(txn2, 5, 6))
+		assert.Nil(t, tbl.appendRows(txn2, 10, 20))
+		assert.Nil(t, txn2.Commit())
+		assert.Equal(t, 10, countRows())
+
+		assert.Equal(t, txnbase.ErrStaleCommitTS, txn.CommitPrepared(ts-1))
+		assert.Nil(t, txn.CommitPrepared(ts))
+		assert.Equal(t, 20, countRows())
+		assert.Equal(t, 0, len(tbl.mgr.PreparedTxns()))
+	}
+	{
+		txn := tbl.mgr.StartTxn(nil)
+		assert.Nil(t, tbl.appendRows(txn, 20, 30))
+		assert.Equal(t, txnbase.ErrTxnNotPrepared, txn.AbortPrepared())
+		_, err := txn.Prepare()
+		assert.Nil(t, err)
+		assert.Nil(t, txn.AbortPrepared())
+		assert.Equal(t, txnbase.ErrTxnNotPrepared, txn.CommitPrepared(0))
+
+		txn = tbl.mgr.StartTxn(nil)
+		assert.Nil(t, tbl.appendRows(txn, 20, 30))
+		assert.Nil(t, txn.Commit())
+		assert.Equal(t, 30, countRows())
+	}
+	// A txn cannot be prepared if it conflicts with a committed txn
+	{
+		txn := tbl.mgr.StartTxn(nil)
+		assert.Nil(t, tbl.getRelation(txn).BatchDedup(tbl.operand(30)))
+		txn2 := tbl.mgr.StartTxn(nil)
+		assert.Nil(t, tbl.appendRows(txn2, 30, 31))
+		assert.Nil(t, txn2.Commit())
+		_, err := txn.Prepare()
+		assert.Equal(t, txnbase.ErrDuplicated, err)
+		assert.Equal(t, txnbase.ErrTxnNotPrepared, txn.AbortPrepared())
+		assert.Equal(t, 0, len(tbl.mgr.PreparedTxns()))
+	}
+}
+
+// A txn started after a txn is prepared sees it as an active txn, so it does
+// not see it committed at a coordinator ts below its start ts either
+func TestCommitPreparedAfterRead(t *testing.T) {
+	schema := catalog.MockSchema(2)
+	schema.BlockMaxRows = 10
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, schema)
+	defer tbl.close()
+	{
+		txn := tbl.mgr.StartTxn(nil)
+		assert.Nil(t, tbl.appendRows(txn, 0, 5))
+		assert.Nil(t, txn.Commit())
+	}
+
+	txn := tbl.mgr.StartTxn(nil)
+	assert.Nil(t, tbl.appendRows(txn, 5, 10))
+	assert.Nil(t, tbl.getRelation(txn).DeleteByFilter(handle.Filter{Op: handle.FilterEq, Col: tbl.operand(0)}))
+	ts, err := txn.Prepare()
+	assert.Nil(t, err)
+
+	reader := tbl.mgr.StartTxn(nil)
+	assert.True(t, reader.GetStartTS() > ts)
+	assert.ElementsMatch(t, []int32{0, 1, 2, 3, 4}, tbl.readCol(reader, 0))
+
+	// The coordinator decides the prepare ts
+	assert.Nil(t, txn.CommitPrepared(ts))
+	assert.True(t, txn.GetCommitTS() > reader.GetStartTS())
+	assert.ElementsMatch(t, []int32{0, 1, 2, 3, 4}, tbl.readCol(reader, 0))
+	assert.Nil(t, reader.Commit())
+
+	reader = tbl.mgr.StartTxn(nil)
+	assert.ElementsMatch(t, []int32{1, 2, 3, 4, 5, 6, 7, 8, 9}, tbl.readCol(reader, 0))
+	assert.Nil(t, reader.Commit())
+
+	// Nothing started after the prepare, so the coordinator ts is kept
+	txn = tbl.mgr.StartTxn(nil)
+	assert.Nil(t, tbl.appendRows(txn, 10, 15))
+	ts, err = txn.Prepare()
+	assert.Nil(t, err)
+	assert.Nil(t, txn.CommitPrepared(ts+10))
+	assert.Equal(t, ts+10, txn.GetCommitTS())
+}
@@ -0,0 +1,181 @@
+package txnimpl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"tae/pkg/catalog"
+	"tae/pkg/common"
+	"tae/pkg/iface/txnif"
+	"tae/pkg/txn/txnbase"
+)
+
+// The changes of a prepared txn to a database or a table
+const (
+	PreparedUse uint8 = iota
+	PreparedCreate
+	PreparedDrop
+)
+
+func init() {
+	txnif.RegisterCmdFactory(CmdPreparedDB, func(int16) txnif.TxnCmd {
+		return NewPreparedDBCmd(0, PreparedUse, "")
+	})
+	txnif.RegisterCmdFactory(CmdPreparedTable, func(int16) txnif.TxnCmd {
+		return NewPreparedTableCmd(0, PreparedUse, "", nil)
+	})
+}
+
+// PreparedDBCmd is the database used by a prepared txn. It is logged by name
+// as the txn is redone from scratch if it is replayed
+type PreparedDBCmd struct {
+	*txnbase.BaseCustomizedCmd
+	Op   uint8
+	Name string
+}
+
+func NewPreparedDBCmd(id uint32, op uint8, name string) *PreparedDBCmd {
+	impl := &PreparedDBCmd{
+		Op:   op,
+		Name: name,
+	}
+	impl.BaseCustomizedCmd = txnbase.NewBaseCustomizedCmd(id, impl)
+	return impl
+}
+
+func (c *PreparedDBCmd) GetType() int16 { return CmdPreparedDB }
+
+func (c *PreparedDBCmd) String() string {
+	return fmt.Sprintf("PreparedDBCmd: ID=%d, Op=%d, Name=%s", c.ID, c.Op, c.Name)
+}
+
+func (c *PreparedDBCmd) WriteTo(w io.Writer) (err error) {
+	if err = binary.Write(w, binary.BigEndian, c.GetType()); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, c.ID); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, c.Op); err != nil {
+		return
+	}
+	_, err = common.WriteString(c.Name, w)
+	return
+}
+
+func (c *PreparedDBCmd) ReadFrom(r io.Reader) (err error) {
+	if err = binary.Read(r, binary.BigEndian, &c.ID); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &c.Op); err != nil {
+		return
+	}
+	c.Name, err = common.ReadString(r)
+	return
+}
+
+func (c *PreparedDBCmd) Marshal() (buf []byte, err error) {
+	var bbuf bytes.Buffer
+	if err = c.WriteTo(&bbuf); err != nil {
+		return
+	}
+	buf = bbuf.Bytes()
+	return
+}
+
+func (c *PreparedDBCmd) Unmarshal(buf []byte) error {
+	return c.ReadFrom(bytes.NewBuffer(buf))
+}
+
+// PreparedTableCmd is a table changed by a prepared txn. It is composed of the
+// local rows appended by the txn and its updates of the committed blocks
+type PreparedTableCmd struct {
+	*txnbase.BaseCustomizedCmd
+	txnbase.ComposedCmd
+	Op     uint8
+	Name   string
+	Schema *catalog.Schema
+}
+
+func NewPreparedTableCmd(id uint32, op uint8, name string, schema *catalog.Schema) *PreparedTableCmd {
+	impl := &PreparedTableCmd{
+		ComposedCmd: *txnbase.NewComposedCmd(),
+		Op:          op,
+		Name:        name,
+		Schema:      schema,
+	}
+	impl.BaseCustomizedCmd = txnbase.NewBaseCustomizedCmd(id, impl)
+	return impl
+}
+
+func (c *PreparedTableCmd) GetType() int16 { return CmdPreparedTable }
+
+func (c *PreparedTableCmd) String() string {
+	s := fmt.Sprintf("PreparedTableCmd: ID=%d, Op=%d, Name=%s", c.ID, c.Op, c.Name)
+	s = fmt.Sprintf("%s\n%s", s, c.ComposedCmd.ToString("\t"))
+	return s
+}
+
+func (c *PreparedTableCmd) WriteTo(w io.Writer) (err error) {
+	if err = binary.Write(w, binary.BigEndian, c.GetType()); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, c.ID); err != nil {
+		return
+	}
+	if err = binary.Write(w, binary.BigEndian, c.Op); err != nil {
+		return
+	}
+	if _, err = common.WriteString(c.Name, w); err != nil {
+		return
+	}
+	if c.Op == PreparedCreate {
+		var buf []byte
+		if buf, err = c.Schema.Marshal(); err != nil {
+			return
+		}
+		if _, err = w.Write(buf); err != nil {
+			return
+		}
+	}
+	err = c.ComposedCmd.WriteTo(w)
+	return
+}
+
+func (c *PreparedTableCmd) ReadFrom(r io.Reader) (err error) {
+	if err = binary.Read(r, binary.BigEndian, &c.ID); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &c.Op); err != nil {
+		return
+	}
+	if c.Name, err = common.ReadString(r); err != nil {
+		return
+	}
+	if c.Op == PreparedCreate {
+		c.Schema = catalog.NewEmptySchema(c.Name)
+		if err = c.Schema.ReadFrom(r); err != nil {
+			return
+		}
+	}
+	cmdType := int16(0)
+	if err = binary.Read(r, binary.BigEndian, &cmdType); err != nil {
+		return
+	}
+	err = c.ComposedCmd.ReadFrom(r)
+	return
+}
+
+func (c *PreparedTableCmd) Marshal() (buf []byte, err error) {
+	var bbuf bytes.Buffer
+	if err = c.WriteTo(&bbuf); err != nil {
+		return
+	}
+	buf = bbuf.Bytes()
+	return
+}
+
+func (c *PreparedTableCmd) Unmarshal(buf []byte) error {
+	return c.ReadFrom(bytes.NewBuffer(buf))
+}
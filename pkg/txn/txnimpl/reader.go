@@ -0,0 +1,102 @@
+package txnimpl
+
+import (
+	"bytes"
+	"tae/pkg/catalog"
+	"tae/pkg/iface/handle"
+	"tae/pkg/iface/txnif"
+
+	gbat "github.com/matrixorigin/matrixone/pkg/container/batch"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+)
+
+// relationReader reads the rows of a relation visible to the txn. It returns
+// one batch per committed block and then one batch per local insert node
+type relationReader struct {
+	txn      txnif.AsyncTxn
+	entry    *catalog.TableEntry
+	segIt    *segmentIt
+	blkIt    *blockIt
+	localPos int
+}
+
+func newRelationReader(txn txnif.AsyncTxn, entry *catalog.TableEntry) *relationReader {
+	return &relationReader{
+		txn:   txn,
+		entry: entry,
+		segIt: newSegmentIt(txn, entry),
+	}
+}
+
+// Next returns the next non-empty batch of attrs. A nil batch is returned if
+// all the visible rows were read. If ctx is a handle.Filter, the committed
+// blocks that cannot match it are skipped by their zone maps
+func (r *relationReader) Next(ctx interface{}, attrs []string) (bat *gbat.Batch, err error) {
+	if len(attrs) == 0 {
+		return
+	}
+	schema := r.entry.GetSchemaFor(r.txn)
+	for _, attr := range attrs {
+		if schema.GetColIdx(attr) < 0 {
+			return nil, catalog.ErrNotFound
+		}
+	}
+	for r.segIt.Valid() {
+		if r.blkIt == nil {
+			r.blkIt = newBlockIt(r.txn, r.segIt.curr)
+		}
+		if !r.blkIt.Valid() {
+			r.blkIt = nil
+			r.segIt.Next()
+			continue
+		}
+		entry := r.blkIt.curr
+		blk := r.blkIt.GetBlock()
+		r.blkIt.Next()
+		if filter, ok := ctx.(handle.Filter); ok {
+			var match bool
+			if match, err = entry.GetBlockData().MayMatch(r.txn, filter); err != nil {
+				return
+			}
+			if !match {
+				continue
+			}
+		}
+		if bat, err = readBlock(blk, attrs); err != nil || gvec.Length(bat.Vecs[0]) > 0 {
+			return
+		}
+	}
+	return r.nextLocal(attrs)
+}
+
+func (r *relationReader) nextLocal(attrs []string) (bat *gbat.Batch, err error) {
+	store, ok := r.txn.GetStore().(*txnStore)
+	if !ok {
+		return
+	}
+	table := store.tables[r.entry.GetID()]
+	if table == nil {
+		return
+	}
+	for {
+		if bat, err = table.GetLocalBatch(r.localPos, attrs); err != nil || bat == nil {
+			return
+		}
+		r.localPos++
+		if gvec.Length(bat.Vecs[0]) > 0 {
+			return
+		}
+	}
+}
+
+func readBlock(blk handle.Block, attrs []string) (bat *gbat.Batch, err error) {
+	bat = gbat.New(true, attrs)
+	for i, attr := range attrs {
+		var vec *gvec.Vector
+		if vec, err = blk.GetVectorCopy(attr, new(bytes.Buffer), new(bytes.Buffer)); err != nil {
+			return
+		}
+		bat.Vecs[i] = vec
+	}
+	return
+}
@@ -1,6 +1,7 @@
 package txnimpl
 
 import (
+	"reflect"
 	"tae/pkg/catalog"
 	"tae/pkg/iface/handle"
 	"tae/pkg/iface/txnif"
@@ -29,18 +30,70 @@ func (h *txnRelation) ID() uint64     { return h.entry.GetID() }
 func (h *txnRelation) String() string { return h.entry.String() }
 
 func (h *txnRelation) GetMeta() interface{}   { return h.entry }
-func (h *txnRelation) GetSchema() interface{} { return h.entry.GetSchema() }
-
-func (h *txnRelation) Close() error                        { return nil }
-func (h *txnRelation) Rows() int64                         { return 0 }
-func (h *txnRelation) Size(attr string) int64              { return 0 }
-func (h *txnRelation) GetCardinality(attr string) int64    { return 0 }
-func (h *txnRelation) MakeReader() handle.Reader           { return nil }
-func (h *txnRelation) BatchDedup(col *vector.Vector) error { return nil }
+func (h *txnRelation) GetSchema() interface{} { return h.entry.GetSchemaFor(h.Txn) }
+
+func (h *txnRelation) Close() error                     { return nil }
+func (h *txnRelation) Rows() int64                      { return 0 }
+func (h *txnRelation) Size(attr string) int64           { return 0 }
+func (h *txnRelation) GetCardinality(attr string) int64 { return 0 }
+func (h *txnRelation) MakeReader() handle.Reader        { return newRelationReader(h.Txn, h.entry) }
+
+func (h *txnRelation) BatchDedup(col *vector.Vector) error {
+	return h.Txn.GetStore().BatchDedup(h.entry.GetID(), col)
+}
+
 func (h *txnRelation) Append(data *batch.Batch) error {
 	return h.Txn.GetStore().Append(h.entry.GetID(), data)
 }
 
+func (h *txnRelation) AlterTable(req interface{}) error {
+	return h.Txn.GetStore().AlterTable(h.entry.GetID(), req)
+}
+
+// DeleteByFilter deletes the matched rows of the committed blocks and then
+// the matched local rows
+func (h *txnRelation) DeleteByFilter(filter handle.Filter) (err error) {
+	store, ok := h.Txn.GetStore().(*txnStore)
+	if !ok {
+		return
+	}
+	if err = store.checkWritable(); err != nil {
+		return
+	}
+	for it := newSegmentIt(h.Txn, h.entry); it.Valid(); it.Next() {
+		if err = it.GetSegment().PushDeleteOp(filter); err != nil {
+			return
+		}
+	}
+	return store.DeleteLocalByFilter(h.entry.GetID(), filter)
+}
+
+// UpdateByFilter updates the matched rows of the committed blocks and then
+// the matched local rows. v should be of the type of attr
+func (h *txnRelation) UpdateByFilter(filter handle.Filter, attr string, v interface{}) (err error) {
+	store, ok := h.Txn.GetStore().(*txnStore)
+	if !ok {
+		return
+	}
+	if err = store.checkWritable(); err != nil {
+		return
+	}
+	schema := h.entry.GetSchemaFor(h.Txn)
+	col := schema.GetColIdx(attr)
+	if col < 0 {
+		return catalog.ErrNotFound
+	}
+	if reflect.TypeOf(v) != reflect.TypeOf(txnbase.ZeroValue(schema.ColDefs[col].Type)) {
+		return catalog.ErrValidation
+	}
+	for it := newSegmentIt(h.Txn, h.entry); it.Valid(); it.Next() {
+		if err = it.GetSegment().PushUpdateOp(filter, attr, v); err != nil {
+			return
+		}
+	}
+	return store.UpdateLocalByFilter(h.entry.GetID(), filter, uint16(col), v)
+}
+
 func (h *txnRelation) CreateSegment() (seg handle.Segment, err error) {
 	return h.Txn.GetStore().CreateSegment(h.entry.GetID())
 }
@@ -0,0 +1,300 @@
+package txnimpl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"tae/pkg/catalog"
+	"tae/pkg/iface/txnif"
+	"tae/pkg/tables"
+	"tae/pkg/txn/txnbase"
+	"tae/pkg/updates"
+
+	"github.com/RoaringBitmap/roaring"
+	gbat "github.com/matrixorigin/matrixone/pkg/container/batch"
+	"github.com/sirupsen/logrus"
+)
+
+type Replayer struct {
+	catalog     *catalog.Catalog
+	driver      txnbase.NodeDriver
+	dataFactory *tables.DataFactory
+	records     [][]byte
+	lsns        []uint64
+	prepared    map[uint64]*txnbase.TxnCmd
+	maxTxnId    uint64
+	maxTs       txnif.TS
+}
+
+func NewReplayer(c *catalog.Catalog, driver txnbase.NodeDriver, dataFactory *tables.DataFactory) *Replayer {
+	return &Replayer{
+		catalog:     c,
+		driver:      driver,
+		dataFactory: dataFactory,
+		records:     make([][]byte, 0),
+		prepared:    make(map[uint64]*txnbase.TxnCmd),
+		maxTs:       c.GetCheckpointed(),
+	}
+}
+
+func (replayer *Replayer) GetMaxTxnID() uint64 { return replayer.maxTxnId }
+func (replayer *Replayer) GetMaxTS() txnif.TS  { return replayer.maxTs }
+
+func (replayer *Replayer) onReplayEntry(group uint32, commitId uint64, payload []byte, typ uint16, info interface{}) error {
+	if group != txnbase.GroupC {
+		return nil
+	}
+	switch typ {
+	case ETTxnRecord:
+		buf := make([]byte, len(payload))
+		copy(buf, payload)
+		replayer.records = append(replayer.records, buf)
+		// The rollback records are not tracked for truncation
+		replayer.lsns = append(replayer.lsns, 0)
+	case ETTxnGroup:
+		buf := make([]byte, len(payload))
+		copy(buf, payload)
+		records, err := unmarshalTxnGroup(buf)
+		if err != nil {
+			return err
+		}
+		replayer.records = append(replayer.records, records...)
+		for range records {
+			replayer.lsns = append(replayer.lsns, commitId)
+		}
+	case ETTxnPrepare:
+		buf := make([]byte, len(payload))
+		copy(buf, payload)
+		cmd, err := txnbase.BuildCommandFrom(bytes.NewBuffer(buf))
+		if err != nil {
+			return err
+		}
+		record := cmd.(*txnbase.TxnCmd)
+		if record.TxnID > replayer.maxTxnId {
+			replayer.maxTxnId = record.TxnID
+		}
+		if record.CommitTS > replayer.maxTs {
+			replayer.maxTs = record.CommitTS
+		}
+		replayer.prepared[record.TxnID] = record
+	case ETTxnAbortPrepared:
+		delete(replayer.prepared, binary.BigEndian.Uint64(payload))
+	}
+	return nil
+}
+
+// Replay collects all committed txn records first because GroupUC entries
+// can only be loaded after the underlying store finishes replaying
+func (replayer *Replayer) Replay() (err error) {
+	if err = replayer.driver.Replay(replayer.onReplayEntry); err != nil {
+		return
+	}
+	logRecords := make(map[uint64]*logRecord)
+	for i, buf := range replayer.records {
+		var cmd txnif.TxnCmd
+		if cmd, err = txnbase.BuildCommandFrom(bytes.NewBuffer(buf)); err != nil {
+			return
+		}
+		txnCmd := cmd.(*txnbase.TxnCmd)
+		if err = replayer.replayTxn(txnCmd); err != nil {
+			return
+		}
+		// The prepared txn was committed
+		delete(replayer.prepared, txnCmd.TxnID)
+		lsn := replayer.lsns[i]
+		if logRecords[lsn] == nil {
+			logRecords[lsn] = new(logRecord)
+		}
+		logRecords[lsn].add(txnCmd.Cmds, txnCmd.CommitTS)
+	}
+	if tracker, ok := replayer.driver.(*LogTracker); ok {
+		tracker.onReplayed()
+		for lsn, record := range logRecords {
+			tracker.onRecord(lsn, record)
+		}
+	}
+	logrus.Infof("Replayed %d txns: MaxTxnID=%d, MaxTS=%d", len(replayer.records), replayer.maxTxnId, replayer.maxTs)
+	replayer.records = nil
+	replayer.lsns = nil
+	return
+}
+
+func (replayer *Replayer) replayTxn(txnCmd *txnbase.TxnCmd) (err error) {
+	if txnCmd.TxnID > replayer.maxTxnId {
+		replayer.maxTxnId = txnCmd.TxnID
+	}
+	if txnCmd.CommitTS > replayer.maxTs {
+		replayer.maxTs = txnCmd.CommitTS
+	}
+	// Catalog entries committed before the catalog checkpoint are already
+	// replayed from the snapshot
+	checkpointed := txnCmd.CommitTS <= replayer.catalog.GetCheckpointed()
+	for _, cmd := range txnCmd.Cmds {
+		switch c := cmd.(type) {
+		case *AppendCmd:
+			err = replayer.replayAppend(c, txnCmd)
+		case *updates.UpdateCmd:
+			err = replayer.replayUpdate(c.GetUpdates(), txnCmd)
+		default:
+			if !checkpointed {
+				err = replayer.catalog.ReplayCmd(cmd, replayer.dataFactory)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (replayer *Replayer) replayAppend(cmd *AppendCmd, txnCmd *txnbase.TxnCmd) (err error) {
+	if len(cmd.Infos) == 0 {
+		return
+	}
+	batCmd, deletes, err := replayer.loadAppend(cmd)
+	if err != nil {
+		return
+	}
+	table, err := replayer.getTable(cmd.Infos[0].dest.TableID)
+	if err != nil {
+		return
+	}
+	for _, info := range cmd.Infos {
+		if err = replayer.replayAppendInfo(table, batCmd, info); err != nil {
+			return
+		}
+		if deletes == nil {
+			continue
+		}
+		blkDeletes := roaring.New()
+		it := deletes.Iterator()
+		for it.HasNext() {
+			row := it.Next()
+			if row >= info.srcOff && row < info.srcOff+info.srcLen {
+				blkDeletes.Add(row - info.srcOff + info.destOff)
+			}
+		}
+		if blkDeletes.IsEmpty() {
+			continue
+		}
+		blkUpdates := updates.NewEmptyBlockUpdates()
+		blkUpdates.SetLocalDeletes(blkDeletes)
+		if err = replayer.replayUpdateOn(info.dest.TableID, info.dest.SegmentID, info.dest.BlockID, blkUpdates, txnCmd); err != nil {
+			return err
+		}
+	}
+	return
+}
+
+func (replayer *Replayer) replayAppendInfo(table *catalog.TableEntry, batCmd *txnbase.BatchCmd, info *appendInfo) (err error) {
+	segment, err := table.GetSegmentByID(info.dest.SegmentID)
+	if err != nil {
+		return
+	}
+	// The rows are appended in the schema version of the segment
+	attrs := segment.GetSchema().Attrs()
+	block, err := segment.GetBlockEntryByID(info.dest.BlockID)
+	if err != nil {
+		return
+	}
+	// Rows already persisted in the block file are skipped
+	rows := uint32(block.GetBlockData().Rows(nil, true))
+	if rows >= info.destOff+info.destLen {
+		return
+	}
+	bat, err := windowBatch(attrs, batCmd.Bat, info.srcOff, info.srcOff+info.srcLen-1)
+	if err != nil {
+		return
+	}
+	appender, err := table.GetTableData().SetAppender(info.dest)
+	if err != nil {
+		return
+	}
+	defer appender.Close()
+	// The rows of the txns rolled back after appending are not logged. Dead
+	// rows are appended in their place to keep the offsets of the rows
+	for rows < info.destOff {
+		n := info.destOff - rows
+		if n > info.srcLen {
+			n = info.srcLen
+		}
+		var dead *gbat.Batch
+		if dead, err = windowBatch(attrs, batCmd.Bat, info.srcOff, info.srcOff+n-1); err != nil {
+			return
+		}
+		if _, err = appender.ApplyAppend(dead, 0, n, nil); err != nil {
+			return
+		}
+		if err = block.GetBlockData().RollbackAppend(rows, rows+n-1); err != nil {
+			return
+		}
+		rows += n
+	}
+	_, err = appender.ApplyAppend(bat, 0, info.srcLen, nil)
+	return
+}
+
+// loadAppend returns the rows of an append command and the rows deleted
+func (replayer *Replayer) loadAppend(cmd *AppendCmd) (batCmd *txnbase.BatchCmd, deletes *roaring.Bitmap, err error) {
+	for _, subCmd := range cmd.Cmds {
+		switch c := subCmd.(type) {
+		case *txnbase.BatchCmd:
+			batCmd = c
+		case *txnbase.PointerCmd:
+			if batCmd, err = replayer.loadBatch(c); err != nil {
+				return
+			}
+		case *txnbase.DeleteBitmapCmd:
+			deletes = c.Bitmap
+		}
+	}
+	return
+}
+
+func (replayer *Replayer) loadBatch(ptr *txnbase.PointerCmd) (batCmd *txnbase.BatchCmd, err error) {
+	e, err := replayer.driver.LoadEntry(ptr.Group, ptr.Lsn)
+	if err != nil {
+		return
+	}
+	cmd, err := txnbase.BuildCommandFrom(bytes.NewBuffer(e.GetPayload()))
+	if err != nil {
+		return
+	}
+	batCmd = cmd.(*txnbase.BatchCmd)
+	return
+}
+
+func (replayer *Replayer) replayUpdate(blkUpdates *updates.BlockUpdates, txnCmd *txnbase.TxnCmd) error {
+	id := blkUpdates.GetID()
+	return replayer.replayUpdateOn(id.TableID, id.SegmentID, id.BlockID, blkUpdates, txnCmd)
+}
+
+func (replayer *Replayer) replayUpdateOn(tableId, segmentId, blockId uint64, blkUpdates *updates.BlockUpdates, txnCmd *txnbase.TxnCmd) (err error) {
+	table, err := replayer.getTable(tableId)
+	if err != nil {
+		return
+	}
+	segment, err := table.GetSegmentByID(segmentId)
+	if err != nil {
+		return
+	}
+	block, err := segment.GetBlockEntryByID(blockId)
+	if err != nil {
+		return
+	}
+	chain := block.GetBlockData().GetUpdateChain().(*updates.BlockUpdateChain)
+	chain.AddReplayNode(blkUpdates, txnCmd.StartTS, txnCmd.CommitTS)
+	return
+}
+
+func (replayer *Replayer) getTable(id uint64) (table *catalog.TableEntry, err error) {
+	it := replayer.catalog.MakeDBIt(true)
+	for it.Valid() {
+		db := it.Get().GetPayload().(*catalog.DBEntry)
+		if table, err = db.GetTableEntryByID(id); err == nil {
+			return
+		}
+		it.Next()
+	}
+	err = catalog.ErrNotFound
+	return
+}
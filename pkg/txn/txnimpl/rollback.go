@@ -0,0 +1,126 @@
+package txnimpl
+
+import (
+	"tae/pkg/catalog"
+	"tae/pkg/iface/data"
+)
+
+// rollbackAppends marks the rows appended to the shared blocks on pre-commit
+// as dead, so they are never visible
+func (tbl *txnTable) rollbackAppends() (err error) {
+	for _, info := range tbl.applied {
+		var blk data.Block
+		if blk, err = tbl.getBlockData(info.dest); err != nil {
+			return
+		}
+		if err = blk.RollbackAppend(info.destOff, info.destOff+info.destLen-1); err != nil {
+			return
+		}
+	}
+	tbl.applied = nil
+	return
+}
+
+// prepareRollbackEntries removes the segments and blocks created by the txn
+// from the catalog. The ones created on pre-commit were set as the appenders
+// of the table and may be appended by other txns already, so they are
+// committed instead
+func (tbl *txnTable) prepareRollbackEntries() (err error) {
+	for _, blk := range tbl.cblks {
+		if tbl.isShared(blk) {
+			if err = blk.PrepareCommit(); err != nil {
+				return
+			}
+			continue
+		}
+		if err = blk.GetSegment().RemoveEntry(blk); err != nil {
+			return
+		}
+	}
+	for _, seg := range tbl.csegs {
+		if tbl.isShared(seg) {
+			if err = seg.PrepareCommit(); err != nil {
+				return
+			}
+			continue
+		}
+		if err = seg.GetTable().RemoveEntry(seg); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (tbl *txnTable) applyRollbackEntries() (err error) {
+	for _, seg := range tbl.sharedSegs {
+		if err = seg.ApplyCommit(); err != nil {
+			return
+		}
+	}
+	for _, blk := range tbl.sharedBlks {
+		if err = blk.ApplyCommit(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (tbl *txnTable) isShared(entry interface{}) bool {
+	switch e := entry.(type) {
+	case *catalog.SegmentEntry:
+		for _, seg := range tbl.sharedSegs {
+			if seg == e {
+				return true
+			}
+		}
+	case *catalog.BlockEntry:
+		for _, blk := range tbl.sharedBlks {
+			if blk == e {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CollectRollbackCmd collects the commands of the segments and blocks
+// committed on rollback
+func (tbl *txnTable) CollectRollbackCmd(cmdMgr *commandManager) (err error) {
+	for _, seg := range tbl.sharedSegs {
+		cmd, err := seg.MakeCommand(uint32(cmdMgr.GetCSN()))
+		if err != nil {
+			return err
+		}
+		cmdMgr.AddCmd(cmd)
+	}
+	for _, blk := range tbl.sharedBlks {
+		cmd, err := blk.MakeCommand(uint32(cmdMgr.GetCSN()))
+		if err != nil {
+			return err
+		}
+		cmdMgr.AddCmd(cmd)
+	}
+	return
+}
+
+// logRollback logs the segments and blocks committed on rollback, as the
+// rows of the other txns appended to them are replayed on restart
+func (store *txnStore) logRollback() (err error) {
+	if store.driver == nil {
+		return
+	}
+	cmdMgr := newCommandManager(store.driver)
+	for _, table := range store.tables {
+		if err = table.CollectRollbackCmd(cmdMgr); err != nil {
+			return
+		}
+	}
+	if cmdMgr.GetCSN() == 0 {
+		return
+	}
+	buf, err := cmdMgr.MakeTxnRecord(store.txn)
+	if err != nil {
+		return
+	}
+	return store.logEntry(ETTxnRecord, buf)
+}
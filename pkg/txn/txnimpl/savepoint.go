@@ -0,0 +1,255 @@
+package txnimpl
+
+import (
+	"tae/pkg/catalog"
+	"tae/pkg/iface/handle"
+	"tae/pkg/iface/txnif"
+	"tae/pkg/txn/txnbase"
+	"tae/pkg/updates"
+
+	"github.com/RoaringBitmap/roaring"
+	gbat "github.com/matrixorigin/matrixone/pkg/container/batch"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/common"
+)
+
+// tableSavepoint is the local state of a txnTable at a savepoint. A zero
+// tableSavepoint is the state of a table not touched yet
+type tableSavepoint struct {
+	rows        uint32
+	inodes      int
+	deletes     []*roaring.Bitmap
+	index       int
+	updates     map[common.ID]*updates.UpdatesSnapshot
+	csegs       int
+	cblks       int
+	dedupCols   int
+	createEntry txnif.TxnEntry
+	dropEntry   txnif.TxnEntry
+	altered     *catalog.Schema
+}
+
+type storeSavepoint struct {
+	tables      map[uint64]*tableSavepoint
+	symbols     map[string]bool
+	database    handle.Database
+	createEntry txnif.TxnEntry
+	dropEntry   txnif.TxnEntry
+	// renamed is the name given to the database of the txn, if any
+	renamed string
+}
+
+func (tbl *txnTable) Savepoint() *tableSavepoint {
+	sp := &tableSavepoint{
+		rows:        tbl.rows,
+		inodes:      len(tbl.inodes),
+		deletes:     make([]*roaring.Bitmap, len(tbl.inodes)),
+		index:       tbl.index.Savepoint(),
+		updates:     make(map[common.ID]*updates.UpdatesSnapshot),
+		csegs:       len(tbl.csegs),
+		cblks:       len(tbl.cblks),
+		dedupCols:   len(tbl.dedupCols),
+		createEntry: tbl.createEntry,
+		dropEntry:   tbl.dropEntry,
+	}
+	if tbl.altered {
+		sp.altered = tbl.schema
+	}
+	for i, node := range tbl.inodes {
+		sp.deletes[i] = node.CloneDeletes()
+	}
+	for id, node := range tbl.updateNodes {
+		sp.updates[id] = node.Snapshot()
+	}
+	return sp
+}
+
+func (tbl *txnTable) RollbackToSavepoint(sp *tableSavepoint) (err error) {
+	if err = tbl.rollbackInserts(sp); err != nil {
+		return
+	}
+	tbl.index.RollbackToSavepoint(sp.index)
+	for id, node := range tbl.updateNodes {
+		if snap := sp.updates[id]; snap != nil {
+			node.Restore(snap)
+			continue
+		}
+		if err = node.ApplyRollback(); err != nil {
+			return
+		}
+		delete(tbl.updateNodes, id)
+	}
+	for _, blk := range tbl.cblks[sp.cblks:] {
+		if err = blk.GetSegment().RemoveEntry(blk); err != nil {
+			return
+		}
+	}
+	tbl.cblks = tbl.cblks[:sp.cblks]
+	for _, seg := range tbl.csegs[sp.csegs:] {
+		if err = seg.GetTable().RemoveEntry(seg); err != nil {
+			return
+		}
+	}
+	tbl.csegs = tbl.csegs[:sp.csegs]
+	tbl.dedupCols = tbl.dedupCols[:sp.dedupCols]
+	if tbl.altered && tbl.schema != sp.altered {
+		if sp.altered == nil {
+			tbl.entry.RollbackAlter(tbl.txn)
+			tbl.schema = tbl.entry.GetSchemaFor(tbl.txn)
+			tbl.altered = false
+		} else {
+			tbl.entry.RestoreAlter(tbl.txn, sp.altered)
+			tbl.schema = sp.altered
+		}
+	}
+
+	if tbl.createEntry != nil && sp.createEntry == nil {
+		if err = tbl.entry.GetDB().RemoveEntry(tbl.entry); err != nil {
+			return
+		}
+		tbl.createEntry = nil
+		tbl.dropEntry = nil
+	} else if tbl.dropEntry != nil && sp.dropEntry == nil {
+		tbl.entry.Lock()
+		tbl.entry.UndoDropLocked()
+		tbl.entry.Unlock()
+		tbl.dropEntry = nil
+	}
+	return
+}
+
+// rollbackInserts drops the insert nodes registered after the savepoint and
+// the rows appended to the last one kept
+func (tbl *txnTable) rollbackInserts(sp *tableSavepoint) (err error) {
+	if tbl.appendable != nil {
+		tbl.appendable.Close()
+		tbl.appendable = nil
+	}
+	for _, node := range tbl.inodes[sp.inodes:] {
+		if err = node.Close(); err != nil {
+			return
+		}
+	}
+	tbl.inodes = tbl.inodes[:sp.inodes]
+	tbl.rows = sp.rows
+	if sp.inodes > 0 {
+		last := tbl.inodes[sp.inodes-1]
+		rows := sp.rows - uint32(sp.inodes-1)*txnbase.MaxNodeRows
+		if rows < last.Rows() {
+			if last, err = tbl.replaceInsertNode(sp.inodes-1, rows); err != nil {
+				return
+			}
+		}
+		tbl.appendable = tbl.nodesMgr.Pin(last)
+	}
+	// The savepoint is kept, so the nodes get copies of its deletes
+	for i, node := range tbl.inodes {
+		var deletes *roaring.Bitmap
+		if sp.deletes[i] != nil {
+			deletes = sp.deletes[i].Clone()
+		}
+		node.SetDeletes(deletes)
+	}
+	return
+}
+
+// replaceInsertNode replaces the pos-th insert node with a new one holding its
+// first rows, so the buffer taken by the other rows is released
+func (tbl *txnTable) replaceInsertNode(pos int, rows uint32) (node InsertNode, err error) {
+	old := tbl.inodes[pos]
+	var window *gbat.Batch
+	if rows > 0 {
+		h := tbl.nodesMgr.Pin(old)
+		window, err = old.Window(0, rows-1)
+		h.Close()
+		if err != nil {
+			return
+		}
+	}
+	if err = old.Close(); err != nil {
+		return
+	}
+	n := NewInsertNode(tbl, tbl.nodesMgr, old.GetID(), tbl.driver)
+	tbl.inodes[pos] = n
+	node = n
+	if window == nil {
+		return
+	}
+	h := tbl.nodesMgr.Pin(n)
+	defer h.Close()
+	err = n.Expand(txnbase.EstimateSize(window, 0, rows), func() error {
+		_, err := n.Append(window, 0)
+		return err
+	})
+	return
+}
+
+// Savepoint returns the id of a savepoint of all the local changes
+func (store *txnStore) Savepoint() int {
+	sp := &storeSavepoint{
+		tables:      make(map[uint64]*tableSavepoint),
+		database:    store.database,
+		createEntry: store.createEntry,
+		dropEntry:   store.dropEntry,
+	}
+	if store.renameEntry != nil {
+		sp.renamed = store.renameEntry.GetNameFor(store.txn)
+	}
+	for id, table := range store.tables {
+		sp.tables[id] = table.Savepoint()
+	}
+	if store.warChecker != nil {
+		sp.symbols = store.warChecker.copySymbols()
+	}
+	store.savepoints = append(store.savepoints, sp)
+	return len(store.savepoints) - 1
+}
+
+func (store *txnStore) RollbackToSavepoint(id int) (err error) {
+	if id < 0 || id >= len(store.savepoints) {
+		return txnbase.ErrSavepointNotFound
+	}
+	sp := store.savepoints[id]
+	for tid, table := range store.tables {
+		if tsp := sp.tables[tid]; tsp != nil {
+			if err = table.RollbackToSavepoint(tsp); err != nil {
+				return
+			}
+			continue
+		}
+		if err = table.RollbackToSavepoint(new(tableSavepoint)); err != nil {
+			return
+		}
+		if err = table.Close(); err != nil {
+			return
+		}
+		delete(store.tables, tid)
+	}
+	if store.createEntry != nil && sp.createEntry == nil {
+		if err = store.catalog.RemoveEntry(store.createEntry.(*catalog.DBEntry)); err != nil {
+			return
+		}
+		store.createEntry = nil
+		// All the entries read by the checker were in the removed database
+		store.warChecker = nil
+	} else if store.dropEntry != nil && sp.dropEntry == nil {
+		db := store.dropEntry.(*catalog.DBEntry)
+		db.Lock()
+		db.UndoDropLocked()
+		db.Unlock()
+		store.dropEntry = nil
+	}
+	if store.renameEntry != nil {
+		if sp.renamed == "" {
+			store.renameEntry.RollbackRename(store.txn)
+			store.renameEntry = nil
+		} else {
+			store.renameEntry.RestoreRename(store.txn, sp.renamed)
+		}
+	}
+	if store.warChecker != nil {
+		store.warChecker.restoreSymbols(sp.symbols)
+	}
+	store.database = sp.database
+	store.savepoints = store.savepoints[:id+1]
+	return
+}
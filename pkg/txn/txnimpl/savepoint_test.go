@@ -0,0 +1,96 @@
+package txnimpl
+
+import (
+	"tae/pkg/catalog"
+	"tae/pkg/txn/txnbase"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSavepoint(t *testing.T) {
+	schema := catalog.MockSchema(2)
+	schema.BlockMaxRows = 1000
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, schema)
+	defer tbl.close()
+	{
+		txn := tbl.mgr.StartTxn(nil)
+		sp0, err := txn.Savepoint()
+		assert.Nil(t, err)
+		db, err := txn.CreateDatabase("db2")
+		assert.Nil(t, err)
+		_, err = db.CreateRelation(catalog.MockSchema(2))
+		assert.Nil(t, err)
+		// The database and the table created after the savepoint are removed
+		assert.Nil(t, txn.RollbackToSavepoint(sp0))
+		_, err = txn.GetDatabase("db2")
+		assert.Equal(t, catalog.ErrNotFound, err)
+
+		assert.Nil(t, tbl.appendRows(txn, 0, 10))
+		sp1, err := txn.Savepoint()
+		assert.Nil(t, err)
+		assert.Nil(t, tbl.appendRows(txn, 10, 20))
+		assert.Equal(t, txnbase.ErrDuplicated, tbl.appendRows(txn, 15, 16))
+		assert.Nil(t, txn.RollbackToSavepoint(sp1))
+		// The keys appended after the savepoint can be appended again
+		assert.Nil(t, tbl.appendRows(txn, 15, 30))
+		assert.Equal(t, txnbase.ErrDuplicated, tbl.appendRows(txn, 5, 6))
+		assert.Nil(t, txn.RollbackToSavepoint(sp1))
+		assert.Equal(t, txnbase.ErrSavepointNotFound, txn.RollbackToSavepoint(sp1+1))
+		assert.Nil(t, txn.Commit())
+	}
+	{
+		txn := tbl.mgr.StartTxn(nil)
+		assert.Equal(t, 10, tbl.countRows(txn))
+		blk := tbl.firstBlock(txn)
+		assert.Nil(t, blk.RangeDelete(0, 1))
+		sp, err := txn.Savepoint()
+		assert.Nil(t, err)
+		assert.Nil(t, blk.RangeDelete(2, 4))
+		assert.Nil(t, blk.Update(5, 1, int32(100)))
+		assert.Equal(t, 5, tbl.countRows(txn))
+		assert.Nil(t, txn.RollbackToSavepoint(sp))
+		vals := tbl.readCol(txn, 1)
+		assert.Equal(t, 8, len(vals))
+		assert.Equal(t, int32(5), vals[3])
+		// The rows are deleted and updated again after the rollback
+		assert.Nil(t, blk.RangeDelete(2, 2))
+		assert.Nil(t, blk.Update(5, 1, int32(200)))
+		assert.Nil(t, txn.Commit())
+	}
+	{
+		txn := tbl.mgr.StartTxn(nil)
+		assert.Equal(t, []int32{3, 4, 200, 6, 7, 8, 9}, tbl.readCol(txn, 1))
+		sp, err := txn.Savepoint()
+		assert.Nil(t, err)
+		assert.Nil(t, tbl.firstBlock(txn).RangeDelete(3, 9))
+		assert.Nil(t, txn.RollbackToSavepoint(sp))
+		assert.Nil(t, txn.Commit())
+
+		// No update node of the rolled back deletes is left in the chain
+		txn = tbl.mgr.StartTxn(nil)
+		assert.Nil(t, tbl.firstBlock(txn).RangeDelete(3, 9))
+		assert.Nil(t, txn.Commit())
+	}
+	{
+		txn := tbl.mgr.StartTxn(nil)
+		sp, err := txn.Savepoint()
+		assert.Nil(t, err)
+		db, err := txn.GetDatabase("db")
+		assert.Nil(t, err)
+		_, err = db.DropRelationByName(schema.Name)
+		assert.Nil(t, err)
+		_, err = db.GetRelationByName(schema.Name)
+		assert.Equal(t, catalog.ErrNotFound, err)
+		assert.Nil(t, txn.RollbackToSavepoint(sp))
+		assert.Nil(t, tbl.appendRows(txn, 100, 105))
+		assert.Nil(t, txn.Commit())
+
+		txn = tbl.mgr.StartTxn(nil)
+		assert.Equal(t, 5, tbl.countRows(txn))
+		assert.Nil(t, txn.Commit())
+		_, err = txn.Savepoint()
+		assert.Equal(t, txnbase.ErrTxnNotActive, err)
+	}
+}
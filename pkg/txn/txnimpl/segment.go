@@ -7,6 +7,9 @@ import (
 	"tae/pkg/iface/handle"
 	"tae/pkg/iface/txnif"
 	"tae/pkg/txn/txnbase"
+
+	"github.com/matrixorigin/matrixone/pkg/container/batch"
+	"github.com/matrixorigin/matrixone/pkg/container/vector"
 )
 
 type txnSegment struct {
@@ -28,7 +31,15 @@ func newSegmentIt(txn txnif.AsyncTxn, meta *catalog.TableEntry) *segmentIt {
 		linkIt: meta.MakeSegmentIt(true),
 	}
 	if it.linkIt.Valid() {
-		it.curr = it.linkIt.Get().GetPayload().(*catalog.SegmentEntry)
+		curr := it.linkIt.Get().GetPayload().(*catalog.SegmentEntry)
+		curr.RLock()
+		valid := curr.TxnCanRead(txn, curr.RWMutex)
+		curr.RUnlock()
+		if valid {
+			it.curr = curr
+		} else {
+			it.Next()
+		}
 	}
 	return it
 }
@@ -78,6 +89,46 @@ func (seg *txnSegment) MakeBlockIt() (it handle.BlockIt) {
 	return newBlockIt(seg.Txn, seg.entry)
 }
 
+// GetByFilter returns the matched rows of each visible block by block id.
+// The blocks without any matched row are skipped
+func (seg *txnSegment) GetByFilter(filter handle.Filter, offsetOnly bool) (bats map[uint64]*batch.Batch, err error) {
+	bats = make(map[uint64]*batch.Batch)
+	it := newBlockIt(seg.Txn, seg.entry)
+	for it.Valid() {
+		blk := it.GetBlock()
+		var bat *batch.Batch
+		if bat, err = blk.GetByFilter(filter, offsetOnly); err != nil {
+			return
+		}
+		if vector.Length(bat.Vecs[0]) > 0 {
+			bats[blk.ID()] = bat
+		}
+		it.Next()
+	}
+	return
+}
+
 func (seg *txnSegment) CreateBlock() (blk handle.Block, err error) {
 	return seg.Txn.GetStore().CreateBlock(seg.entry.GetTable().GetID(), seg.entry.GetID())
 }
+
+// PushDeleteOp deletes the rows of the visible blocks matching filter
+func (seg *txnSegment) PushDeleteOp(filter handle.Filter) (err error) {
+	for it := newBlockIt(seg.Txn, seg.entry); it.Valid(); it.Next() {
+		if err = it.GetBlock().PushDeleteOp(filter); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// PushUpdateOp sets attr of the rows of the visible blocks matching filter
+// to v
+func (seg *txnSegment) PushUpdateOp(filter handle.Filter, attr string, v interface{}) (err error) {
+	for it := newBlockIt(seg.Txn, seg.entry); it.Valid(); it.Next() {
+		if err = it.GetBlock().PushUpdateOp(filter, attr, v); err != nil {
+			return
+		}
+	}
+	return
+}
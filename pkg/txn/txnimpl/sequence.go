@@ -0,0 +1,110 @@
+package txnimpl
+
+import (
+	"math"
+	"tae/pkg/catalog"
+	"tae/pkg/txn/txnbase"
+
+	"github.com/matrixorigin/matrixone/pkg/container/nulls"
+	"github.com/matrixorigin/matrixone/pkg/container/types"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+)
+
+// fillSeq returns the values of the auto-increment column colDef. The rows
+// omitting the column, or having NULLs in vec, take the next values of the
+// table sequence. The values appended explicitly are never taken
+func (tbl *txnTable) fillSeq(colDef *catalog.ColDef, vec *gvec.Vector, rows int) (*gvec.Vector, error) {
+	if vec != nil && !nulls.Any(vec.Nsp) {
+		for row := 0; row < rows; row++ {
+			tbl.observeSeq(txnbase.GetValue(vec, uint32(row)))
+		}
+		return vec, nil
+	}
+	filled := gvec.New(colDef.Type)
+	for row := 0; row < rows; row++ {
+		if vec != nil && !nulls.Contains(vec.Nsp, uint64(row)) {
+			v := txnbase.GetValue(vec, uint32(row))
+			tbl.observeSeq(v)
+			txnbase.AppendValue(filled, v)
+			continue
+		}
+		v, ok := seqToValue(colDef.Type, tbl.nextSeq())
+		if !ok {
+			return nil, &ColumnError{Err: ErrSeqOverflow, Col: colDef.Name, Row: row}
+		}
+		txnbase.AppendValue(filled, v)
+	}
+	return filled, nil
+}
+
+// nextSeq returns the next value of the range taken by the txn. A new range
+// is taken from the table once the range is used up
+func (tbl *txnTable) nextSeq() uint64 {
+	if tbl.seqNext == 0 || tbl.seqNext > tbl.seqEnd {
+		tbl.seqNext, tbl.seqEnd = tbl.entry.AllocSeqRange(catalog.SeqRangeSize)
+		if tbl.seqEnd > tbl.seqLogged {
+			tbl.seqLogged = tbl.seqEnd
+		}
+	}
+	seq := tbl.seqNext
+	tbl.seqNext++
+	return seq
+}
+
+func (tbl *txnTable) observeSeq(v interface{}) {
+	seq, ok := valueToSeq(v)
+	if !ok {
+		return
+	}
+	tbl.entry.ObserveSeq(seq)
+	if seq > tbl.seqLogged {
+		tbl.seqLogged = seq
+	}
+}
+
+func valueToSeq(v interface{}) (uint64, bool) {
+	var seq int64
+	switch val := v.(type) {
+	case int8:
+		seq = int64(val)
+	case int16:
+		seq = int64(val)
+	case int32:
+		seq = int64(val)
+	case int64:
+		seq = val
+	case uint8:
+		return uint64(val), val > 0
+	case uint16:
+		return uint64(val), val > 0
+	case uint32:
+		return uint64(val), val > 0
+	case uint64:
+		return val, val > 0
+	default:
+		return 0, false
+	}
+	return uint64(seq), seq > 0
+}
+
+func seqToValue(typ types.Type, seq uint64) (interface{}, bool) {
+	switch typ.Oid {
+	case types.T_int8:
+		return int8(seq), seq <= math.MaxInt8
+	case types.T_int16:
+		return int16(seq), seq <= math.MaxInt16
+	case types.T_int32:
+		return int32(seq), seq <= math.MaxInt32
+	case types.T_int64:
+		return int64(seq), seq <= math.MaxInt64
+	case types.T_uint8:
+		return uint8(seq), seq <= math.MaxUint8
+	case types.T_uint16:
+		return uint16(seq), seq <= math.MaxUint16
+	case types.T_uint32:
+		return uint32(seq), seq <= math.MaxUint32
+	case types.T_uint64:
+		return seq, true
+	}
+	return nil, false
+}
@@ -10,6 +10,8 @@ import (
 
 	"github.com/jiangxinmeng1/logstore/pkg/entry"
 	"github.com/matrixorigin/matrixone/pkg/container/batch"
+	"github.com/matrixorigin/matrixone/pkg/container/vector"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/common"
 	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/mutation/buffer/base"
 	"github.com/sirupsen/logrus"
 )
@@ -26,8 +28,11 @@ type txnStore struct {
 	database    handle.Database
 	createEntry txnif.TxnEntry
 	dropEntry   txnif.TxnEntry
+	renameEntry *catalog.DBEntry
 	cmdMgr      *commandManager
 	logs        []entry.Entry
+	record      []byte
+	savepoints  []*storeSavepoint
 	warChecker  *warChecker
 	dataFactory *tables.DataFactory
 }
@@ -65,6 +70,8 @@ func (store *txnStore) Close() error {
 	store.dropEntry = nil
 	store.cmdMgr = nil
 	store.logs = nil
+	store.record = nil
+	store.savepoints = nil
 	store.warChecker = nil
 	return err
 }
@@ -73,7 +80,34 @@ func (store *txnStore) BindTxn(txn txnif.AsyncTxn) {
 	store.txn = txn
 }
 
+// checkWritable returns txnbase.ErrTxnReadOnly if the txn is read-only
+func (store *txnStore) checkWritable() error {
+	if store.txn.IsReadOnly() {
+		return txnbase.ErrTxnReadOnly
+	}
+	return nil
+}
+
+// BatchDedup checks the keys to be appended. It is rejected by a read-only txn
+// as the keys are checked again on commit
+func (store *txnStore) BatchDedup(id uint64, pks *vector.Vector) error {
+	if err := store.checkWritable(); err != nil {
+		return err
+	}
+	table, err := store.getOrSetTable(id)
+	if err != nil {
+		return err
+	}
+	if table.IsDeleted() {
+		return txnbase.ErrNotFound
+	}
+	return table.BatchDedup(pks)
+}
+
 func (store *txnStore) Append(id uint64, data *batch.Batch) error {
+	if err := store.checkWritable(); err != nil {
+		return err
+	}
 	table, err := store.getOrSetTable(id)
 	if err != nil {
 		return err
@@ -84,22 +118,98 @@ func (store *txnStore) Append(id uint64, data *batch.Batch) error {
 	return table.Append(data)
 }
 
+// AlterTable makes a new schema version of the table, which is visible to
+// the txn only until it commits
+func (store *txnStore) AlterTable(id uint64, req interface{}) error {
+	if err := store.checkWritable(); err != nil {
+		return err
+	}
+	table, err := store.getOrSetTable(id)
+	if err != nil {
+		return err
+	}
+	if table.IsDeleted() {
+		return txnbase.ErrNotFound
+	}
+	return table.AlterTable(req.(*catalog.AlterTableReq))
+}
+
 func (store *txnStore) RangeDeleteLocalRows(id uint64, start, end uint32) error {
+	if err := store.checkWritable(); err != nil {
+		return err
+	}
 	table := store.tables[id]
 	return table.RangeDeleteLocalRows(start, end)
 }
 
 func (store *txnStore) UpdateLocalValue(id uint64, row uint32, col uint16, value interface{}) error {
+	if err := store.checkWritable(); err != nil {
+		return err
+	}
 	table := store.tables[id]
 	return table.UpdateLocalValue(row, col, value)
 }
 
+// DeleteLocalByFilter deletes the rows appended by the txn to the table that
+// match filter
+func (store *txnStore) DeleteLocalByFilter(id uint64, filter handle.Filter) error {
+	if err := store.checkWritable(); err != nil {
+		return err
+	}
+	table := store.tables[id]
+	if table == nil {
+		return nil
+	}
+	return table.DeleteLocalByFilter(filter)
+}
+
+// UpdateLocalByFilter updates the rows appended by the txn to the table that
+// match filter
+func (store *txnStore) UpdateLocalByFilter(id uint64, filter handle.Filter, col uint16, v interface{}) error {
+	if err := store.checkWritable(); err != nil {
+		return err
+	}
+	table := store.tables[id]
+	if table == nil {
+		return nil
+	}
+	return table.UpdateLocalByFilter(filter, col, v)
+}
+
 func (store *txnStore) AddUpdateNode(id uint64, node txnif.BlockUpdates) error {
+	if err := store.checkWritable(); err != nil {
+		return err
+	}
 	table := store.tables[id]
 	return table.AddUpdateNode(node)
 }
 
+func (store *txnStore) RangeDelete(id *common.ID, start, end uint32) error {
+	if err := store.checkWritable(); err != nil {
+		return err
+	}
+	table, err := store.getOrSetTable(id.TableID)
+	if err != nil {
+		return err
+	}
+	return table.RangeDelete(id, start, end)
+}
+
+func (store *txnStore) Update(id *common.ID, row uint32, col uint16, v interface{}) error {
+	if err := store.checkWritable(); err != nil {
+		return err
+	}
+	table, err := store.getOrSetTable(id.TableID)
+	if err != nil {
+		return err
+	}
+	return table.Update(id, row, col, v)
+}
+
 func (store *txnStore) UseDatabase(name string) (err error) {
+	if name == catalog.SystemDBName {
+		return catalog.ErrReadOnly
+	}
 	if err = store.checkDatabase(name); err != nil {
 		return
 	}
@@ -116,7 +226,12 @@ func (store *txnStore) checkDatabase(name string) (err error) {
 	return
 }
 
+// GetDatabase binds the database of name to the txn, except the system
+// database, which is read-only
 func (store *txnStore) GetDatabase(name string) (db handle.Database, err error) {
+	if name == catalog.SystemDBName {
+		return newSysDatabase(store.txn, store.catalog), nil
+	}
 	if err = store.checkDatabase(name); err != nil {
 		return
 	}
@@ -130,6 +245,9 @@ func (store *txnStore) GetDatabase(name string) (db handle.Database, err error)
 }
 
 func (store *txnStore) CreateDatabase(name string) (handle.Database, error) {
+	if err := store.checkWritable(); err != nil {
+		return nil, err
+	}
 	if store.database != nil {
 		return nil, txnbase.ErrTxnDifferentDatabase
 	}
@@ -143,6 +261,9 @@ func (store *txnStore) CreateDatabase(name string) (handle.Database, error) {
 }
 
 func (store *txnStore) DropDatabase(name string) (db handle.Database, err error) {
+	if err = store.checkWritable(); err != nil {
+		return
+	}
 	if err = store.checkDatabase(name); err != nil {
 		return
 	}
@@ -155,7 +276,28 @@ func (store *txnStore) DropDatabase(name string) (db handle.Database, err error)
 	return store.database, err
 }
 
+// RenameDatabase gives the database of the txn newName. The txns started
+// before the txn commits still find it by name
+func (store *txnStore) RenameDatabase(name, newName string) (db handle.Database, err error) {
+	if err = store.checkWritable(); err != nil {
+		return
+	}
+	if err = store.checkDatabase(name); err != nil {
+		return
+	}
+	meta, err := store.catalog.RenameDBEntry(name, newName, store.txn)
+	if err != nil {
+		return
+	}
+	store.renameEntry = meta
+	store.database = newDatabase(store.txn, meta)
+	return store.database, err
+}
+
 func (store *txnStore) CreateRelation(def interface{}) (relation handle.Relation, err error) {
+	if err = store.checkWritable(); err != nil {
+		return
+	}
 	schema := def.(*catalog.Schema)
 	db := store.database.GetMeta().(*catalog.DBEntry)
 	var factory catalog.TableDataFactory
@@ -176,6 +318,9 @@ func (store *txnStore) CreateRelation(def interface{}) (relation handle.Relation
 }
 
 func (store *txnStore) DropRelationByName(name string) (relation handle.Relation, err error) {
+	if err = store.checkWritable(); err != nil {
+		return
+	}
 	db := store.database.GetMeta().(*catalog.DBEntry)
 	meta, err := db.DropTableEntry(name, store.txn)
 	if err != nil {
@@ -190,6 +335,20 @@ func (store *txnStore) DropRelationByName(name string) (relation handle.Relation
 	return
 }
 
+// RenameRelation renames the table of name, which makes a new schema version
+// of it like any other alter
+func (store *txnStore) RenameRelation(name, newName string) (err error) {
+	if err = store.checkWritable(); err != nil {
+		return
+	}
+	db := store.database.GetMeta().(*catalog.DBEntry)
+	meta, err := db.GetTableEntry(name, store.txn)
+	if err != nil {
+		return
+	}
+	return store.AlterTable(meta.GetID(), catalog.NewRenameTableReq(newName))
+}
+
 func (store *txnStore) GetRelationByName(name string) (relation handle.Relation, err error) {
 	db := store.database.GetMeta().(*catalog.DBEntry)
 	meta, err := db.GetTableEntry(name, store.txn)
@@ -201,6 +360,9 @@ func (store *txnStore) GetRelationByName(name string) (relation handle.Relation,
 }
 
 func (store *txnStore) CreateSegment(tid uint64) (seg handle.Segment, err error) {
+	if err = store.checkWritable(); err != nil {
+		return
+	}
 	var table Table
 	if table, err = store.getOrSetTable(tid); err != nil {
 		return
@@ -216,16 +378,23 @@ func (store *txnStore) getOrSetTable(id uint64) (table Table, err error) {
 			return
 		}
 		relation := newRelation(store.txn, entry)
-		if store.warChecker == nil {
-			store.warChecker = newWarChecker(store.txn, entry.GetDB())
-		}
-		table = newTxnTable(store.txn, relation, store.driver, store.nodesMgr, store.warChecker, store.dataFactory)
+		table = newTxnTable(store.txn, relation, store.driver, store.nodesMgr, store.getWarChecker(entry.GetDB()), store.dataFactory)
 		store.tables[id] = table
 	}
 	return
 }
 
+func (store *txnStore) getWarChecker(db *catalog.DBEntry) *warChecker {
+	if store.warChecker == nil {
+		store.warChecker = newWarChecker(store.txn, db)
+	}
+	return store.warChecker
+}
+
 func (store *txnStore) CreateBlock(tid, sid uint64) (blk handle.Block, err error) {
+	if err = store.checkWritable(); err != nil {
+		return
+	}
 	var table Table
 	if table, err = store.getOrSetTable(tid); err != nil {
 		return
@@ -234,6 +403,10 @@ func (store *txnStore) CreateBlock(tid, sid uint64) (blk handle.Block, err error
 }
 
 func (store *txnStore) ApplyRollback() (err error) {
+	for _, e := range store.logs {
+		e.WaitDone()
+		e.Free()
+	}
 	entry := store.createEntry
 	if entry == nil {
 		entry = store.dropEntry
@@ -243,6 +416,9 @@ func (store *txnStore) ApplyRollback() (err error) {
 			return
 		}
 	}
+	if store.renameEntry != nil {
+		store.renameEntry.RollbackRename(store.txn)
+	}
 	for _, table := range store.tables {
 		if err = table.ApplyRollback(); err != nil {
 			break
@@ -265,6 +441,9 @@ func (store *txnStore) ApplyCommit() (err error) {
 			return
 		}
 	}
+	if store.renameEntry != nil {
+		store.renameEntry.ApplyCommitRename(store.txn)
+	}
 	for _, table := range store.tables {
 		if err = table.ApplyCommit(); err != nil {
 			break
@@ -274,7 +453,14 @@ func (store *txnStore) ApplyCommit() (err error) {
 	return
 }
 
+// PreCommit validates the txn and appends its rows. A prepared txn was
+// validated on prepare
 func (store *txnStore) PreCommit() (err error) {
+	if !store.isPrepared() {
+		if err = store.validate(); err != nil {
+			return
+		}
+	}
 	for _, table := range store.tables {
 		if err = table.PreCommit(); err != nil {
 			panic(err)
@@ -285,7 +471,7 @@ func (store *txnStore) PreCommit() (err error) {
 
 func (store *txnStore) PrepareCommit() (err error) {
 	now := time.Now()
-	if store.warChecker != nil {
+	if store.warChecker != nil && !store.isPrepared() {
 		if err = store.warChecker.check(); err != nil {
 			return err
 		}
@@ -322,19 +508,23 @@ func (store *txnStore) PrepareCommit() (err error) {
 		}
 		store.cmdMgr.AddCmd(cmd)
 	}
+	if store.renameEntry != nil {
+		csn := store.cmdMgr.GetCSN()
+		cmd, err := store.renameEntry.MakeRenameCommand(uint32(csn))
+		if err != nil {
+			panic(err)
+		}
+		store.cmdMgr.AddCmd(cmd)
+	}
 	for _, table := range store.tables {
 		if err = table.CollectCmd(store.cmdMgr); err != nil {
 			panic(err)
 		}
 	}
 
-	logEntry, err := store.cmdMgr.ApplyTxnRecord()
-	if err != nil {
+	if store.record, err = store.cmdMgr.MakeTxnRecord(store.txn); err != nil {
 		panic(err)
 	}
-	if logEntry != nil {
-		store.logs = append(store.logs, logEntry)
-	}
 	logrus.Debugf("Txn-%d PrepareCommit Takes %s", store.txn.GetID(), time.Since(now))
 
 	return
@@ -352,22 +542,27 @@ func (store *txnStore) AddTxnEntry(t txnif.TxnEntryType, entry txnif.TxnEntry) {
 
 func (store *txnStore) PrepareRollback() error {
 	var err error
+	if store.isPrepared() {
+		if err = store.logAbortPrepared(); err != nil {
+			return err
+		}
+	}
 	if store.createEntry != nil {
 		if err := store.catalog.RemoveEntry(store.createEntry.(*catalog.DBEntry)); err != nil {
 			return err
 		}
 	} else if store.dropEntry != nil {
-		if err := store.createEntry.(*catalog.DBEntry).PrepareRollback(); err != nil {
+		if err := store.dropEntry.(*catalog.DBEntry).PrepareRollback(); err != nil {
 			return err
 		}
 	}
 
 	for _, table := range store.tables {
 		if err = table.PrepareRollback(); err != nil {
-			break
+			return err
 		}
 	}
-	return err
+	return store.logRollback()
 }
 
 // func (store *txnStore) FindKeys(db, table uint64, keys [][]byte) []uint32 {
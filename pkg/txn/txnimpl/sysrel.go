@@ -0,0 +1,134 @@
+package txnimpl
+
+import (
+	"tae/pkg/catalog"
+	"tae/pkg/iface/handle"
+	"tae/pkg/iface/txnif"
+	"tae/pkg/txn/txnbase"
+
+	"github.com/matrixorigin/matrixone/pkg/container/batch"
+	"github.com/matrixorigin/matrixone/pkg/container/vector"
+)
+
+// txnSysDatabase is the read-only database of the system tables. It is not
+// bound to the txn, which can use it with any other database
+type txnSysDatabase struct {
+	*txnbase.TxnDatabase
+	catalog *catalog.Catalog
+}
+
+func newSysDatabase(txn txnif.AsyncTxn, c *catalog.Catalog) *txnSysDatabase {
+	return &txnSysDatabase{
+		TxnDatabase: &txnbase.TxnDatabase{
+			Txn: txn,
+		},
+		catalog: c,
+	}
+}
+
+func (db *txnSysDatabase) GetID() uint64   { return catalog.SystemDBID }
+func (db *txnSysDatabase) GetName() string { return catalog.SystemDBName }
+func (db *txnSysDatabase) String() string  { return catalog.SystemDBName }
+
+func (db *txnSysDatabase) CreateRelation(def interface{}) (rel handle.Relation, err error) {
+	return nil, catalog.ErrReadOnly
+}
+
+func (db *txnSysDatabase) DropRelationByName(name string) (rel handle.Relation, err error) {
+	return nil, catalog.ErrReadOnly
+}
+
+func (db *txnSysDatabase) RenameRelation(name, newName string) (err error) {
+	return catalog.ErrReadOnly
+}
+
+func (db *txnSysDatabase) GetRelationByName(name string) (rel handle.Relation, err error) {
+	meta, err := catalog.GetSystemTableEntry(name)
+	if err != nil {
+		return
+	}
+	return newSysRelation(db.Txn, db.catalog, meta), nil
+}
+
+func (db *txnSysDatabase) RelationCnt() int64 { return int64(len(catalog.SystemTableNames())) }
+
+func (db *txnSysDatabase) Relations() (rels []handle.Relation) {
+	for _, name := range catalog.SystemTableNames() {
+		rel, _ := db.GetRelationByName(name)
+		rels = append(rels, rel)
+	}
+	return
+}
+
+// txnSysRelation reads a system table at the snapshot of the txn
+type txnSysRelation struct {
+	*txnbase.TxnRelation
+	catalog *catalog.Catalog
+	entry   *catalog.TableEntry
+}
+
+func newSysRelation(txn txnif.AsyncTxn, c *catalog.Catalog, meta *catalog.TableEntry) *txnSysRelation {
+	return &txnSysRelation{
+		TxnRelation: &txnbase.TxnRelation{
+			Txn: txn,
+		},
+		catalog: c,
+		entry:   meta,
+	}
+}
+
+func (h *txnSysRelation) ID() uint64     { return h.entry.GetID() }
+func (h *txnSysRelation) String() string { return h.entry.String() }
+
+func (h *txnSysRelation) GetMeta() interface{}   { return h.entry }
+func (h *txnSysRelation) GetSchema() interface{} { return h.entry.GetSchema() }
+
+func (h *txnSysRelation) MakeReader() handle.Reader {
+	return &sysRelationReader{
+		txn:     h.Txn,
+		catalog: h.catalog,
+		name:    h.entry.GetSchema().Name,
+	}
+}
+
+// MakeSegmentIt returns an empty iterator, as the rows of a system table
+// are not stored in segments. They are read by MakeReader
+func (h *txnSysRelation) MakeSegmentIt() handle.SegmentIt {
+	return newSegmentIt(h.Txn, h.entry)
+}
+
+func (h *txnSysRelation) BatchDedup(col *vector.Vector) error { return catalog.ErrReadOnly }
+func (h *txnSysRelation) Append(data *batch.Batch) error      { return catalog.ErrReadOnly }
+func (h *txnSysRelation) AlterTable(req interface{}) error    { return catalog.ErrReadOnly }
+
+func (h *txnSysRelation) DeleteByFilter(filter handle.Filter) error { return catalog.ErrReadOnly }
+
+func (h *txnSysRelation) UpdateByFilter(filter handle.Filter, attr string, v interface{}) error {
+	return catalog.ErrReadOnly
+}
+
+func (h *txnSysRelation) CreateSegment() (seg handle.Segment, err error) {
+	return nil, catalog.ErrReadOnly
+}
+
+// sysRelationReader returns all the rows of a system table in one batch
+type sysRelationReader struct {
+	txn     txnif.AsyncTxn
+	catalog *catalog.Catalog
+	name    string
+	done    bool
+}
+
+func (r *sysRelationReader) Next(ctx interface{}, attrs []string) (bat *batch.Batch, err error) {
+	if len(attrs) == 0 || r.done {
+		return
+	}
+	if bat, err = r.catalog.ReadSystemTable(r.name, r.txn, attrs); err != nil {
+		return nil, err
+	}
+	r.done = true
+	if vector.Length(bat.Vecs[0]) == 0 {
+		bat = nil
+	}
+	return
+}
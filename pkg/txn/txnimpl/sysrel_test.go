@@ -0,0 +1,97 @@
+package txnimpl
+
+import (
+	"tae/pkg/catalog"
+	"tae/pkg/iface/handle"
+	"tae/pkg/iface/txnif"
+	"testing"
+
+	gbat "github.com/matrixorigin/matrixone/pkg/container/batch"
+	"github.com/matrixorigin/matrixone/pkg/container/types"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSystemTables(t *testing.T) {
+	schema := catalog.MockSchema(2)
+	schema.BlockMaxRows = 10
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, schema)
+	defer tbl.close()
+	getRelation := func(txn txnif.AsyncTxn, table string) (handle.Relation, error) {
+		db, err := txn.GetDatabase(catalog.SystemDBName)
+		assert.Nil(t, err)
+		return db.GetRelationByName(table)
+	}
+	read := func(txn txnif.AsyncTxn, table string, attrs ...string) *gbat.Batch {
+		rel, err := getRelation(txn, table)
+		assert.Nil(t, err)
+		bat, err := rel.MakeReader().Next(nil, attrs)
+		assert.Nil(t, err)
+		return bat
+	}
+	createTable := func(txn txnif.AsyncTxn, schema *catalog.Schema) {
+		db, err := txn.GetDatabase("db")
+		assert.Nil(t, err)
+		_, err = db.CreateRelation(schema)
+		assert.Nil(t, err)
+	}
+
+	txn := tbl.mgr.StartTxn(nil)
+	created := catalog.MockSchema(1)
+	createTable(txn, created)
+	assert.Nil(t, tbl.appendRows(txn, 0, 15))
+	// The table created by the txn is visible to it
+	bat := read(txn, catalog.SystemTableTables, "name")
+	assert.Equal(t, 2, gvec.Length(bat.Vecs[0]))
+	assert.Equal(t, []byte(created.Name), bat.Vecs[0].Col.(*types.Bytes).Get(1))
+	assert.Nil(t, txn.Commit())
+
+	txn1 := tbl.mgr.StartTxn(nil)
+	bat = read(txn1, catalog.SystemTableDatabases, "id", "name")
+	assert.Equal(t, 1, gvec.Length(bat.Vecs[0]))
+	assert.Equal(t, []byte("db"), bat.Vecs[1].Col.(*types.Bytes).Get(0))
+	bat = read(txn1, catalog.SystemTableColumns, "name", "type")
+	assert.Equal(t, 3, gvec.Length(bat.Vecs[0]))
+	assert.Equal(t, []byte(schema.ColDefs[1].Name), bat.Vecs[0].Col.(*types.Bytes).Get(1))
+	assert.Equal(t, []byte(schema.ColDefs[1].Type.String()), bat.Vecs[1].Col.(*types.Bytes).Get(1))
+	bat = read(txn1, catalog.SystemTableSegments, "id")
+	assert.Equal(t, 1, gvec.Length(bat.Vecs[0]))
+	bat = read(txn1, catalog.SystemTableBlocks, "segment_id", "state", "rows")
+	assert.Equal(t, 2, gvec.Length(bat.Vecs[0]))
+	assert.Equal(t, []byte(catalog.ES_Appendable.Repr()), bat.Vecs[1].Col.(*types.Bytes).Get(0))
+	assert.Equal(t, []uint32{10, 5}, bat.Vecs[2].Col.([]uint32))
+
+	// The rows deleted after txn1 started are still counted by txn1
+	txn = tbl.mgr.StartTxn(nil)
+	assert.Nil(t, tbl.getRelation(txn).DeleteByFilter(handle.Filter{Op: handle.FilterBtw, Col: tbl.operand(0, 2)}))
+	assert.Nil(t, txn.Commit())
+	bat = read(txn1, catalog.SystemTableBlocks, "rows")
+	assert.Equal(t, []uint32{10, 5}, bat.Vecs[0].Col.([]uint32))
+
+	// The table created after txn1 started is not visible to txn1
+	txn = tbl.mgr.StartTxn(nil)
+	createTable(txn, catalog.MockSchema(1))
+	assert.Nil(t, txn.Commit())
+	bat = read(txn1, catalog.SystemTableTables, "id")
+	assert.Equal(t, 2, gvec.Length(bat.Vecs[0]))
+	assert.Nil(t, txn1.Commit())
+
+	txn = tbl.mgr.StartTxn(nil)
+	bat = read(txn, catalog.SystemTableTables, "id")
+	assert.Equal(t, 3, gvec.Length(bat.Vecs[0]))
+	bat = read(txn, catalog.SystemTableBlocks, "rows")
+	assert.Equal(t, []uint32{7, 5}, bat.Vecs[0].Col.([]uint32))
+
+	// The system tables are read only
+	rel, err := getRelation(txn, catalog.SystemTableBlocks)
+	assert.Nil(t, err)
+	_, err = rel.MakeReader().Next(nil, []string{"xx"})
+	assert.Equal(t, catalog.ErrNotFound, err)
+	assert.Equal(t, catalog.ErrReadOnly, rel.Append(tbl.makeBatch(0, 1)))
+	_, err = getRelation(txn, "xx")
+	assert.Equal(t, catalog.ErrNotFound, err)
+	_, err = txn.CreateDatabase(catalog.SystemDBName)
+	assert.Equal(t, catalog.ErrDuplicate, err)
+	assert.Nil(t, txn.Commit())
+}
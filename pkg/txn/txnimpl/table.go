@@ -14,6 +14,7 @@ import (
 
 	"github.com/matrixorigin/matrixone/pkg/container/batch"
 	gbat "github.com/matrixorigin/matrixone/pkg/container/batch"
+	"github.com/matrixorigin/matrixone/pkg/container/nulls"
 	"github.com/matrixorigin/matrixone/pkg/container/vector"
 	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
 	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/common"
@@ -23,23 +24,70 @@ import (
 
 var (
 	ErrDuplicateNode = errors.New("tae: duplicate node")
+	// ErrAlterAfterAppend is returned if a txn alters a table it appended to
+	ErrAlterAfterAppend = errors.New("tae: alter table after append")
+	// ErrUpdateAddedColumn is returned if a column is updated in a block
+	// written before the column was added
+	ErrUpdateAddedColumn = errors.New("tae: update column added after the block")
+	// ErrPrepareAlter is returned if a txn altering a table is prepared
+	ErrPrepareAlter = errors.New("tae: prepare alter table")
+	// ErrMissingColumn is returned if an append omits a column that is not
+	// nullable and has no default
+	ErrMissingColumn = errors.New("tae: missing column")
+	// ErrNotNull is returned if an append has NULLs in a NOT NULL column
+	ErrNotNull = errors.New("tae: null value in not null column")
+	// ErrUnknownColumn is returned if an append has a column not in the schema
+	ErrUnknownColumn = errors.New("tae: unknown column")
+	// ErrSeqOverflow is returned if the auto-increment value of a row is out
+	// of the range of the column type
+	ErrSeqOverflow = errors.New("tae: auto-increment value overflow")
+	// ErrCompositeKey is returned by the operations not supported on a table
+	// with a composite primary key, such as an update of a key column
+	ErrCompositeKey = errors.New("tae: not supported on composite primary key")
 )
 
+// ColumnError is an error of the column Col at the row Row of an append. Row
+// is -1 if the error is not of a row
+type ColumnError struct {
+	Err error
+	Col string
+	Row int
+}
+
+func (e *ColumnError) Error() string {
+	if e.Row < 0 {
+		return fmt.Sprintf("%s: column %s", e.Err, e.Col)
+	}
+	return fmt.Sprintf("%s: column %s, row %d", e.Err, e.Col, e.Row)
+}
+
+func (e *ColumnError) Unwrap() error { return e.Err }
+
 type Table interface {
 	io.Closer
 	GetSchema() *catalog.Schema
 	GetID() uint64
 	RangeDeleteLocalRows(start, end uint32) error
 	Append(data *batch.Batch) error
+	AlterTable(req *catalog.AlterTableReq) error
 	LocalDeletesToString() string
 	IsLocalDeleted(row uint32) bool
 	GetLocalPhysicalAxis(row uint32) (int, uint32)
+	GetLocalBatch(pos int, attrs []string) (*gbat.Batch, error)
 	UpdateLocalValue(row uint32, col uint16, value interface{}) error
+	DeleteLocalByFilter(filter handle.Filter) error
+	UpdateLocalByFilter(filter handle.Filter, col uint16, v interface{}) error
 	Rows() uint32
 	BatchDedupLocal(data *gbat.Batch) error
 	BatchDedupLocalByCol(col *gvec.Vector) error
+	BatchDedup(col *gvec.Vector) error
 	AddUpdateNode(txnif.BlockUpdates) error
+	RangeDelete(id *common.ID, start, end uint32) error
+	Update(id *common.ID, row uint32, col uint16, v interface{}) error
 	IsDeleted() bool
+	IsAltered() bool
+	CheckSchema() error
+	PreCommitDedup() error
 	PreCommit() error
 	PrepareCommit() error
 	PrepareRollback() error
@@ -55,6 +103,14 @@ type Table interface {
 	CreateSegment() (handle.Segment, error)
 	CreateBlock(sid uint64) (handle.Block, error)
 	CollectCmd(*commandManager) error
+	CollectRollbackCmd(*commandManager) error
+
+	Savepoint() *tableSavepoint
+	RollbackToSavepoint(*tableSavepoint) error
+
+	AddPrepared()
+	MakePrepareCmd(uint32) (*PreparedTableCmd, error)
+	RedoUpdates(*updates.BlockUpdates) error
 }
 
 type txnTable struct {
@@ -63,9 +119,12 @@ type txnTable struct {
 	dropEntry   txnif.TxnEntry
 	inodes      []InsertNode
 	appendable  base.INodeHandle
-	updateNodes map[common.ID]*updates.BlockUpdates
+	updateNodes map[common.ID]*updates.BlockUpdateNode
 	driver      txnbase.NodeDriver
 	entry       *catalog.TableEntry
+	// schema is the schema version the txn reads and writes
+	schema      *catalog.Schema
+	altered     bool
 	handle      handle.Relation
 	nodesMgr    base.INodeManager
 	index       TableIndex
@@ -77,6 +136,16 @@ type txnTable struct {
 	warChecker  *warChecker
 	dataFactory *tables.DataFactory
 	logs        []txnbase.NodeEntry
+	appended    map[common.ID]uint32
+	dedupCols   []*gvec.Vector
+	// applied is the rows appended to the shared blocks on pre-commit
+	applied    []*appendInfo
+	sharedSegs []*catalog.SegmentEntry
+	sharedBlks []*catalog.BlockEntry
+	// seqNext and seqEnd are the auto-increment values left in the range
+	// taken by the txn. seqLogged is the max value taken to be logged
+	seqNext, seqEnd uint64
+	seqLogged       uint64
 }
 
 func newTxnTable(txn txnif.AsyncTxn, handle handle.Relation, driver txnbase.NodeDriver, mgr base.INodeManager, checker *warChecker, dataFactory *tables.DataFactory) *txnTable {
@@ -87,13 +156,15 @@ func newTxnTable(txn txnif.AsyncTxn, handle handle.Relation, driver txnbase.Node
 		nodesMgr:    mgr,
 		handle:      handle,
 		entry:       handle.GetMeta().(*catalog.TableEntry),
+		schema:      handle.GetMeta().(*catalog.TableEntry).GetSchemaFor(txn),
 		driver:      driver,
 		index:       NewSimpleTableIndex(),
-		updateNodes: make(map[common.ID]*updates.BlockUpdates),
+		updateNodes: make(map[common.ID]*updates.BlockUpdateNode),
 		csegs:       make([]*catalog.SegmentEntry, 0),
 		dsegs:       make([]*catalog.SegmentEntry, 0),
 		dataFactory: dataFactory,
 		logs:        make([]txnbase.NodeEntry, 0),
+		appended:    make(map[common.ID]uint32),
 	}
 	return tbl
 }
@@ -106,6 +177,32 @@ func (tbl *txnTable) WaitSynced() {
 }
 
 func (tbl *txnTable) CollectCmd(cmdMgr *commandManager) error {
+	tbl.entry.RLock()
+	if tbl.entry.CreateAndDropInSameTxn() {
+		tbl.entry.RUnlock()
+		return nil
+	}
+	tbl.entry.RUnlock()
+	if tbl.createEntry != nil || tbl.dropEntry != nil {
+		csn := cmdMgr.GetCSN()
+		cmd, err := tbl.entry.MakeCommand(uint32(csn))
+		if err != nil {
+			return err
+		}
+		cmdMgr.AddCmd(cmd)
+	}
+	if tbl.altered {
+		csn := cmdMgr.GetCSN()
+		cmd, err := tbl.entry.MakeAlterCommand(uint32(csn))
+		if err != nil {
+			return err
+		}
+		cmdMgr.AddCmd(cmd)
+	}
+	if tbl.seqLogged > 0 {
+		csn := cmdMgr.GetCSN()
+		cmdMgr.AddCmd(tbl.entry.MakeSeqCommand(uint32(csn), tbl.seqLogged))
+	}
 	for _, seg := range tbl.csegs {
 		csn := cmdMgr.GetCSN()
 		cmd, err := seg.MakeCommand(uint32(csn))
@@ -142,6 +239,14 @@ func (tbl *txnTable) CollectCmd(cmdMgr *commandManager) error {
 			cmdMgr.AddCmd(cmd)
 		}
 	}
+	for _, node := range tbl.updateNodes {
+		csn := cmdMgr.GetCSN()
+		cmd, _, err := node.MakeCommand(uint32(csn), false)
+		if err != nil {
+			return err
+		}
+		cmdMgr.AddCmd(cmd)
+	}
 	return nil
 }
 
@@ -199,8 +304,37 @@ func (tbl *txnTable) IsDeleted() bool {
 	return tbl.dropEntry != nil
 }
 
+func (tbl *txnTable) IsAltered() bool {
+	return tbl.altered
+}
+
 func (tbl *txnTable) GetSchema() *catalog.Schema {
-	return tbl.entry.GetSchema()
+	return tbl.schema
+}
+
+// AlterTable makes a new schema version of the table for the txn. The rows
+// appended by the txn are in the previous version, so the txn should alter
+// the columns before appending to it. A rename keeps the columns
+func (tbl *txnTable) AlterTable(req *catalog.AlterTableReq) (err error) {
+	if len(tbl.inodes) > 0 && req.Kind != catalog.AlterRenameTable {
+		return ErrAlterAfterAppend
+	}
+	schema, err := tbl.entry.AlterSchema(tbl.txn, req)
+	if err != nil {
+		return
+	}
+	tbl.schema = schema
+	tbl.altered = true
+	return
+}
+
+// CheckSchema checks the rows appended by the txn are still in the latest
+// schema version, so they can be appended to the blocks of the version
+func (tbl *txnTable) CheckSchema() error {
+	if len(tbl.inodes) == 0 {
+		return nil
+	}
+	return tbl.entry.CheckSchemaWrite(tbl.txn, tbl.schema)
 }
 
 func (tbl *txnTable) GetMeta() *catalog.TableEntry {
@@ -234,6 +368,7 @@ func (tbl *txnTable) Close() error {
 	tbl.dblks = nil
 	tbl.warChecker = nil
 	tbl.logs = nil
+	tbl.appended = nil
 	return nil
 }
 
@@ -258,17 +393,79 @@ func (tbl *txnTable) AddUpdateNode(node txnif.BlockUpdates) error {
 	if u != nil {
 		return ErrDuplicateNode
 	}
-	tbl.updateNodes[id] = node.(*updates.BlockUpdates)
+	tbl.updateNodes[id] = node.(*updates.BlockUpdateNode)
 	return nil
 }
 
+func (tbl *txnTable) getOrSetUpdateNode(id *common.ID) (node *updates.BlockUpdateNode, err error) {
+	if node = tbl.updateNodes[*id]; node != nil {
+		return
+	}
+	var blk data.Block
+	if blk, err = tbl.getBlockData(id); err != nil {
+		return
+	}
+	node = blk.GetUpdateChain().(*updates.BlockUpdateChain).AddNode(tbl.txn)
+	err = tbl.AddUpdateNode(node)
+	return
+}
+
+// RangeDelete deletes the rows in [start, end] of a committed block
+func (tbl *txnTable) RangeDelete(id *common.ID, start, end uint32) (err error) {
+	node, err := tbl.getOrSetUpdateNode(id)
+	if err != nil {
+		return
+	}
+	chain := node.GetChain()
+	chain.Lock()
+	defer chain.Unlock()
+	if err = chain.CheckDeleteLocked(tbl.txn, start, end); err != nil {
+		return
+	}
+	node.Lock()
+	defer node.Unlock()
+	return node.DeleteLocked(start, end)
+}
+
+// Update updates the column of a row in a committed block. col is the index
+// of the column in the schema version of the txn
+func (tbl *txnTable) Update(id *common.ID, row uint32, col uint16, v interface{}) (err error) {
+	// The block dedup does not track the composite keys changed by updates
+	if tbl.schema.IsCompositeKey() && tbl.schema.IsKeyCol(int(col)) {
+		return ErrCompositeKey
+	}
+	if col, err = tbl.getBlockColIdx(id, col); err != nil {
+		return
+	}
+	node, err := tbl.getOrSetUpdateNode(id)
+	if err != nil {
+		return
+	}
+	chain := node.GetChain()
+	chain.Lock()
+	defer chain.Unlock()
+	if err = chain.CheckUpdateLocked(tbl.txn, row, col); err != nil {
+		return
+	}
+	node.Lock()
+	defer node.Unlock()
+	return node.UpdateLocked(row, col, v)
+}
+
 func (tbl *txnTable) Append(data *batch.Batch) error {
-	var err error
+	data, err := tbl.prepareAppendData(data)
+	if err != nil {
+		return err
+	}
 	if tbl.appendable == nil {
 		if err = tbl.registerInsertNode(); err != nil {
 			return err
 		}
 	}
+	keys, err := tbl.getKeys(data)
+	if err != nil {
+		return err
+	}
 	appended := uint32(0)
 	offset := uint32(0)
 	length := uint32(vector.Length(data.Vecs[0]))
@@ -290,7 +487,7 @@ func (tbl *txnTable) Append(data *batch.Batch) error {
 		space := n.GetSpace()
 		logrus.Debugf("Appended: %d, Space:%d", appended, space)
 		start := tbl.rows
-		if err = tbl.index.BatchInsert(data.Vecs[tbl.GetSchema().PrimaryKey], int(offset), int(appended), start, false); err != nil {
+		if err = tbl.index.BatchInsert(keys, int(offset), int(appended), start, false); err != nil {
 			break
 		}
 		offset += appended
@@ -307,6 +504,61 @@ func (tbl *txnTable) Append(data *batch.Batch) error {
 	return err
 }
 
+// prepareAppendData returns the columns of data in the order of the schema.
+// The omitted columns are filled with their defaults or NULLs, and the rows
+// omitting the auto-increment column take the values of the table sequence.
+// All the columns are validated before the sequence is used, so a rejected
+// append never advances it
+func (tbl *txnTable) prepareAppendData(data *batch.Batch) (*batch.Batch, error) {
+	schema := tbl.GetSchema()
+	for _, attr := range data.Attrs {
+		if schema.GetColIdx(attr) < 0 {
+			return nil, &ColumnError{Err: ErrUnknownColumn, Col: attr, Row: -1}
+		}
+	}
+	rows := vector.Length(data.Vecs[0])
+	prepared := batch.New(true, schema.Attrs())
+	seqCols := make([]int, 0, 1)
+	for i, colDef := range schema.ColDefs {
+		pos := -1
+		for j, attr := range data.Attrs {
+			if attr == colDef.Name {
+				pos = j
+				break
+			}
+		}
+		if pos >= 0 {
+			prepared.Vecs[i] = data.Vecs[pos]
+		}
+		if colDef.Default.Kind == catalog.AutoIncrementDefault {
+			seqCols = append(seqCols, i)
+			continue
+		}
+		if pos < 0 {
+			if !colDef.HasFill() {
+				return nil, &ColumnError{Err: ErrMissingColumn, Col: colDef.Name, Row: -1}
+			}
+			prepared.Vecs[i] = colDef.MakeFill(uint32(rows))
+			continue
+		}
+		vec := data.Vecs[pos]
+		if !colDef.Nullable && nulls.Any(vec.Nsp) {
+			for row := 0; row < rows; row++ {
+				if nulls.Contains(vec.Nsp, uint64(row)) {
+					return nil, &ColumnError{Err: ErrNotNull, Col: colDef.Name, Row: row}
+				}
+			}
+		}
+	}
+	for _, i := range seqCols {
+		var err error
+		if prepared.Vecs[i], err = tbl.fillSeq(schema.ColDefs[i], prepared.Vecs[i], rows); err != nil {
+			return nil, err
+		}
+	}
+	return prepared, nil
+}
+
 // 1. Split the interval into multiple intervals, with each interval belongs to only one insert node
 // 2. For each new interval, call insert node RangeDelete
 // 3. Update the table index
@@ -366,6 +618,9 @@ func (tbl *txnTable) UpdateLocalValue(row uint32, col uint16, value interface{})
 	if err != nil {
 		return err
 	}
+	vec := gvec.New(tbl.GetSchema().ColDefs[col].Type)
+	txnbase.AppendValue(vec, value)
+	window.Vecs[col] = vec
 	if err = n.RangeDelete(uint32(noffset), uint32(noffset)); err != nil {
 		return err
 	}
@@ -373,6 +628,71 @@ func (tbl *txnTable) UpdateLocalValue(row uint32, col uint16, value interface{})
 	return err
 }
 
+// getLocalRowsByFilter returns the local rows not deleted that match filter
+func (tbl *txnTable) getLocalRowsByFilter(filter handle.Filter) (rows []uint32, err error) {
+	schema := tbl.GetSchema()
+	attr := filter.Attr
+	if attr == "" {
+		attr = schema.ColDefs[schema.PrimaryKey].Name
+	}
+	colIdx := schema.GetColIdx(attr)
+	if colIdx < 0 {
+		return nil, catalog.ErrNotFound
+	}
+	match, err := tables.NewRowMatcher(filter, schema.ColDefs[colIdx])
+	if err != nil {
+		return
+	}
+	for pos := range tbl.inodes {
+		var bat *gbat.Batch
+		if bat, err = tbl.GetLocalBatch(pos, []string{attr}); err != nil {
+			return
+		}
+		// The rows of the batch skip the deleted ones
+		n := tbl.inodes[pos]
+		i := uint32(0)
+		for row := uint32(0); row < n.Rows(); row++ {
+			if n.IsRowDeleted(row) {
+				continue
+			}
+			if match(txnbase.GetValue(bat.Vecs[0], i)) {
+				rows = append(rows, uint32(pos)*txnbase.MaxNodeRows+row)
+			}
+			i++
+		}
+	}
+	return
+}
+
+// DeleteLocalByFilter deletes the local rows that match filter
+func (tbl *txnTable) DeleteLocalByFilter(filter handle.Filter) (err error) {
+	rows, err := tbl.getLocalRowsByFilter(filter)
+	if err != nil {
+		return
+	}
+	for _, row := range rows {
+		if err = tbl.RangeDeleteLocalRows(row, row); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// UpdateLocalByFilter sets the column col of the local rows that match
+// filter to v. The updated rows are appended as new rows
+func (tbl *txnTable) UpdateLocalByFilter(filter handle.Filter, col uint16, v interface{}) (err error) {
+	rows, err := tbl.getLocalRowsByFilter(filter)
+	if err != nil {
+		return
+	}
+	for _, row := range rows {
+		if err = tbl.UpdateLocalValue(row, col, v); err != nil {
+			return
+		}
+	}
+	return
+}
+
 func (tbl *txnTable) Rows() uint32 {
 	cnt := len(tbl.inodes)
 	if cnt == 0 {
@@ -381,14 +701,39 @@ func (tbl *txnTable) Rows() uint32 {
 	return (uint32(cnt)-1)*txnbase.MaxNodeRows + tbl.inodes[cnt-1].Rows()
 }
 
+// getKeys returns the primary keys of the rows of bat, whose columns are in
+// the order of the schema
+func (tbl *txnTable) getKeys(bat *gbat.Batch) (*gvec.Vector, error) {
+	return tbl.GetSchema().GetKeys(func(colIdx int) (*gvec.Vector, error) {
+		return bat.Vecs[colIdx], nil
+	})
+}
+
 func (tbl *txnTable) BatchDedupLocal(bat *gbat.Batch) error {
-	return tbl.BatchDedupLocalByCol(bat.Vecs[tbl.GetSchema().PrimaryKey])
+	keys, err := tbl.getKeys(bat)
+	if err != nil {
+		return err
+	}
+	return tbl.BatchDedupLocalByCol(keys)
 }
 
 func (tbl *txnTable) BatchDedupLocalByCol(col *gvec.Vector) error {
 	return tbl.index.BatchDedup(col)
 }
 
+// BatchDedup checks the keys against the local rows and the rows of the
+// committed and committing txns. The keys are checked again on commit
+func (tbl *txnTable) BatchDedup(col *gvec.Vector) (err error) {
+	if err = tbl.BatchDedupLocalByCol(col); err != nil {
+		return
+	}
+	if err = tbl.entry.GetTableData().BatchDedup(tbl.txn, col); err != nil {
+		return
+	}
+	tbl.dedupCols = append(tbl.dedupCols, col)
+	return
+}
+
 func (tbl *txnTable) GetLocalValue(row uint32, col uint16) (interface{}, error) {
 	npos, noffset := tbl.GetLocalPhysicalAxis(row)
 	n := tbl.inodes[npos]
@@ -397,6 +742,46 @@ func (tbl *txnTable) GetLocalValue(row uint32, col uint16) (interface{}, error)
 	return n.GetValue(int(col), noffset)
 }
 
+// GetLocalBatch returns the attrs of the rows in the pos-th insert node that
+// are not deleted. A nil batch is returned if pos is out of range
+func (tbl *txnTable) GetLocalBatch(pos int, attrs []string) (bat *gbat.Batch, err error) {
+	if pos >= len(tbl.inodes) {
+		return
+	}
+	n := tbl.inodes[pos]
+	h := tbl.nodesMgr.Pin(n)
+	if h == nil {
+		panic("not expected")
+	}
+	defer h.Close()
+	schema := tbl.GetSchema()
+	bat = gbat.New(true, attrs)
+	if n.Rows() == 0 {
+		for i, attr := range attrs {
+			bat.Vecs[i] = gvec.New(schema.ColDefs[schema.GetColIdx(attr)].Type)
+		}
+		return
+	}
+	window, err := n.Window(0, n.Rows()-1)
+	if err != nil {
+		return
+	}
+	var sels []int64
+	for row := uint32(0); row < n.Rows(); row++ {
+		if !n.IsRowDeleted(row) {
+			sels = append(sels, int64(row))
+		}
+	}
+	for i, attr := range attrs {
+		vec := window.Vecs[schema.GetColIdx(attr)]
+		if len(sels) < int(n.Rows()) {
+			gvec.Shrink(vec, sels)
+		}
+		bat.Vecs[i] = vec
+	}
+	return
+}
+
 func (tbl *txnTable) PrepareRollback() (err error) {
 	if tbl.createEntry != nil {
 		entry := tbl.createEntry.(*catalog.TableEntry)
@@ -409,59 +794,163 @@ func (tbl *txnTable) PrepareRollback() (err error) {
 			return
 		}
 	}
-	// TODO: remove all inserts and updates
-	return
+	// The entries and rows of a created table are removed with the table
+	if tbl.createEntry != nil {
+		tbl.sharedSegs = nil
+		tbl.sharedBlks = nil
+		return
+	}
+	// The entries created by an alter txn are in its schema version, so no
+	// other txn appends to them
+	if tbl.altered {
+		tbl.entry.RollbackAlter(tbl.txn)
+		tbl.sharedSegs = nil
+		tbl.sharedBlks = nil
+	}
+	if err = tbl.rollbackAppends(); err != nil {
+		return
+	}
+	return tbl.prepareRollbackEntries()
 }
 
-func (tbl *txnTable) applyAppendInode(node InsertNode) (err error) {
+// getAppender returns an appender of the appendable block of the table in the
+// schema version of the txn. A new segment is created for the version if the
+// appendable one is of another version
+func (tbl *txnTable) getAppender() data.BlockAppender {
 	tableData := tbl.entry.GetTableData()
+	id, appender, err := tableData.GetAppender()
+	if err == nil && !tbl.isSchemaOf(id) {
+		appender.Close()
+		err = data.ErrAppendableSegmentNotFound
+	}
+	if err == data.ErrAppendableSegmentNotFound {
+		seg, err := tbl.CreateSegment()
+		if err != nil {
+			panic(err)
+		}
+		blk, err := seg.CreateBlock()
+		if err != nil {
+			panic(err)
+		}
+		tbl.sharedSegs = append(tbl.sharedSegs, seg.GetMeta().(*catalog.SegmentEntry))
+		tbl.sharedBlks = append(tbl.sharedBlks, blk.GetMeta().(*catalog.BlockEntry))
+		if appender, err = tableData.SetAppender(blk.Fingerprint()); err != nil {
+			panic(err)
+		}
+	} else if err == data.ErrAppendableBlockNotFound {
+		blk, err := tbl.CreateBlock(id.SegmentID)
+		if err != nil {
+			panic(err)
+		}
+		tbl.sharedBlks = append(tbl.sharedBlks, blk.GetMeta().(*catalog.BlockEntry))
+		if appender, err = tableData.SetAppender(blk.Fingerprint()); err != nil {
+			panic(err)
+		}
+	}
+	return appender
+}
+
+// applyAppendInode appends the rows of node through appender, which is kept
+// open for the next node until its block is full. All the rows of the txn
+// are in its schema version, so they share the appenders of the version
+func (tbl *txnTable) applyAppendInode(node InsertNode, appender data.BlockAppender) (data.BlockAppender, error) {
 	appended := uint32(0)
 	for appended < node.Rows() {
-		id, appender, err := tableData.GetAppender()
-		if err == data.ErrAppendableSegmentNotFound {
-			seg, err := tbl.CreateSegment()
-			if err != nil {
-				panic(err)
-			}
-			blk, err := seg.CreateBlock()
-			if err != nil {
-				panic(err)
-			}
-			if appender, err = tableData.SetAppender(blk.Fingerprint()); err != nil {
-				panic(err)
-			}
-		} else if err == data.ErrAppendableBlockNotFound {
-			blk, err := tbl.CreateBlock(id.SegmentID)
-			if err != nil {
-				panic(err)
-			}
-			if appender, err = tableData.SetAppender(blk.Fingerprint()); err != nil {
-				panic(err)
-			}
+		if appender == nil {
+			appender = tbl.getAppender()
 		}
 		toAppend, err := appender.PrepareAppend(node.Rows() - appended)
-		bat, err := node.Window(0, toAppend-1)
-		var destOff uint32
-		if destOff, err = appender.ApplyAppend(bat, 0, toAppend, nil); err != nil {
+		if err != nil {
 			panic(err)
 		}
-		appender.Close()
+		if toAppend == 0 {
+			appender.Close()
+			appender = nil
+			continue
+		}
+		bat, err := node.Window(appended, appended+toAppend-1)
+		if err != nil {
+			panic(err)
+		}
+		destOff, err := appender.ApplyAppend(bat, 0, toAppend, tbl.txn)
+		if err != nil {
+			panic(err)
+		}
+		tbl.appended[*appender.GetID()] = destOff + toAppend - 1
 		info := node.AddApplyInfo(appended, toAppend, destOff, toAppend, appender.GetID())
+		tbl.applied = append(tbl.applied, info)
 		logrus.Debug(info.String())
+		if err = tbl.applyLocalDeletes(node, appended, toAppend, destOff, appender.GetID()); err != nil {
+			return appender, err
+		}
 		appended += toAppend
-		if appended == node.Rows() {
+	}
+	return appender, nil
+}
+
+// applyLocalDeletes deletes the rows of dest appended from the rows in
+// [srcOff, srcOff+srcLen) of node which were deleted by the txn
+func (tbl *txnTable) applyLocalDeletes(node InsertNode, srcOff, srcLen, destOff uint32, dest *common.ID) (err error) {
+	deletes := node.CloneDeletes()
+	if deletes == nil {
+		return
+	}
+	it := deletes.Iterator()
+	it.AdvanceIfNeeded(srcOff)
+	for it.HasNext() {
+		row := it.Next()
+		if row >= srcOff+srcLen {
 			break
 		}
+		destRow := row - srcOff + destOff
+		if err = tbl.RangeDelete(dest, destRow, destRow); err != nil {
+			break
+		}
+	}
+	return
+}
+
+// isSchemaOf returns true if the segment of id is in the schema version of
+// the txn, so the rows of the txn can be appended to it
+func (tbl *txnTable) isSchemaOf(id *common.ID) bool {
+	seg, err := tbl.entry.GetSegmentByID(id.SegmentID)
+	return err == nil && seg.GetSchema() == tbl.schema
+}
+
+// PreCommitDedup checks the keys deduped by the txn again against the rows of
+// the txns committed after it started. The txns are pre-committed one by one,
+// so the rows of all the txns committing before are already appended
+func (tbl *txnTable) PreCommitDedup() (err error) {
+	// No other txn can append to a table created by this txn
+	if tbl.createEntry != nil {
+		return
+	}
+	for _, col := range tbl.dedupCols {
+		if err = tbl.entry.GetTableData().BatchDedup(tbl.txn, col); err != nil {
+			return
+		}
 	}
 	return
 }
 
 func (tbl *txnTable) PreCommit() (err error) {
+	if tbl.isPrepared() {
+		for _, node := range tbl.inodes {
+			if err = tbl.applyPreparedInode(node); err != nil {
+				break
+			}
+		}
+		return
+	}
+	var appender data.BlockAppender
 	for _, node := range tbl.inodes {
-		if err = tbl.applyAppendInode(node); err != nil {
+		if appender, err = tbl.applyAppendInode(node, appender); err != nil {
 			break
 		}
 	}
+	if appender != nil {
+		appender.Close()
+	}
 	return
 }
 
@@ -496,11 +985,27 @@ func (tbl *txnTable) PrepareCommit() (err error) {
 			return
 		}
 	}
+	if len(tbl.appended) > 0 || len(tbl.updateNodes) > 0 {
+		tbl.entry.UpdateLastWriteTS(tbl.txn.GetCommitTS())
+	}
+	for id, offset := range tbl.appended {
+		var blk data.Block
+		if blk, err = tbl.getBlockData(&id); err != nil {
+			return
+		}
+		blk.RecordTxnAppend(tbl.txn, offset)
+	}
+	for _, node := range tbl.updateNodes {
+		if err = node.PrepareCommit(); err != nil {
+			return
+		}
+	}
 	// TODO
 	return
 }
 
 func (tbl *txnTable) ApplyCommit() (err error) {
+	tbl.removePrepared()
 	tbl.entry.RLock()
 	if tbl.entry.CreateAndDropInSameTxn() {
 		tbl.entry.RUnlock()
@@ -517,6 +1022,9 @@ func (tbl *txnTable) ApplyCommit() (err error) {
 			return
 		}
 	}
+	if tbl.altered {
+		tbl.entry.ApplyCommitAlter(tbl.txn)
+	}
 	for _, seg := range tbl.csegs {
 		if err = seg.ApplyCommit(); err != nil {
 			break
@@ -527,18 +1035,74 @@ func (tbl *txnTable) ApplyCommit() (err error) {
 			break
 		}
 	}
+	for id := range tbl.appended {
+		var blk data.Block
+		if blk, err = tbl.getBlockData(&id); err != nil {
+			return
+		}
+		if err = blk.ApplyCommitAppend(tbl.txn); err != nil {
+			return
+		}
+	}
+	for _, node := range tbl.updateNodes {
+		if err = node.ApplyCommit(); err != nil {
+			return
+		}
+	}
 	// TODO
 	return
 }
 
+func (tbl *txnTable) getBlockEntry(id *common.ID) (meta *catalog.BlockEntry, err error) {
+	seg, err := tbl.entry.GetSegmentByID(id.SegmentID)
+	if err != nil {
+		return
+	}
+	return seg.GetBlockEntryByID(id.BlockID)
+}
+
+func (tbl *txnTable) getBlockData(id *common.ID) (blk data.Block, err error) {
+	meta, err := tbl.getBlockEntry(id)
+	if err != nil {
+		return
+	}
+	blk = meta.GetBlockData()
+	return
+}
+
+// getBlockColIdx maps the column col of the schema version of the txn to
+// the column of the block, which may be written in another version
+func (tbl *txnTable) getBlockColIdx(id *common.ID, col uint16) (blkCol uint16, err error) {
+	if int(col) >= len(tbl.schema.ColDefs) {
+		return 0, catalog.ErrNotFound
+	}
+	meta, err := tbl.getBlockEntry(id)
+	if err != nil {
+		return
+	}
+	if meta.GetSchema() == tbl.schema {
+		return col, nil
+	}
+	idx := meta.GetSchema().GetColIdxByID(tbl.schema.ColDefs[col].ID)
+	if idx < 0 {
+		return 0, ErrUpdateAddedColumn
+	}
+	return uint16(idx), nil
+}
+
 func (tbl *txnTable) ApplyRollback() (err error) {
+	tbl.removePrepared()
 	if tbl.createEntry != nil || tbl.dropEntry != nil {
 		if err = tbl.entry.ApplyRollback(); err != nil {
 			return
 		}
 	}
-	// TODO: rollback all inserts and updates
-	return
+	for _, node := range tbl.updateNodes {
+		if err = node.ApplyRollback(); err != nil {
+			return
+		}
+	}
+	return tbl.applyRollbackEntries()
 }
 
 // func (tbl *txnTable) PrepareCommit() (entry NodeEntry, err error) {
@@ -13,12 +13,12 @@ type txnImpl struct {
 }
 
 var TxnFactory = func(catalog *catalog.Catalog) txnbase.TxnFactory {
-	return func(mgr *txnbase.TxnManager, store txnif.TxnStore, txnId, start uint64, info []byte) txnif.AsyncTxn {
+	return func(mgr *txnbase.TxnManager, store txnif.TxnStore, txnId uint64, start txnif.TS, info []byte) txnif.AsyncTxn {
 		return newTxnImpl(catalog, mgr, store, txnId, start, info)
 	}
 }
 
-func newTxnImpl(catalog *catalog.Catalog, mgr *txnbase.TxnManager, store txnif.TxnStore, txnId, start uint64, info []byte) *txnImpl {
+func newTxnImpl(catalog *catalog.Catalog, mgr *txnbase.TxnManager, store txnif.TxnStore, txnId uint64, start txnif.TS, info []byte) *txnImpl {
 	impl := &txnImpl{
 		Txn:     txnbase.NewTxn(mgr, store, txnId, start, info),
 		catalog: catalog,
@@ -34,6 +34,10 @@ func (txn *txnImpl) DropDatabase(name string) (db handle.Database, err error) {
 	return txn.Store.DropDatabase(name)
 }
 
+func (txn *txnImpl) RenameDatabase(name, newName string) (db handle.Database, err error) {
+	return txn.Store.RenameDatabase(name, newName)
+}
+
 func (txn *txnImpl) GetDatabase(name string) (db handle.Database, err error) {
 	return txn.Store.GetDatabase(name)
 }
@@ -2,6 +2,7 @@ package txnimpl
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"math/rand"
 	"os"
@@ -11,7 +12,11 @@ import (
 	"sync/atomic"
 	"tae/pkg/catalog"
 	com "tae/pkg/common"
+	"tae/pkg/dataio"
+	"tae/pkg/iface/handle"
 	"tae/pkg/iface/txnif"
+	"tae/pkg/index"
+	"tae/pkg/tables"
 	"tae/pkg/txn/txnbase"
 	"tae/pkg/updates"
 	"testing"
@@ -21,9 +26,11 @@ import (
 
 	"github.com/RoaringBitmap/roaring"
 	"github.com/RoaringBitmap/roaring/roaring64"
+	gbat "github.com/matrixorigin/matrixone/pkg/container/batch"
 	"github.com/matrixorigin/matrixone/pkg/container/nulls"
 	"github.com/matrixorigin/matrixone/pkg/container/types"
 	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/container/vector"
 	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/mock"
 	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/mutation/buffer"
 	"github.com/panjf2000/ants/v2"
@@ -65,7 +72,7 @@ func makeTable(t *testing.T, dir string, colCnt int, bufSize uint64) *txnTable {
 	id := common.NextGlobalSeqNum()
 	schema := catalog.MockSchemaAll(colCnt)
 	rel := mockTestRelation(id, schema)
-	txn := txnbase.NewTxn(nil, nil, common.NextGlobalSeqNum(), common.NextGlobalSeqNum(), nil)
+	txn := txnbase.NewTxn(nil, nil, common.NextGlobalSeqNum(), txnif.TS(common.NextGlobalSeqNum()), nil)
 	return newTxnTable(txn, rel, driver, mgr, nil, nil)
 }
 
@@ -167,10 +174,13 @@ func TestUpdate(t *testing.T) {
 	row := uint32(999)
 	assert.False(t, tbl.IsLocalDeleted(row))
 	rows := tbl.Rows()
-	err := tbl.UpdateLocalValue(row, 0, 999)
+	err := tbl.UpdateLocalValue(row, 0, int8(99))
 	assert.Nil(t, err)
 	assert.True(t, tbl.IsLocalDeleted(row))
 	assert.Equal(t, rows+1, tbl.Rows())
+	v, err := tbl.GetLocalValue(rows, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int8(99), v)
 }
 
 func TestAppend(t *testing.T) {
@@ -211,9 +221,67 @@ func TestAppend(t *testing.T) {
 	assert.Equal(t, 3*int(brows), int(tbl.index.Count()))
 }
 
+func TestTableSavepoint(t *testing.T) {
+	dir := initTestPath(t)
+	// Only one insert node fits in the buffer, so the others are unloaded
+	tbl := makeTable(t, dir, 2, common.K*40)
+	defer tbl.driver.Close()
+	tbl.GetSchema().PrimaryKey = 1
+
+	rows := txnbase.MaxNodeRows / 2 * 5
+	bats := make([]*gbat.Batch, 5)
+	for i := range bats {
+		bats[i] = mock.MockBatch(tbl.GetSchema().Types(), uint64(rows/5))
+		pks := make([]int16, rows/5)
+		for j := range pks {
+			pks[j] = int16(i*int(rows/5) + j)
+		}
+		bats[i].Vecs[1] = gvec.New(tbl.GetSchema().ColDefs[1].Type)
+		assert.Nil(t, gvec.Append(bats[i].Vecs[1], pks))
+	}
+	for _, bat := range bats[:3] {
+		assert.Nil(t, tbl.Append(bat))
+	}
+	assert.Nil(t, tbl.RangeDeleteLocalRows(100, 200))
+	pk, err := tbl.GetLocalValue(rows/5*3-1, 1)
+	assert.Nil(t, err)
+	sp := tbl.Savepoint()
+
+	for _, bat := range bats[3:] {
+		assert.Nil(t, tbl.Append(bat))
+	}
+	assert.Nil(t, tbl.RangeDeleteLocalRows(300, 400))
+	assert.Nil(t, tbl.RangeDeleteLocalRows(rows/5*3-1, rows/5*3-1))
+	assert.Equal(t, 3, len(tbl.inodes))
+	assert.NotEqual(t, uint64(0), tbl.inodes[1].(*insertNode).lsn)
+	assert.Equal(t, txnbase.ErrDuplicated, tbl.BatchDedupLocal(bats[4]))
+
+	assert.Nil(t, tbl.RollbackToSavepoint(sp))
+	assert.Equal(t, rows/5*3, tbl.Rows())
+	assert.Equal(t, 2, len(tbl.inodes))
+	assert.Equal(t, int(rows/5*3), tbl.index.Count())
+	assert.True(t, tbl.IsLocalDeleted(150))
+	assert.False(t, tbl.IsLocalDeleted(350))
+	assert.False(t, tbl.IsLocalDeleted(rows/5*3-1))
+	v, err := tbl.GetLocalValue(rows/5*3-1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, pk, v)
+	assert.Equal(t, txnbase.ErrDuplicated, tbl.BatchDedupLocal(bats[2]))
+
+	// The rows after the savepoint can be appended again
+	for _, bat := range bats[3:] {
+		assert.Nil(t, tbl.BatchDedupLocal(bat))
+		assert.Nil(t, tbl.Append(bat))
+	}
+	assert.Equal(t, rows, tbl.Rows())
+	assert.Equal(t, int(rows), tbl.index.Count())
+}
+
 func TestIndex(t *testing.T) {
 	index := NewSimpleTableIndex()
 	err := index.Insert(1, 10)
+	assert.Equal(t, vector.VecTypeNotSupportErr, err)
+	err = index.Insert(int64(1), 10)
 	assert.Nil(t, err)
 	err = index.Insert("one", 10)
 	assert.Nil(t, err)
@@ -258,6 +326,47 @@ func TestIndex(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestIndexAllTypes(t *testing.T) {
+	schema := catalog.MockSchemaAll(14)
+	bat := mock.MockBatch(schema.Types(), 100)
+	for i, vec := range bat.Vecs {
+		idx := NewSimpleTableIndex()
+		assert.Nil(t, idx.BatchDedup(vec))
+		assert.Nil(t, idx.BatchInsert(vec, 0, gvec.Length(vec), 0, true), schema.ColDefs[i].Type.String())
+		assert.Equal(t, gvec.Length(vec), idx.Count())
+
+		window := gvec.New(vec.Typ)
+		gvec.Window(vec, 20, 22, window)
+		assert.Equal(t, txnbase.ErrDuplicated, idx.BatchDedup(window))
+		row, err := idx.Find(txnbase.GetValue(vec, 21))
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(21), row)
+		assert.Equal(t, txnbase.ErrDuplicated, idx.Insert(txnbase.GetValue(vec, 21), 0))
+		assert.Nil(t, idx.Delete(txnbase.GetValue(vec, 21)))
+		window = gvec.New(vec.Typ)
+		gvec.Window(vec, 21, 22, window)
+		assert.Nil(t, idx.BatchDedup(window))
+	}
+
+	// Composite keys are unique on all the columns together
+	keys, err := index.NewCompositeKeys(bat.Vecs[2], bat.Vecs[13])
+	assert.Nil(t, err)
+	idx := NewSimpleTableIndex()
+	assert.Nil(t, idx.BatchInsert(keys, 0, gvec.Length(keys), 0, true))
+	col0 := gvec.New(bat.Vecs[2].Typ)
+	assert.Nil(t, gvec.Append(col0, []int32{21}))
+	col1 := gvec.New(bat.Vecs[13].Typ)
+	gvec.Window(bat.Vecs[13], 22, 23, col1)
+	keys, err = index.NewCompositeKeys(col0, col1)
+	assert.Nil(t, err)
+	assert.Nil(t, idx.BatchDedup(keys))
+	col1 = gvec.New(bat.Vecs[13].Typ)
+	gvec.Window(bat.Vecs[13], 21, 22, col1)
+	keys, err = index.NewCompositeKeys(col0, col1)
+	assert.Nil(t, err)
+	assert.Equal(t, txnbase.ErrDuplicated, idx.BatchDedup(keys))
+}
+
 func TestLoad(t *testing.T) {
 	dir := initTestPath(t)
 	tbl := makeTable(t, dir, 14, common.K*2000)
@@ -536,6 +645,129 @@ func initTestContext(t *testing.T, dir string) (*catalog.Catalog, *txnbase.TxnMa
 	return c, mgr, driver
 }
 
+// initDataTestContext is initTestContext with the data of the tables, so the
+// rows of the committed txns are appended to blocks. The options are applied
+// to the txn manager before it starts
+func initDataTestContext(t *testing.T, dir string, opts ...func(*txnbase.TxnManager)) (*catalog.Catalog, *txnbase.TxnManager, txnbase.NodeDriver) {
+	c := catalog.MockCatalog(dir, "mock", nil)
+	driver := txnbase.NewNodeDriver(dir, "store", nil)
+	txnBufMgr := buffer.NewNodeManager(common.G, nil)
+	mutBufMgr := buffer.NewNodeManager(common.G, nil)
+	factory := tables.NewDataFactory(dataio.SegmentFileIOFactory, mutBufMgr, dir)
+	mgr := txnbase.NewTxnManager(TxnStoreFactory(c, driver, txnBufMgr, factory), TxnFactory(c))
+	for _, opt := range opts {
+		opt(mgr)
+	}
+	mgr.Start()
+	return c, mgr, driver
+}
+
+// testTable is a table of schema in the database "db" of a data test context
+type testTable struct {
+	t      *testing.T
+	c      *catalog.Catalog
+	mgr    *txnbase.TxnManager
+	driver txnbase.NodeDriver
+	schema *catalog.Schema
+}
+
+// newTestTable opens a data test context and creates the table of schema
+func newTestTable(t *testing.T, schema *catalog.Schema, opts ...func(*txnbase.TxnManager)) *testTable {
+	c, mgr, driver := initDataTestContext(t, initTestPath(t), opts...)
+	tbl := &testTable{t: t, c: c, mgr: mgr, driver: driver, schema: schema}
+	txn := mgr.StartTxn(nil)
+	db, err := txn.CreateDatabase("db")
+	assert.Nil(t, err)
+	_, err = db.CreateRelation(schema)
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Commit())
+	return tbl
+}
+
+func (tbl *testTable) close() {
+	tbl.driver.Close()
+	tbl.mgr.Stop()
+	tbl.c.Close()
+}
+
+func (tbl *testTable) getRelation(txn txnif.AsyncTxn) handle.Relation {
+	db, err := txn.GetDatabase("db")
+	assert.Nil(tbl.t, err)
+	rel, err := db.GetRelationByName(tbl.schema.Name)
+	assert.Nil(tbl.t, err)
+	return rel
+}
+
+func (tbl *testTable) firstBlock(txn txnif.AsyncTxn) handle.Block {
+	return tbl.getRelation(txn).MakeSegmentIt().GetSegment().MakeBlockIt().GetBlock()
+}
+
+// makeBatch returns the rows in [start, end). The columns of a row all hold
+// the row number
+func (tbl *testTable) makeBatch(start, end int32) *gbat.Batch {
+	bat := gbat.New(true, tbl.schema.Attrs())
+	for i, colDef := range tbl.schema.ColDefs {
+		vals := make([]int32, 0)
+		for v := start; v < end; v++ {
+			vals = append(vals, v)
+		}
+		bat.Vecs[i] = gvec.New(colDef.Type)
+		assert.Nil(tbl.t, gvec.Append(bat.Vecs[i], vals))
+	}
+	return bat
+}
+
+// appendRows appends the rows in [start, end), deduped by the primary key
+func (tbl *testTable) appendRows(txn txnif.AsyncTxn, start, end int32) error {
+	bat := tbl.makeBatch(start, end)
+	rel := tbl.getRelation(txn)
+	if err := rel.BatchDedup(bat.Vecs[tbl.schema.PrimaryKey]); err != nil {
+		return err
+	}
+	return rel.Append(bat)
+}
+
+// operand returns the vector of vals used as the operand of a filter
+func (tbl *testTable) operand(vals ...int32) *gvec.Vector {
+	vec := gvec.New(tbl.schema.ColDefs[0].Type)
+	assert.Nil(tbl.t, gvec.Append(vec, vals))
+	return vec
+}
+
+// readCol returns the values of the column col visible to the txn
+func (tbl *testTable) readCol(txn txnif.AsyncTxn, col int) []int32 {
+	reader := tbl.getRelation(txn).MakeReader()
+	vals := make([]int32, 0)
+	for {
+		bat, err := reader.Next(nil, []string{tbl.schema.ColDefs[col].Name})
+		assert.Nil(tbl.t, err)
+		if bat == nil {
+			break
+		}
+		vals = append(vals, bat.Vecs[0].Col.([]int32)...)
+	}
+	return vals
+}
+
+// countByFilter returns the number of the committed rows visible to the txn
+// that match filter
+func (tbl *testTable) countByFilter(txn txnif.AsyncTxn, filter handle.Filter) int {
+	cnt := 0
+	for it := tbl.getRelation(txn).MakeSegmentIt(); it.Valid(); it.Next() {
+		bats, err := it.GetSegment().GetByFilter(filter, false)
+		assert.Nil(tbl.t, err)
+		for _, bat := range bats {
+			cnt += gvec.Length(bat.Vecs[0])
+		}
+	}
+	return cnt
+}
+
+// countRows returns the number of the rows visible to the txn
+func (tbl *testTable) countRows(txn txnif.AsyncTxn) int {
+	return len(tbl.readCol(txn, 0))
+}
+
 // 1. Txn1 create database "db" and table "tb1". Commit
 // 2. Txn2 drop database
 // 3. Txn3 create table "tb2"
@@ -819,3 +1051,319 @@ func TestBlock1(t *testing.T) {
 	}
 	assert.Equal(t, blkCnt, cnt)
 }
+
+func TestAppendSchemaVersion(t *testing.T) {
+	dir := initTestPath(t)
+	c, mgr, driver := initDataTestContext(t, dir)
+	defer driver.Close()
+	defer mgr.Stop()
+	defer c.Close()
+
+	schema := catalog.MockSchema(1)
+	schema.BlockMaxRows = 15000
+	schema.SegmentMaxBlocks = 2
+	makeBatch := func(start, end int32) *gbat.Batch {
+		bat := gbat.New(true, schema.Attrs())
+		bat.Vecs[0] = gvec.New(schema.ColDefs[0].Type)
+		vals := make([]int32, 0, end-start)
+		for v := start; v < end; v++ {
+			vals = append(vals, v)
+		}
+		assert.Nil(t, gvec.Append(bat.Vecs[0], vals))
+		return bat
+	}
+	var meta *catalog.TableEntry
+	{
+		txn := mgr.StartTxn(nil)
+		db, err := txn.CreateDatabase("db")
+		assert.Nil(t, err)
+		rel, err := db.CreateRelation(schema)
+		assert.Nil(t, err)
+		assert.Nil(t, rel.Append(makeBatch(0, 5)))
+		assert.Nil(t, txn.Commit())
+		meta = rel.GetMeta().(*catalog.TableEntry)
+	}
+	{
+		// The rows of 3 insert nodes are appended to the blocks of the renamed
+		// version only
+		txn := mgr.StartTxn(nil)
+		db, _ := txn.GetDatabase("db")
+		rel, err := db.GetRelationByName(schema.Name)
+		assert.Nil(t, err)
+		assert.Nil(t, rel.AlterTable(catalog.NewRenameTableReq("renamed")))
+		assert.Nil(t, rel.Append(makeBatch(5, 25005)))
+		assert.Nil(t, txn.Commit())
+	}
+	versions := make([]uint32, 0)
+	rows := make([][]int, 0)
+	for it := meta.MakeSegmentIt(false); it.Valid(); it.Next() {
+		seg := it.Get().GetPayload().(*catalog.SegmentEntry)
+		versions = append(versions, seg.GetSchema().Version)
+		segRows := make([]int, 0)
+		for blkIt := seg.MakeBlockIt(false); blkIt.Valid(); blkIt.Next() {
+			blk := blkIt.Get().GetPayload().(*catalog.BlockEntry)
+			segRows = append(segRows, blk.GetBlockData().Rows(nil, true))
+		}
+		rows = append(rows, segRows)
+	}
+	assert.ElementsMatch(t, []uint32{0, 1}, versions)
+	for i, version := range versions {
+		if version == 0 {
+			assert.Equal(t, []int{5}, rows[i])
+		} else {
+			assert.ElementsMatch(t, []int{15000, 10000}, rows[i])
+		}
+	}
+}
+
+func TestCompositeKeyTable(t *testing.T) {
+	schema := catalog.MockSchema(3)
+	assert.Nil(t, schema.SetCompositeKey(0, 1))
+	schema.BlockMaxRows = 10
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, schema)
+	defer tbl.close()
+	keys := func(bat *gbat.Batch) *gvec.Vector {
+		vec, err := index.NewCompositeKeys(bat.Vecs[0], bat.Vecs[1])
+		assert.Nil(t, err)
+		return vec
+	}
+	{
+		txn := tbl.mgr.StartTxn(nil)
+		rel := tbl.getRelation(txn)
+		bat := tbl.makeBatch(0, 15)
+		assert.Nil(t, rel.BatchDedup(keys(bat)))
+		assert.Nil(t, rel.Append(bat))
+		assert.Equal(t, txnbase.ErrDuplicated, rel.BatchDedup(keys(tbl.makeBatch(14, 15))))
+		assert.Nil(t, txn.Commit())
+	}
+
+	txn := tbl.mgr.StartTxn(nil)
+	rel := tbl.getRelation(txn)
+	assert.Equal(t, txnbase.ErrDuplicated, rel.BatchDedup(keys(tbl.makeBatch(3, 4))))
+	assert.Equal(t, txnbase.ErrDuplicated, rel.BatchDedup(keys(tbl.makeBatch(12, 13))))
+
+	// A key sharing the first column with a committed key is not a duplicate
+	bat := tbl.makeBatch(3, 4)
+	bat.Vecs[1] = tbl.operand(4)
+	assert.Nil(t, rel.BatchDedup(keys(bat)))
+	assert.Nil(t, rel.Append(bat))
+	assert.Equal(t, txnbase.ErrDuplicated, rel.BatchDedup(keys(bat)))
+
+	// The key columns cannot be updated, while the others can
+	filter := handle.Filter{Op: handle.FilterEq, Col: tbl.operand(5)}
+	assert.Equal(t, ErrCompositeKey, rel.UpdateByFilter(filter, schema.ColDefs[1].Name, int32(100)))
+	assert.Nil(t, rel.UpdateByFilter(filter, schema.ColDefs[2].Name, int32(100)))
+	assert.Nil(t, txn.Commit())
+	assert.Equal(t, 16, tbl.countRows(tbl.mgr.StartTxn(nil)))
+}
+
+func TestLogTrackerTruncate(t *testing.T) {
+	dir := initTestPath(t)
+	c := catalog.MockCatalog(dir, "mock", nil)
+	defer c.Close()
+	tracker := NewLogTracker(txnbase.NewNodeDriver(dir, "store", nil))
+	defer tracker.Close()
+	factory := tables.NewDataFactory(dataio.SegmentFileIOFactory, buffer.NewNodeManager(common.G, nil), dir)
+	mgr := txnbase.NewTxnManager(TxnStoreFactory(c, tracker, buffer.NewNodeManager(common.G, nil), factory), TxnFactory(c))
+	mgr.Start()
+	defer mgr.Stop()
+	tbl := &testTable{t: t, c: c, mgr: mgr, driver: tracker, schema: catalog.MockSchema(2)}
+	tbl.schema.BlockMaxRows = 10
+	tbl.schema.SegmentMaxBlocks = 2
+	createTable := func(schema *catalog.Schema) {
+		txn := mgr.StartTxn(nil)
+		db, err := txn.GetDatabase("db")
+		assert.Nil(t, err)
+		_, err = db.CreateRelation(schema)
+		assert.Nil(t, err)
+		assert.Nil(t, txn.Commit())
+	}
+	{
+		txn := mgr.StartTxn(nil)
+		_, err := txn.CreateDatabase("db")
+		assert.Nil(t, err)
+		assert.Nil(t, txn.Commit())
+	}
+	createTable(tbl.schema)
+	catalogTs := mgr.Clock.Last()
+	txn := mgr.StartTxn(nil)
+	assert.Nil(t, tbl.appendRows(txn, 0, 5))
+	assert.Nil(t, txn.Commit())
+	dataTs := txn.GetCommitTS()
+	createTable(catalog.MockSchema(2))
+
+	// The catalog records after a data record are kept until the data is
+	// checkpointed
+	assert.Nil(t, tracker.Truncate(catalogTs))
+	assert.Equal(t, uint64(2), tracker.GetTruncated())
+	assert.Nil(t, tracker.Truncate(mgr.Clock.Last()))
+	assert.Equal(t, uint64(2), tracker.GetTruncated())
+	assert.Nil(t, tracker.TruncateData(dataTs-1))
+	assert.Equal(t, uint64(2), tracker.GetTruncated())
+	assert.Nil(t, tracker.TruncateData(dataTs))
+	assert.Equal(t, uint64(4), tracker.GetTruncated())
+	assert.Equal(t, uint64(4), tracker.GetCheckpointed(txnbase.GroupC))
+
+	// A data record is kept until the catalog changed with it is checkpointed
+	txn = mgr.StartTxn(nil)
+	assert.Nil(t, tbl.appendRows(txn, 5, 10))
+	db, err := txn.GetDatabase("db")
+	assert.Nil(t, err)
+	_, err = db.CreateRelation(catalog.MockSchema(2))
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Commit())
+	assert.Nil(t, tracker.TruncateData(txn.GetCommitTS()))
+	assert.Equal(t, uint64(4), tracker.GetTruncated())
+	assert.Nil(t, tracker.Truncate(txn.GetCommitTS()))
+	assert.Equal(t, uint64(5), tracker.GetTruncated())
+}
+
+func TestAbortExpired(t *testing.T) {
+	schema := catalog.MockSchema(2)
+	schema.BlockMaxRows = 10
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, schema, func(mgr *txnbase.TxnManager) {
+		mgr.ReapInterval = 5 * time.Millisecond
+	})
+	defer tbl.close()
+	createTable := func(txn txnif.AsyncTxn, schema *catalog.Schema) error {
+		db, err := txn.GetDatabase("db")
+		assert.Nil(t, err)
+		_, err = db.CreateRelation(schema)
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	txn := tbl.mgr.StartTxn(nil, txnbase.WithContext(ctx))
+	other := catalog.MockSchema(2)
+	assert.Nil(t, createTable(txn, other))
+	assert.Nil(t, tbl.appendRows(txn, 0, 10))
+
+	// The table created by the txn blocks the other writers until it ends
+	txn2 := tbl.mgr.StartTxn(nil)
+	assert.Equal(t, txnif.TxnWWConflictErr, createTable(txn2, other))
+	assert.Nil(t, txn2.Rollback())
+
+	cancel()
+	for i := 0; i < 400 && !txn.IsAborted(); i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.True(t, txn.IsAborted())
+
+	// The changes of the aborted txn are rolled back
+	txn = tbl.mgr.StartTxn(nil)
+	assert.Nil(t, createTable(txn, other))
+	assert.Nil(t, tbl.appendRows(txn, 0, 10))
+	assert.Nil(t, txn.Commit())
+	txn = tbl.mgr.StartTxn(nil)
+	assert.Equal(t, 10, tbl.countRows(txn))
+	assert.Nil(t, txn.Commit())
+}
+
+func TestReadOnlyTxn(t *testing.T) {
+	schema := catalog.MockSchema(2)
+	schema.BlockMaxRows = 1000
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, schema)
+	defer tbl.close()
+	other := catalog.MockSchema(2)
+
+	txn := tbl.mgr.StartTxn(nil)
+	beforeCreate := txn.GetStartTS()
+	db, err := txn.GetDatabase("db")
+	assert.Nil(t, err)
+	_, err = db.CreateRelation(other)
+	assert.Nil(t, err)
+	assert.Nil(t, tbl.appendRows(txn, 0, 10))
+	assert.Nil(t, txn.Commit())
+	firstCommit := txn.GetCommitTS()
+	txn = tbl.mgr.StartTxn(nil)
+	assert.Nil(t, tbl.appendRows(txn, 10, 20))
+	assert.Nil(t, txn.Commit())
+
+	txn = tbl.mgr.StartTxn(nil, txnbase.WithReadOnly())
+	assert.Equal(t, 20, tbl.countRows(txn))
+	assert.Equal(t, txnbase.ErrTxnReadOnly, tbl.appendRows(txn, 20, 30))
+	db, err = txn.GetDatabase("db")
+	assert.Nil(t, err)
+	_, err = db.CreateRelation(catalog.MockSchema(2))
+	assert.Equal(t, txnbase.ErrTxnReadOnly, err)
+	_, err = db.DropRelationByName(schema.Name)
+	assert.Equal(t, txnbase.ErrTxnReadOnly, err)
+	_, err = txn.Prepare()
+	assert.Equal(t, txnbase.ErrTxnReadOnly, err)
+	assert.Nil(t, txn.Commit())
+	assert.Equal(t, txnif.TxnStateCommitted, txn.GetTxnState(false))
+	assert.Equal(t, 0, len(tbl.mgr.ActiveTxns()))
+
+	// Time travel queries
+	txn = tbl.mgr.StartTxn(nil, txnbase.WithSnapshotTS(firstCommit))
+	assert.Equal(t, firstCommit, txn.GetStartTS())
+	assert.Equal(t, 10, tbl.countRows(txn))
+	assert.Nil(t, txn.Rollback())
+	txn = tbl.mgr.StartTxn(nil, txnbase.WithSnapshotTS(beforeCreate))
+	db, err = txn.GetDatabase("db")
+	assert.Nil(t, err)
+	_, err = db.GetRelationByName(other.Name)
+	assert.Equal(t, catalog.ErrNotFound, err)
+	assert.Nil(t, txn.Commit())
+
+	// A read committed snapshot does not move forward
+	txn = tbl.mgr.StartTxn(nil, txnbase.WithSnapshotTS(firstCommit), txnbase.WithIsolation(txnif.ReadCommitted))
+	txn.StartStatement()
+	assert.Equal(t, 10, tbl.countRows(txn))
+	assert.Equal(t, firstCommit, txn.GetStartTS())
+	assert.Nil(t, txn.Commit())
+}
+
+func TestStartTxnAt(t *testing.T) {
+	schema := catalog.MockSchema(2)
+	schema.BlockMaxRows = 1000
+	schema.SegmentMaxBlocks = 2
+	tbl := newTestTable(t, schema, func(mgr *txnbase.TxnManager) {
+		mgr.Retention = 100 * time.Millisecond
+	})
+	defer tbl.close()
+	mgr := tbl.mgr
+
+	txn := mgr.StartTxn(nil)
+	assert.Nil(t, tbl.appendRows(txn, 0, 10))
+	assert.Nil(t, txn.Commit())
+	firstCommit := txn.GetCommitTS()
+	txn = mgr.StartTxn(nil)
+	assert.Nil(t, tbl.appendRows(txn, 10, 20))
+	assert.Nil(t, txn.Commit())
+	txn = mgr.StartTxn(nil)
+	blk := tbl.firstBlock(txn).GetMeta().(*catalog.BlockEntry)
+	assert.Nil(t, tbl.getRelation(txn).DeleteByFilter(handle.Filter{Op: handle.FilterBtw, Col: tbl.operand(0, 4)}))
+	assert.Nil(t, txn.Commit())
+	merge := func() {
+		blk.GetBlockData().GetUpdateChain().(*updates.BlockUpdateChain).AddMergeNodeBefore(mgr.Watermark())
+	}
+
+	txn, err := mgr.StartTxnAt(nil, firstCommit)
+	assert.Nil(t, err)
+	assert.True(t, txn.IsReadOnly())
+	assert.Equal(t, 10, tbl.countRows(txn))
+	assert.Equal(t, txnbase.ErrTxnReadOnly, tbl.appendRows(txn, 20, 30))
+
+	// The snapshot of an active txn is kept out of the retention window. The
+	// window ends at the last issued ts
+	time.Sleep(150 * time.Millisecond)
+	other := mgr.StartTxn(nil)
+	assert.Nil(t, other.Rollback())
+	assert.Equal(t, firstCommit, mgr.Watermark())
+	merge()
+	assert.Equal(t, 10, tbl.countRows(txn))
+	assert.Nil(t, txn.Commit())
+	assert.True(t, mgr.Watermark() > firstCommit)
+	merge()
+	txn = mgr.StartTxn(nil)
+	assert.Equal(t, 15, tbl.countRows(txn))
+	assert.Nil(t, txn.Commit())
+
+	_, err = mgr.StartTxnAt(nil, firstCommit)
+	assert.Equal(t, txnbase.ErrTSOutOfRetention, err)
+	assert.Equal(t, 0, len(mgr.ActiveTxns()))
+}
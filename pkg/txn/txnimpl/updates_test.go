@@ -3,9 +3,14 @@ package txnimpl
 import (
 	"tae/pkg/catalog"
 	com "tae/pkg/common"
+	"tae/pkg/iface/handle"
+	"tae/pkg/iface/txnif"
+	"tae/pkg/txn/txnbase"
 	"tae/pkg/updates"
 	"testing"
 
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
+	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/mock"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -47,3 +52,212 @@ func TestBlockUpdate1(t *testing.T) {
 		t.Log(c.SimplePPString(com.PPL1))
 	}
 }
+
+func TestPushDeleteAndUpdateOp(t *testing.T) {
+	dir := initTestPath(t)
+	c, mgr, driver := initDataTestContext(t, dir)
+	defer driver.Close()
+	defer mgr.Stop()
+	defer c.Close()
+
+	schema := catalog.MockSchema(2)
+	schema.PrimaryKey = 0
+	schema.BlockMaxRows = 10
+	schema.SegmentMaxBlocks = 2
+	getRelation := func(txn txnif.AsyncTxn) handle.Relation {
+		db, err := txn.GetDatabase("db")
+		assert.Nil(t, err)
+		rel, err := db.GetRelationByName(schema.Name)
+		assert.Nil(t, err)
+		return rel
+	}
+	forEachSegment := func(txn txnif.AsyncTxn, op func(seg handle.Segment) error) (err error) {
+		for it := getRelation(txn).MakeSegmentIt(); it.Valid(); it.Next() {
+			if err = op(it.GetSegment()); err != nil {
+				return
+			}
+		}
+		return
+	}
+	readCol := func(txn txnif.AsyncTxn, col int) []int32 {
+		reader := getRelation(txn).MakeReader()
+		vals := make([]int32, 0)
+		for {
+			bat, err := reader.Next(nil, []string{schema.ColDefs[col].Name})
+			assert.Nil(t, err)
+			if bat == nil {
+				break
+			}
+			vals = append(vals, bat.Vecs[0].Col.([]int32)...)
+		}
+		return vals
+	}
+	makeFilter := func(op handle.FilterOp, vals ...int32) handle.Filter {
+		vec := gvec.New(schema.ColDefs[0].Type)
+		assert.Nil(t, gvec.Append(vec, vals))
+		return handle.Filter{Op: op, Col: vec}
+	}
+	{
+		txn := mgr.StartTxn(nil)
+		db, _ := txn.CreateDatabase("db")
+		rel, err := db.CreateRelation(schema)
+		assert.Nil(t, err)
+		assert.Nil(t, rel.Append(mock.MockBatch(schema.Types(), 30)))
+		assert.Nil(t, txn.Commit())
+	}
+
+	txn1 := mgr.StartTxn(nil)
+	txn2 := mgr.StartTxn(nil)
+	// The deleted rows span two blocks
+	assert.Nil(t, forEachSegment(txn1, func(seg handle.Segment) error {
+		return seg.PushDeleteOp(makeFilter(handle.FilterBtw, 5, 14))
+	}))
+	assert.Nil(t, forEachSegment(txn1, func(seg handle.Segment) error {
+		return seg.PushUpdateOp(makeFilter(handle.FilterEq, 20, 21), schema.ColDefs[1].Name, int32(999))
+	}))
+	assert.Equal(t, catalog.ErrNotFound, forEachSegment(txn1, func(seg handle.Segment) error {
+		return seg.PushUpdateOp(makeFilter(handle.FilterEq, 20), "xx", int32(999))
+	}))
+	vals := readCol(txn1, 1)
+	assert.Equal(t, 20, len(vals))
+	assert.Equal(t, int32(15), vals[5])
+	assert.Equal(t, int32(999), vals[10])
+	assert.Equal(t, int32(999), vals[11])
+
+	// The rows deleted or updated by txn1 conflict with txn2
+	assert.Equal(t, txnif.TxnWWConflictErr, forEachSegment(txn2, func(seg handle.Segment) error {
+		return seg.PushDeleteOp(makeFilter(handle.FilterEq, 14))
+	}))
+	assert.Equal(t, txnif.TxnWWConflictErr, forEachSegment(txn2, func(seg handle.Segment) error {
+		return seg.PushUpdateOp(makeFilter(handle.FilterEq, 21), schema.ColDefs[1].Name, int32(888))
+	}))
+	assert.Nil(t, forEachSegment(txn2, func(seg handle.Segment) error {
+		return seg.PushDeleteOp(makeFilter(handle.FilterEq, 29))
+	}))
+	assert.Nil(t, txn1.Commit())
+	assert.Nil(t, txn2.Commit())
+
+	txn := mgr.StartTxn(nil)
+	vals = readCol(txn, 1)
+	assert.Equal(t, 19, len(vals))
+	assert.Equal(t, int32(999), vals[10])
+	assert.Equal(t, int32(28), vals[18])
+	assert.Nil(t, txn.Commit())
+}
+
+func TestDedupCommitted(t *testing.T) {
+	dir := initTestPath(t)
+	c, mgr, driver := initDataTestContext(t, dir)
+	defer driver.Close()
+	defer mgr.Stop()
+	defer c.Close()
+
+	schema := catalog.MockSchema(1)
+	schema.BlockMaxRows = 10
+	schema.SegmentMaxBlocks = 2
+	{
+		txn := mgr.StartTxn(nil)
+		db, err := txn.CreateDatabase("db")
+		assert.Nil(t, err)
+		_, err = db.CreateRelation(schema)
+		assert.Nil(t, err)
+		assert.Nil(t, txn.Commit())
+	}
+	getRelation := func(txn txnif.AsyncTxn) handle.Relation {
+		db, err := txn.GetDatabase("db")
+		assert.Nil(t, err)
+		rel, err := db.GetRelationByName(schema.Name)
+		assert.Nil(t, err)
+		return rel
+	}
+	bat := mock.MockBatch(schema.Types(), 15)
+	pks := bat.Vecs[schema.PrimaryKey]
+
+	txn1 := mgr.StartTxn(nil)
+	txn2 := mgr.StartTxn(nil)
+	rel := getRelation(txn1)
+	assert.Nil(t, rel.BatchDedup(pks))
+	assert.Nil(t, rel.Append(bat))
+	assert.Equal(t, txnbase.ErrDuplicated, rel.BatchDedup(pks))
+	assert.Nil(t, txn1.Commit())
+
+	// The rows committed after txn2 started are duplicated too
+	assert.Equal(t, txnbase.ErrDuplicated, getRelation(txn2).BatchDedup(pks))
+	assert.Nil(t, txn2.Rollback())
+
+	txn3 := mgr.StartTxn(nil)
+	rel = getRelation(txn3)
+	keys := func(vals ...int32) *gvec.Vector {
+		vec := gvec.New(schema.ColDefs[schema.PrimaryKey].Type)
+		assert.Nil(t, gvec.Append(vec, vals))
+		return vec
+	}
+	assert.Nil(t, rel.BatchDedup(keys(15, 16)))
+	assert.Equal(t, txnbase.ErrDuplicated, rel.BatchDedup(keys(15, 14)))
+	assert.Nil(t, txn3.Commit())
+}
+
+func TestReinsertDeleted(t *testing.T) {
+	dir := initTestPath(t)
+	c, mgr, driver := initDataTestContext(t, dir)
+	defer driver.Close()
+	defer mgr.Stop()
+	defer c.Close()
+
+	schema := catalog.MockSchema(1)
+	schema.BlockMaxRows = 10
+	schema.SegmentMaxBlocks = 2
+	bat := mock.MockBatch(schema.Types(), 10)
+	{
+		txn := mgr.StartTxn(nil)
+		db, _ := txn.CreateDatabase("db")
+		rel, err := db.CreateRelation(schema)
+		assert.Nil(t, err)
+		assert.Nil(t, rel.Append(bat))
+		assert.Nil(t, txn.Commit())
+	}
+	getRelation := func(txn txnif.AsyncTxn) handle.Relation {
+		db, err := txn.GetDatabase("db")
+		assert.Nil(t, err)
+		rel, err := db.GetRelationByName(schema.Name)
+		assert.Nil(t, err)
+		return rel
+	}
+	keys := func(vals ...int32) *gvec.Vector {
+		vec := gvec.New(schema.ColDefs[schema.PrimaryKey].Type)
+		assert.Nil(t, gvec.Append(vec, vals))
+		return vec
+	}
+	{
+		txn := mgr.StartTxn(nil)
+		rel := getRelation(txn)
+		assert.Nil(t, rel.DeleteByFilter(handle.Filter{Op: handle.FilterEq, Col: keys(5)}))
+		// The key deleted by the txn itself can be inserted again
+		assert.Nil(t, rel.BatchDedup(keys(5)))
+		assert.Nil(t, txn.Commit())
+	}
+	{
+		txn := mgr.StartTxn(nil)
+		rel := getRelation(txn)
+		assert.Equal(t, txnbase.ErrDuplicated, rel.BatchDedup(keys(4)))
+		reinsert := mock.MockBatch(schema.Types(), 10)
+		for _, vec := range reinsert.Vecs {
+			vec.Col = vec.Col.([]int32)[5:6]
+		}
+		assert.Nil(t, rel.Append(reinsert))
+		assert.Nil(t, txn.Commit())
+	}
+	txn := mgr.StartTxn(nil)
+	reader := getRelation(txn).MakeReader()
+	vals := make([]int32, 0)
+	for {
+		bat, err := reader.Next(nil, []string{schema.ColDefs[schema.PrimaryKey].Name})
+		assert.Nil(t, err)
+		if bat == nil {
+			break
+		}
+		vals = append(vals, bat.Vecs[0].Col.([]int32)...)
+	}
+	assert.ElementsMatch(t, []int32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, vals)
+	assert.Nil(t, txn.Commit())
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/RoaringBitmap/roaring"
 	gbat "github.com/matrixorigin/matrixone/pkg/container/batch"
+	gvec "github.com/matrixorigin/matrixone/pkg/container/vector"
 	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/common"
 	"github.com/matrixorigin/matrixone/pkg/vm/engine/aoe/storage/container/batch"
 )
@@ -31,8 +32,8 @@ type BlockUpdates struct {
 	baseDeletes  *roaring.Bitmap
 	localDeletes *roaring.Bitmap
 	txn          txnif.AsyncTxn
-	startTs      uint64
-	commitTs     uint64
+	startTs      txnif.TS
+	commitTs     txnif.TS
 	nodeType     NodeType
 }
 
@@ -62,7 +63,7 @@ func NewBlockUpdates(txn txnif.AsyncTxn, meta *catalog.BlockEntry, rwlocker *syn
 	return updates
 }
 
-func NewMergeBlockUpdates(commitTs uint64, meta *catalog.BlockEntry, rwlocker *sync.RWMutex, baseDeletes *roaring.Bitmap) *BlockUpdates {
+func NewMergeBlockUpdates(commitTs txnif.TS, meta *catalog.BlockEntry, rwlocker *sync.RWMutex, baseDeletes *roaring.Bitmap) *BlockUpdates {
 	if rwlocker == nil {
 		rwlocker = new(sync.RWMutex)
 	}
@@ -94,9 +95,41 @@ func (n *BlockUpdates) String() string {
 	return s
 }
 
+func (n *BlockUpdates) SetLocalDeletes(deletes *roaring.Bitmap) { n.localDeletes = deletes }
+
+// AddDeletes adds the rows of deletes to the local deletes
+func (n *BlockUpdates) AddDeletes(deletes *roaring.Bitmap) {
+	if n.localDeletes == nil {
+		n.localDeletes = deletes.Clone()
+		return
+	}
+	n.localDeletes.Or(deletes)
+}
+
 func (n *BlockUpdates) IsMerge() bool     { return n.nodeType == NT_Merge }
 func (n *BlockUpdates) GetID() *common.ID { return n.id }
 
+// IsOwnedBy returns true if the updates were made by txn and not committed yet
+func (n *BlockUpdates) IsOwnedBy(txn txnif.AsyncTxn) bool {
+	return n.txn != nil && n.txn.GetID() == txn.GetID()
+}
+
+// isRollbackedLocked returns true if the txn of the updates is rollbacking or
+// rollbacked
+func (n *BlockUpdates) isRollbackedLocked() bool {
+	if n.txn == nil {
+		return false
+	}
+	state := n.txn.GetTxnState(false)
+	return state == txnif.TxnStateRollbacking || state == txnif.TxnStateRollbacked
+}
+
+// IsConcurrentWith returns true if the updates were not committed before txn
+// started
+func (n *BlockUpdates) IsConcurrentWith(txn txnif.AsyncTxn) bool {
+	return n.commitTs == txnif.UncommitTS || n.commitTs > txn.GetStartTS()
+}
+
 func (n *BlockUpdates) DeleteLocked(start, end uint32) error {
 	for i := start; i <= end; i++ {
 		if (n.baseDeletes != nil && n.baseDeletes.Contains(i)) || (n.localDeletes != nil && n.localDeletes.Contains(i)) {
@@ -111,12 +144,12 @@ func (n *BlockUpdates) DeleteLocked(start, end uint32) error {
 }
 
 func (n *BlockUpdates) UpdateLocked(row uint32, colIdx uint16, v interface{}) error {
-	if (n.baseDeletes != nil && n.baseDeletes.Contains(row)) || n.localDeletes.Contains(row) {
+	if (n.baseDeletes != nil && n.baseDeletes.Contains(row)) || (n.localDeletes != nil && n.localDeletes.Contains(row)) {
 		return txnif.TxnWWConflictErr
 	}
 	col, ok := n.cols[colIdx]
 	if !ok {
-		col = NewColumnUpdates(n.id, n.meta.GetSegment().GetTable().GetSchema().ColDefs[colIdx], n.RWMutex)
+		col = NewColumnUpdates(n.id, n.meta.GetSchema().ColDefs[colIdx], n.RWMutex)
 		n.cols[colIdx] = col
 	}
 	return col.UpdateLocked(row, v)
@@ -126,8 +159,8 @@ func (n *BlockUpdates) GetColumnUpdatesLocked(colIdx uint16) txnif.ColumnUpdates
 	return n.cols[colIdx]
 }
 
-func (n *BlockUpdates) GetCommitTSLocked() uint64 { return n.commitTs }
-func (n *BlockUpdates) GetStartTS() uint64        { return n.startTs }
+func (n *BlockUpdates) GetCommitTSLocked() txnif.TS { return n.commitTs }
+func (n *BlockUpdates) GetStartTS() txnif.TS        { return n.startTs }
 
 func (n *BlockUpdates) MergeColumnLocked(ob txnif.BlockUpdates, colIdx uint16) error {
 	o := ob.(*BlockUpdates)
@@ -143,7 +176,7 @@ func (n *BlockUpdates) MergeColumnLocked(ob txnif.BlockUpdates, colIdx uint16) e
 	}
 	currCol := n.cols[colIdx]
 	if currCol == nil {
-		currCol = NewColumnUpdates(n.id, n.meta.GetSegment().GetTable().GetSchema().ColDefs[colIdx], n.RWMutex)
+		currCol = NewColumnUpdates(n.id, n.meta.GetSchema().ColDefs[colIdx], n.RWMutex)
 		n.cols[colIdx] = currCol
 	}
 	currCol.MergeLocked(col)
@@ -160,7 +193,7 @@ func (n *BlockUpdates) MergeLocked(o *BlockUpdates) error {
 	for colIdx, col := range o.cols {
 		currCol := n.cols[colIdx]
 		if currCol == nil {
-			currCol = NewColumnUpdates(n.id, n.meta.GetSegment().GetTable().GetSchema().ColDefs[colIdx], n.RWMutex)
+			currCol = NewColumnUpdates(n.id, n.meta.GetSchema().ColDefs[colIdx], n.RWMutex)
 			n.cols[colIdx] = currCol
 		}
 		currCol.MergeLocked(col)
@@ -168,6 +201,42 @@ func (n *BlockUpdates) MergeLocked(o *BlockUpdates) error {
 	return nil
 }
 
+// UpdatesSnapshot is the state of the updates of a txn at a savepoint
+type UpdatesSnapshot struct {
+	deletes *roaring.Bitmap
+	masks   map[uint16]*roaring.Bitmap
+}
+
+// SnapshotLocked copies the deletes and the updated rows of the node. A txn
+// only adds deletes and updates, so RestoreLocked drops what was added after
+func (n *BlockUpdates) SnapshotLocked() *UpdatesSnapshot {
+	snap := &UpdatesSnapshot{
+		masks: make(map[uint16]*roaring.Bitmap),
+	}
+	if n.localDeletes != nil {
+		snap.deletes = n.localDeletes.Clone()
+	}
+	for colIdx, col := range n.cols {
+		snap.masks[colIdx] = col.txnMask.Clone()
+	}
+	return snap
+}
+
+func (n *BlockUpdates) RestoreLocked(snap *UpdatesSnapshot) {
+	n.localDeletes = nil
+	if snap.deletes != nil {
+		n.localDeletes = snap.deletes.Clone()
+	}
+	for colIdx, col := range n.cols {
+		mask := snap.masks[colIdx]
+		if mask == nil {
+			delete(n.cols, colIdx)
+			continue
+		}
+		col.restoreLocked(mask)
+	}
+}
+
 func (n *BlockUpdates) ReadFrom(r io.Reader) error {
 	buf := make([]byte, txnbase.IDSize)
 	var err error
@@ -184,6 +253,10 @@ func (n *BlockUpdates) ReadFrom(r io.Reader) error {
 		if _, err = r.Read(buf); err != nil {
 			return err
 		}
+		n.localDeletes = roaring.New()
+		if err = n.localDeletes.UnmarshalBinary(buf); err != nil {
+			return err
+		}
 	}
 	colCnt := uint16(0)
 	if err = binary.Read(r, binary.BigEndian, &colCnt); err != nil {
@@ -198,6 +271,7 @@ func (n *BlockUpdates) ReadFrom(r io.Reader) error {
 		if err = col.ReadFrom(r); err != nil {
 			return err
 		}
+		n.cols[colIdx] = col
 	}
 	return err
 }
@@ -325,6 +399,51 @@ func (n *BlockUpdates) TxnCanRead(txn txnif.AsyncTxn, rwlocker *sync.RWMutex) bo
 	return state != txnif.TxnStateRollbacked
 }
 
+// ApplyToColumn applies the column updates and all the deletes to vec
+func (n *BlockUpdates) ApplyToColumn(colIdx uint16, vec *gvec.Vector) *gvec.Vector {
+	col := n.cols[colIdx]
+	if col == nil {
+		if n.localDeletes == nil || n.localDeletes.IsEmpty() {
+			return vec
+		}
+		col = NewColumnUpdates(n.id, nil, nil)
+	}
+	return col.ApplyToColumn(vec, n.localDeletes)
+}
+
+// ApplyDeletes applies only the deletes to vec, which is a column without
+// any update
+func (n *BlockUpdates) ApplyDeletes(vec *gvec.Vector) *gvec.Vector {
+	if n.localDeletes == nil || n.localDeletes.IsEmpty() {
+		return vec
+	}
+	return NewColumnUpdates(n.id, nil, nil).ApplyToColumn(vec, n.localDeletes)
+}
+
+// ApplyUpdatesToColumn applies only the column updates to vec. The row
+// positions of vec are kept
+func (n *BlockUpdates) ApplyUpdatesToColumn(colIdx uint16, vec *gvec.Vector) *gvec.Vector {
+	col := n.cols[colIdx]
+	if col == nil {
+		return vec
+	}
+	return col.ApplyToColumn(vec, nil)
+}
+
+func (n *BlockUpdates) HasColumnUpdates(colIdx uint16) bool { return n.cols[colIdx] != nil }
+
+// GetDeleteCntLocked returns the number of the deleted rows
+func (n *BlockUpdates) GetDeleteCntLocked() uint32 {
+	if n.localDeletes == nil {
+		return 0
+	}
+	return uint32(n.localDeletes.GetCardinality())
+}
+
+func (n *BlockUpdates) IsRowDeleted(row uint32) bool {
+	return n.localDeletes != nil && n.localDeletes.Contains(row)
+}
+
 func (n *BlockUpdates) ApplyChanges(bat *gbat.Batch, deletes *roaring.Bitmap) *batch.Batch {
 	n.cols[0].ApplyToColumn(bat.Vecs[0], deletes)
 	return nil
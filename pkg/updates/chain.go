@@ -1,10 +1,13 @@
 package updates
 
 import (
+	"math"
 	"sync"
 	"tae/pkg/catalog"
 	com "tae/pkg/common"
 	"tae/pkg/iface/txnif"
+
+	"github.com/RoaringBitmap/roaring"
 )
 
 type BlockUpdateChain struct {
@@ -45,13 +48,38 @@ func (chain *BlockUpdateChain) AddNode(txn txnif.AsyncTxn) *BlockUpdateNode {
 	return node
 }
 
-func (chain *BlockUpdateChain) AddMergeNode() *BlockUpdateNode {
+func (chain *BlockUpdateChain) AddReplayNode(updates *BlockUpdates, startTs, commitTs txnif.TS) *BlockUpdateNode {
+	if updates.RWMutex == nil {
+		updates.RWMutex = new(sync.RWMutex)
+	}
+	updates.id = chain.meta.AsCommonID()
+	updates.meta = chain.meta
+	updates.startTs = startTs
+	updates.commitTs = commitTs
+	updates.nodeType = NT_Normal
+	colDefs := chain.meta.GetSchema().ColDefs
+	for colIdx, col := range updates.cols {
+		col.rwlock = updates.RWMutex
+		col.colDef = colDefs[colIdx]
+		col.target = updates.id
+	}
+	chain.Lock()
+	defer chain.Unlock()
+	node := NewBlockUpdateNode(chain, updates)
+	chain.UpdateLocked(node)
+	return node
+}
+
+// AddMergeNodeBefore merges the updates committed at or before watermark. The
+// merged versions are not read at a ts before the merge, so the watermark is
+// kept out of the retention window and the snapshots of the active txns
+func (chain *BlockUpdateChain) AddMergeNodeBefore(watermark txnif.TS) *BlockUpdateNode {
 	chain.Lock()
 	defer chain.Unlock()
 	var merge *BlockUpdates
 	chain.LoopChainLocked(func(updates *BlockUpdateNode) bool {
 		updates.RLock()
-		if updates.GetCommitTSLocked() == txnif.UncommitTS {
+		if updates.GetCommitTSLocked() == txnif.UncommitTS || updates.GetCommitTSLocked() > watermark {
 			updates.RUnlock()
 			return true
 		}
@@ -74,6 +102,14 @@ func (chain *BlockUpdateChain) AddMergeNode() *BlockUpdateNode {
 	return node
 }
 
+// DeleteNodeLocked removes the node of a rollbacked txn from the chain
+func (chain *BlockUpdateChain) DeleteNodeLocked(node *BlockUpdateNode) {
+	chain.Delete(node.DLNode)
+	if chain.latestCommit == node {
+		chain.latestCommit = nil
+	}
+}
+
 func (chain *BlockUpdateChain) LoopChainLocked(fn func(updateNode *BlockUpdateNode) bool, reverse bool) {
 	wrapped := func(node *com.DLNode) bool {
 		updates := node.GetPayload().(*BlockUpdateNode)
@@ -112,13 +148,181 @@ func (chain *BlockUpdateChain) FirstNode() (node *BlockUpdateNode) {
 	return chain.GetHead().GetPayload().(*BlockUpdateNode)
 }
 
-// Read Related
+// CollectUpdatesLocked merges all the updates and deletes visible to txn into
+// a new BlockUpdates. Deletes on rows not less than maxRows are ignored
+func (chain *BlockUpdateChain) CollectUpdatesLocked(txn txnif.AsyncTxn, maxRows uint32) *BlockUpdates {
+	visible := make([]*BlockUpdateNode, 0)
+	chain.LoopChainLocked(func(node *BlockUpdateNode) bool {
+		// The chain lock is released while waiting for a committing txn
+		if !node.TxnCanRead(txn, chain.RWMutex) {
+			return true
+		}
+		visible = append(visible, node)
+		return !node.IsMerge()
+	}, false)
+	if len(visible) == 0 {
+		return nil
+	}
+	collected := NewMergeBlockUpdates(visible[0].GetCommitTSLocked(), chain.meta, nil, nil)
+	for i := len(visible) - 1; i >= 0; i-- {
+		node := visible[i]
+		node.RLock()
+		collected.MergeLocked(node.BlockUpdates)
+		node.RUnlock()
+	}
+	if collected.localDeletes != nil {
+		collected.localDeletes.RemoveRange(uint64(maxRows), uint64(math.MaxUint32)+1)
+	}
+	return collected
+}
+
+// CollectKeysLocked collects the changes on the keys of the column colIdx
+// for txn to dedup. removed are the rows which no longer hold their keys, as
+// they were deleted or had their keys updated by txn or by a committed or
+// committing txn. keys are the keys updated by the txns not rollbacked, whose
+// rows hold them or may hold them once committed
+func (chain *BlockUpdateChain) CollectKeysLocked(txn txnif.AsyncTxn, colIdx uint16) (removed *roaring.Bitmap, keys []interface{}) {
+	removed = roaring.NewBitmap()
+	keys = make([]interface{}, 0)
+	// The nodes are looped from the newest one, and the rows removed by a
+	// newer node are not changed by an older one
+	chain.LoopChainLocked(func(node *BlockUpdateNode) bool {
+		node.RLock()
+		defer node.RUnlock()
+		if node.isRollbackedLocked() {
+			return true
+		}
+		if col := node.cols[colIdx]; col != nil {
+			for row, v := range col.txnVals {
+				if !removed.Contains(row) {
+					keys = append(keys, v)
+				}
+			}
+		}
+		if node.commitTs == txnif.UncommitTS && !node.IsOwnedBy(txn) {
+			return true
+		}
+		if node.localDeletes != nil {
+			removed.Or(node.localDeletes)
+		}
+		if col := node.cols[colIdx]; col != nil {
+			removed.Or(col.txnMask)
+		}
+		return !node.IsMerge()
+	}, false)
+	return
+}
+
+// CheckDeleteLocked returns TxnWWConflictErr if any row in [start, end] was
+// deleted by another txn, or was updated by a txn that is not committed
+// before txn started
+func (chain *BlockUpdateChain) CheckDeleteLocked(txn txnif.AsyncTxn, start, end uint32) (err error) {
+	chain.LoopChainLocked(func(node *BlockUpdateNode) bool {
+		if node.IsOwnedBy(txn) {
+			return true
+		}
+		if rangeIntersects(node.localDeletes, start, end) {
+			err = txnif.TxnWWConflictErr
+			return false
+		}
+		if !node.IsConcurrentWith(txn) {
+			return true
+		}
+		for _, col := range node.cols {
+			if rangeIntersects(col.txnMask, start, end) {
+				err = txnif.TxnWWConflictErr
+				return false
+			}
+		}
+		return true
+	}, false)
+	return
+}
 
-// func (chain *BlockUpdateChain) CollectUpdates(txn txnif.AsyncTxn) *BlockUpdates {
-// 	if chain == nil {
-// 		return nil
-// 	}
-// 	if txn == nil {
-// 		return nil
-// 	}
-// }
+// CheckUpdateLocked returns TxnWWConflictErr if row was deleted by another
+// txn, or the column of row was updated by a txn that is not committed before
+// txn started
+func (chain *BlockUpdateChain) CheckUpdateLocked(txn txnif.AsyncTxn, row uint32, colIdx uint16) (err error) {
+	chain.LoopChainLocked(func(node *BlockUpdateNode) bool {
+		if node.IsOwnedBy(txn) {
+			return true
+		}
+		if node.localDeletes != nil && node.localDeletes.Contains(row) {
+			err = txnif.TxnWWConflictErr
+			return false
+		}
+		if !node.IsConcurrentWith(txn) {
+			return true
+		}
+		if col := node.cols[colIdx]; col != nil && col.txnMask.Contains(row) {
+			err = txnif.TxnWWConflictErr
+			return false
+		}
+		return true
+	}, false)
+	return
+}
+
+// CheckReadLocked returns TxnRWConflictErr if another txn committing between
+// the start and the commit of txn deleted or updated a row read by txn, or
+// updated the column colIdx of a row to a value txn would have read. matchRow
+// tells if txn read the row and matchValue tells if txn would read a row with
+// the value. The txns committing after txn, e.g. after txn is prepared, are
+// serialized after it and never conflict
+func (chain *BlockUpdateChain) CheckReadLocked(txn txnif.AsyncTxn, colIdx uint16, matchRow func(row uint32) bool, matchValue func(v interface{}) bool) (err error) {
+	startTs, endTs := txn.GetStartTS(), txn.GetCommitTS()
+	if prepareTs := txn.GetPrepareTS(); !prepareTs.IsEmpty() {
+		endTs = prepareTs
+	}
+	chain.LoopChainLocked(func(node *BlockUpdateNode) bool {
+		if node.IsMerge() || node.IsOwnedBy(txn) {
+			return true
+		}
+		node.RLock()
+		defer node.RUnlock()
+		if node.commitTs == txnif.UncommitTS || node.commitTs <= startTs || node.commitTs >= endTs {
+			return true
+		}
+		if node.localDeletes != nil {
+			it := node.localDeletes.Iterator()
+			for it.HasNext() {
+				if matchRow(it.Next()) {
+					err = txnif.TxnRWConflictErr
+					return false
+				}
+			}
+		}
+		// Any column updated in a row read by txn
+		for _, col := range node.cols {
+			it := col.txnMask.Iterator()
+			for it.HasNext() {
+				if matchRow(it.Next()) {
+					err = txnif.TxnRWConflictErr
+					return false
+				}
+			}
+		}
+		// The column read by txn updated to a value read by txn
+		if col := node.cols[colIdx]; col != nil {
+			for _, v := range col.txnVals {
+				if matchValue(v) {
+					err = txnif.TxnRWConflictErr
+					return false
+				}
+			}
+		}
+		return true
+	}, false)
+	return
+}
+
+func rangeIntersects(bm *roaring.Bitmap, start, end uint32) bool {
+	if bm == nil {
+		return false
+	}
+	cnt := bm.Rank(end)
+	if start > 0 {
+		cnt -= bm.Rank(start - 1)
+	}
+	return cnt > 0
+}
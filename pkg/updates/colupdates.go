@@ -147,6 +147,16 @@ func (n *ColumnUpdates) UpdateLocked(row uint32, v interface{}) error {
 	return nil
 }
 
+// restoreLocked keeps only the updates of the rows in mask
+func (n *ColumnUpdates) restoreLocked(mask *roaring.Bitmap) {
+	added := roaring.AndNot(n.txnMask, mask)
+	it := added.Iterator()
+	for it.HasNext() {
+		delete(n.txnVals, it.Next())
+	}
+	n.txnMask = mask.Clone()
+}
+
 func (n *ColumnUpdates) MergeLocked(o txnif.ColumnUpdates) error {
 	for k, v := range o.(*ColumnUpdates).txnVals {
 		n.txnMask.Add(k)
@@ -37,3 +37,32 @@ func (n *BlockUpdateNode) PrepareCommit() (err error) {
 	n.chain.UpdateLocked(n)
 	return
 }
+
+func (n *BlockUpdateNode) ApplyCommit() (err error) {
+	n.chain.Lock()
+	defer n.chain.Unlock()
+	return n.BlockUpdates.ApplyCommit()
+}
+
+func (n *BlockUpdateNode) Snapshot() *UpdatesSnapshot {
+	n.chain.RLock()
+	defer n.chain.RUnlock()
+	n.RLock()
+	defer n.RUnlock()
+	return n.SnapshotLocked()
+}
+
+func (n *BlockUpdateNode) Restore(snap *UpdatesSnapshot) {
+	n.chain.Lock()
+	defer n.chain.Unlock()
+	n.Lock()
+	defer n.Unlock()
+	n.RestoreLocked(snap)
+}
+
+func (n *BlockUpdateNode) ApplyRollback() (err error) {
+	n.chain.Lock()
+	defer n.chain.Unlock()
+	n.chain.DeleteNodeLocked(n)
+	return
+}
@@ -37,6 +37,8 @@ func (c *UpdateCmd) String() string {
 	return ""
 }
 
+func (c *UpdateCmd) GetUpdates() *BlockUpdates { return c.updates }
+
 func (c *UpdateCmd) GetType() int16 { return txnbase.CmdUpdate }
 
 func (c *UpdateCmd) WriteTo(w io.Writer) (err error) {
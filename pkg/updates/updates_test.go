@@ -358,7 +358,7 @@ func TestUpdates2(t *testing.T) {
 
 	for i := 0; i < cnt1+cnt2; i++ {
 		txn := new(txnbase.Txn)
-		txn.TxnCtx = txnbase.NewTxnCtx(nil, common.NextGlobalSeqNum(), uint64(i)*2, nil)
+		txn.TxnCtx = txnbase.NewTxnCtx(nil, common.NextGlobalSeqNum(), txnif.TS(i)*2, nil)
 		node := chain.AddNode(txn)
 		// updates := node.GetUpdates()
 		node.DeleteLocked(uint32(i)*10, uint32(i+1)*10-1)
@@ -388,7 +388,7 @@ func TestUpdates2(t *testing.T) {
 	}, true)
 	assert.Equal(t, cnt2+cnt1, totalCnt)
 
-	m := chain.AddMergeNode()
+	m := chain.AddMergeNodeBefore(txnif.TS(cnt1-1)*2 + 1)
 	t.Log(m.String())
 	t.Log(m.localDeletes.String())
 
@@ -407,7 +407,7 @@ func TestUpdates2(t *testing.T) {
 	assert.Equal(t, cnt2+cnt1+1, totalCnt)
 	// t.Log(link.GetHead().GetPayload().(*BlockUpdates).String())
 
-	commitTs := chain.FirstNode().GetStartTS() + uint64(100)
+	commitTs := chain.FirstNode().GetStartTS() + txnif.TS(100)
 	for {
 		node := chain.FirstNode()
 		if node.GetCommitTSLocked() != txnif.UncommitTS {
@@ -427,7 +427,7 @@ func TestUpdates2(t *testing.T) {
 		return true
 	}, false)
 
-	m = chain.AddMergeNode()
+	m = chain.AddMergeNodeBefore(commitTs)
 
 	chain.LoopChainLocked(func(node *BlockUpdateNode) bool {
 		t.Log(node.String())
@@ -450,7 +450,7 @@ func TestUpdates3(t *testing.T) {
 	now := time.Now()
 	for i := 0; i < blkCnt; i++ {
 		blk, _ := seg.CreateBlock(nil, catalog.ES_Appendable, nil)
-		blk.CreateAt = common.NextGlobalSeqNum()
+		blk.CreateAt = txnif.TS(common.NextGlobalSeqNum())
 		chain := NewUpdateChain(nil, blk)
 		chains = append(chains, chain)
 	}
@@ -459,24 +459,24 @@ func TestUpdates3(t *testing.T) {
 		for j := 0; j < nodeCnt; j++ {
 			txn := new(txnbase.Txn)
 			txn.TxnCtx = new(txnbase.TxnCtx)
-			txn.StartTS = common.NextGlobalSeqNum()
+			txn.StartTS = txnif.TS(common.NextGlobalSeqNum())
 			node := chain.AddNode(txn)
 			node.DeleteLocked(uint32(j)*1, uint32(j+1)*(uint32(nodeCnt))-1)
 			node.UpdateLocked(uint32(j)+1000, 0, int32((j+1)*1000))
-			node.commitTs = common.NextGlobalSeqNum()
+			node.commitTs = txnif.TS(common.NextGlobalSeqNum())
 		}
 	}
 	t.Log(time.Since(now))
 
 	now = time.Now()
 	for _, chain := range chains {
-		chain.AddMergeNode()
+		chain.AddMergeNodeBefore(txnif.TS(common.NextGlobalSeqNum()))
 	}
 	t.Log(time.Since(now))
 
 	now = time.Now()
 	for _, chain := range chains {
-		m := chain.AddMergeNode()
+		m := chain.AddMergeNodeBefore(txnif.TS(common.NextGlobalSeqNum()))
 		t.Log(m.localDeletes.String())
 	}
 	t.Log(time.Since(now))
@@ -507,3 +507,161 @@ func TestUpdates3(t *testing.T) {
 	}
 	t.Log(time.Since(now))
 }
+
+func TestCollectUpdates(t *testing.T) {
+	schema := catalog.MockSchema(1)
+	c := catalog.MockCatalog(initTestPath(t), "mock", nil)
+	defer c.Close()
+
+	db, _ := c.CreateDBEntry("db", nil)
+	table, _ := db.CreateTableEntry(schema, nil, nil)
+	seg, _ := table.CreateSegment(nil, catalog.ES_Appendable, nil)
+	blk, _ := seg.CreateBlock(nil, catalog.ES_Appendable, nil)
+	chain := NewUpdateChain(nil, blk)
+
+	txns := make([]*txnbase.Txn, 4)
+	for i := range txns {
+		txn := new(txnbase.Txn)
+		txn.TxnCtx = txnbase.NewTxnCtx(nil, common.NextGlobalSeqNum(), txnif.TS(i)*10+1, nil)
+		node := chain.AddNode(txn)
+		assert.Nil(t, node.DeleteLocked(uint32(i)*10, uint32(i)*10+9))
+		assert.Nil(t, node.UpdateLocked(100+uint32(i), 0, int32(i)))
+		if i < len(txns)-1 {
+			txn.CommitTS = txn.StartTS + 1
+			assert.Nil(t, node.PrepareCommit())
+			assert.Nil(t, node.ApplyCommit())
+		}
+		txns[i] = txn
+	}
+
+	reader := new(txnbase.Txn)
+	reader.TxnCtx = txnbase.NewTxnCtx(nil, common.NextGlobalSeqNum(), 15, nil)
+	collected := chain.CollectUpdatesLocked(reader, 1000)
+	assert.Equal(t, uint64(20), collected.localDeletes.GetCardinality())
+	assert.Equal(t, 2, collected.cols[0].GetUpdateCntLocked())
+	collected = chain.CollectUpdatesLocked(reader, 15)
+	assert.Equal(t, uint64(15), collected.localDeletes.GetCardinality())
+
+	// The uncommitted updates are only visible to its own txn
+	collected = chain.CollectUpdatesLocked(txns[3], 1000)
+	assert.Equal(t, uint64(40), collected.localDeletes.GetCardinality())
+	assert.Equal(t, int32(3), collected.cols[0].txnVals[103])
+
+	// The keys updated by all the txns not rollbacked exist, while only the
+	// committed txns and the txn itself remove the rows
+	removed, keys := chain.CollectKeysLocked(reader, 0)
+	assert.ElementsMatch(t, []interface{}{int32(0), int32(1), int32(2), int32(3)}, keys)
+	assert.Equal(t, uint64(33), removed.GetCardinality())
+	removed, _ = chain.CollectKeysLocked(txns[3], 0)
+	assert.Equal(t, uint64(44), removed.GetCardinality())
+	removed, keys = chain.CollectKeysLocked(reader, 1)
+	assert.Empty(t, keys)
+	assert.Equal(t, uint64(30), removed.GetCardinality())
+	txns[3].State = txnif.TxnStateRollbacked
+	_, keys = chain.CollectKeysLocked(reader, 0)
+	assert.Equal(t, 3, len(keys))
+	txns[3].State = txnif.TxnStateActive
+
+	chain.AddMergeNodeBefore(22)
+	reader.TxnCtx = txnbase.NewTxnCtx(nil, common.NextGlobalSeqNum(), 100, nil)
+	collected = chain.CollectUpdatesLocked(reader, 1000)
+	assert.Equal(t, uint64(30), collected.localDeletes.GetCardinality())
+	assert.Equal(t, 3, collected.cols[0].GetUpdateCntLocked())
+
+	reader.TxnCtx = txnbase.NewTxnCtx(nil, common.NextGlobalSeqNum(), 1, nil)
+	assert.Nil(t, chain.CollectUpdatesLocked(reader, 1000))
+}
+
+func TestMergeBeforeWatermark(t *testing.T) {
+	schema := catalog.MockSchema(1)
+	c := catalog.MockCatalog(initTestPath(t), "mock", nil)
+	defer c.Close()
+
+	db, _ := c.CreateDBEntry("db", nil)
+	table, _ := db.CreateTableEntry(schema, nil, nil)
+	seg, _ := table.CreateSegment(nil, catalog.ES_Appendable, nil)
+	blk, _ := seg.CreateBlock(nil, catalog.ES_Appendable, nil)
+	chain := NewUpdateChain(nil, blk)
+
+	// The updates are committed at 2, 12, 22 and 32
+	for i := 0; i < 4; i++ {
+		txn := new(txnbase.Txn)
+		txn.TxnCtx = txnbase.NewTxnCtx(nil, common.NextGlobalSeqNum(), txnif.TS(i)*10+1, nil)
+		node := chain.AddNode(txn)
+		assert.Nil(t, node.DeleteLocked(uint32(i)*10, uint32(i)*10+9))
+		txn.CommitTS = txn.StartTS + 1
+		assert.Nil(t, node.PrepareCommit())
+		assert.Nil(t, node.ApplyCommit())
+	}
+	m := chain.AddMergeNodeBefore(15)
+	assert.Equal(t, txnif.TS(12), m.GetCommitTSLocked())
+	assert.Equal(t, uint64(20), m.localDeletes.GetCardinality())
+
+	// The versions after the watermark are still read at any ts
+	reader := new(txnbase.Txn)
+	for ts, deletes := range map[txnif.TS]uint64{5: 10, 15: 20, 25: 30, 35: 40} {
+		reader.TxnCtx = txnbase.NewTxnCtx(nil, common.NextGlobalSeqNum(), ts, nil)
+		collected := chain.CollectUpdatesLocked(reader, 1000)
+		assert.Equal(t, deletes, collected.localDeletes.GetCardinality())
+	}
+	assert.Nil(t, chain.AddMergeNodeBefore(1))
+}
+
+func TestCheckRead(t *testing.T) {
+	schema := catalog.MockSchema(2)
+	c := catalog.MockCatalog(initTestPath(t), "mock", nil)
+	defer c.Close()
+
+	db, _ := c.CreateDBEntry("db", nil)
+	table, _ := db.CreateTableEntry(schema, nil, nil)
+	seg, _ := table.CreateSegment(nil, catalog.ES_Appendable, nil)
+	blk, _ := seg.CreateBlock(nil, catalog.ES_Appendable, nil)
+	chain := NewUpdateChain(nil, blk)
+
+	// The reader read the rows in [0, 10) and the rows with a value of
+	// column 0 less than 10
+	reader := new(txnbase.Txn)
+	reader.TxnCtx = txnbase.NewTxnCtx(nil, common.NextGlobalSeqNum(), 10, nil)
+	matchRow := func(row uint32) bool { return row < 10 }
+	matchValue := func(v interface{}) bool { return v.(int32) < 10 }
+	check := func() error {
+		return chain.CheckReadLocked(reader, 0, matchRow, matchValue)
+	}
+	commit := func(startTs txnif.TS, op func(node *BlockUpdateNode)) {
+		txn := new(txnbase.Txn)
+		txn.TxnCtx = txnbase.NewTxnCtx(nil, common.NextGlobalSeqNum(), startTs, nil)
+		node := chain.AddNode(txn)
+		op(node)
+		txn.CommitTS = startTs + 1
+		assert.Nil(t, node.PrepareCommit())
+		assert.Nil(t, node.ApplyCommit())
+	}
+
+	// Committed before the reader started
+	commit(5, func(node *BlockUpdateNode) { assert.Nil(t, node.DeleteLocked(0, 0)) })
+	assert.Nil(t, check())
+	// The rows not read and the columns not read updated to any value
+	commit(11, func(node *BlockUpdateNode) {
+		assert.Nil(t, node.DeleteLocked(20, 20))
+		assert.Nil(t, node.UpdateLocked(21, 0, int32(30)))
+		assert.Nil(t, node.UpdateLocked(22, 1, int32(1)))
+	})
+	assert.Nil(t, check())
+
+	// The reader is prepared at 20. The txn committing after it is
+	// serialized after the reader and does not conflict
+	reader.Lock()
+	assert.Nil(t, reader.ToPreparedLocked(20))
+	reader.Unlock()
+	commit(20, func(node *BlockUpdateNode) {
+		assert.Nil(t, node.DeleteLocked(1, 1))
+		assert.Nil(t, node.UpdateLocked(23, 0, int32(1)))
+	})
+	assert.Nil(t, check())
+
+	// Committed at 14, between the start and the prepare of the reader
+	commit(13, func(node *BlockUpdateNode) { assert.Nil(t, node.UpdateLocked(24, 0, int32(2))) })
+	assert.Equal(t, txnif.TxnRWConflictErr, check())
+	reader.PrepareTS = 14
+	assert.Nil(t, check())
+}